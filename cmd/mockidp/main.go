@@ -0,0 +1,232 @@
+// Command mockidp is a minimal OpenID Connect provider for trying out and
+// testing user-service single sign-on locally. It signs in whoever types an
+// email address; never expose it outside a development machine.
+//
+//  go run ./cmd/mockidp
+//  OIDC_ISSUER=http://localhost:9000 OIDC_CLIENT_ID=cnad-user-service OIDC_CLIENT_SECRET=mock-secret go run .
+package main
+
+import (
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "html/template"
+    "log"
+    "math/big"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt"
+)
+
+const codeTTL = time.Minute
+
+type pendingCode struct {
+    email         string
+    emailVerified bool
+    nonce         string
+    redirectURI   string
+    codeChallenge string
+    expires       time.Time
+}
+
+type provider struct {
+    issuer       string
+    clientID     string
+    clientSecret string
+    key          *rsa.PrivateKey
+    keyID        string
+
+    mu    sync.Mutex
+    codes map[string]pendingCode
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock IdP</title></head>
+<body style="font-family: sans-serif; max-width: 24rem; margin: 4rem auto;">
+    <h2>Mock identity provider</h2>
+    <p>Sign in to <b>{{.ClientID}}</b> as:</p>
+    <form method="POST" action="/authorize">
+        {{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+        {{end}}
+        <input type="email" name="email" placeholder="Email" required autofocus>
+        <label><input type="checkbox" name="email_verified" value="true" checked> email verified</label>
+        <p><button type="submit">Sign in</button></p>
+    </form>
+</body>
+</html>`))
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "issuer":                                p.issuer,
+        "authorization_endpoint":                p.issuer + "/authorize",
+        "token_endpoint":                        p.issuer + "/token",
+        "jwks_uri":                              p.issuer + "/jwks",
+        "response_types_supported":              []string{"code"},
+        "subject_types_supported":               []string{"public"},
+        "id_token_signing_alg_values_supported": []string{"RS256"},
+        "code_challenge_methods_supported":      []string{"S256"},
+    })
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+    pub := p.key.PublicKey
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "keys": []map[string]string{{
+            "kty": "RSA",
+            "use": "sig",
+            "alg": "RS256",
+            "kid": p.keyID,
+            "n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+            "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+        }},
+    })
+}
+
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    if r.Form.Get("client_id") != p.clientID || r.Form.Get("redirect_uri") == "" {
+        http.Error(w, "Unknown client", http.StatusBadRequest)
+        return
+    }
+
+    if r.Method == http.MethodGet {
+        params := url.Values{}
+        for _, k := range []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+            params.Set(k, r.Form.Get(k))
+        }
+        loginPage.Execute(w, map[string]interface{}{"ClientID": p.clientID, "Params": params})
+        return
+    }
+
+    code := make([]byte, 16)
+    rand.Read(code)
+    codeString := hex.EncodeToString(code)
+
+    p.mu.Lock()
+    p.codes[codeString] = pendingCode{
+        email:         strings.ToLower(strings.TrimSpace(r.Form.Get("email"))),
+        emailVerified: r.Form.Get("email_verified") == "true",
+        nonce:         r.Form.Get("nonce"),
+        redirectURI:   r.Form.Get("redirect_uri"),
+        codeChallenge: r.Form.Get("code_challenge"),
+        expires:       time.Now().Add(codeTTL),
+    }
+    p.mu.Unlock()
+
+    q := url.Values{"code": {codeString}}
+    if state := r.Form.Get("state"); state != "" {
+        q.Set("state", state)
+    }
+    http.Redirect(w, r, r.Form.Get("redirect_uri")+"?"+q.Encode(), http.StatusFound)
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+        return
+    }
+
+    if r.PostForm.Get("client_id") != p.clientID ||
+        subtle.ConstantTimeCompare([]byte(r.PostForm.Get("client_secret")), []byte(p.clientSecret)) != 1 {
+        writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+        return
+    }
+
+    p.mu.Lock()
+    pending, ok := p.codes[r.PostForm.Get("code")]
+    delete(p.codes, r.PostForm.Get("code"))
+    p.mu.Unlock()
+
+    if !ok || time.Now().After(pending.expires) || pending.redirectURI != r.PostForm.Get("redirect_uri") {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+        return
+    }
+    if pending.codeChallenge != "" {
+        sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+        if base64.RawURLEncoding.EncodeToString(sum[:]) != pending.codeChallenge {
+            writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+            return
+        }
+    }
+
+    // Stable subject per email so repeated logins map to the same identity
+    sub := sha256.Sum256([]byte(pending.email))
+
+    now := time.Now()
+    token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+        "iss":            p.issuer,
+        "sub":            hex.EncodeToString(sub[:8]),
+        "aud":            p.clientID,
+        "iat":            now.Unix(),
+        "exp":            now.Add(5 * time.Minute).Unix(),
+        "nonce":          pending.nonce,
+        "email":          pending.email,
+        "email_verified": pending.emailVerified,
+    })
+    token.Header["kid"] = p.keyID
+    idToken, err := token.SignedString(p.key)
+    if err != nil {
+        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "access_token": hex.EncodeToString(sub[:]),
+        "token_type":   "Bearer",
+        "expires_in":   300,
+        "id_token":     idToken,
+    })
+}
+
+func main() {
+    addr := flag.String("addr", ":9000", "listen address")
+    issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, as reachable by user-service")
+    clientID := flag.String("client-id", "cnad-user-service", "the one client this provider knows")
+    clientSecret := flag.String("client-secret", "mock-secret", "that client's secret")
+    flag.Parse()
+
+    // A fresh key and kid each run; relying parties re-fetch the JWKS on an unknown kid
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        log.Fatal("Failed to generate signing key:", err)
+    }
+    kid := make([]byte, 4)
+    rand.Read(kid)
+
+    p := &provider{
+        issuer:       strings.TrimRight(*issuer, "/"),
+        clientID:     *clientID,
+        clientSecret: *clientSecret,
+        key:          key,
+        keyID:        hex.EncodeToString(kid),
+        codes:        make(map[string]pendingCode),
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+    mux.HandleFunc("/jwks", p.jwks)
+    mux.HandleFunc("/authorize", p.authorize)
+    mux.HandleFunc("/token", p.token)
+
+    log.Printf("Mock IdP %s listening on %s (client %s)", p.issuer, *addr, p.clientID)
+    log.Fatal(http.ListenAndServe(*addr, mux))
+}
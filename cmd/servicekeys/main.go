@@ -0,0 +1,71 @@
+// Command servicekeys generates the Ed25519 key pairs services use to sign
+// service-to-service tokens during local development.
+//
+//  go run ./cmd/servicekeys            # writes ./keys/<service>.key and .pub
+//  go run ./cmd/servicekeys -force     # rotate existing keys
+package main
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/x509"
+    "encoding/pem"
+    "flag"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+func writePEM(path, blockType string, der []byte, mode os.FileMode) error {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    return pem.Encode(f, &pem.Block{Type: blockType, Bytes: der})
+}
+
+func main() {
+    outDir := flag.String("out", "keys", "directory to write keys to")
+    services := flag.String("services", "user-service,vehicle-service,billing-service", "comma-separated service names")
+    force := flag.Bool("force", false, "overwrite existing keys")
+    flag.Parse()
+
+    if err := os.MkdirAll(*outDir, 0o700); err != nil {
+        log.Fatal("Failed to create key directory:", err)
+    }
+
+    for _, name := range strings.Split(*services, ",") {
+        name = strings.TrimSpace(name)
+        keyPath := filepath.Join(*outDir, name+".key")
+        pubPath := filepath.Join(*outDir, name+".pub")
+
+        if _, err := os.Stat(keyPath); err == nil && !*force {
+            log.Printf("Keeping existing key for %s (use -force to rotate)", name)
+            continue
+        }
+
+        public, private, err := ed25519.GenerateKey(rand.Reader)
+        if err != nil {
+            log.Fatal("Failed to generate key:", err)
+        }
+
+        privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+        if err != nil {
+            log.Fatal("Failed to encode private key:", err)
+        }
+        publicDER, err := x509.MarshalPKIXPublicKey(public)
+        if err != nil {
+            log.Fatal("Failed to encode public key:", err)
+        }
+
+        if err := writePEM(keyPath, "PRIVATE KEY", privateDER, 0o600); err != nil {
+            log.Fatal("Failed to write private key:", err)
+        }
+        if err := writePEM(pubPath, "PUBLIC KEY", publicDER, 0o644); err != nil {
+            log.Fatal("Failed to write public key:", err)
+        }
+        log.Printf("Generated %s and %s", keyPath, pubPath)
+    }
+}
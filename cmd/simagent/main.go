@@ -0,0 +1,165 @@
+// Command simagent pretends to be the telematics unit in a car, for trying out
+// remote lock/unlock and telemetry without hardware. It polls vehicle-service
+// for commands, carries them out after a short delay and reports its state.
+//
+// Register a device first (as an operator) and pass the returned key:
+//
+//  curl -X POST -H "Authorization: Bearer $TOKEN" -d '{"device_id":"sim-1"}' \
+//      http://localhost:8085/api/vehicles/1/devices
+//  go run ./cmd/simagent -device sim-1 -key <device_key>
+//
+// -fail-rate and -silent make the agent reject or ignore commands, for testing
+// failure and timeout handling.
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "math/rand"
+    "net/http"
+    "time"
+)
+
+type command struct {
+    ID      int    `json:"id"`
+    Command string `json:"command"`
+}
+
+type reading struct {
+    RecordedAt   time.Time `json:"recorded_at"`
+    Latitude     float64   `json:"latitude"`
+    Longitude    float64   `json:"longitude"`
+    BatteryLevel int       `json:"battery_level"`
+    OdometerKm   float64   `json:"odometer_km"`
+    SpeedKmh     float64   `json:"speed_kmh"`
+    DoorsLocked  bool      `json:"doors_locked"`
+}
+
+type agent struct {
+    baseURL  string
+    deviceID string
+    key      string
+    client   *http.Client
+
+    state reading
+}
+
+func (a *agent) do(method, path string, body, out interface{}) error {
+    var payload bytes.Buffer
+    if body != nil {
+        if err := json.NewEncoder(&payload).Encode(body); err != nil {
+            return err
+        }
+    }
+
+    req, err := http.NewRequest(method, a.baseURL+path, &payload)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Device-ID", a.deviceID)
+    req.Header.Set("X-Device-Key", a.key)
+
+    resp, err := a.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+    }
+    if out == nil {
+        return nil
+    }
+    return json.NewDecoder(resp.Body).Decode(&struct {
+        Data interface{} `json:"data"`
+    }{Data: out})
+}
+
+func (a *agent) report() {
+    a.state.RecordedAt = time.Now().UTC()
+    body := map[string]interface{}{"readings": []reading{a.state}}
+    if err := a.do("POST", "/api/telemetry", body, nil); err != nil {
+        log.Printf("Telemetry failed: %v", err)
+    }
+}
+
+func (a *agent) handle(c command, delay time.Duration, failRate float64, silent bool) {
+    log.Printf("Received command %d: %s", c.ID, c.Command)
+    if silent {
+        log.Printf("Ignoring command %d (-silent)", c.ID)
+        return
+    }
+
+    time.Sleep(delay)
+    ack := map[string]interface{}{"success": true}
+    if rand.Float64() < failRate {
+        ack = map[string]interface{}{"success": false, "error": "simulated actuator fault"}
+    } else {
+        a.state.DoorsLocked = c.Command == "lock"
+    }
+
+    if err := a.do("POST", fmt.Sprintf("/api/device/commands/%d/ack", c.ID), ack, nil); err != nil {
+        log.Printf("Acknowledging command %d failed: %v", c.ID, err)
+        return
+    }
+    log.Printf("Acknowledged command %d (success=%v)", c.ID, ack["success"])
+    a.report()
+}
+
+func main() {
+    baseURL := flag.String("url", "http://localhost:8085", "vehicle-service base URL")
+    deviceID := flag.String("device", "", "registered device ID")
+    key := flag.String("key", "", "device key returned at registration")
+    poll := flag.Duration("poll", time.Second, "how often to poll for commands")
+    interval := flag.Duration("telemetry", 30*time.Second, "how often to send a telemetry reading")
+    delay := flag.Duration("delay", 500*time.Millisecond, "simulated actuator time per command")
+    failRate := flag.Float64("fail-rate", 0, "share of commands to report as failed (0-1)")
+    silent := flag.Bool("silent", false, "never acknowledge commands, to exercise timeouts")
+    lat := flag.Float64("lat", 1.2834, "reported latitude")
+    lng := flag.Float64("lng", 103.8607, "reported longitude")
+    level := flag.Int("battery", 80, "reported battery level")
+    flag.Parse()
+
+    if *deviceID == "" || *key == "" {
+        log.Fatal("-device and -key are required")
+    }
+
+    a := &agent{
+        baseURL:  *baseURL,
+        deviceID: *deviceID,
+        key:      *key,
+        client:   &http.Client{Timeout: 10 * time.Second},
+        state: reading{
+            Latitude:     *lat,
+            Longitude:    *lng,
+            BatteryLevel: *level,
+            DoorsLocked:  true,
+        },
+    }
+
+    log.Printf("Simulated agent %s polling %s", a.deviceID, a.baseURL)
+    a.report()
+
+    pollTicker := time.NewTicker(*poll)
+    telemetryTicker := time.NewTicker(*interval)
+    for {
+        select {
+        case <-pollTicker.C:
+            var commands []command
+            if err := a.do("GET", "/api/device/commands", nil, &commands); err != nil {
+                log.Printf("Polling failed: %v", err)
+                continue
+            }
+            for _, c := range commands {
+                a.handle(c, *delay, *failRate, *silent)
+            }
+        case <-telemetryTicker.C:
+            a.report()
+        }
+    }
+}
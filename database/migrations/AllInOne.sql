@@ -1,126 +1,792 @@
-SQL Script--
-User Service--------
-create table
-  public.users (
-    id serial not null,
-    email character varying(255) not null,
-    phone_number character varying(20) null,
-    password_hash character varying(255) not null,
-    membership_tier character varying(20) null default 'Basic'::character varying,
-    created_at timestamp without time zone null default current_timestamp,
-    constraint users_pkey primary key (id),
-    constraint users_email_key unique (email)
-  ) tablespace pg_default;
-
-
-Vehicle Service--------
-create table
-  public.vehicles (
-    id serial not null,
-    model character varying(100) not null,
-    type character varying(50) not null,
-    license_plate character varying(20) not null,
-    status character varying(20) not null default 'available'::character varying,
-    location character varying(255) null,
-    battery_level integer null,
-    cleanliness_status character varying(20) null default 'clean'::character varying,
-    created_at timestamp without time zone null default current_timestamp,
-    last_status_update timestamp without time zone null default current_timestamp,
-    hourly_rate numeric(10, 2) not null default 9.00,
-    constraint vehicles_pkey primary key (id),
-    constraint vehicles_license_plate_key unique (license_plate),
-    constraint vehicles_battery_level_check check (
-      (
-        (battery_level >= 0)
-        and (battery_level <= 100)
-      )
-    )
-  ) tablespace pg_default;
-
-create index if not exists idx_vehicles_status on public.vehicles using btree (status) tablespace pg_default;
-
-(Insert Vehicle Data)
-WITH inserted_vehicles AS (
-    INSERT INTO vehicles (model, type, license_plate, status, location, battery_level, cleanliness_status) 
-    VALUES
-        ('Tesla Model 3', 'Electric Sedan', 'SGP1234A', 'available', 'Marina Bay Sands', 90, 'clean'),
-        ('Tesla Model Y', 'Electric SUV', 'SGP5678B', 'available', 'East Coast Park', 85, 'clean'),
-        ('Nissan Leaf', 'Electric Hatchback', 'SGP9012C', 'available', 'ION Orchard', 75, 'clean'),
-        ('BYD Atto 3', 'Electric SUV', 'SGP3456D', 'maintenance', 'Bugis Junction', 30, 'needs_cleaning'),
-        ('Tesla Model Y', 'Electric SUV', 'SGP7890E', 'available', 'JEM Jurong East', 95, 'clean'),
-        ('Hyundai Kona Electric', 'Electric SUV', 'SGP2345F', 'available', 'Kallang Wave Mall', 88, 'clean'),
-        ('Kia EV6', 'Electric Crossover', 'SGP6789G', 'available', 'Somerset 313', 92, 'clean'),
-        ('BYD Seal', 'Electric Sedan', 'SGP0123H', 'charging', 'Tampines Mall', 15, 'clean'),
-        ('MG4', 'Electric Hatchback', 'SGP4567J', 'available', 'AMK Hub', 87, 'clean'),
-        ('Tesla Model 3', 'Electric Sedan', 'SGP8901K', 'available', 'Clementi Mall', 83, 'needs_cleaning')
-    RETURNING id, model
-)
-
-create table
-  public.bookings (
-    id serial not null,
-    user_id integer not null,
-    vehicle_id integer not null,
-    start_time timestamp without time zone not null,
-    end_time timestamp without time zone not null,
-    status character varying(20) not null default 'pending'::character varying,
-    created_at timestamp without time zone null default current_timestamp,
-    updated_at timestamp without time zone null default current_timestamp,
-    total_cost numeric(10, 2) null,
-    constraint bookings_pkey primary key (id),
-    constraint bookings_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
-    constraint valid_time_range check ((end_time > start_time))
-  ) tablespace pg_default;
-
-create index if not exists idx_bookings_user_id on public.bookings using btree (user_id) tablespace pg_default;
-
-create index if not exists idx_bookings_vehicle_id on public.bookings using btree (vehicle_id) tablespace pg_default;
-
-create index if not exists idx_bookings_status on public.bookings using btree (status) tablespace pg_default;
-
-create trigger create_invoice_after_booking
-after insert on bookings for each row
-execute function create_invoice_for_booking ();
-
-create table
-  public.pricing_tiers (
-    id serial not null,
-    name character varying(50) not null,
-    hourly_rate numeric(10, 2) not null,
-    discount numeric(5, 2) not null,
-    created_at timestamp without time zone null default current_timestamp,
-    constraint pricing_tiers_pkey primary key (id),
-    constraint pricing_tiers_name_key unique (name),
-    constraint valid_discount check (
-      (
-        (discount >= (0)::numeric)
-        and (discount <= (100)::numeric)
-      )
-    )
-  ) tablespace pg_default;
-
-INSERT INTO public.pricing_tiers (name, hourly_rate, discount) VALUES
-    ('Basic', 9.00, 0.00),    -- 0% discount
-    ('Premium', 9.00, 11.11), -- $1 off = ~11.11% discount on $9
-    ('VIP', 9.00, 22.22);     -- $2 off = ~22.22% discount on $9
-
-Billing Service ----------
-create table
-  public.invoices (
-    id serial not null,
-    booking_id integer not null,
-    user_id integer not null,
-    amount numeric(10, 2) not null,
-    discount_amount numeric(10, 2) not null default 0.00,
-    final_amount numeric(10, 2) not null,
-    status character varying(20) not null default 'pending'::character varying,
-    created_at timestamp without time zone null default current_timestamp,
-    updated_at timestamp without time zone null default current_timestamp,
-    constraint invoices_pkey primary key (id),
-    constraint invoices_booking_id_fkey foreign key (booking_id) references bookings (id),
-    constraint invoices_user_id_fkey foreign key (user_id) references users (id)
-  ) tablespace pg_default;
-
-create index if not exists idx_invoices_user_id on public.invoices using btree (user_id) tablespace pg_default;
-
-create index if not exists idx_invoices_status on public.invoices using btree (status) tablespace pg_default;
+SQL Script--
+User Service--------
+create table
+  public.users (
+    id serial not null,
+    email character varying(255) not null,
+    pending_email character varying(255) null,
+    email_change_token_hash character(64) null,
+    email_change_expires_at timestamp without time zone null,
+    phone_number character varying(20) null,
+    password_hash character varying(255) not null,
+    membership_tier character varying(20) null default 'Basic'::character varying,
+    role character varying(20) not null default 'member'::character varying,
+    licence_number character varying(20) null,
+    licence_class character varying(10) null,
+    licence_expiry date null,
+    licence_country character(2) null,
+    verification_status character varying(20) not null default 'unverified'::character varying,
+    verification_note text null,
+    verified_at timestamp without time zone null,
+    reviewed_by integer null,
+    status character varying(20) not null default 'active'::character varying,
+    deleted_at timestamp without time zone null,
+    data_retention_until timestamp without time zone null,
+    payment_methods_removed_at timestamp without time zone null,
+    created_at timestamp without time zone null default current_timestamp,
+    constraint users_pkey primary key (id),
+    constraint users_email_key unique (email),
+    constraint users_reviewed_by_fkey foreign key (reviewed_by) references users (id),
+    constraint users_role_check check (
+      (role)::text = any (array['member'::text, 'operator'::text])
+    ),
+    constraint users_status_check check (
+      (status)::text = any (array['active'::text, 'suspended'::text, 'deleted'::text])
+    ),
+    constraint users_verification_status_check check (
+      (verification_status)::text = any (
+        array['unverified'::text, 'pending'::text, 'verified'::text, 'rejected'::text]
+      )
+    )
+  ) tablespace pg_default;
+
+create unique index if not exists idx_users_email_change_token on public.users using btree (email_change_token_hash) tablespace pg_default;
+
+create index if not exists idx_users_verification_status on public.users using btree (verification_status) tablespace pg_default;
+
+create table
+  public.licence_documents (
+    id serial not null,
+    user_id integer not null,
+    kind character varying(20) not null,
+    storage_key character varying(255) not null,
+    content_type character varying(50) not null,
+    size_bytes bigint not null,
+    created_at timestamp without time zone null default current_timestamp,
+    constraint licence_documents_pkey primary key (id),
+    constraint licence_documents_user_id_fkey foreign key (user_id) references users (id),
+    constraint licence_documents_kind_check check (
+      (kind)::text = any (array['licence_front'::text, 'licence_back'::text, 'identity'::text])
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_licence_documents_user_id on public.licence_documents using btree (user_id) tablespace pg_default;
+
+create table
+  public.user_identities (
+    id serial not null,
+    user_id integer not null,
+    issuer character varying(255) not null,
+    subject character varying(255) not null,
+    email character varying(255) null,
+    created_at timestamp without time zone null default current_timestamp,
+    constraint user_identities_pkey primary key (id),
+    constraint user_identities_issuer_subject_key unique (issuer, subject),
+    constraint user_identities_user_id_fkey foreign key (user_id) references users (id)
+  ) tablespace pg_default;
+
+create index if not exists idx_user_identities_user_id on public.user_identities using btree (user_id) tablespace pg_default;
+
+create table
+  public.oauth_clients (
+    client_id character varying(64) not null,
+    name character varying(100) not null,
+    redirect_uris text[] not null,
+    created_by integer null,
+    created_at timestamp without time zone null default current_timestamp,
+    constraint oauth_clients_pkey primary key (client_id),
+    constraint oauth_clients_created_by_fkey foreign key (created_by) references users (id)
+  ) tablespace pg_default;
+
+create table
+  public.oauth_authorization_codes (
+    code_hash character(64) not null,
+    client_id character varying(64) not null,
+    user_id integer not null,
+    redirect_uri text not null,
+    code_challenge character varying(128) not null,
+    scope character varying(255) null,
+    expires_at timestamp without time zone not null,
+    used_at timestamp without time zone null,
+    created_at timestamp without time zone null default current_timestamp,
+    constraint oauth_authorization_codes_pkey primary key (code_hash),
+    constraint oauth_authorization_codes_client_id_fkey foreign key (client_id) references oauth_clients (client_id),
+    constraint oauth_authorization_codes_user_id_fkey foreign key (user_id) references users (id)
+  ) tablespace pg_default;
+
+
+Vehicle Service--------
+create table
+  public.stations (
+    id serial not null,
+    name character varying(100) not null,
+    address character varying(255) not null default ''::character varying,
+    latitude double precision not null,
+    longitude double precision not null,
+    capacity integer not null,
+    charger_count integer not null default 0,
+    opens_at time without time zone null,
+    closes_at time without time zone null,
+    created_at timestamp without time zone null default current_timestamp,
+    constraint stations_pkey primary key (id),
+    constraint stations_name_key unique (name),
+    constraint stations_capacity_check check ((capacity > 0)),
+    constraint stations_charger_count_check check (
+      (
+        (charger_count >= 0)
+        and (charger_count <= capacity)
+      )
+    ),
+    constraint stations_opening_hours_check check (((opens_at is null) = (closes_at is null)))
+  ) tablespace pg_default;
+
+create table
+  public.vehicle_specs (
+    model character varying(100) not null,
+    battery_capacity_kwh numeric(6, 2) not null,
+    consumption_kwh_per_100km numeric(5, 2) not null,
+    constraint vehicle_specs_pkey primary key (model),
+    constraint vehicle_specs_battery_capacity_kwh_check check ((battery_capacity_kwh > 0)),
+    constraint vehicle_specs_consumption_kwh_per_100km_check check ((consumption_kwh_per_100km > 0))
+  ) tablespace pg_default;
+
+(Insert Vehicle Spec Data)
+INSERT INTO vehicle_specs (model, battery_capacity_kwh, consumption_kwh_per_100km)
+VALUES
+    ('Tesla Model 3', 57.50, 14.50),
+    ('Tesla Model Y', 75.00, 16.50),
+    ('Nissan Leaf', 40.00, 17.00),
+    ('BYD Atto 3', 60.48, 16.00),
+    ('Hyundai Kona Electric', 64.00, 15.50),
+    ('Kia EV6', 77.40, 17.50),
+    ('BYD Seal', 82.50, 16.00),
+    ('MG4', 64.00, 16.00);
+
+create table
+  public.vehicles (
+    id serial not null,
+    model character varying(100) not null,
+    type character varying(50) not null,
+    license_plate character varying(20) not null,
+    status character varying(20) not null default 'available'::character varying,
+    location character varying(255) null,
+    latitude double precision null,
+    longitude double precision null,
+    battery_level integer null,
+    cleanliness_status character varying(20) null default 'clean'::character varying,
+    odometer_km numeric(10, 1) null,
+    doors_locked boolean null,
+    last_cleaned_at timestamp without time zone null,
+    decommissioned_at timestamp without time zone null,
+    decommission_reason text null,
+    created_at timestamp without time zone null default current_timestamp,
+    last_status_update timestamp without time zone null default current_timestamp,
+    hourly_rate numeric(10, 2) not null default 9.00,
+    home_station_id integer null,
+    vehicle_class character varying(20) not null default 'standard'::character varying,
+    constraint vehicles_pkey primary key (id),
+    constraint vehicles_home_station_id_fkey foreign key (home_station_id) references stations (id),
+    constraint vehicles_license_plate_key unique (license_plate),
+    constraint vehicles_battery_level_check check (
+      (
+        (battery_level >= 0)
+        and (battery_level <= 100)
+      )
+    ),
+    constraint vehicles_coordinates_check check (
+      (
+        (latitude is null and longitude is null)
+        or (
+          (latitude between -90 and 90)
+          and (longitude between -180 and 180)
+        )
+      )
+    ),
+    constraint vehicles_vehicle_class_check check (
+      (
+        (vehicle_class)::text = any (
+          array[
+            ('standard'::character varying)::text,
+            ('premium'::character varying)::text,
+            ('luxury'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_vehicles_status on public.vehicles using btree (status) tablespace pg_default;
+
+create index if not exists idx_vehicles_coordinates on public.vehicles using btree (latitude, longitude) tablespace pg_default;
+
+create index if not exists idx_vehicles_home_station_id on public.vehicles using btree (home_station_id) tablespace pg_default;
+
+create table
+  public.charging_sessions (
+    id serial not null,
+    vehicle_id integer not null,
+    charger_id character varying(50) not null,
+    station_id integer null,
+    power_kw numeric(6, 2) not null,
+    start_level integer not null,
+    end_level integer null,
+    energy_kwh numeric(8, 2) not null default 0.00,
+    expected_ready_at timestamp without time zone null,
+    started_at timestamp without time zone not null default current_timestamp,
+    ended_at timestamp without time zone null,
+    constraint charging_sessions_pkey primary key (id),
+    constraint charging_sessions_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint charging_sessions_station_id_fkey foreign key (station_id) references stations (id),
+    constraint charging_sessions_power_kw_check check ((power_kw > 0)),
+    constraint charging_sessions_levels_check check (
+      (
+        (start_level between 0 and 100)
+        and (end_level is null or end_level between 0 and 100)
+      )
+    )
+  ) tablespace pg_default;
+
+create unique index if not exists idx_charging_sessions_open_vehicle on public.charging_sessions using btree (vehicle_id) tablespace pg_default
+where
+  (ended_at is null);
+
+create unique index if not exists idx_charging_sessions_open_charger on public.charging_sessions using btree (charger_id) tablespace pg_default
+where
+  (ended_at is null);
+
+create table
+  public.vehicle_status_history (
+    id bigserial not null,
+    vehicle_id integer not null,
+    recorded_at timestamp without time zone not null default current_timestamp,
+    source character varying(20) not null default 'status'::character varying,
+    location character varying(255) null,
+    latitude double precision null,
+    longitude double precision null,
+    battery_level integer null,
+    cleanliness_status character varying(20) null,
+    odometer_km numeric(10, 1) null,
+    speed_kmh numeric(5, 1) null,
+    doors_locked boolean null,
+    constraint vehicle_status_history_pkey primary key (id),
+    constraint vehicle_status_history_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint vehicle_status_history_source_check check (
+      (
+        (source)::text = any (
+          array[
+            ('status'::character varying)::text,
+            ('device'::character varying)::text,
+            ('cleaning'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_vehicle_status_history_vehicle_time on public.vehicle_status_history using btree (vehicle_id, recorded_at) tablespace pg_default;
+
+create table
+  public.vehicle_devices (
+    device_id character varying(64) not null,
+    vehicle_id integer not null,
+    key_hash character(64) not null,
+    created_at timestamp without time zone not null default current_timestamp,
+    last_seen_at timestamp without time zone null,
+    constraint vehicle_devices_pkey primary key (device_id),
+    constraint vehicle_devices_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id)
+  ) tablespace pg_default;
+
+create index if not exists idx_vehicle_devices_vehicle_id on public.vehicle_devices using btree (vehicle_id) tablespace pg_default;
+
+create table
+  public.vehicle_commands (
+    id serial not null,
+    vehicle_id integer not null,
+    booking_id integer null,
+    user_id integer null,
+    command character varying(20) not null,
+    status character varying(20) not null default 'pending'::character varying,
+    error text null,
+    created_at timestamp without time zone not null default current_timestamp,
+    sent_at timestamp without time zone null,
+    acknowledged_at timestamp without time zone null,
+    expires_at timestamp without time zone not null,
+    constraint vehicle_commands_pkey primary key (id),
+    constraint vehicle_commands_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint vehicle_commands_command_check check (
+      (
+        (command)::text = any (
+          array[
+            ('lock'::character varying)::text,
+            ('unlock'::character varying)::text
+          ]
+        )
+      )
+    ),
+    constraint vehicle_commands_status_check check (
+      (
+        (status)::text = any (
+          array[
+            ('pending'::character varying)::text,
+            ('sent'::character varying)::text,
+            ('acknowledged'::character varying)::text,
+            ('failed'::character varying)::text,
+            ('expired'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_vehicle_commands_vehicle_status on public.vehicle_commands using btree (vehicle_id, status) tablespace pg_default;
+
+create index if not exists idx_vehicle_commands_booking_id on public.vehicle_commands using btree (booking_id) tablespace pg_default;
+
+(Insert Vehicle Data)
+WITH inserted_vehicles AS (
+    INSERT INTO vehicles (model, type, license_plate, status, location, latitude, longitude, battery_level, cleanliness_status, vehicle_class) 
+    VALUES
+        ('Tesla Model 3', 'Electric Sedan', 'SGP1234A', 'available', 'Marina Bay Sands', 1.2834, 103.8607, 90, 'clean', 'standard'),
+        ('Tesla Model Y', 'Electric SUV', 'SGP5678B', 'available', 'East Coast Park', 1.3008, 103.9122, 85, 'clean', 'premium'),
+        ('Nissan Leaf', 'Electric Hatchback', 'SGP9012C', 'available', 'ION Orchard', 1.3040, 103.8318, 75, 'clean', 'standard'),
+        ('BYD Atto 3', 'Electric SUV', 'SGP3456D', 'maintenance', 'Bugis Junction', 1.2993, 103.8555, 30, 'needs_cleaning', 'standard'),
+        ('Tesla Model Y', 'Electric SUV', 'SGP7890E', 'available', 'JEM Jurong East', 1.3332, 103.7436, 95, 'clean', 'premium'),
+        ('Hyundai Kona Electric', 'Electric SUV', 'SGP2345F', 'available', 'Kallang Wave Mall', 1.3026, 103.8751, 88, 'clean', 'standard'),
+        ('Kia EV6', 'Electric Crossover', 'SGP6789G', 'available', 'Somerset 313', 1.3014, 103.8384, 92, 'clean', 'premium'),
+        ('BYD Seal', 'Electric Sedan', 'SGP0123H', 'charging', 'Tampines Mall', 1.3526, 103.9447, 15, 'clean', 'luxury'),
+        ('MG4', 'Electric Hatchback', 'SGP4567J', 'available', 'AMK Hub', 1.3692, 103.8484, 87, 'clean', 'standard'),
+        ('Tesla Model 3', 'Electric Sedan', 'SGP8901K', 'available', 'Clementi Mall', 1.3150, 103.7649, 83, 'needs_cleaning', 'standard')
+    RETURNING id, model
+)
+
+create table
+  public.booking_series (
+    id serial not null,
+    user_id integer not null,
+    vehicle_id integer not null,
+    recurrence character varying(255) not null,
+    start_time timestamp without time zone not null,
+    end_time timestamp without time zone not null,
+    return_station_id integer null,
+    status character varying(20) not null default 'active'::character varying,
+    created_at timestamp without time zone not null default current_timestamp,
+    constraint booking_series_pkey primary key (id),
+    constraint booking_series_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint booking_series_return_station_id_fkey foreign key (return_station_id) references stations (id),
+    constraint booking_series_time_range check ((end_time > start_time)),
+    constraint booking_series_status_check check (
+      (
+        (status)::text = any (
+          array[
+            ('active'::character varying)::text,
+            ('cancelled'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_booking_series_user_id on public.booking_series using btree (user_id) tablespace pg_default;
+
+create table
+  public.calendar_tokens (
+    user_id integer not null,
+    token_hash character(64) not null,
+    created_at timestamp without time zone not null default current_timestamp,
+    constraint calendar_tokens_pkey primary key (user_id),
+    constraint calendar_tokens_token_hash_key unique (token_hash)
+  ) tablespace pg_default;
+
+create table
+  public.bookings (
+    id serial not null,
+    user_id integer not null,
+    vehicle_id integer not null,
+    start_time timestamp without time zone not null,
+    end_time timestamp without time zone not null,
+    status character varying(20) not null default 'pending'::character varying,
+    created_at timestamp without time zone null default current_timestamp,
+    updated_at timestamp without time zone null default current_timestamp,
+    total_cost numeric(10, 2) null,
+    pickup_station_id integer null,
+    return_station_id integer null,
+    dropoff_fee numeric(10, 2) not null default 0.00,
+    planned_distance_km numeric(7, 1) null,
+    cancelled_at timestamp without time zone null,
+    cancellation_fee numeric(10, 2) null,
+    overdue_at timestamp without time zone null,
+    returned_at timestamp without time zone null,
+    late_fee numeric(10, 2) null,
+    delay_warned_at timestamp without time zone null,
+    series_id integer null,
+    rehomed_at timestamp without time zone null,
+    constraint bookings_pkey primary key (id),
+    constraint bookings_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint bookings_pickup_station_id_fkey foreign key (pickup_station_id) references stations (id),
+    constraint bookings_return_station_id_fkey foreign key (return_station_id) references stations (id),
+    constraint bookings_series_id_fkey foreign key (series_id) references booking_series (id),
+    constraint valid_time_range check ((end_time > start_time))
+  ) tablespace pg_default;
+
+create index if not exists idx_bookings_user_id on public.bookings using btree (user_id) tablespace pg_default;
+
+create index if not exists idx_bookings_vehicle_id on public.bookings using btree (vehicle_id) tablespace pg_default;
+
+create index if not exists idx_bookings_status on public.bookings using btree (status) tablespace pg_default;
+
+create index if not exists idx_bookings_series_id on public.bookings using btree (series_id) tablespace pg_default;
+
+create trigger create_invoice_after_booking
+after insert on bookings for each row
+execute function create_invoice_for_booking ();
+
+create table
+  public.booking_amendments (
+    id serial not null,
+    booking_id integer not null,
+    kind character varying(20) not null,
+    previous_start_time timestamp without time zone not null,
+    previous_end_time timestamp without time zone not null,
+    new_start_time timestamp without time zone not null,
+    new_end_time timestamp without time zone not null,
+    previous_estimate numeric(10, 2) null,
+    new_estimate numeric(10, 2) null,
+    amended_by integer not null,
+    created_at timestamp without time zone not null default current_timestamp,
+    constraint booking_amendments_pkey primary key (id),
+    constraint booking_amendments_booking_id_fkey foreign key (booking_id) references bookings (id),
+    constraint booking_amendments_kind_check check (
+      (
+        (kind)::text = any (
+          array[
+            ('extension'::character varying)::text,
+            ('reschedule'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_booking_amendments_booking_id on public.booking_amendments using btree (booking_id) tablespace pg_default;
+
+create table
+  public.waitlist_entries (
+    id serial not null,
+    user_id integer not null,
+    start_time timestamp without time zone not null,
+    end_time timestamp without time zone not null,
+    station_id integer null,
+    latitude double precision null,
+    longitude double precision null,
+    radius_km numeric(5, 2) null,
+    vehicle_type character varying(50) null,
+    vehicle_classes text[] null,
+    status character varying(20) not null default 'waiting'::character varying,
+    held_vehicle_id integer null,
+    hold_expires_at timestamp without time zone null,
+    booking_id integer null,
+    created_at timestamp without time zone not null default current_timestamp,
+    constraint waitlist_entries_pkey primary key (id),
+    constraint waitlist_entries_station_id_fkey foreign key (station_id) references stations (id),
+    constraint waitlist_entries_held_vehicle_id_fkey foreign key (held_vehicle_id) references vehicles (id),
+    constraint waitlist_entries_booking_id_fkey foreign key (booking_id) references bookings (id),
+    constraint waitlist_entries_time_range check ((end_time > start_time)),
+    constraint waitlist_entries_place_check check (
+      (
+        (station_id is not null and latitude is null and longitude is null)
+        or (
+          station_id is null
+          and latitude is not null
+          and longitude is not null
+          and radius_km is not null
+        )
+      )
+    ),
+    constraint waitlist_entries_status_check check (
+      (
+        (status)::text = any (
+          array[
+            ('waiting'::character varying)::text,
+            ('held'::character varying)::text,
+            ('booked'::character varying)::text,
+            ('expired'::character varying)::text,
+            ('cancelled'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_waitlist_entries_status_created_at on public.waitlist_entries using btree (status, created_at) tablespace pg_default;
+
+create index if not exists idx_waitlist_entries_user_id on public.waitlist_entries using btree (user_id) tablespace pg_default;
+
+create index if not exists idx_waitlist_entries_held_vehicle_id on public.waitlist_entries using btree (held_vehicle_id) tablespace pg_default
+where
+  ((status)::text = 'held'::text);
+
+create table
+  public.damage_reports (
+    id serial not null,
+    booking_id integer not null,
+    vehicle_id integer not null,
+    user_id integer not null,
+    stage character varying(10) not null,
+    description text not null,
+    drivable boolean not null default true,
+    created_at timestamp without time zone not null default current_timestamp,
+    constraint damage_reports_pkey primary key (id),
+    constraint damage_reports_booking_id_fkey foreign key (booking_id) references bookings (id),
+    constraint damage_reports_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint damage_reports_stage_check check (
+      (
+        (stage)::text = any (
+          array[
+            ('start'::character varying)::text,
+            ('end'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_damage_reports_vehicle_id on public.damage_reports using btree (vehicle_id) tablespace pg_default;
+
+create table
+  public.damage_report_photos (
+    id serial not null,
+    report_id integer not null,
+    storage_key text not null,
+    content_type character varying(50) not null,
+    size_bytes bigint not null,
+    constraint damage_report_photos_pkey primary key (id),
+    constraint damage_report_photos_report_id_fkey foreign key (report_id) references damage_reports (id) on delete cascade
+  ) tablespace pg_default;
+
+create index if not exists idx_damage_report_photos_report_id on public.damage_report_photos using btree (report_id) tablespace pg_default;
+
+create table
+  public.maintenance_tickets (
+    id serial not null,
+    vehicle_id integer not null,
+    reason text not null,
+    severity character varying(10) not null,
+    status character varying(20) not null default 'open'::character varying,
+    reported_by integer not null,
+    damage_report_id integer null,
+    assigned_technician character varying(100) null,
+    expected_return_at timestamp without time zone null,
+    resolution_notes text null,
+    created_at timestamp without time zone not null default current_timestamp,
+    resolved_at timestamp without time zone null,
+    constraint maintenance_tickets_pkey primary key (id),
+    constraint maintenance_tickets_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint maintenance_tickets_damage_report_id_fkey foreign key (damage_report_id) references damage_reports (id),
+    constraint maintenance_tickets_severity_check check (
+      (
+        (severity)::text = any (
+          array[
+            ('low'::character varying)::text,
+            ('medium'::character varying)::text,
+            ('high'::character varying)::text,
+            ('critical'::character varying)::text
+          ]
+        )
+      )
+    ),
+    constraint maintenance_tickets_status_check check (
+      (
+        (status)::text = any (
+          array[
+            ('open'::character varying)::text,
+            ('in_progress'::character varying)::text,
+            ('resolved'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create index if not exists idx_maintenance_tickets_vehicle_status on public.maintenance_tickets using btree (vehicle_id, status) tablespace pg_default;
+
+create table
+  public.cleaning_tasks (
+    id serial not null,
+    vehicle_id integer not null,
+    reason character varying(20) not null,
+    status character varying(20) not null default 'open'::character varying,
+    assigned_to integer null,
+    booking_id integer null,
+    notes text null,
+    created_at timestamp without time zone not null default current_timestamp,
+    started_at timestamp without time zone null,
+    completed_at timestamp without time zone null,
+    constraint cleaning_tasks_pkey primary key (id),
+    constraint cleaning_tasks_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint cleaning_tasks_booking_id_fkey foreign key (booking_id) references bookings (id),
+    constraint cleaning_tasks_reason_check check (
+      (
+        (reason)::text = any (
+          array[
+            ('flagged'::character varying)::text,
+            ('rating'::character varying)::text,
+            ('trip_count'::character varying)::text
+          ]
+        )
+      )
+    ),
+    constraint cleaning_tasks_status_check check (
+      (
+        (status)::text = any (
+          array[
+            ('open'::character varying)::text,
+            ('in_progress'::character varying)::text,
+            ('completed'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create unique index if not exists idx_cleaning_tasks_open_vehicle on public.cleaning_tasks using btree (vehicle_id) tablespace pg_default
+where
+  (status != 'completed');
+
+create table
+  public.cleaning_task_photos (
+    id serial not null,
+    task_id integer not null,
+    storage_key text not null,
+    content_type character varying(50) not null,
+    size_bytes bigint not null,
+    constraint cleaning_task_photos_pkey primary key (id),
+    constraint cleaning_task_photos_task_id_fkey foreign key (task_id) references cleaning_tasks (id) on delete cascade
+  ) tablespace pg_default;
+
+create index if not exists idx_cleaning_task_photos_task_id on public.cleaning_task_photos using btree (task_id) tablespace pg_default;
+
+create table
+  public.cleanliness_ratings (
+    id serial not null,
+    booking_id integer not null,
+    vehicle_id integer not null,
+    user_id integer not null,
+    rating integer not null,
+    comment text null,
+    created_at timestamp without time zone not null default current_timestamp,
+    constraint cleanliness_ratings_pkey primary key (id),
+    constraint cleanliness_ratings_booking_id_key unique (booking_id),
+    constraint cleanliness_ratings_booking_id_fkey foreign key (booking_id) references bookings (id),
+    constraint cleanliness_ratings_vehicle_id_fkey foreign key (vehicle_id) references vehicles (id),
+    constraint cleanliness_ratings_rating_check check (((rating >= 1) and (rating <= 5)))
+  ) tablespace pg_default;
+
+create index if not exists idx_cleanliness_ratings_vehicle_id on public.cleanliness_ratings using btree (vehicle_id) tablespace pg_default;
+
+create table
+  public.pricing_tiers (
+    id serial not null,
+    name character varying(50) not null,
+    hourly_rate numeric(10, 2) not null,
+    discount numeric(5, 2) not null,
+    created_at timestamp without time zone null default current_timestamp,
+    constraint pricing_tiers_pkey primary key (id),
+    constraint pricing_tiers_name_key unique (name),
+    constraint valid_discount check (
+      (
+        (discount >= (0)::numeric)
+        and (discount <= (100)::numeric)
+      )
+    )
+  ) tablespace pg_default;
+
+INSERT INTO public.pricing_tiers (name, hourly_rate, discount) VALUES
+    ('Basic', 9.00, 0.00),    -- 0% discount
+    ('Premium', 9.00, 11.11), -- $1 off = ~11.11% discount on $9
+    ('VIP', 9.00, 22.22);     -- $2 off = ~22.22% discount on $9
+
+create table
+  public.cancellation_policies (
+    id serial not null,
+    membership_tier character varying(50) not null,
+    free_hours_before numeric(5, 2) not null,
+    late_fee_percent numeric(5, 2) not null,
+    no_show_fee_percent numeric(5, 2) not null,
+    updated_at timestamp without time zone null default current_timestamp,
+    constraint cancellation_policies_pkey primary key (id),
+    constraint cancellation_policies_membership_tier_key unique (membership_tier),
+    constraint cancellation_policies_membership_tier_fkey foreign key (membership_tier) references pricing_tiers (name),
+    constraint valid_cancellation_fees check (
+      (
+        (free_hours_before >= (0)::numeric)
+        and (late_fee_percent >= (0)::numeric)
+        and (late_fee_percent <= (100)::numeric)
+        and (no_show_fee_percent >= (0)::numeric)
+        and (no_show_fee_percent <= (100)::numeric)
+      )
+    )
+  ) tablespace pg_default;
+
+INSERT INTO public.cancellation_policies (membership_tier, free_hours_before, late_fee_percent, no_show_fee_percent) VALUES
+    ('Basic', 24.00, 50.00, 100.00),
+    ('Premium', 12.00, 50.00, 100.00),
+    ('VIP', 2.00, 25.00, 100.00);
+
+create table
+  public.booking_policies (
+    id serial not null,
+    membership_tier character varying(50) not null,
+    max_duration_hours numeric(6, 2) not null,
+    max_advance_days integer not null,
+    max_active_bookings integer not null,
+    vehicle_classes text[] not null default array['standard'::text],
+    updated_at timestamp without time zone null default current_timestamp,
+    constraint booking_policies_pkey primary key (id),
+    constraint booking_policies_membership_tier_key unique (membership_tier),
+    constraint booking_policies_membership_tier_fkey foreign key (membership_tier) references pricing_tiers (name),
+    constraint valid_booking_limits check (
+      (
+        (max_duration_hours > (0)::numeric)
+        and (max_advance_days >= 1)
+        and (max_active_bookings >= 1)
+        and (cardinality(vehicle_classes) >= 1)
+      )
+    )
+  ) tablespace pg_default;
+
+INSERT INTO public.booking_policies (membership_tier, max_duration_hours, max_advance_days, max_active_bookings, vehicle_classes) VALUES
+    ('Basic', 24.00, 14, 3, array['standard']),
+    ('Premium', 72.00, 30, 10, array['standard', 'premium']),
+    ('VIP', 168.00, 90, 30, array['standard', 'premium', 'luxury']);
+
+Billing Service ----------
+create table
+  public.invoices (
+    id serial not null,
+    booking_id integer not null,
+    user_id integer not null,
+    amount numeric(10, 2) not null,
+    discount_amount numeric(10, 2) not null default 0.00,
+    dropoff_fee numeric(10, 2) not null default 0.00,
+    final_amount numeric(10, 2) not null,
+    status character varying(20) not null default 'pending'::character varying,
+    kind character varying(20) not null default 'booking'::character varying,
+    created_at timestamp without time zone null default current_timestamp,
+    updated_at timestamp without time zone null default current_timestamp,
+    constraint invoices_pkey primary key (id),
+    constraint invoices_booking_id_fkey foreign key (booking_id) references bookings (id),
+    constraint invoices_user_id_fkey foreign key (user_id) references users (id),
+    constraint invoices_kind_check check (
+      (
+        (kind)::text = any (
+          array[
+            ('booking'::character varying)::text,
+            ('cancellation_fee'::character varying)::text,
+            ('late_return_fee'::character varying)::text
+          ]
+        )
+      )
+    )
+  ) tablespace pg_default;
+
+create unique index if not exists idx_invoices_cancellation_fee on public.invoices using btree (booking_id) tablespace pg_default
+where
+  ((kind)::text = 'cancellation_fee'::text);
+
+create unique index if not exists idx_invoices_late_return_fee on public.invoices using btree (booking_id) tablespace pg_default
+where
+  ((kind)::text = 'late_return_fee'::text);
+
+create index if not exists idx_invoices_user_id on public.invoices using btree (user_id) tablespace pg_default;
+
+create index if not exists idx_invoices_status on public.invoices using btree (status) tablespace pg_default;
@@ -1,13 +1,13 @@
-module cnad-carsharinggo
-
-go 1.23.2
-
-require (
-	github.com/golang-jwt/jwt v3.2.2+incompatible
-	github.com/gorilla/handlers v1.5.2
-	github.com/gorilla/mux v1.8.1
-	github.com/lib/pq v1.10.9
-	golang.org/x/crypto v0.30.0
-)
-
-require github.com/felixge/httpsnoop v1.0.3 // indirect
+module cnad-carsharinggo
+
+go 1.23.2
+
+require (
+	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/gorilla/handlers v1.5.2
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.30.0
+)
+
+require github.com/felixge/httpsnoop v1.0.3 // indirect
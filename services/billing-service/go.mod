@@ -4,11 +4,10 @@ module billing-service
 go 1.21
 
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.9
-	golang.org/x/crypto v0.30.0
 )
 
 require github.com/felixge/httpsnoop v1.0.3 // indirect
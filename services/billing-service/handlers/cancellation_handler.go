@@ -0,0 +1,132 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "billing-service/models"
+    "billing-service/userclient"
+)
+
+// lists the cancellation policy of every membership tier
+func (h *BillingHandler) GetCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+    policies, err := h.repo.GetCancellationPolicies()
+    if err != nil {
+        sendError(w, fmt.Sprintf("Error retrieving cancellation policies: %v", err), http.StatusInternalServerError)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, models.Response{
+        Success: true,
+        Data:    policies,
+    })
+}
+
+// priceCancellation reads a cancellation request and prices it under the
+// booking owner's membership tier. A waived cancellation, made by the
+// operator rather than the driver, costs nothing.
+func (h *BillingHandler) priceCancellation(w http.ResponseWriter, r *http.Request) (*models.CancellationFee, bool) {
+    var req struct {
+        BookingID   int    `json:"booking_id"`
+        UserID      int    `json:"user_id"`
+        CancelledAt string `json:"cancelled_at"`
+        NoShow      bool   `json:"no_show"`
+        Waived      bool   `json:"waived"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendError(w, "Invalid request body", http.StatusBadRequest)
+        return nil, false
+    }
+
+    cancelledAt := time.Now()
+    if req.CancelledAt != "" {
+        var err error
+        cancelledAt, err = time.Parse(time.RFC3339, req.CancelledAt)
+        if err != nil {
+            sendError(w, "Invalid cancellation time format", http.StatusBadRequest)
+            return nil, false
+        }
+    }
+
+    user, err := h.users.GetUser(r.Context(), req.UserID)
+    if err != nil {
+        if err == userclient.ErrNotFound {
+            sendError(w, "User not found", http.StatusNotFound)
+            return nil, false
+        }
+        sendError(w, fmt.Sprintf("Error getting user membership: %v", err), http.StatusBadGateway)
+        return nil, false
+    }
+
+    fee, err := h.repo.PriceCancellation(user.ID, user.MembershipTier, req.BookingID, cancelledAt, req.NoShow)
+    if err != nil {
+        status := http.StatusInternalServerError
+        if strings.HasSuffix(err.Error(), "not found") {
+            status = http.StatusNotFound
+        }
+        sendError(w, fmt.Sprintf("Error pricing cancellation: %v", err), status)
+        return nil, false
+    }
+    if req.Waived {
+        fee.Waived, fee.NoShow = true, false
+        fee.FeePercent, fee.Fee = 0, 0
+    }
+
+    return fee, true
+}
+
+// tells vehicle-service what cancelling a booking would cost, without charging
+func (h *BillingHandler) QuoteCancellation(w http.ResponseWriter, r *http.Request) {
+    fee, ok := h.priceCancellation(w, r)
+    if !ok {
+        return
+    }
+
+    sendJSON(w, http.StatusOK, models.Response{
+        Success: true,
+        Data:    fee,
+    })
+}
+
+// charges the cancellation fee for a booking vehicle-service is cancelling
+func (h *BillingHandler) ChargeCancellation(w http.ResponseWriter, r *http.Request) {
+    fee, ok := h.priceCancellation(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.repo.ChargeCancellation(fee); err != nil {
+        sendError(w, fmt.Sprintf("Error charging cancellation: %v", err), http.StatusInternalServerError)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, models.Response{
+        Success: true,
+        Data:    fee,
+    })
+}
+
+// undoes a cancellation charge when vehicle-service couldn't cancel the booking
+func (h *BillingHandler) RevertCancellation(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        BookingID int `json:"booking_id"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BookingID == 0 {
+        sendError(w, "booking_id is required", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.repo.RevertCancellation(req.BookingID); err != nil {
+        sendError(w, fmt.Sprintf("Error reverting cancellation: %v", err), http.StatusConflict)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, models.Response{
+        Success: true,
+        Data:    map[string]int{"booking_id": req.BookingID},
+    })
+}
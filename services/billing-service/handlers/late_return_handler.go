@@ -0,0 +1,57 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "billing-service/models"
+    "billing-service/userclient"
+)
+
+// charges a driver for returning a car after the booking ended
+func (h *BillingHandler) ChargeLateReturn(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        BookingID  int    `json:"booking_id"`
+        UserID     int    `json:"user_id"`
+        ReturnedAt string `json:"returned_at"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendError(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    returnedAt, err := time.Parse(time.RFC3339, req.ReturnedAt)
+    if err != nil {
+        sendError(w, "Invalid return time format", http.StatusBadRequest)
+        return
+    }
+
+    user, err := h.users.GetUser(r.Context(), req.UserID)
+    if err != nil {
+        if err == userclient.ErrNotFound {
+            sendError(w, "User not found", http.StatusNotFound)
+            return
+        }
+        sendError(w, fmt.Sprintf("Error getting user membership: %v", err), http.StatusBadGateway)
+        return
+    }
+
+    fee, err := h.repo.ChargeLateReturn(user.ID, user.MembershipTier, req.BookingID, returnedAt)
+    if err != nil {
+        status := http.StatusInternalServerError
+        if strings.HasSuffix(err.Error(), "not found") {
+            status = http.StatusNotFound
+        }
+        sendError(w, fmt.Sprintf("Error charging late return: %v", err), status)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, models.Response{
+        Success: true,
+        Data:    fee,
+    })
+}
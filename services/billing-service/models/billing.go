@@ -1,53 +1,94 @@
-package models
-
-import (
-    "time"
-)
-
-type PricingTier struct {
-    ID          int     `json:"id"`
-    Name        string  `json:"name"`  // Basic, Premium, VIP
-    HourlyRate  float64 `json:"hourly_rate"`
-    Discount    float64 `json:"discount"` // Percentage discount
-    CreatedAt   time.Time `json:"created_at"`
-}
-
-type Invoice struct {
-    ID             int       `json:"id"`
-    BookingID      int       `json:"booking_id"`
-    UserID         int       `json:"user_id"`
-    Amount         float64   `json:"amount"`
-    DiscountAmount float64   `json:"discount_amount"`
-    FinalAmount    float64   `json:"final_amount"`
-    Status         string    `json:"status"`
-    CreatedAt      time.Time `json:"created_at"`
-    UpdatedAt      time.Time `json:"updated_at"`
-    VehicleID      int       `json:"vehicle_id"`
-    VehicleModel   string    `json:"vehicle_model"`
-    MembershipTier string    `json:"membership_tier"`
-}
-
-type PaymentMethod struct {
-    ID          int       `json:"id"`
-    UserID      int       `json:"user_id"`
-    Type        string    `json:"type"`     // credit_card, debit_card, etc.
-    Provider    string    `json:"provider"` // visa, mastercard, etc.
-    LastFour    string    `json:"last_four"`
-    ExpiryDate  string    `json:"expiry_date"`
-    IsDefault   bool      `json:"is_default"`
-    CreatedAt   time.Time `json:"created_at"`
-}
-
-type BillingCalculation struct {
-    Duration        float64 `json:"duration"`        // in hours
-    BaseRate        float64 `json:"base_rate"`
-    MemberDiscount  float64 `json:"member_discount"`
-    FinalAmount     float64 `json:"final_amount"`
-}
-
-// Response wrapper for consistent API responses
-type Response struct {
-    Success bool        `json:"success"`
-    Data    interface{} `json:"data,omitempty"`
-    Error   string     `json:"error,omitempty"`
+package models
+
+import (
+    "time"
+)
+
+type PricingTier struct {
+    ID          int     `json:"id"`
+    Name        string  `json:"name"`  // Basic, Premium, VIP
+    HourlyRate  float64 `json:"hourly_rate"`
+    Discount    float64 `json:"discount"` // Percentage discount
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+type Invoice struct {
+    ID             int       `json:"id"`
+    BookingID      int       `json:"booking_id"`
+    UserID         int       `json:"user_id"`
+    Amount         float64   `json:"amount"`
+    DiscountAmount float64   `json:"discount_amount"`
+    DropoffFee     float64   `json:"dropoff_fee"`
+    FinalAmount    float64   `json:"final_amount"`
+    Status         string    `json:"status"`
+    Kind           string    `json:"kind"` // booking, cancellation_fee or late_return_fee
+    CreatedAt      time.Time `json:"created_at"`
+    UpdatedAt      time.Time `json:"updated_at"`
+    VehicleID      int       `json:"vehicle_id"`
+    VehicleModel   string    `json:"vehicle_model"`
+    MembershipTier string    `json:"membership_tier"`
+}
+
+type PaymentMethod struct {
+    ID          int       `json:"id"`
+    UserID      int       `json:"user_id"`
+    Type        string    `json:"type"`     // credit_card, debit_card, etc.
+    Provider    string    `json:"provider"` // visa, mastercard, etc.
+    LastFour    string    `json:"last_four"`
+    ExpiryDate  string    `json:"expiry_date"`
+    IsDefault   bool      `json:"is_default"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+type BillingCalculation struct {
+    Duration        float64 `json:"duration"`        // in hours
+    BaseRate        float64 `json:"base_rate"`
+    MemberDiscount  float64 `json:"member_discount"`
+    FinalAmount     float64 `json:"final_amount"`
+}
+
+// CancellationPolicy sets what a membership tier pays to cancel. Cancelling at
+// least FreeHoursBefore the start is free, later costs LateFeePercent of the
+// booking and a no-show costs NoShowFeePercent.
+type CancellationPolicy struct {
+    MembershipTier   string    `json:"membership_tier"`
+    FreeHoursBefore  float64   `json:"free_hours_before"`
+    LateFeePercent   float64   `json:"late_fee_percent"`
+    NoShowFeePercent float64   `json:"no_show_fee_percent"`
+    UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// CancellationFee is the price of cancelling a booking at a given time.
+// Invoice is set once the fee has been charged.
+type CancellationFee struct {
+    BookingID   int                 `json:"booking_id"`
+    UserID      int                 `json:"user_id"`
+    Policy      *CancellationPolicy `json:"policy"`
+    CancelledAt time.Time           `json:"cancelled_at"`
+    FreeUntil   time.Time           `json:"free_until"`
+    NoShow      bool                `json:"no_show"`
+    Waived      bool                `json:"waived"` // cancelled by the operator, so never charged
+    BookingCost float64             `json:"booking_cost"`
+    FeePercent  float64             `json:"fee_percent"`
+    Fee         float64             `json:"fee"`
+    Invoice     *Invoice            `json:"invoice,omitempty"`
+}
+
+// LateReturnFee is the charge for bringing a car back after its booking ended
+type LateReturnFee struct {
+    BookingID  int       `json:"booking_id"`
+    UserID     int       `json:"user_id"`
+    EndTime    time.Time `json:"end_time"`
+    ReturnedAt time.Time `json:"returned_at"`
+    LateHours  float64   `json:"late_hours"`
+    Multiplier float64   `json:"multiplier"`
+    Fee        float64   `json:"fee"`
+    Invoice    *Invoice  `json:"invoice,omitempty"`
+}
+
+// Response wrapper for consistent API responses
+type Response struct {
+    Success bool        `json:"success"`
+    Data    interface{} `json:"data,omitempty"`
+    Error   string     `json:"error,omitempty"`
 }
\ No newline at end of file
@@ -0,0 +1,211 @@
+package repository
+
+import (
+    "database/sql"
+    "fmt"
+    "math"
+    "time"
+
+    "billing-service/models"
+)
+
+// GetCancellationPolicies lists every tier's cancellation policy
+func (r *BillingRepository) GetCancellationPolicies() ([]models.CancellationPolicy, error) {
+    rows, err := r.db.Query(`
+        SELECT c.membership_tier, c.free_hours_before, c.late_fee_percent, c.no_show_fee_percent, c.updated_at
+        FROM cancellation_policies c
+        JOIN pricing_tiers t ON t.name = c.membership_tier
+        ORDER BY t.id
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("error querying cancellation policies: %v", err)
+    }
+    defer rows.Close()
+
+    policies := []models.CancellationPolicy{}
+    for rows.Next() {
+        var p models.CancellationPolicy
+        err := rows.Scan(&p.MembershipTier, &p.FreeHoursBefore, &p.LateFeePercent, &p.NoShowFeePercent, &p.UpdatedAt)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning cancellation policy row: %v", err)
+        }
+        policies = append(policies, p)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating cancellation policy rows: %v", err)
+    }
+
+    return policies, nil
+}
+
+// PriceCancellation works out what cancelling a booking at cancelledAt costs
+// under the membership tier's policy. Cancelling once the booking has started
+// counts as a no-show.
+func (r *BillingRepository) PriceCancellation(userID int, membershipTier string, bookingID int, cancelledAt time.Time, noShow bool) (*models.CancellationFee, error) {
+    var policy models.CancellationPolicy
+    err := r.db.QueryRow(`
+        SELECT membership_tier, free_hours_before, late_fee_percent, no_show_fee_percent, updated_at
+        FROM cancellation_policies
+        WHERE membership_tier = $1
+    `, membershipTier).Scan(&policy.MembershipTier, &policy.FreeHoursBefore, &policy.LateFeePercent,
+        &policy.NoShowFeePercent, &policy.UpdatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("no cancellation policy for %s members", membershipTier)
+        }
+        return nil, fmt.Errorf("error getting cancellation policy: %v", err)
+    }
+
+    var start, end time.Time
+    var dropoffFee float64
+    err = r.db.QueryRow(`
+        SELECT start_time, end_time, dropoff_fee FROM bookings WHERE id = $1 AND user_id = $2
+    `, bookingID, userID).Scan(&start, &end, &dropoffFee)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("booking not found")
+        }
+        return nil, fmt.Errorf("error getting booking: %v", err)
+    }
+
+    calculation, err := r.CalculateRentalCost(membershipTier, end.Sub(start).Hours())
+    if err != nil {
+        return nil, err
+    }
+
+    fee := &models.CancellationFee{
+        BookingID:   bookingID,
+        UserID:      userID,
+        Policy:      &policy,
+        CancelledAt: cancelledAt,
+        FreeUntil:   start.Add(-time.Duration(policy.FreeHoursBefore * float64(time.Hour))),
+        NoShow:      noShow || !cancelledAt.Before(start),
+        BookingCost: calculation.FinalAmount + dropoffFee,
+    }
+    switch {
+    case fee.NoShow:
+        fee.FeePercent = policy.NoShowFeePercent
+    case cancelledAt.After(fee.FreeUntil):
+        fee.FeePercent = policy.LateFeePercent
+    }
+    fee.Fee = math.Round(fee.BookingCost*fee.FeePercent) / 100
+
+    return fee, nil
+}
+
+// ChargeCancellation voids the booking's unpaid rental invoice and raises an
+// invoice for the cancellation fee, if there is one. Charging the same
+// booking again returns the fee invoice already raised.
+func (r *BillingRepository) ChargeCancellation(fee *models.CancellationFee) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    var existing models.Invoice
+    err = tx.QueryRow(`
+        SELECT id, user_id, booking_id, amount, discount_amount, dropoff_fee, final_amount, status, kind, created_at
+        FROM invoices
+        WHERE booking_id = $1 AND kind = 'cancellation_fee'
+    `, fee.BookingID).Scan(&existing.ID, &existing.UserID, &existing.BookingID, &existing.Amount,
+        &existing.DiscountAmount, &existing.DropoffFee, &existing.FinalAmount, &existing.Status,
+        &existing.Kind, &existing.CreatedAt)
+    if err == nil {
+        tx.Rollback()
+        fee.Fee = existing.FinalAmount
+        fee.Invoice = &existing
+        return nil
+    }
+    if err != sql.ErrNoRows {
+        tx.Rollback()
+        return fmt.Errorf("error checking cancellation invoice: %v", err)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE invoices
+        SET status = 'void', updated_at = CURRENT_TIMESTAMP
+        WHERE booking_id = $1 AND kind = 'booking' AND status = 'pending'
+    `, fee.BookingID)
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error voiding booking invoice: %v", err)
+    }
+
+    if fee.Fee > 0 {
+        var invoice models.Invoice
+        err = tx.QueryRow(`
+            INSERT INTO invoices (
+                user_id, booking_id, amount, discount_amount, dropoff_fee,
+                final_amount, status, kind, created_at
+            )
+            VALUES ($1, $2, $3, 0, 0, $3, 'pending', 'cancellation_fee', CURRENT_TIMESTAMP)
+            RETURNING id, user_id, booking_id, amount, discount_amount, dropoff_fee,
+                      final_amount, status, kind, created_at
+        `, fee.UserID, fee.BookingID, fee.Fee).Scan(
+            &invoice.ID, &invoice.UserID, &invoice.BookingID, &invoice.Amount,
+            &invoice.DiscountAmount, &invoice.DropoffFee, &invoice.FinalAmount, &invoice.Status,
+            &invoice.Kind, &invoice.CreatedAt)
+        if err != nil {
+            tx.Rollback()
+            return fmt.Errorf("error creating cancellation invoice: %v", err)
+        }
+        fee.Invoice = &invoice
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing transaction: %v", err)
+    }
+
+    return nil
+}
+
+// RevertCancellation undoes ChargeCancellation for a booking that couldn't be
+// cancelled after all: the unpaid fee invoice is removed and the rental
+// invoice is owed again. A fee that has already been paid is left alone.
+func (r *BillingRepository) RevertCancellation(bookingID int) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    var paid bool
+    err = tx.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM invoices
+            WHERE booking_id = $1 AND kind = 'cancellation_fee' AND status != 'pending'
+        )
+    `, bookingID).Scan(&paid)
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error checking cancellation invoice: %v", err)
+    }
+    if paid {
+        tx.Rollback()
+        return fmt.Errorf("the cancellation fee has already been paid")
+    }
+
+    _, err = tx.Exec(`
+        DELETE FROM invoices WHERE booking_id = $1 AND kind = 'cancellation_fee'
+    `, bookingID)
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error removing cancellation invoice: %v", err)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE invoices
+        SET status = 'pending', updated_at = CURRENT_TIMESTAMP
+        WHERE booking_id = $1 AND kind = 'booking' AND status = 'void'
+    `, bookingID)
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error restoring booking invoice: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing transaction: %v", err)
+    }
+
+    return nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+    "database/sql"
+    "fmt"
+    "math"
+    "time"
+
+    "billing-service/models"
+)
+
+// Late hours are charged at this multiple of the member's hourly price
+const lateReturnMultiplier = 1.5
+
+// ChargeLateReturn raises an invoice for the time between a booking's end and
+// the car's return, at lateReturnMultiplier times the member's rate. Charging
+// the same booking again returns the invoice already raised.
+func (r *BillingRepository) ChargeLateReturn(userID int, membershipTier string, bookingID int, returnedAt time.Time) (*models.LateReturnFee, error) {
+    fee := &models.LateReturnFee{
+        BookingID:  bookingID,
+        UserID:     userID,
+        ReturnedAt: returnedAt,
+        Multiplier: lateReturnMultiplier,
+    }
+
+    err := r.db.QueryRow(`
+        SELECT end_time FROM bookings WHERE id = $1 AND user_id = $2
+    `, bookingID, userID).Scan(&fee.EndTime)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("booking not found")
+        }
+        return nil, fmt.Errorf("error getting booking: %v", err)
+    }
+
+    if !returnedAt.After(fee.EndTime) {
+        return fee, nil
+    }
+    fee.LateHours = returnedAt.Sub(fee.EndTime).Hours()
+
+    calculation, err := r.CalculateRentalCost(membershipTier, fee.LateHours)
+    if err != nil {
+        return nil, err
+    }
+    fee.Fee = math.Round(calculation.FinalAmount*lateReturnMultiplier*100) / 100
+
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    var invoice models.Invoice
+    err = tx.QueryRow(`
+        SELECT id, user_id, booking_id, amount, discount_amount, dropoff_fee, final_amount, status, kind, created_at
+        FROM invoices
+        WHERE booking_id = $1 AND kind = 'late_return_fee'
+    `, bookingID).Scan(&invoice.ID, &invoice.UserID, &invoice.BookingID, &invoice.Amount,
+        &invoice.DiscountAmount, &invoice.DropoffFee, &invoice.FinalAmount, &invoice.Status,
+        &invoice.Kind, &invoice.CreatedAt)
+    if err == nil {
+        tx.Rollback()
+        fee.Fee = invoice.FinalAmount
+        fee.Invoice = &invoice
+        return fee, nil
+    }
+    if err != sql.ErrNoRows {
+        tx.Rollback()
+        return nil, fmt.Errorf("error checking late return invoice: %v", err)
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO invoices (
+            user_id, booking_id, amount, discount_amount, dropoff_fee,
+            final_amount, status, kind, created_at
+        )
+        VALUES ($1, $2, $3, 0, 0, $3, 'pending', 'late_return_fee', CURRENT_TIMESTAMP)
+        RETURNING id, user_id, booking_id, amount, discount_amount, dropoff_fee,
+                  final_amount, status, kind, created_at
+    `, userID, bookingID, fee.Fee).Scan(
+        &invoice.ID, &invoice.UserID, &invoice.BookingID, &invoice.Amount,
+        &invoice.DiscountAmount, &invoice.DropoffFee, &invoice.FinalAmount, &invoice.Status,
+        &invoice.Kind, &invoice.CreatedAt)
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error creating late return invoice: %v", err)
+    }
+    fee.Invoice = &invoice
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("error committing transaction: %v", err)
+    }
+
+    return fee, nil
+}
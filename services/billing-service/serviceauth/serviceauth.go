@@ -0,0 +1,203 @@
+package serviceauth
+
+import (
+    "context"
+    "crypto/ed25519"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt"
+    "github.com/gorilla/mux"
+)
+
+// Service calls carry a short-lived EdDSA-signed token in this header. End-user
+// bearer tokens travel in Authorization and are never accepted on internal routes.
+const Header = "X-Service-Token"
+
+const (
+    tokenTTL      = 5 * time.Minute
+    refreshBefore = time.Minute
+)
+
+// Identity is this service's signing key plus the public keys of the services it
+// trusts. Keys are generated for local development with `go run ./cmd/servicekeys`.
+type Identity struct {
+    name    string
+    private ed25519.PrivateKey
+    peers   map[string]ed25519.PublicKey
+
+    mu     sync.Mutex
+    tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+    token   string
+    expires time.Time
+}
+
+// Load reads <dir>/<name>.key and every <dir>/*.pub
+func Load(dir, name string) (*Identity, error) {
+    keyPEM, err := os.ReadFile(filepath.Join(dir, name+".key"))
+    if err != nil {
+        return nil, fmt.Errorf("error reading signing key for %s: %v", name, err)
+    }
+    private, err := jwt.ParseEdPrivateKeyFromPEM(keyPEM)
+    if err != nil {
+        return nil, fmt.Errorf("error parsing signing key for %s: %v", name, err)
+    }
+
+    pubFiles, err := filepath.Glob(filepath.Join(dir, "*.pub"))
+    if err != nil {
+        return nil, err
+    }
+
+    peers := make(map[string]ed25519.PublicKey)
+    for _, f := range pubFiles {
+        pubPEM, err := os.ReadFile(f)
+        if err != nil {
+            return nil, err
+        }
+        public, err := jwt.ParseEdPublicKeyFromPEM(pubPEM)
+        if err != nil {
+            return nil, fmt.Errorf("error parsing public key %s: %v", f, err)
+        }
+        peers[strings.TrimSuffix(filepath.Base(f), ".pub")] = public.(ed25519.PublicKey)
+    }
+
+    return &Identity{
+        name:    name,
+        private: private.(ed25519.PrivateKey),
+        peers:   peers,
+        tokens:  make(map[string]cachedToken),
+    }, nil
+}
+
+func (id *Identity) Name() string {
+    return id.name
+}
+
+// Token returns a token for calling the audience service, reusing a cached one
+// until shortly before it expires
+func (id *Identity) Token(audience string) (string, error) {
+    id.mu.Lock()
+    defer id.mu.Unlock()
+
+    if cached, ok := id.tokens[audience]; ok && time.Until(cached.expires) > refreshBefore {
+        return cached.token, nil
+    }
+
+    now := time.Now()
+    expires := now.Add(tokenTTL)
+    token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.StandardClaims{
+        Issuer:    id.name,
+        Subject:   id.name,
+        Audience:  audience,
+        IssuedAt:  now.Unix(),
+        ExpiresAt: expires.Unix(),
+    })
+    token.Header["kid"] = id.name
+
+    signed, err := token.SignedString(id.private)
+    if err != nil {
+        return "", err
+    }
+
+    id.tokens[audience] = cachedToken{token: signed, expires: expires}
+    return signed, nil
+}
+
+// Verify checks a service token addressed to this service and returns the caller's name
+func (id *Identity) Verify(tokenString string) (string, error) {
+    claims := &jwt.StandardClaims{}
+    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+        if token.Method != jwt.SigningMethodEdDSA {
+            return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+        }
+        issuer, _ := token.Header["kid"].(string)
+        key, ok := id.peers[issuer]
+        if !ok {
+            return nil, fmt.Errorf("unknown service %q", issuer)
+        }
+        return key, nil
+    })
+    if err != nil {
+        return "", err
+    }
+    if !token.Valid {
+        return "", errors.New("invalid service token")
+    }
+
+    if claims.Issuer != token.Header["kid"] {
+        return "", errors.New("token issuer does not match signing key")
+    }
+    if !claims.VerifyAudience(id.name, true) {
+        return "", fmt.Errorf("token is not addressed to %s", id.name)
+    }
+    if claims.ExpiresAt-claims.IssuedAt > int64(tokenTTL/time.Second) {
+        return "", errors.New("service token lifetime too long")
+    }
+
+    return claims.Issuer, nil
+}
+
+// Policy whitelists which services may call each route, keyed by
+// "METHOD /path/template" as registered with mux
+type Policy map[string][]string
+
+func (p Policy) allows(r *http.Request, caller string) bool {
+    route := mux.CurrentRoute(r)
+    if route == nil {
+        return false
+    }
+    template, err := route.GetPathTemplate()
+    if err != nil {
+        return false
+    }
+
+    for _, allowed := range p[r.Method+" "+template] {
+        if allowed == caller {
+            return true
+        }
+    }
+    return false
+}
+
+// Middleware admits only service calls whose caller is whitelisted for the route.
+// The caller's name is stored in the request context as "caller_service".
+func Middleware(id *Identity, policy Policy) func(http.HandlerFunc) http.HandlerFunc {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+            tokenString := r.Header.Get(Header)
+            if tokenString == "" {
+                if r.Header.Get("Authorization") != "" {
+                    http.Error(w, "End-user credentials are not accepted on internal routes", http.StatusForbidden)
+                    return
+                }
+                http.Error(w, "Service authentication required", http.StatusUnauthorized)
+                return
+            }
+
+            caller, err := id.Verify(tokenString)
+            if err != nil {
+                http.Error(w, "Invalid service token: "+err.Error(), http.StatusUnauthorized)
+                return
+            }
+
+            if !policy.allows(r, caller) {
+                log.Printf("Denied %s %s to service %s", r.Method, r.URL.Path, caller)
+                http.Error(w, "Service not allowed to call this route", http.StatusForbidden)
+                return
+            }
+
+            ctx := context.WithValue(r.Context(), "caller_service", caller)
+            next.ServeHTTP(w, r.WithContext(ctx))
+        }
+    }
+}
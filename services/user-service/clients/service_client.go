@@ -0,0 +1,76 @@
+package clients
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "cnad-carsharinggo/services/user-service/serviceauth"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// ServiceClient calls another service's /internal JSON API, authenticating as
+// user-service with a signed service token
+type ServiceClient struct {
+    baseURL  string
+    audience string
+    identity *serviceauth.Identity
+    http     *http.Client
+}
+
+func NewServiceClient(baseURL, audience string, identity *serviceauth.Identity) *ServiceClient {
+    return &ServiceClient{
+        baseURL:  strings.TrimRight(baseURL, "/"),
+        audience: audience,
+        identity: identity,
+        http:     &http.Client{Timeout: defaultTimeout},
+    }
+}
+
+// envelope matches the Response wrapper used by vehicle-service and billing-service
+type envelope struct {
+    Success bool            `json:"success"`
+    Data    json.RawMessage `json:"data"`
+    Error   string          `json:"error"`
+}
+
+// Do sends the request and returns the envelope's data payload
+func (c *ServiceClient) Do(method, path string) (json.RawMessage, error) {
+    req, err := http.NewRequest(method, c.baseURL+path, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    token, err := c.identity.Token(c.audience)
+    if err != nil {
+        return nil, fmt.Errorf("error signing service token: %v", err)
+    }
+    req.Header.Set("Accept", "application/json")
+    req.Header.Set(serviceauth.Header, token)
+
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("%s %s: %v", method, path, err)
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+    if err != nil {
+        return nil, fmt.Errorf("%s %s: error reading response: %v", method, path, err)
+    }
+
+    var env envelope
+    if err := json.Unmarshal(body, &env); err != nil {
+        return nil, fmt.Errorf("%s %s: unexpected response (status %d)", method, path, resp.StatusCode)
+    }
+
+    if resp.StatusCode >= 400 || !env.Success {
+        return nil, fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, env.Error)
+    }
+
+    return env.Data, nil
+}
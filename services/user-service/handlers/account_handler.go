@@ -0,0 +1,260 @@
+package handlers
+
+import (
+    "archive/zip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "path"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+    "cnad-carsharinggo/services/user-service/clients"
+    "cnad-carsharinggo/services/user-service/models"
+    "cnad-carsharinggo/services/user-service/repository"
+    "cnad-carsharinggo/services/user-service/storage"
+)
+
+// Invoices must be kept for five years after an account is closed (IRAS record-keeping)
+const invoiceRetentionYears = 5
+
+type AccountHandler struct {
+    UserRepo *repository.UserRepository
+    Store    storage.BlobStore
+    Vehicles *clients.ServiceClient
+    Billing  *clients.ServiceClient
+}
+
+func NewAccountHandler(repo *repository.UserRepository, store storage.BlobStore, vehicles, billing *clients.ServiceClient) *AccountHandler {
+    return &AccountHandler{UserRepo: repo, Store: store, Vehicles: vehicles, Billing: billing}
+}
+
+// DataExport is everything the platform holds about a user, across services
+type DataExport struct {
+    GeneratedAt      time.Time                `json:"generated_at"`
+    Profile          *models.User             `json:"profile"`
+    LicenceDocuments []models.LicenceDocument `json:"licence_documents"`
+    Bookings         json.RawMessage          `json:"bookings"`
+    Invoices         json.RawMessage          `json:"invoices"`
+    PaymentMethods   json.RawMessage          `json:"payment_methods"`
+}
+
+func (h *AccountHandler) gatherExport(userID int) (*DataExport, error) {
+    profile, err := h.UserRepo.GetUserByID(userID)
+    if err != nil {
+        return nil, err
+    }
+
+    docs, err := h.UserRepo.GetLicenceDocuments(userID)
+    if err != nil {
+        return nil, err
+    }
+
+    bookings, err := h.Vehicles.Do("GET", fmt.Sprintf("/internal/users/%d/bookings", userID))
+    if err != nil {
+        return nil, fmt.Errorf("vehicle-service: %v", err)
+    }
+
+    invoices, err := h.Billing.Do("GET", fmt.Sprintf("/internal/users/%d/invoices", userID))
+    if err != nil {
+        return nil, fmt.Errorf("billing-service: %v", err)
+    }
+
+    paymentMethods, err := h.Billing.Do("GET", fmt.Sprintf("/internal/users/%d/payment-methods", userID))
+    if err != nil {
+        return nil, fmt.Errorf("billing-service: %v", err)
+    }
+
+    return &DataExport{
+        GeneratedAt:      time.Now().UTC(),
+        Profile:          profile,
+        LicenceDocuments: docs,
+        Bookings:         bookings,
+        Invoices:         invoices,
+        PaymentMethods:   paymentMethods,
+    }, nil
+}
+
+// ExportData returns the user's personal data as JSON, or as a ZIP that also
+// contains their uploaded licence images when ?format=zip
+func (h *AccountHandler) ExportData(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    export, err := h.gatherExport(claims.UserID)
+    if err != nil {
+        log.Printf("Data export error for user %d: %v", claims.UserID, err)
+        http.Error(w, "Failed to gather data export: "+err.Error(), http.StatusBadGateway)
+        return
+    }
+
+    filename := fmt.Sprintf("cnad-export-%d-%s", claims.UserID, export.GeneratedAt.Format("20060102"))
+
+    if r.URL.Query().Get("format") != "zip" {
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        enc.Encode(export)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+
+    zw := zip.NewWriter(w)
+    files := []struct {
+        name    string
+        content interface{}
+    }{
+        {"profile.json", export.Profile},
+        {"licence_documents.json", export.LicenceDocuments},
+        {"bookings.json", export.Bookings},
+        {"invoices.json", export.Invoices},
+        {"payment_methods.json", export.PaymentMethods},
+    }
+    for _, file := range files {
+        f, err := zw.Create(file.name)
+        if err != nil {
+            log.Printf("Error writing %s to export: %v", file.name, err)
+            return
+        }
+        enc := json.NewEncoder(f)
+        enc.SetIndent("", "  ")
+        enc.Encode(file.content)
+    }
+
+    for _, doc := range export.LicenceDocuments {
+        blob, err := h.Store.Get(doc.StorageKey)
+        if err != nil {
+            log.Printf("Skipping licence document %d in export: %v", doc.ID, err)
+            continue
+        }
+        f, err := zw.Create(path.Join("documents", path.Base(doc.StorageKey)))
+        if err == nil {
+            _, err = io.Copy(f, blob)
+        }
+        blob.Close()
+        if err != nil {
+            log.Printf("Error writing licence document %d to export: %v", doc.ID, err)
+            return
+        }
+    }
+
+    if err := zw.Close(); err != nil {
+        log.Printf("Error finishing export archive: %v", err)
+    }
+}
+
+// DeleteAccount anonymises the user. Invoices are kept (linked to the anonymised
+// row) for the retention period; payment methods and licence images are removed.
+// The account is closed first, so a failure to remove payment methods in
+// billing-service can't leave it open; RemovePendingPaymentMethods retries them.
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    var req models.DeleteAccountRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    storedPassword, err := h.UserRepo.GetPasswordHash(claims.UserID)
+    if err != nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(req.Password)); err != nil {
+        http.Error(w, "Password is incorrect", http.StatusForbidden)
+        return
+    }
+
+    // Refuse while the user still has a car booked, which they should cancel
+    // first, or still has a car out past the end of its booking
+    raw, err := h.Vehicles.Do("GET", fmt.Sprintf("/internal/users/%d/bookings", claims.UserID))
+    if err != nil {
+        log.Printf("Error checking bookings before account deletion: %v", err)
+        http.Error(w, "Failed to check bookings", http.StatusBadGateway)
+        return
+    }
+    var bookings []struct {
+        Status     string     `json:"status"`
+        EndTime    time.Time  `json:"end_time"`
+        OverdueAt  *time.Time `json:"overdue_at"`
+        ReturnedAt *time.Time `json:"returned_at"`
+    }
+    // An empty booking list is omitted from the response envelope entirely
+    if len(raw) > 0 {
+        if err := json.Unmarshal(raw, &bookings); err != nil {
+            http.Error(w, "Failed to check bookings", http.StatusBadGateway)
+            return
+        }
+    }
+    for _, b := range bookings {
+        if (b.Status == "pending" || b.Status == "confirmed") && b.EndTime.After(time.Now()) {
+            http.Error(w, "Cancel your upcoming bookings before deleting your account", http.StatusConflict)
+            return
+        }
+        if b.OverdueAt != nil && b.ReturnedAt == nil {
+            http.Error(w, "Return your overdue car before deleting your account", http.StatusConflict)
+            return
+        }
+    }
+
+    retainUntil := time.Now().AddDate(invoiceRetentionYears, 0, 0)
+    keys, err := h.UserRepo.AnonymiseUser(claims.UserID, retainUntil)
+    if err != nil {
+        log.Printf("AnonymiseUser error: %v", err)
+        http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+        return
+    }
+
+    if err := h.removePaymentMethods(claims.UserID); err != nil {
+        log.Printf("Failed to remove payment methods for closed account %d, will retry: %v", claims.UserID, err)
+    }
+
+    for _, key := range keys {
+        if err := h.Store.Delete(key); err != nil {
+            log.Printf("Failed to delete blob %s for closed account %d: %v", key, claims.UserID, err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "message":                 "Account deleted",
+        "invoices_retained_until": retainUntil.Format("2006-01-02"),
+    })
+}
+
+// removePaymentMethods deletes a closed account's payment methods in
+// billing-service and records that it is done
+func (h *AccountHandler) removePaymentMethods(userID int) error {
+    if _, err := h.Billing.Do("DELETE", fmt.Sprintf("/internal/users/%d/payment-methods", userID)); err != nil {
+        return err
+    }
+    return h.UserRepo.MarkPaymentMethodsRemoved(userID)
+}
+
+// RemovePendingPaymentMethods retries payment method removal for closed
+// accounts where billing-service couldn't be reached at the time
+func (h *AccountHandler) RemovePendingPaymentMethods() {
+    userIDs, err := h.UserRepo.GetPendingPaymentMethodRemovals()
+    if err != nil {
+        log.Printf("Error finding closed accounts with payment methods: %v", err)
+        return
+    }
+    for _, userID := range userIDs {
+        if err := h.removePaymentMethods(userID); err != nil {
+            log.Printf("Failed to remove payment methods for closed account %d: %v", userID, err)
+        }
+    }
+}
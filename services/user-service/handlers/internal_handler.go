@@ -0,0 +1,140 @@
+package handlers
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+    "cnad-carsharinggo/services/user-service/models"
+    "cnad-carsharinggo/services/user-service/notify"
+    "cnad-carsharinggo/services/user-service/repository"
+)
+
+// InternalHandler serves user data to vehicle-service and billing-service so they
+// don't read the users table directly. Routes are mounted under /internal.
+type InternalHandler struct {
+    UserRepo *repository.UserRepository
+    Mailer   notify.Mailer
+}
+
+func NewInternalHandler(repo *repository.UserRepository, mailer notify.Mailer) *InternalHandler {
+    return &InternalHandler{UserRepo: repo, Mailer: mailer}
+}
+
+// InternalUser is the subset of a user other services are allowed to see
+type InternalUser struct {
+    ID                 int        `json:"id"`
+    Email              string     `json:"email"`
+    MembershipTier     string     `json:"membership_tier"`
+    Role               string     `json:"role"`
+    Status             string     `json:"status"`
+    VerificationStatus string     `json:"verification_status"`
+    LicenceExpiry      *time.Time `json:"licence_expiry"`
+}
+
+type IntrospectionResponse struct {
+    Active    bool  `json:"active"`
+    ExpiresAt int64 `json:"exp,omitempty"`
+    *InternalUser
+}
+
+func toInternalUser(u *models.User) *InternalUser {
+    return &InternalUser{
+        ID:                 u.ID,
+        Email:              u.Email,
+        MembershipTier:     u.MembershipTier,
+        Role:               u.Role,
+        Status:             u.Status,
+        VerificationStatus: u.VerificationStatus,
+        LicenceExpiry:      u.LicenceExpiry,
+    }
+}
+
+func (h *InternalHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return
+    }
+
+    // Other services treat 404 as a missing user, so only send it for one
+    user, err := h.UserRepo.GetUserByID(userID)
+    if err != nil && err.Error() == "user not found" {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Failed to get user", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(toInternalUser(user))
+}
+
+// IntrospectToken follows RFC 7662: an invalid token or an inactive account is
+// reported as {"active": false} with status 200 rather than as an error.
+// Tokens issued to third-party apps aren't accepted by the other services.
+func (h *InternalHandler) IntrospectToken(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Token string `json:"token"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+        http.Error(w, "token is required", http.StatusBadRequest)
+        return
+    }
+
+    resp := IntrospectionResponse{Active: false}
+
+    if claims, err := ValidateToken(req.Token); err == nil && !claims.ThirdParty() {
+        if user, err := h.UserRepo.GetUserByID(claims.UserID); err == nil && user.Status == models.StatusActive {
+            resp = IntrospectionResponse{
+                Active:       true,
+                ExpiresAt:    claims.ExpiresAt,
+                InternalUser: toInternalUser(user),
+            }
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
+
+// NotifyUser emails a user on behalf of another service, which never sees the
+// address itself. Closed accounts are not contacted.
+func (h *InternalHandler) NotifyUser(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return
+    }
+
+    var req struct {
+        Subject string `json:"subject"`
+        Body    string `json:"body"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Subject == "" || req.Body == "" {
+        http.Error(w, "subject and body are required", http.StatusBadRequest)
+        return
+    }
+
+    user, err := h.UserRepo.GetUserByID(userID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    sent := false
+    if user.Status != models.StatusDeleted {
+        if err := h.Mailer.Send(user.Email, req.Subject, req.Body); err != nil {
+            http.Error(w, "Failed to send notification", http.StatusBadGateway)
+            return
+        }
+        sent = true
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]bool{"sent": sent})
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "cnad-carsharinggo/services/user-service/models"
+    "cnad-carsharinggo/services/user-service/oidc"
+    "cnad-carsharinggo/services/user-service/repository"
+)
+
+const authorizationCodeTTL = time.Minute
+
+// OAuthHandler is the authorization server third-party apps use to obtain
+// tokens for our API: authorization code flow with mandatory PKCE (S256).
+type OAuthHandler struct {
+    UserRepo *repository.UserRepository
+}
+
+func NewOAuthHandler(repo *repository.UserRepository) *OAuthHandler {
+    return &OAuthHandler{UserRepo: repo}
+}
+
+// validRedirectURI allows https, or plain http only for apps running on this machine
+func validRedirectURI(raw string) bool {
+    u, err := url.Parse(raw)
+    if err != nil || u.Host == "" || u.Fragment != "" {
+        return false
+    }
+    if u.Scheme == "https" {
+        return true
+    }
+    host := u.Hostname()
+    return u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1")
+}
+
+func (h *OAuthHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    var req models.RegisterClientRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    req.Name = strings.TrimSpace(req.Name)
+    if req.Name == "" || len(req.RedirectURIs) == 0 {
+        http.Error(w, "Name and at least one redirect URI are required", http.StatusBadRequest)
+        return
+    }
+    for _, uri := range req.RedirectURIs {
+        if !validRedirectURI(uri) {
+            http.Error(w, "Redirect URIs must be absolute https URLs (http is allowed for localhost)", http.StatusBadRequest)
+            return
+        }
+    }
+
+    clientID, err := newToken()
+    if err != nil {
+        http.Error(w, "Failed to register client", http.StatusInternalServerError)
+        return
+    }
+
+    client := &models.OAuthClient{ClientID: clientID, Name: req.Name, RedirectURIs: req.RedirectURIs}
+    if err := h.UserRepo.CreateOAuthClient(client, claims.UserID); err != nil {
+        log.Printf("CreateOAuthClient error: %v", err)
+        http.Error(w, "Failed to register client", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(client)
+}
+
+// GetClient returns a client's public details for the consent page
+func (h *OAuthHandler) GetClient(w http.ResponseWriter, r *http.Request) {
+    client, err := h.UserRepo.GetOAuthClient(mux.Vars(r)["clientId"])
+    if err != nil {
+        http.Error(w, "Client not found", http.StatusNotFound)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "client_id": client.ClientID,
+        "name":      client.Name,
+    })
+}
+
+// checkClient verifies the client and exact redirect URI. Until both are known
+// to be good, errors must be shown to the user instead of redirected (RFC 6749 4.1.2.1).
+func (h *OAuthHandler) checkClient(clientID, redirectURI string) bool {
+    client, err := h.UserRepo.GetOAuthClient(clientID)
+    if err != nil {
+        return false
+    }
+    for _, uri := range client.RedirectURIs {
+        if uri == redirectURI {
+            return true
+        }
+    }
+    return false
+}
+
+// oauthScopes are the scopes third-party apps can be granted
+var oauthScopes = map[string]bool{
+    ScopeProfile: true,
+}
+
+// ScopeProfile lets an app read the user's profile from GET /api/users/me
+const ScopeProfile = "profile"
+
+// checkRequest returns an RFC 6749 error code, or "" if the request is acceptable.
+// An empty scope is granted as profile.
+func checkRequest(req *models.AuthorizeRequest) string {
+    if req.ResponseType != "code" {
+        return "unsupported_response_type"
+    }
+    if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+        return "invalid_request"
+    }
+
+    scopes := strings.Fields(req.Scope)
+    if len(scopes) == 0 {
+        scopes = []string{ScopeProfile}
+    }
+    for _, s := range scopes {
+        if !oauthScopes[s] {
+            return "invalid_scope"
+        }
+    }
+    req.Scope = strings.Join(scopes, " ")
+    return ""
+}
+
+func redirectWith(redirectURI string, params url.Values) string {
+    sep := "?"
+    if strings.Contains(redirectURI, "?") {
+        sep = "&"
+    }
+    return redirectURI + sep + params.Encode()
+}
+
+func authorizeRequestFromQuery(q url.Values) models.AuthorizeRequest {
+    return models.AuthorizeRequest{
+        ClientID:            q.Get("client_id"),
+        RedirectURI:         q.Get("redirect_uri"),
+        ResponseType:        q.Get("response_type"),
+        Scope:               q.Get("scope"),
+        State:               q.Get("state"),
+        CodeChallenge:       q.Get("code_challenge"),
+        CodeChallengeMethod: q.Get("code_challenge_method"),
+    }
+}
+
+// Authorize validates an authorization request and sends the browser to the
+// consent page, which holds the user's token and approves it with Approve
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+    req := authorizeRequestFromQuery(r.URL.Query())
+    if !h.checkClient(req.ClientID, req.RedirectURI) {
+        http.Error(w, "Unknown client or redirect URI", http.StatusBadRequest)
+        return
+    }
+
+    if errCode := checkRequest(&req); errCode != "" {
+        params := url.Values{"error": {errCode}}
+        if req.State != "" {
+            params.Set("state", req.State)
+        }
+        http.Redirect(w, r, redirectWith(req.RedirectURI, params), http.StatusFound)
+        return
+    }
+
+    http.Redirect(w, r, "/authorize.html?"+r.URL.RawQuery, http.StatusFound)
+}
+
+// Approve records the signed-in user's decision and returns where to send the browser
+func (h *OAuthHandler) Approve(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    var req struct {
+        models.AuthorizeRequest
+        Approve bool `json:"approve"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    if !h.checkClient(req.ClientID, req.RedirectURI) {
+        http.Error(w, "Unknown client or redirect URI", http.StatusBadRequest)
+        return
+    }
+
+    params := url.Values{}
+    if req.State != "" {
+        params.Set("state", req.State)
+    }
+
+    if errCode := checkRequest(&req.AuthorizeRequest); errCode != "" {
+        params.Set("error", errCode)
+    } else if !req.Approve {
+        params.Set("error", "access_denied")
+    } else {
+        code, err := newToken()
+        if err != nil {
+            http.Error(w, "Failed to issue authorization code", http.StatusInternalServerError)
+            return
+        }
+        err = h.UserRepo.CreateAuthorizationCode(hashToken(code), models.AuthorizationCode{
+            ClientID:      req.ClientID,
+            UserID:        claims.UserID,
+            RedirectURI:   req.RedirectURI,
+            CodeChallenge: req.CodeChallenge,
+            Scope:         req.Scope,
+        }, time.Now().Add(authorizationCodeTTL))
+        if err != nil {
+            log.Printf("CreateAuthorizationCode error: %v", err)
+            http.Error(w, "Failed to issue authorization code", http.StatusInternalServerError)
+            return
+        }
+        params.Set("code", code)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "redirect_to": redirectWith(req.RedirectURI, params),
+    })
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(http.StatusBadRequest)
+    json.NewEncoder(w).Encode(map[string]string{
+        "error":             code,
+        "error_description": description,
+    })
+}
+
+// Token redeems an authorization code for a bearer token (RFC 6749 4.1.3)
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        tokenError(w, "invalid_request", "body must be application/x-www-form-urlencoded")
+        return
+    }
+
+    if r.PostForm.Get("grant_type") != "authorization_code" {
+        tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+        return
+    }
+
+    verifier := r.PostForm.Get("code_verifier")
+    if verifier == "" {
+        tokenError(w, "invalid_request", "code_verifier is required")
+        return
+    }
+
+    code, err := h.UserRepo.ConsumeAuthorizationCode(hashToken(r.PostForm.Get("code")))
+    if err != nil {
+        tokenError(w, "invalid_grant", err.Error())
+        return
+    }
+
+    if code.ClientID != r.PostForm.Get("client_id") || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+        tokenError(w, "invalid_grant", "client_id or redirect_uri does not match the authorization request")
+        return
+    }
+    if subtle.ConstantTimeCompare([]byte(oidc.S256Challenge(verifier)), []byte(code.CodeChallenge)) != 1 {
+        tokenError(w, "invalid_grant", "code_verifier does not match code_challenge")
+        return
+    }
+
+    user, err := h.UserRepo.GetUserByID(code.UserID)
+    if err != nil || user.Status != models.StatusActive {
+        tokenError(w, "invalid_grant", "account is not active")
+        return
+    }
+
+    tokenString, expires, err := issueAppToken(user, code.ClientID, code.Scope)
+    if err != nil {
+        http.Error(w, "Could not generate token", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "access_token": tokenString,
+        "token_type":   "Bearer",
+        "expires_in":   int(time.Until(expires).Seconds()),
+        "scope":        code.Scope,
+    })
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "mime"
+    "net/http"
+    "net/mail"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "golang.org/x/crypto/bcrypt"
+    "cnad-carsharinggo/services/user-service/models"
+)
+
+const (
+    emailChangeTTL    = 24 * time.Hour
+    minPasswordLength = 8
+)
+
+var (
+    // E.164: leading +, country code without a leading zero, at most 15 digits
+    e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+    membershipTiers = map[string]bool{"Basic": true, "Premium": true, "VIP": true}
+)
+
+func validatePhoneNumber(phone string) error {
+    if !e164Pattern.MatchString(phone) {
+        return fmt.Errorf("phone number must be in E.164 format, e.g. +6591234567")
+    }
+    return nil
+}
+
+func validateEmail(email string) error {
+    addr, err := mail.ParseAddress(email)
+    if err != nil || addr.Address != email {
+        return fmt.Errorf("invalid email address")
+    }
+    return nil
+}
+
+// hashToken stores only a digest of one-time tokens so a database leak can't replay them
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    user, err := h.UserRepo.GetUserByID(claims.UserID)
+    if err != nil {
+        http.Error(w, "Failed to load profile: "+err.Error(), http.StatusNotFound)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(user)
+}
+
+func (h *UserHandler) PatchMe(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+        http.Error(w, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+        return
+    }
+
+    var patch models.UpdateProfileRequest
+    if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    h.applyProfileUpdate(w, claims.UserID, patch)
+}
+
+// applyProfileUpdate validates a merge patch, stores it and replies with the updated profile
+func (h *UserHandler) applyProfileUpdate(w http.ResponseWriter, userID int, patch models.UpdateProfileRequest) {
+    if patch.MembershipTier.Set {
+        http.Error(w, "Membership tier can only be changed by an operator", http.StatusForbidden)
+        return
+    }
+    if !patch.Email.Set && !patch.PhoneNumber.Set {
+        http.Error(w, "No updates provided", http.StatusBadRequest)
+        return
+    }
+
+    if patch.Email.Set && patch.Email.Value == nil {
+        http.Error(w, "Email cannot be removed", http.StatusBadRequest)
+        return
+    }
+    if patch.PhoneNumber.Set && patch.PhoneNumber.Value != nil {
+        if err := validatePhoneNumber(*patch.PhoneNumber.Value); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+
+    current, err := h.UserRepo.GetUserByID(userID)
+    if err != nil {
+        http.Error(w, "Failed to load profile: "+err.Error(), http.StatusNotFound)
+        return
+    }
+
+    // Validate the email before writing anything so a bad address doesn't leave a half-applied patch
+    var newEmail string
+    if patch.Email.Set {
+        newEmail = strings.TrimSpace(*patch.Email.Value)
+        if err := validateEmail(newEmail); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if strings.EqualFold(newEmail, current.Email) {
+            newEmail = ""
+        }
+    }
+
+    if newEmail != "" {
+        inUse, err := h.UserRepo.EmailInUse(newEmail, userID)
+        if err != nil {
+            http.Error(w, "Failed to update profile: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        if inUse {
+            http.Error(w, "Email is already registered", http.StatusConflict)
+            return
+        }
+    }
+
+    if err := h.UserRepo.UpdateProfile(userID, patch); err != nil {
+        http.Error(w, "Failed to update profile: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    // A new email only takes effect once the link sent to it is followed
+    if newEmail != "" {
+        if err := h.startEmailChange(userID, newEmail); err != nil {
+            log.Printf("Email change error: %v", err)
+            http.Error(w, "Failed to start email verification", http.StatusInternalServerError)
+            return
+        }
+    }
+
+    user, err := h.UserRepo.GetUserByID(userID)
+    if err != nil {
+        http.Error(w, "Failed to load profile: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(user)
+}
+
+// SetMembershipTier moves a user to another tier. Tiers set cancellation
+// fees and booking limits, so only operators can change them.
+func (h *UserHandler) SetMembershipTier(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return
+    }
+
+    var req struct {
+        MembershipTier string `json:"membership_tier"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+    if !membershipTiers[req.MembershipTier] {
+        http.Error(w, "Membership tier must be Basic, Premium or VIP", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.UserRepo.SetMembershipTier(userID, req.MembershipTier); err != nil {
+        http.Error(w, "Failed to update membership tier: "+err.Error(), http.StatusNotFound)
+        return
+    }
+
+    user, err := h.UserRepo.GetUserByID(userID)
+    if err != nil {
+        http.Error(w, "Failed to load profile: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(user)
+}
+
+func (h *UserHandler) startEmailChange(userID int, newEmail string) error {
+    token, err := newToken()
+    if err != nil {
+        return err
+    }
+
+    if err := h.UserRepo.RequestEmailChange(userID, newEmail, hashToken(token), time.Now().Add(emailChangeTTL)); err != nil {
+        return err
+    }
+
+    link := fmt.Sprintf("%s/users/verify-email?token=%s", h.PublicURL, url.QueryEscape(token))
+    body := fmt.Sprintf("Confirm your new email address for CNAD Car Sharing by opening:\n\n%s\n\nThe link expires in 24 hours.", link)
+    return h.Mailer.Send(newEmail, "Confirm your new email address", body)
+}
+
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+    token := r.URL.Query().Get("token")
+    if token == "" {
+        http.Error(w, "Missing token", http.StatusBadRequest)
+        return
+    }
+
+    user, err := h.UserRepo.ConfirmEmailChange(hashToken(token))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Email address updated",
+        "email":   user.Email,
+    })
+}
+
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    var req models.ChangePasswordRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    if len(req.NewPassword) < minPasswordLength {
+        http.Error(w, fmt.Sprintf("New password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+        return
+    }
+
+    storedPassword, err := h.UserRepo.GetPasswordHash(claims.UserID)
+    if err != nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+
+    if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(req.CurrentPassword)); err != nil {
+        http.Error(w, "Current password is incorrect", http.StatusForbidden)
+        return
+    }
+
+    if err := h.UserRepo.UpdatePassword(claims.UserID, req.NewPassword); err != nil {
+        log.Printf("UpdatePassword error: %v", err)
+        http.Error(w, "Failed to change password", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Password changed successfully",
+    })
+}
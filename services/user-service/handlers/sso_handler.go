@@ -0,0 +1,195 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/golang-jwt/jwt"
+    "cnad-carsharinggo/services/user-service/models"
+    "cnad-carsharinggo/services/user-service/oidc"
+    "cnad-carsharinggo/services/user-service/repository"
+)
+
+const (
+    ssoCookieName = "oidc_login"
+    ssoCookiePath = "/users/oidc"
+    ssoLoginTTL   = 10 * time.Minute
+)
+
+var errUnverifiedEmail = errors.New("identity provider did not supply a verified email address")
+
+// SSOHandler signs users in through an external OpenID Connect provider.
+// Provider is nil when single sign-on is not configured.
+type SSOHandler struct {
+    UserRepo  *repository.UserRepository
+    Provider  *oidc.Provider
+    PublicURL string
+}
+
+func NewSSOHandler(repo *repository.UserRepository, provider *oidc.Provider, publicURL string) *SSOHandler {
+    return &SSOHandler{UserRepo: repo, Provider: provider, PublicURL: publicURL}
+}
+
+// ssoLogin is kept in a signed cookie between Login and Callback so that the
+// callback can only complete a login this browser started
+type ssoLogin struct {
+    State        string `json:"state"`
+    Nonce        string `json:"nonce"`
+    CodeVerifier string `json:"code_verifier"`
+    jwt.StandardClaims
+}
+
+func (h *SSOHandler) Login(w http.ResponseWriter, r *http.Request) {
+    if h.Provider == nil {
+        http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+        return
+    }
+
+    state, err := newToken()
+    if err != nil {
+        http.Error(w, "Failed to start login", http.StatusInternalServerError)
+        return
+    }
+    nonce, err := newToken()
+    if err != nil {
+        http.Error(w, "Failed to start login", http.StatusInternalServerError)
+        return
+    }
+    verifier, challenge, err := oidc.NewPKCE()
+    if err != nil {
+        http.Error(w, "Failed to start login", http.StatusInternalServerError)
+        return
+    }
+
+    redirect, err := h.Provider.AuthCodeURL(state, nonce, challenge)
+    if err != nil {
+        log.Printf("OIDC discovery error: %v", err)
+        http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+        return
+    }
+
+    expires := time.Now().Add(ssoLoginTTL)
+    cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &ssoLogin{
+        State:          state,
+        Nonce:          nonce,
+        CodeVerifier:   verifier,
+        StandardClaims: jwt.StandardClaims{ExpiresAt: expires.Unix()},
+    }).SignedString(jwtKey)
+    if err != nil {
+        http.Error(w, "Failed to start login", http.StatusInternalServerError)
+        return
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name:     ssoCookieName,
+        Value:    cookie,
+        Path:     ssoCookiePath,
+        Expires:  expires,
+        HttpOnly: true,
+        Secure:   strings.HasPrefix(h.PublicURL, "https://"),
+        SameSite: http.SameSiteLaxMode,
+    })
+    http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// Callback finishes the provider login, links or creates the account and hands
+// our own bearer token to the frontend in the URL fragment
+func (h *SSOHandler) Callback(w http.ResponseWriter, r *http.Request) {
+    if h.Provider == nil {
+        http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+        return
+    }
+
+    q := r.URL.Query()
+    if errCode := q.Get("error"); errCode != "" {
+        http.Error(w, "Sign-in was not completed: "+errCode, http.StatusUnauthorized)
+        return
+    }
+
+    cookie, err := r.Cookie(ssoCookieName)
+    if err != nil {
+        http.Error(w, "Login session expired, please try again", http.StatusBadRequest)
+        return
+    }
+    // The cookie is single use
+    http.SetCookie(w, &http.Cookie{Name: ssoCookieName, Path: ssoCookiePath, MaxAge: -1})
+
+    login := &ssoLogin{}
+    _, err = jwt.ParseWithClaims(cookie.Value, login, func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+        }
+        return jwtKey, nil
+    })
+    if err != nil || login.State == "" || login.State != q.Get("state") {
+        http.Error(w, "Invalid login state", http.StatusBadRequest)
+        return
+    }
+
+    idToken, err := h.Provider.Exchange(q.Get("code"), login.CodeVerifier, login.Nonce)
+    if err != nil {
+        log.Printf("OIDC exchange error: %v", err)
+        http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+        return
+    }
+
+    user, err := h.findOrCreateUser(models.ExternalIdentity{
+        Issuer:  h.Provider.Issuer(),
+        Subject: idToken.Subject,
+        Email:   idToken.Email,
+    }, idToken.EmailVerified)
+    if err != nil {
+        if errors.Is(err, errUnverifiedEmail) {
+            http.Error(w, "Your identity provider did not supply a verified email address", http.StatusConflict)
+            return
+        }
+        log.Printf("OIDC account linking error: %v", err)
+        http.Error(w, "Could not sign you in, please try again later", http.StatusInternalServerError)
+        return
+    }
+
+    if user.Status != models.StatusActive {
+        http.Error(w, "Account is "+user.Status, http.StatusForbidden)
+        return
+    }
+
+    tokenString, _, err := issueToken(user)
+    if err != nil {
+        http.Error(w, "Could not generate token", http.StatusInternalServerError)
+        return
+    }
+
+    // A fragment never reaches server logs or Referer headers
+    fragment := url.Values{}
+    fragment.Set("token", tokenString)
+    fragment.Set("user_id", fmt.Sprint(user.ID))
+    fragment.Set("email", user.Email)
+    http.Redirect(w, r, h.PublicURL+"/#"+fragment.Encode(), http.StatusFound)
+}
+
+// findOrCreateUser resolves an external identity to an account. An existing
+// account is only linked by email when the provider has verified the address.
+func (h *SSOHandler) findOrCreateUser(identity models.ExternalIdentity, emailVerified bool) (*models.User, error) {
+    if user, err := h.UserRepo.FindUserByIdentity(identity.Issuer, identity.Subject); err == nil {
+        return user, nil
+    }
+
+    if identity.Email == "" || !emailVerified {
+        return nil, errUnverifiedEmail
+    }
+
+    existing, err := h.UserRepo.FindUserByEmail(identity.Email)
+    if err == nil {
+        if err := h.UserRepo.LinkIdentity(existing.ID, identity); err != nil {
+            return nil, err
+        }
+        return existing, nil
+    }
+
+    return h.UserRepo.CreateExternalUser(identity)
+}
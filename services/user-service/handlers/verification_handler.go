@@ -0,0 +1,261 @@
+package handlers
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "cnad-carsharinggo/services/user-service/models"
+    "cnad-carsharinggo/services/user-service/repository"
+    "cnad-carsharinggo/services/user-service/storage"
+)
+
+const maxDocumentSize = 10 << 20 // 10 MB
+
+var (
+    licenceNumberPattern  = regexp.MustCompile(`^[A-Z0-9-]{5,20}$`)
+    licenceCountryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+    documentKinds = map[string]bool{
+        "licence_front": true,
+        "licence_back":  true,
+        "identity":      true,
+    }
+    documentTypes = map[string]string{
+        "image/jpeg": ".jpg",
+        "image/png":  ".png",
+    }
+)
+
+type VerificationHandler struct {
+    UserRepo *repository.UserRepository
+    Store    storage.BlobStore
+}
+
+func NewVerificationHandler(repo *repository.UserRepository, store storage.BlobStore) *VerificationHandler {
+    return &VerificationHandler{UserRepo: repo, Store: store}
+}
+
+// authorizeUser checks that the {id} path parameter belongs to the caller
+func authorizeUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+    claims, ok := r.Context().Value("claims").(*Claims)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return 0, false
+    }
+
+    userID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return 0, false
+    }
+
+    if userID != claims.UserID {
+        http.Error(w, "Unauthorized to access this user", http.StatusForbidden)
+        return 0, false
+    }
+    return userID, true
+}
+
+func (h *VerificationHandler) SubmitLicence(w http.ResponseWriter, r *http.Request) {
+    userID, ok := authorizeUser(w, r)
+    if !ok {
+        return
+    }
+
+    var req models.LicenceRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    number := strings.ToUpper(strings.TrimSpace(req.LicenceNumber))
+    country := strings.ToUpper(strings.TrimSpace(req.LicenceCountry))
+    class := strings.TrimSpace(req.LicenceClass)
+
+    if !licenceNumberPattern.MatchString(number) {
+        http.Error(w, "Invalid licence number", http.StatusBadRequest)
+        return
+    }
+    if class == "" {
+        http.Error(w, "Licence class is required", http.StatusBadRequest)
+        return
+    }
+    if !licenceCountryPattern.MatchString(country) {
+        http.Error(w, "Licence country must be an ISO 3166-1 alpha-2 code", http.StatusBadRequest)
+        return
+    }
+
+    expiry, err := time.Parse("2006-01-02", req.LicenceExpiry)
+    if err != nil {
+        http.Error(w, "Licence expiry must be in YYYY-MM-DD format", http.StatusBadRequest)
+        return
+    }
+    if expiry.AddDate(0, 0, 1).Before(time.Now()) {
+        http.Error(w, "Licence has expired", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.UserRepo.UpdateLicence(userID, number, class, expiry, country); err != nil {
+        log.Printf("UpdateLicence error: %v", err)
+        http.Error(w, "Failed to update licence: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    user, err := h.UserRepo.GetUserByID(userID)
+    if err != nil {
+        http.Error(w, "Failed to load user: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "message":             "Licence details saved",
+        "verification_status": user.VerificationStatus,
+    })
+}
+
+func (h *VerificationHandler) UploadDocument(w http.ResponseWriter, r *http.Request) {
+    userID, ok := authorizeUser(w, r)
+    if !ok {
+        return
+    }
+
+    r.Body = http.MaxBytesReader(w, r.Body, maxDocumentSize+1024)
+    if err := r.ParseMultipartForm(maxDocumentSize); err != nil {
+        http.Error(w, "Upload must be a multipart form no larger than 10 MB", http.StatusBadRequest)
+        return
+    }
+
+    kind := r.FormValue("kind")
+    if !documentKinds[kind] {
+        http.Error(w, "kind must be one of licence_front, licence_back, identity", http.StatusBadRequest)
+        return
+    }
+
+    file, _, err := r.FormFile("document")
+    if err != nil {
+        http.Error(w, "document file is required", http.StatusBadRequest)
+        return
+    }
+    defer file.Close()
+
+    // Trust the file contents rather than the client-supplied content type
+    head := make([]byte, 512)
+    n, err := io.ReadFull(file, head)
+    if err != nil && err != io.ErrUnexpectedEOF {
+        http.Error(w, "Failed to read document", http.StatusBadRequest)
+        return
+    }
+    head = head[:n]
+    contentType := http.DetectContentType(head)
+    ext, ok := documentTypes[contentType]
+    if !ok {
+        http.Error(w, "Document must be a JPEG or PNG image", http.StatusUnsupportedMediaType)
+        return
+    }
+
+    key := fmt.Sprintf("licences/%d/%d-%s%s", userID, time.Now().UnixNano(), kind, ext)
+    size, err := h.Store.Put(key, io.MultiReader(bytes.NewReader(head), file))
+    if err != nil {
+        log.Printf("Blob store error: %v", err)
+        http.Error(w, "Failed to store document", http.StatusInternalServerError)
+        return
+    }
+
+    doc := &models.LicenceDocument{
+        UserID:      userID,
+        Kind:        kind,
+        StorageKey:  key,
+        ContentType: contentType,
+        Size:        size,
+    }
+    if err := h.UserRepo.AddLicenceDocument(doc); err != nil {
+        log.Printf("AddLicenceDocument error: %v", err)
+        h.Store.Delete(key)
+        http.Error(w, "Failed to save document", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(doc)
+}
+
+// GetReviewQueue lists users waiting for an operator to check their licence
+func (h *VerificationHandler) GetReviewQueue(w http.ResponseWriter, r *http.Request) {
+    queue, err := h.UserRepo.GetPendingVerifications()
+    if err != nil {
+        log.Printf("GetPendingVerifications error: %v", err)
+        http.Error(w, "Failed to load review queue", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(queue)
+}
+
+func (h *VerificationHandler) GetDocument(w http.ResponseWriter, r *http.Request) {
+    docID, err := strconv.Atoi(mux.Vars(r)["docId"])
+    if err != nil {
+        http.Error(w, "Invalid document ID", http.StatusBadRequest)
+        return
+    }
+
+    doc, err := h.UserRepo.GetLicenceDocument(docID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    blob, err := h.Store.Get(doc.StorageKey)
+    if err != nil {
+        log.Printf("Blob store error for %s: %v", doc.StorageKey, err)
+        http.Error(w, "Document content unavailable", http.StatusNotFound)
+        return
+    }
+    defer blob.Close()
+
+    w.Header().Set("Content-Type", doc.ContentType)
+    w.Header().Set("Cache-Control", "private, no-store")
+    io.Copy(w, blob)
+}
+
+func (h *VerificationHandler) ReviewVerification(w http.ResponseWriter, r *http.Request) {
+    claims := r.Context().Value("claims").(*Claims)
+
+    userID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return
+    }
+
+    var decision models.ReviewDecision
+    if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    if !decision.Approve && strings.TrimSpace(decision.Note) == "" {
+        http.Error(w, "A note is required when rejecting a verification", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.UserRepo.ReviewVerification(userID, claims.UserID, decision); err != nil {
+        http.Error(w, "Failed to record decision: "+err.Error(), http.StatusConflict)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Verification decision recorded",
+    })
+}
@@ -1,141 +1,247 @@
-package main
-
-import (
-    "database/sql"
-    "fmt"
-    "log"
-    "net/http"
-    "os"
-    "time"
-    "path/filepath"
-
-    gorillaCORS "github.com/gorilla/handlers"
-    "github.com/gorilla/mux"
-    _ "github.com/lib/pq"
-
-    userHandlers "cnad-carsharinggo/services/user-service/handlers"
-    "cnad-carsharinggo/services/user-service/repository"
-    "cnad-carsharinggo/services/user-service/middleware"
-)
-
-// Configuration constants
-const (
-    defaultPort = ":8080"
-    dbConnRetries = 5
-    dbConnRetryDelay = 5 * time.Second
-)
-
-func initDB(connStr string) (*sql.DB, error) {
-    var db *sql.DB
-    var err error
-
-    // Try to connect to the database with retries
-    for i := 0; i < dbConnRetries; i++ {
-        db, err = sql.Open("postgres", connStr)
-        if err != nil {
-            log.Printf("Failed to open database connection (attempt %d/%d): %v", i+1, dbConnRetries, err)
-            time.Sleep(dbConnRetryDelay)
-            continue
-        }
-
-        // Test the connection
-        err = db.Ping()
-        if err == nil {
-            log.Println("Successfully connected to the database")
-            break
-        }
-
-        log.Printf("Failed to ping database (attempt %d/%d): %v", i+1, dbConnRetries, err)
-        db.Close()
-        time.Sleep(dbConnRetryDelay)
-    }
-
-    if err != nil {
-        return nil, fmt.Errorf("failed to establish database connection after %d attempts: %v", dbConnRetries, err)
-    }
-
-    // Configure connection pool
-    db.SetMaxOpenConns(25)
-    db.SetMaxIdleConns(25)
-    db.SetConnMaxLifetime(5 * time.Minute)
-
-    return db, nil
-}
-
-func setupRoutes(userHandler *userHandlers.UserHandler) *mux.Router {
-    r := mux.NewRouter()
-
-    // API routes
-    api := r.PathPrefix("/users").Subrouter()
-    api.HandleFunc("/register", userHandler.RegisterUser).Methods("POST", "OPTIONS")
-    api.HandleFunc("/login", userHandler.LoginUser).Methods("POST", "OPTIONS")
-    api.HandleFunc("/{id}/profile", middleware.AuthMiddleware(userHandler.UpdateUserProfile)).Methods("PUT", "OPTIONS")
-
-    fs := http.FileServer(http.Dir("frontend"))
-    r.HandleFunc("/", serveIndex)
-    r.PathPrefix("/").Handler(http.StripPrefix("/", fs))
-
-    return r
-}
-
-// serveIndex serves the index.html file
-func serveIndex(w http.ResponseWriter, r *http.Request) {
-    frontendDir := filepath.Join(".", "frontend")
-    http.ServeFile(w, r, filepath.Join(frontendDir, "index.html"))
-}
-
-func setupCORS(handler http.Handler) http.Handler {
-    return gorillaCORS.CORS(
-        gorillaCORS.AllowedOrigins([]string{"*"}),
-        gorillaCORS.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-        gorillaCORS.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-        gorillaCORS.MaxAge(86400), // 24 hours
-    )(handler)
-}
-
-func main() {
-    // Setup logging
-    log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-    
-    // Database connection string
-    connStr := "postgres://postgres.wjdhhzmaclmsvaiszagk:22KC6282t04@@aws-0-ap-southeast-1.pooler.supabase.com:6543/postgres"
-    
-    // Initialize database connection
-    db, err := initDB(connStr)
-    if err != nil {
-        log.Fatal("Failed to initialize database:", err)
-    }
-    defer db.Close()
-
-    // Initialize repositories and handlers
-    userRepo := repository.NewUserRepository(db)
-    userHandler := userHandlers.NewUserHandler(userRepo)
-
-    // Setup routes
-    router := setupRoutes(userHandler)
-
-    // Setup CORS
-    corsHandler := setupCORS(router)
-
-    // Determine port
-    port := os.Getenv("PORT")
-    if port == "" {
-        port = defaultPort
-    }
-
-    // Create server
-    server := &http.Server{
-        Addr:         port,
-        Handler:      corsHandler,
-        ReadTimeout:  15 * time.Second,
-        WriteTimeout: 15 * time.Second,
-        IdleTimeout:  60 * time.Second,
-    }
-
-    // Start server
-    log.Printf("User Service starting on port %s\n", port)
-    log.Printf("Serving frontend from: %s\n", filepath.Join(".", "frontend"))
-    if err := server.ListenAndServe(); err != nil {
-        log.Fatal("Server failed to start:", err)
-    }
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "time"
+    "path/filepath"
+
+    gorillaCORS "github.com/gorilla/handlers"
+    "github.com/gorilla/mux"
+    _ "github.com/lib/pq"
+
+    userHandlers "cnad-carsharinggo/services/user-service/handlers"
+    "cnad-carsharinggo/services/user-service/repository"
+    "cnad-carsharinggo/services/user-service/serviceauth"
+    "cnad-carsharinggo/services/user-service/clients"
+    "cnad-carsharinggo/services/user-service/middleware"
+    "cnad-carsharinggo/services/user-service/notify"
+    "cnad-carsharinggo/services/user-service/oidc"
+    "cnad-carsharinggo/services/user-service/storage"
+)
+
+// Configuration constants
+const (
+    defaultPort = ":8080"
+    defaultBlobDir = "uploads"
+    defaultPublicURL = "http://localhost:8080"
+    defaultVehicleServiceURL = "http://localhost:8085"
+    defaultBillingServiceURL = "http://localhost:8083"
+    defaultKeysDir = "../../keys"
+    serviceName = "user-service"
+    dbConnRetries = 5
+    dbConnRetryDelay = 5 * time.Second
+    paymentCleanupInterval = 10 * time.Minute
+)
+
+// getEnv returns the environment variable or a fallback when it is unset
+func getEnv(key, fallback string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return fallback
+}
+
+func initDB(connStr string) (*sql.DB, error) {
+    var db *sql.DB
+    var err error
+
+    // Try to connect to the database with retries
+    for i := 0; i < dbConnRetries; i++ {
+        db, err = sql.Open("postgres", connStr)
+        if err != nil {
+            log.Printf("Failed to open database connection (attempt %d/%d): %v", i+1, dbConnRetries, err)
+            time.Sleep(dbConnRetryDelay)
+            continue
+        }
+
+        // Test the connection
+        err = db.Ping()
+        if err == nil {
+            log.Println("Successfully connected to the database")
+            break
+        }
+
+        log.Printf("Failed to ping database (attempt %d/%d): %v", i+1, dbConnRetries, err)
+        db.Close()
+        time.Sleep(dbConnRetryDelay)
+    }
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to establish database connection after %d attempts: %v", dbConnRetries, err)
+    }
+
+    // Configure connection pool
+    db.SetMaxOpenConns(25)
+    db.SetMaxIdleConns(25)
+    db.SetConnMaxLifetime(5 * time.Minute)
+
+    return db, nil
+}
+
+// internalPolicy lists which services may call each internal route
+var internalPolicy = serviceauth.Policy{
+    "GET /internal/users/{id}":                {"vehicle-service", "billing-service"},
+    "POST /internal/tokens/introspect":        {"vehicle-service", "billing-service"},
+    "POST /internal/users/{id}/notifications": {"vehicle-service"},
+}
+
+func setupRoutes(identity *serviceauth.Identity, userHandler *userHandlers.UserHandler, verificationHandler *userHandlers.VerificationHandler, accountHandler *userHandlers.AccountHandler, internalHandler *userHandlers.InternalHandler, ssoHandler *userHandlers.SSOHandler, oauthHandler *userHandlers.OAuthHandler) *mux.Router {
+    r := mux.NewRouter()
+
+    // Internal routes for other services, never called by browsers
+    serviceAuth := serviceauth.Middleware(identity, internalPolicy)
+    internal := r.PathPrefix("/internal").Subrouter()
+    internal.HandleFunc("/users/{id}", serviceAuth(internalHandler.GetUser)).Methods("GET")
+    internal.HandleFunc("/tokens/introspect", serviceAuth(internalHandler.IntrospectToken)).Methods("POST")
+    internal.HandleFunc("/users/{id}/notifications", serviceAuth(internalHandler.NotifyUser)).Methods("POST")
+
+    // API routes
+    api := r.PathPrefix("/users").Subrouter()
+    api.HandleFunc("/register", userHandler.RegisterUser).Methods("POST", "OPTIONS")
+    api.HandleFunc("/login", userHandler.LoginUser).Methods("POST", "OPTIONS")
+    api.HandleFunc("/verify-email", userHandler.VerifyEmail).Methods("GET", "OPTIONS")
+    api.HandleFunc("/me", middleware.ScopedAuth(userHandlers.ScopeProfile, userHandler.GetMe)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/me", middleware.AuthMiddleware(userHandler.PatchMe)).Methods("PATCH", "OPTIONS")
+    api.HandleFunc("/me/password", middleware.AuthMiddleware(userHandler.ChangePassword)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/me/export", middleware.AuthMiddleware(accountHandler.ExportData)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/me", middleware.AuthMiddleware(accountHandler.DeleteAccount)).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/{id}/profile", middleware.AuthMiddleware(userHandler.UpdateUserProfile)).Methods("PUT", "OPTIONS")
+
+    // Single sign-on through an external OpenID Connect provider
+    api.HandleFunc("/oidc/login", ssoHandler.Login).Methods("GET")
+    api.HandleFunc("/oidc/callback", ssoHandler.Callback).Methods("GET")
+
+    // Driver licence verification
+    api.HandleFunc("/{id}/licence", middleware.AuthMiddleware(verificationHandler.SubmitLicence)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/{id}/licence/documents", middleware.AuthMiddleware(verificationHandler.UploadDocument)).Methods("POST", "OPTIONS")
+
+    // Operator review queue
+    api.HandleFunc("/verifications", middleware.AuthMiddleware(middleware.RequireOperator(verificationHandler.GetReviewQueue))).Methods("GET", "OPTIONS")
+    api.HandleFunc("/verifications/documents/{docId}", middleware.AuthMiddleware(middleware.RequireOperator(verificationHandler.GetDocument))).Methods("GET", "OPTIONS")
+    api.HandleFunc("/{id}/verification", middleware.AuthMiddleware(middleware.RequireOperator(verificationHandler.ReviewVerification))).Methods("POST", "OPTIONS")
+    api.HandleFunc("/{id}/membership", middleware.AuthMiddleware(middleware.RequireOperator(userHandler.SetMembershipTier))).Methods("PUT", "OPTIONS")
+
+    // Authorization server for third-party apps
+    oauth := r.PathPrefix("/oauth").Subrouter()
+    oauth.HandleFunc("/authorize", oauthHandler.Authorize).Methods("GET")
+    oauth.HandleFunc("/authorize", middleware.AuthMiddleware(oauthHandler.Approve)).Methods("POST", "OPTIONS")
+    oauth.HandleFunc("/token", oauthHandler.Token).Methods("POST", "OPTIONS")
+    oauth.HandleFunc("/clients", middleware.AuthMiddleware(middleware.RequireOperator(oauthHandler.RegisterClient))).Methods("POST", "OPTIONS")
+    oauth.HandleFunc("/clients/{clientId}", oauthHandler.GetClient).Methods("GET", "OPTIONS")
+
+    fs := http.FileServer(http.Dir("frontend"))
+    r.HandleFunc("/", serveIndex)
+    r.PathPrefix("/").Handler(http.StripPrefix("/", fs))
+
+    return r
+}
+
+// serveIndex serves the index.html file
+func serveIndex(w http.ResponseWriter, r *http.Request) {
+    frontendDir := filepath.Join(".", "frontend")
+    http.ServeFile(w, r, filepath.Join(frontendDir, "index.html"))
+}
+
+func setupCORS(handler http.Handler) http.Handler {
+    return gorillaCORS.CORS(
+        gorillaCORS.AllowedOrigins([]string{"*"}),
+        gorillaCORS.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+        gorillaCORS.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+        gorillaCORS.MaxAge(86400), // 24 hours
+    )(handler)
+}
+
+func main() {
+    // Setup logging
+    log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+    
+    // Database connection string
+    connStr := "postgres://postgres.wjdhhzmaclmsvaiszagk:22KC6282t04@@aws-0-ap-southeast-1.pooler.supabase.com:6543/postgres"
+    
+    // Initialize database connection
+    db, err := initDB(connStr)
+    if err != nil {
+        log.Fatal("Failed to initialize database:", err)
+    }
+    defer db.Close()
+
+    // Signing key for calls to other services and public keys to check theirs
+    identity, err := serviceauth.Load(getEnv("SERVICE_KEYS_DIR", defaultKeysDir), serviceName)
+    if err != nil {
+        log.Fatal("Failed to load service keys (run `go run ./cmd/servicekeys` from the repo root):", err)
+    }
+
+    // Licence documents go to the local filesystem unless another store is wired in
+    blobStore, err := storage.NewLocalStore(getEnv("BLOB_STORE_DIR", defaultBlobDir))
+    if err != nil {
+        log.Fatal("Failed to initialize blob store:", err)
+    }
+
+    // Initialize repositories and handlers
+    userRepo := repository.NewUserRepository(db)
+    middleware.UseAccounts(userRepo)
+    mailer := notify.NewLogMailer()
+    userHandler := userHandlers.NewUserHandler(userRepo, mailer, getEnv("PUBLIC_URL", defaultPublicURL))
+    verificationHandler := userHandlers.NewVerificationHandler(userRepo, blobStore)
+
+    // Other services, used to gather data exports and close accounts
+    vehicleClient := clients.NewServiceClient(getEnv("VEHICLE_SERVICE_URL", defaultVehicleServiceURL), "vehicle-service", identity)
+    billingClient := clients.NewServiceClient(getEnv("BILLING_SERVICE_URL", defaultBillingServiceURL), "billing-service", identity)
+    accountHandler := userHandlers.NewAccountHandler(userRepo, blobStore, vehicleClient, billingClient)
+
+    internalHandler := userHandlers.NewInternalHandler(userRepo, mailer)
+
+    // Closed accounts whose payment methods couldn't be removed are retried
+    go func() {
+        for range time.Tick(paymentCleanupInterval) {
+            accountHandler.RemovePendingPaymentMethods()
+        }
+    }()
+
+    // Single sign-on is enabled by pointing OIDC_ISSUER at a provider
+    publicURL := getEnv("PUBLIC_URL", defaultPublicURL)
+    var provider *oidc.Provider
+    if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+        provider = oidc.NewProvider(oidc.Config{
+            Issuer:       issuer,
+            ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+            ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+            RedirectURL:  getEnv("OIDC_REDIRECT_URL", publicURL+"/users/oidc/callback"),
+        })
+        log.Printf("Single sign-on enabled with provider %s", issuer)
+    }
+    ssoHandler := userHandlers.NewSSOHandler(userRepo, provider, publicURL)
+    oauthHandler := userHandlers.NewOAuthHandler(userRepo)
+
+    // Setup routes
+    router := setupRoutes(identity, userHandler, verificationHandler, accountHandler, internalHandler, ssoHandler, oauthHandler)
+
+    // Setup CORS
+    corsHandler := setupCORS(router)
+
+    // Determine port
+    port := os.Getenv("PORT")
+    if port == "" {
+        port = defaultPort
+    }
+
+    // Create server
+    server := &http.Server{
+        Addr:         port,
+        Handler:      corsHandler,
+        ReadTimeout:  15 * time.Second,
+        WriteTimeout: 15 * time.Second,
+        IdleTimeout:  60 * time.Second,
+    }
+
+    // Start server
+    log.Printf("User Service starting on port %s\n", port)
+    log.Printf("Serving frontend from: %s\n", filepath.Join(".", "frontend"))
+    if err := server.ListenAndServe(); err != nil {
+        log.Fatal("Server failed to start:", err)
+    }
 }
\ No newline at end of file
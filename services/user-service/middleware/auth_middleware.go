@@ -1,40 +1,92 @@
-package middleware
-
-import (
-    "context"
-    "net/http"
-    "strings"
-
-    "cnad-carsharinggo/services/user-service/handlers"
-)
-
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        // Get the Authorization header
-        authHeader := r.Header.Get("Authorization")
-        if authHeader == "" {
-            http.Error(w, "Missing authorization token", http.StatusUnauthorized)
-            return
-        }
-
-        // Check if the header starts with "Bearer "
-        parts := strings.Split(authHeader, " ")
-        if len(parts) != 2 || parts[0] != "Bearer" {
-            http.Error(w, "Invalid token format", http.StatusUnauthorized)
-            return
-        }
-
-        tokenString := parts[1]
-
-        // Validate the token
-        claims, err := handlers.ValidateToken(tokenString)
-        if err != nil {
-            http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
-            return
-        }
-
-        // Add claims to request context
-        ctx := context.WithValue(r.Context(), "claims", claims)
-        next.ServeHTTP(w, r.WithContext(ctx))
-    }
+package middleware
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "strings"
+
+    "cnad-carsharinggo/services/user-service/handlers"
+    "cnad-carsharinggo/services/user-service/models"
+    "cnad-carsharinggo/services/user-service/repository"
+)
+
+// accounts is where token holders' account status is checked
+var accounts *repository.UserRepository
+
+// UseAccounts sets the repository used to check that a token's account is
+// still active. It must be called before serving requests.
+func UseAccounts(repo *repository.UserRepository) {
+    accounts = repo
+}
+
+// AuthMiddleware accepts our own clients' tokens only; tokens issued to
+// third-party apps are refused
+func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return authenticate("", next)
+}
+
+// ScopedAuth also accepts third-party app tokens that were granted scope
+func ScopedAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+    return authenticate(scope, next)
+}
+
+func authenticate(scope string, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        // Get the Authorization header
+        authHeader := r.Header.Get("Authorization")
+        if authHeader == "" {
+            http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+            return
+        }
+
+        // Check if the header starts with "Bearer "
+        parts := strings.Split(authHeader, " ")
+        if len(parts) != 2 || parts[0] != "Bearer" {
+            http.Error(w, "Invalid token format", http.StatusUnauthorized)
+            return
+        }
+
+        tokenString := parts[1]
+
+        // Validate the token
+        claims, err := handlers.ValidateToken(tokenString)
+        if err != nil {
+            http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+            return
+        }
+
+        if claims.ThirdParty() && (scope == "" || !claims.HasScope(scope)) {
+            http.Error(w, "Token does not grant access to this resource", http.StatusForbidden)
+            return
+        }
+
+        // Tokens outlive account closure and suspension, so check the account each time
+        status, err := accounts.GetUserStatus(claims.UserID)
+        if err != nil && err.Error() != "user not found" {
+            log.Printf("Error checking account status: %v", err)
+            http.Error(w, "Failed to check account", http.StatusInternalServerError)
+            return
+        }
+        if status != models.StatusActive {
+            http.Error(w, "Account is not active", http.StatusUnauthorized)
+            return
+        }
+
+        // Add claims to request context
+        ctx := context.WithValue(r.Context(), "claims", claims)
+        next.ServeHTTP(w, r.WithContext(ctx))
+    }
+}
+
+// RequireOperator must be wrapped by AuthMiddleware so the claims are present
+func RequireOperator(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        claims, ok := r.Context().Value("claims").(*handlers.Claims)
+        if !ok || claims.Role != models.RoleOperator {
+            http.Error(w, "Operator access required", http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    }
 }
\ No newline at end of file
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// OAuthClient is a third-party app allowed to obtain tokens for our API.
+// All clients are public: they prove possession of the code with PKCE, not a secret.
+type OAuthClient struct {
+    ClientID     string    `json:"client_id"`
+    Name         string    `json:"name"`
+    RedirectURIs []string  `json:"redirect_uris"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+type RegisterClientRequest struct {
+    Name         string   `json:"name"`
+    RedirectURIs []string `json:"redirect_uris"`
+}
+
+// AuthorizationCode is a one-time code issued after the user approves a client
+type AuthorizationCode struct {
+    ClientID      string
+    UserID        int
+    RedirectURI   string
+    CodeChallenge string
+    Scope         string
+}
+
+// AuthorizeRequest is the consent page's approval of an authorization request
+type AuthorizeRequest struct {
+    ClientID            string `json:"client_id"`
+    RedirectURI         string `json:"redirect_uri"`
+    ResponseType        string `json:"response_type"`
+    Scope               string `json:"scope"`
+    State               string `json:"state"`
+    CodeChallenge       string `json:"code_challenge"`
+    CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// ExternalIdentity links an account at an OpenID Connect provider to a user
+type ExternalIdentity struct {
+    Issuer  string
+    Subject string
+    Email   string
+}
@@ -0,0 +1,25 @@
+package models
+
+import "encoding/json"
+
+// OptionalString follows JSON Merge Patch (RFC 7396) semantics: an omitted field
+// leaves Set false, an explicit null sets Set with a nil Value, and a string sets both.
+type OptionalString struct {
+    Set   bool
+    Value *string
+}
+
+func (o *OptionalString) UnmarshalJSON(data []byte) error {
+    o.Set = true
+    if string(data) == "null" {
+        o.Value = nil
+        return nil
+    }
+
+    var s string
+    if err := json.Unmarshal(data, &s); err != nil {
+        return err
+    }
+    o.Value = &s
+    return nil
+}
@@ -1,23 +1,95 @@
-package models
-
-import "time"
-
-type User struct {
-    ID             int       `json:"id"`
-    Email          string    `json:"email"`
-    PhoneNumber    string    `json:"phone_number"`
-    PasswordHash   string    `json:"-"` // Hide from JSON responses
-    MembershipTier string    `json:"membership_tier"`
-    CreatedAt      time.Time `json:"created_at"`
-}
-
-type LoginRequest struct {
-    Email    string `json:"email"`
-    Password string `json:"password"`
-}
-
-type UpdateProfileRequest struct {
-    Email          string `json:"email"`
-    PhoneNumber    string `json:"phone_number"`
-    MembershipTier string `json:"membership_tier"`
-}
\ No newline at end of file
+package models
+
+import "time"
+
+// Driver verification states
+const (
+    VerificationUnverified = "unverified"
+    VerificationPending    = "pending"
+    VerificationVerified   = "verified"
+    VerificationRejected   = "rejected"
+)
+
+// Account states
+const (
+    StatusActive    = "active"
+    StatusSuspended = "suspended"
+    StatusDeleted   = "deleted"
+)
+
+// User roles
+const (
+    RoleMember   = "member"
+    RoleOperator = "operator"
+)
+
+type User struct {
+    ID                 int        `json:"id"`
+    Email              string     `json:"email"`
+    PendingEmail       *string    `json:"pending_email,omitempty"`
+    PhoneNumber        string     `json:"phone_number"`
+    PasswordHash       string     `json:"-"` // Hide from JSON responses
+    MembershipTier     string     `json:"membership_tier"`
+    Role               string     `json:"role"`
+    Status             string     `json:"status"`
+    LicenceNumber      *string    `json:"licence_number"`
+    LicenceClass       *string    `json:"licence_class"`
+    LicenceExpiry      *time.Time `json:"licence_expiry"`
+    LicenceCountry     *string    `json:"licence_country"`
+    VerificationStatus string     `json:"verification_status"`
+    VerificationNote   *string    `json:"verification_note,omitempty"`
+    VerifiedAt         *time.Time `json:"verified_at,omitempty"`
+    CreatedAt          time.Time  `json:"created_at"`
+}
+
+type LoginRequest struct {
+    Email    string `json:"email"`
+    Password string `json:"password"`
+}
+
+// UpdateProfileRequest is applied as a JSON Merge Patch: omitted fields are
+// left alone and null clears a field. MembershipTier is only read to refuse
+// it; operators change tiers through SetMembershipTier.
+type UpdateProfileRequest struct {
+    Email          OptionalString `json:"email"`
+    PhoneNumber    OptionalString `json:"phone_number"`
+    MembershipTier OptionalString `json:"membership_tier"`
+}
+
+type DeleteAccountRequest struct {
+    Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+    CurrentPassword string `json:"current_password"`
+    NewPassword     string `json:"new_password"`
+}
+
+type LicenceRequest struct {
+    LicenceNumber  string `json:"licence_number"`
+    LicenceClass   string `json:"licence_class"`
+    LicenceExpiry  string `json:"licence_expiry"` // YYYY-MM-DD
+    LicenceCountry string `json:"licence_country"` // ISO 3166-1 alpha-2
+}
+
+// LicenceDocument is an uploaded licence or identity image awaiting review
+type LicenceDocument struct {
+    ID          int       `json:"id"`
+    UserID      int       `json:"user_id"`
+    Kind        string    `json:"kind"` // licence_front, licence_back, identity
+    StorageKey  string    `json:"-"`
+    ContentType string    `json:"content_type"`
+    Size        int64     `json:"size"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+// VerificationReview is an entry in the operator review queue
+type VerificationReview struct {
+    User      User              `json:"user"`
+    Documents []LicenceDocument `json:"documents"`
+}
+
+type ReviewDecision struct {
+    Approve bool   `json:"approve"`
+    Note    string `json:"note"`
+}
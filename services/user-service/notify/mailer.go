@@ -0,0 +1,20 @@
+package notify
+
+import "log"
+
+// Mailer delivers transactional email. LogMailer is used until an SMTP or
+// provider integration is configured.
+type Mailer interface {
+    Send(to, subject, body string) error
+}
+
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+    return &LogMailer{}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+    log.Printf("EMAIL to=%s subject=%q\n%s", to, subject, body)
+    return nil
+}
@@ -0,0 +1,267 @@
+// Package oidc is a small OpenID Connect relying party: discovery, the
+// authorization code flow with PKCE, and ID token verification against the
+// provider's published keys.
+package oidc
+
+import (
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt"
+)
+
+const (
+    requestTimeout = 10 * time.Second
+    // Providers rotate keys rarely; re-fetch after this long or on an unknown kid
+    jwksTTL = time.Hour
+)
+
+// Config describes the provider and how this service is registered with it
+type Config struct {
+    Issuer       string
+    ClientID     string
+    ClientSecret string
+    RedirectURL  string
+}
+
+type discovery struct {
+    Issuer                string `json:"issuer"`
+    AuthorizationEndpoint string `json:"authorization_endpoint"`
+    TokenEndpoint         string `json:"token_endpoint"`
+    JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the ID token claims we use to find or create an account
+type IDTokenClaims struct {
+    Email         string `json:"email"`
+    EmailVerified bool   `json:"email_verified"`
+    Nonce         string `json:"nonce"`
+    jwt.StandardClaims
+}
+
+// Provider is discovered lazily so user-service can start before the IdP does
+type Provider struct {
+    config Config
+    http   *http.Client
+
+    mu        sync.Mutex
+    meta      *discovery
+    keys      map[string]*rsa.PublicKey
+    keysFetch time.Time
+}
+
+func NewProvider(config Config) *Provider {
+    config.Issuer = strings.TrimRight(config.Issuer, "/")
+    return &Provider{
+        config: config,
+        http:   &http.Client{Timeout: requestTimeout},
+    }
+}
+
+func (p *Provider) Issuer() string {
+    return p.config.Issuer
+}
+
+func (p *Provider) getJSON(endpoint string, out interface{}) error {
+    resp, err := p.http.Get(endpoint)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("%s: unexpected status %d", endpoint, resp.StatusCode)
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *Provider) discover() (*discovery, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if p.meta != nil {
+        return p.meta, nil
+    }
+
+    var meta discovery
+    if err := p.getJSON(p.config.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+        return nil, fmt.Errorf("error discovering provider: %v", err)
+    }
+    if strings.TrimRight(meta.Issuer, "/") != p.config.Issuer {
+        return nil, fmt.Errorf("provider reports issuer %q, expected %q", meta.Issuer, p.config.Issuer)
+    }
+
+    p.meta = &meta
+    return p.meta, nil
+}
+
+// AuthCodeURL is where the browser is sent to sign in at the provider
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+    meta, err := p.discover()
+    if err != nil {
+        return "", err
+    }
+
+    q := url.Values{}
+    q.Set("response_type", "code")
+    q.Set("client_id", p.config.ClientID)
+    q.Set("redirect_uri", p.config.RedirectURL)
+    q.Set("scope", "openid email")
+    q.Set("state", state)
+    q.Set("nonce", nonce)
+    q.Set("code_challenge", codeChallenge)
+    q.Set("code_challenge_method", "S256")
+
+    sep := "?"
+    if strings.Contains(meta.AuthorizationEndpoint, "?") {
+        sep = "&"
+    }
+    return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token claims
+func (p *Provider) Exchange(code, codeVerifier, nonce string) (*IDTokenClaims, error) {
+    meta, err := p.discover()
+    if err != nil {
+        return nil, err
+    }
+
+    form := url.Values{}
+    form.Set("grant_type", "authorization_code")
+    form.Set("code", code)
+    form.Set("redirect_uri", p.config.RedirectURL)
+    form.Set("client_id", p.config.ClientID)
+    form.Set("client_secret", p.config.ClientSecret)
+    form.Set("code_verifier", codeVerifier)
+
+    resp, err := p.http.PostForm(meta.TokenEndpoint, form)
+    if err != nil {
+        return nil, fmt.Errorf("error exchanging code: %v", err)
+    }
+    defer resp.Body.Close()
+
+    var body struct {
+        IDToken          string `json:"id_token"`
+        Error            string `json:"error"`
+        ErrorDescription string `json:"error_description"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        return nil, fmt.Errorf("error decoding token response: %v", err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("token endpoint: %s %s", body.Error, body.ErrorDescription)
+    }
+    if body.IDToken == "" {
+        return nil, errors.New("token response has no id_token")
+    }
+
+    return p.verifyIDToken(body.IDToken, nonce)
+}
+
+func (p *Provider) verifyIDToken(idToken, nonce string) (*IDTokenClaims, error) {
+    claims := &IDTokenClaims{}
+    _, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+        if token.Method != jwt.SigningMethodRS256 {
+            return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+        }
+        kid, _ := token.Header["kid"].(string)
+        return p.key(kid)
+    })
+    if err != nil {
+        return nil, fmt.Errorf("invalid id_token: %v", err)
+    }
+
+    if claims.Issuer != p.config.Issuer {
+        return nil, errors.New("id_token issuer mismatch")
+    }
+    if !claims.VerifyAudience(p.config.ClientID, true) {
+        return nil, errors.New("id_token audience mismatch")
+    }
+    if claims.Nonce != nonce {
+        return nil, errors.New("id_token nonce mismatch")
+    }
+    if claims.Subject == "" {
+        return nil, errors.New("id_token has no subject")
+    }
+    return claims, nil
+}
+
+// key returns the provider's public key for kid, refreshing the JWKS when needed
+func (p *Provider) key(kid string) (*rsa.PublicKey, error) {
+    meta, err := p.discover()
+    if err != nil {
+        return nil, err
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if key, ok := p.keys[kid]; ok && time.Since(p.keysFetch) < jwksTTL {
+        return key, nil
+    }
+
+    var set struct {
+        Keys []struct {
+            Kid string `json:"kid"`
+            Kty string `json:"kty"`
+            N   string `json:"n"`
+            E   string `json:"e"`
+        } `json:"keys"`
+    }
+    if err := p.getJSON(meta.JWKSURI, &set); err != nil {
+        return nil, fmt.Errorf("error fetching provider keys: %v", err)
+    }
+
+    keys := make(map[string]*rsa.PublicKey)
+    for _, k := range set.Keys {
+        if k.Kty != "RSA" {
+            continue
+        }
+        n, err := base64.RawURLEncoding.DecodeString(k.N)
+        if err != nil {
+            continue
+        }
+        e, err := base64.RawURLEncoding.DecodeString(k.E)
+        if err != nil {
+            continue
+        }
+        keys[k.Kid] = &rsa.PublicKey{
+            N: new(big.Int).SetBytes(n),
+            E: int(new(big.Int).SetBytes(e).Int64()),
+        }
+    }
+    p.keys = keys
+    p.keysFetch = time.Now()
+
+    key, ok := keys[kid]
+    if !ok {
+        return nil, fmt.Errorf("unknown signing key %q", kid)
+    }
+    return key, nil
+}
+
+// NewPKCE returns a random code verifier and its S256 challenge (RFC 7636)
+func NewPKCE() (verifier, challenge string, err error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", "", err
+    }
+    verifier = base64.RawURLEncoding.EncodeToString(b)
+    return verifier, S256Challenge(verifier), nil
+}
+
+func S256Challenge(verifier string) string {
+    sum := sha256.Sum256([]byte(verifier))
+    return base64.RawURLEncoding.EncodeToString(sum[:])
+}
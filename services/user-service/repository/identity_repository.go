@@ -0,0 +1,83 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "cnad-carsharinggo/services/user-service/models"
+)
+
+// FindUserByIdentity returns the user linked to an external identity
+func (r *UserRepository) FindUserByIdentity(issuer, subject string) (*models.User, error) {
+    user, err := scanUser(r.DB.QueryRow(`
+        SELECT `+userColumns+` FROM users
+        WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)
+    `, issuer, subject))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("identity not linked")
+        }
+        return nil, err
+    }
+    return user, nil
+}
+
+// FindUserByEmail loads the full profile for an email address, case-insensitively
+func (r *UserRepository) FindUserByEmail(email string) (*models.User, error) {
+    user, err := scanUser(r.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE LOWER(email) = LOWER($1)`, email))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("user not found")
+        }
+        return nil, err
+    }
+    return user, nil
+}
+
+func (r *UserRepository) LinkIdentity(userID int, identity models.ExternalIdentity) error {
+    _, err := r.DB.Exec(`
+        INSERT INTO user_identities (user_id, issuer, subject, email)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (issuer, subject) DO NOTHING
+    `, userID, identity.Issuer, identity.Subject, identity.Email)
+    if err != nil {
+        return fmt.Errorf("error linking identity: %v", err)
+    }
+    return nil
+}
+
+// CreateExternalUser creates an account for a first-time single sign-on user.
+// The account has no usable password until the user sets one.
+func (r *UserRepository) CreateExternalUser(identity models.ExternalIdentity) (*models.User, error) {
+    tx, err := r.DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    var userID int
+    err = tx.QueryRow(`
+        INSERT INTO users (email, password_hash, membership_tier, created_at)
+        VALUES ($1, '!', 'Basic', $2)
+        RETURNING id
+    `, identity.Email, time.Now()).Scan(&userID)
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error creating user: %v", err)
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO user_identities (user_id, issuer, subject, email)
+        VALUES ($1, $2, $3, $4)
+    `, userID, identity.Issuer, identity.Subject, identity.Email)
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error linking identity: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    return r.GetUserByID(userID)
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "cnad-carsharinggo/services/user-service/models"
+)
+
+// refreshVerificationStatus moves a user into the review queue once both licence
+// details and a licence image are on file. Any change resets a previous decision.
+func refreshVerificationStatus(tx *sql.Tx, userID int) error {
+    _, err := tx.Exec(`
+        UPDATE users
+        SET verification_status = CASE
+                WHEN licence_number IS NOT NULL AND EXISTS (
+                    SELECT 1 FROM licence_documents
+                    WHERE user_id = $1 AND kind IN ('licence_front', 'licence_back')
+                ) THEN 'pending'
+                ELSE 'unverified'
+            END,
+            verification_note = NULL,
+            verified_at = NULL,
+            reviewed_by = NULL
+        WHERE id = $1
+    `, userID)
+    return err
+}
+
+func (r *UserRepository) UpdateLicence(userID int, number, class string, expiry time.Time, country string) error {
+    tx, err := r.DB.Begin()
+    if err != nil {
+        return err
+    }
+
+    result, err := tx.Exec(`
+        UPDATE users
+        SET licence_number = $1,
+            licence_class = $2,
+            licence_expiry = $3,
+            licence_country = $4
+        WHERE id = $5
+    `, number, class, expiry, country, userID)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if rows == 0 {
+        tx.Rollback()
+        return errors.New("user not found")
+    }
+
+    if err := refreshVerificationStatus(tx, userID); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit()
+}
+
+func (r *UserRepository) AddLicenceDocument(doc *models.LicenceDocument) error {
+    tx, err := r.DB.Begin()
+    if err != nil {
+        return err
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO licence_documents (user_id, kind, storage_key, content_type, size_bytes)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at
+    `, doc.UserID, doc.Kind, doc.StorageKey, doc.ContentType, doc.Size).Scan(&doc.ID, &doc.CreatedAt)
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error saving licence document: %v", err)
+    }
+
+    if err := refreshVerificationStatus(tx, doc.UserID); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit()
+}
+
+func (r *UserRepository) GetLicenceDocument(docID int) (*models.LicenceDocument, error) {
+    var d models.LicenceDocument
+    err := r.DB.QueryRow(`
+        SELECT id, user_id, kind, storage_key, content_type, size_bytes, created_at
+        FROM licence_documents
+        WHERE id = $1
+    `, docID).Scan(&d.ID, &d.UserID, &d.Kind, &d.StorageKey, &d.ContentType, &d.Size, &d.CreatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("document not found")
+        }
+        return nil, err
+    }
+    return &d, nil
+}
+
+// GetLicenceDocuments lists a user's uploaded documents, oldest first
+func (r *UserRepository) GetLicenceDocuments(userID int) ([]models.LicenceDocument, error) {
+    rows, err := r.DB.Query(`
+        SELECT id, user_id, kind, storage_key, content_type, size_bytes, created_at
+        FROM licence_documents
+        WHERE user_id = $1
+        ORDER BY created_at
+    `, userID)
+    if err != nil {
+        return nil, fmt.Errorf("error querying licence documents: %v", err)
+    }
+    defer rows.Close()
+
+    docs := []models.LicenceDocument{}
+    for rows.Next() {
+        var d models.LicenceDocument
+        if err := rows.Scan(&d.ID, &d.UserID, &d.Kind, &d.StorageKey, &d.ContentType, &d.Size, &d.CreatedAt); err != nil {
+            return nil, fmt.Errorf("error scanning licence document: %v", err)
+        }
+        docs = append(docs, d)
+    }
+    return docs, rows.Err()
+}
+
+// GetPendingVerifications returns the operator review queue, oldest submission first
+func (r *UserRepository) GetPendingVerifications() ([]models.VerificationReview, error) {
+    rows, err := r.DB.Query(`
+        SELECT ` + userColumns + `
+        FROM users u
+        WHERE verification_status = 'pending'
+        ORDER BY (SELECT MAX(created_at) FROM licence_documents d WHERE d.user_id = u.id)
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("error querying pending verifications: %v", err)
+    }
+
+    var users []*models.User
+    for rows.Next() {
+        user, err := scanUser(rows)
+        if err != nil {
+            rows.Close()
+            return nil, fmt.Errorf("error scanning user row: %v", err)
+        }
+        users = append(users, user)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    queue := []models.VerificationReview{}
+    for _, u := range users {
+        docs, err := r.GetLicenceDocuments(u.ID)
+        if err != nil {
+            return nil, err
+        }
+        queue = append(queue, models.VerificationReview{User: *u, Documents: docs})
+    }
+    return queue, nil
+}
+
+func (r *UserRepository) ReviewVerification(userID, reviewerID int, decision models.ReviewDecision) error {
+    status := models.VerificationRejected
+    if decision.Approve {
+        status = models.VerificationVerified
+    }
+
+    var note interface{}
+    if decision.Note != "" {
+        note = decision.Note
+    }
+
+    result, err := r.DB.Exec(`
+        UPDATE users
+        SET verification_status = $1,
+            verification_note = $2,
+            reviewed_by = $3,
+            verified_at = CASE WHEN $1 = 'verified' THEN CURRENT_TIMESTAMP ELSE NULL END
+        WHERE id = $4 AND verification_status = 'pending'
+    `, status, note, reviewerID, userID)
+    if err != nil {
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("user not found or not awaiting review")
+    }
+    return nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/lib/pq"
+    "cnad-carsharinggo/services/user-service/models"
+)
+
+func (r *UserRepository) CreateOAuthClient(client *models.OAuthClient, createdBy int) error {
+    err := r.DB.QueryRow(`
+        INSERT INTO oauth_clients (client_id, name, redirect_uris, created_by)
+        VALUES ($1, $2, $3, $4)
+        RETURNING created_at
+    `, client.ClientID, client.Name, pq.Array(client.RedirectURIs), createdBy).Scan(&client.CreatedAt)
+    if err != nil {
+        return fmt.Errorf("error creating client: %v", err)
+    }
+    return nil
+}
+
+func (r *UserRepository) GetOAuthClient(clientID string) (*models.OAuthClient, error) {
+    var client models.OAuthClient
+    err := r.DB.QueryRow(`
+        SELECT client_id, name, redirect_uris, created_at FROM oauth_clients WHERE client_id = $1
+    `, clientID).Scan(&client.ClientID, &client.Name, pq.Array(&client.RedirectURIs), &client.CreatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("client not found")
+        }
+        return nil, err
+    }
+    return &client, nil
+}
+
+func (r *UserRepository) CreateAuthorizationCode(codeHash string, code models.AuthorizationCode, expiresAt time.Time) error {
+    _, err := r.DB.Exec(`
+        INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, code_challenge, scope, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, codeHash, code.ClientID, code.UserID, code.RedirectURI, code.CodeChallenge, code.Scope, expiresAt)
+    if err != nil {
+        return fmt.Errorf("error storing authorization code: %v", err)
+    }
+    return nil
+}
+
+// ConsumeAuthorizationCode marks an unexpired code as used and returns it.
+// A code can only be redeemed once.
+func (r *UserRepository) ConsumeAuthorizationCode(codeHash string) (*models.AuthorizationCode, error) {
+    var code models.AuthorizationCode
+    var scope sql.NullString
+    err := r.DB.QueryRow(`
+        UPDATE oauth_authorization_codes
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE code_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+        RETURNING client_id, user_id, redirect_uri, code_challenge, scope
+    `, codeHash).Scan(&code.ClientID, &code.UserID, &code.RedirectURI, &code.CodeChallenge, &scope)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("authorization code is invalid, expired or already used")
+        }
+        return nil, err
+    }
+    code.Scope = scope.String
+    return &code, nil
+}
@@ -1,121 +1,351 @@
-package repository
-
-import (
-    "database/sql"
-    "errors"
-    "time"
-    "fmt"
-    "strings"
-
-    "cnad-carsharinggo/services/user-service/models"
-    "golang.org/x/crypto/bcrypt"
-)
-
-type UserRepository struct {
-    DB *sql.DB
-}
-
-func NewUserRepository(db *sql.DB) *UserRepository {
-    return &UserRepository{DB: db}
-}
-
-func (r *UserRepository) CreateUser(user *models.User, password string) error {
-    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-    if err != nil {
-        return err
-    }
-
-    if user.MembershipTier == "" {
-        user.MembershipTier = "Basic"
-    }
-
-    query := `
-        INSERT INTO users (email, phone_number, password_hash, membership_tier, created_at)
-        VALUES ($1, $2, $3, $4, $5)
-        RETURNING id
-    `
-    err = r.DB.QueryRow(
-        query, 
-        user.Email, 
-        user.PhoneNumber, 
-        string(hashedPassword), 
-        user.MembershipTier, 
-        time.Now(),
-    ).Scan(&user.ID)
-
-    return err
-}
-
-func (r *UserRepository) FindByEmail(email string) (*models.User, string, error) {
-    var user models.User
-    var storedPassword string
-    query := `SELECT id, email, password_hash, membership_tier FROM users WHERE email = $1`
-    
-    err := r.DB.QueryRow(query, email).Scan(
-        &user.ID, 
-        &user.Email, 
-        &storedPassword, 
-        &user.MembershipTier,
-    )
-    if err != nil {
-        return nil, "", err
-    }
-
-    return &user, storedPassword, nil
-}
-
-func (r *UserRepository) UpdateProfile(userID string, updates models.UpdateProfileRequest) error {
-    var setClause []string
-    var updateValues []interface{}
-    paramCount := 1
-
-    // Build dynamic update query based on provided fields
-    if updates.Email != "" {
-        setClause = append(setClause, fmt.Sprintf("email = $%d", paramCount))
-        updateValues = append(updateValues, updates.Email)
-        paramCount++
-    }
-
-    if updates.PhoneNumber != "" {
-        setClause = append(setClause, fmt.Sprintf("phone_number = $%d", paramCount))
-        updateValues = append(updateValues, updates.PhoneNumber)
-        paramCount++
-    }
-
-    if updates.MembershipTier != "" {
-        setClause = append(setClause, fmt.Sprintf("membership_tier = $%d", paramCount))
-        updateValues = append(updateValues, updates.MembershipTier)
-        paramCount++
-    }
-
-    // If no updates provided
-    if len(setClause) == 0 {
-        return errors.New("no updates provided")
-    }
-
-    // Construct the final query
-    query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d",
-        strings.Join(setClause, ", "),
-        paramCount)
-    
-    // Add the userID as the last parameter
-    updateValues = append(updateValues, userID)
-
-    // Execute the update
-    result, err := r.DB.Exec(query, updateValues...)
-    if err != nil {
-        return err
-    }
-
-    // Check if any rows were affected
-    rowsAffected, err := result.RowsAffected()
-    if err != nil {
-        return err
-    }
-
-    if rowsAffected == 0 {
-        return errors.New("user not found")
-    }
-
-    return nil
-}
\ No newline at end of file
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+    "fmt"
+    "strings"
+
+    "cnad-carsharinggo/services/user-service/models"
+    "golang.org/x/crypto/bcrypt"
+)
+
+type UserRepository struct {
+    DB *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+    return &UserRepository{DB: db}
+}
+
+const userColumns = `
+    id, email, pending_email, COALESCE(phone_number, ''), membership_tier, role, status,
+    licence_number, licence_class, licence_expiry, licence_country,
+    verification_status, verification_note, verified_at, created_at
+`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+    var u models.User
+    err := row.Scan(
+        &u.ID, &u.Email, &u.PendingEmail, &u.PhoneNumber, &u.MembershipTier, &u.Role, &u.Status,
+        &u.LicenceNumber, &u.LicenceClass, &u.LicenceExpiry, &u.LicenceCountry,
+        &u.VerificationStatus, &u.VerificationNote, &u.VerifiedAt, &u.CreatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &u, nil
+}
+
+func (r *UserRepository) GetUserByID(userID int) (*models.User, error) {
+    user, err := scanUser(r.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, userID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("user not found")
+        }
+        return nil, err
+    }
+    return user, nil
+}
+
+// GetUserStatus returns whether the account is active, suspended or deleted
+func (r *UserRepository) GetUserStatus(userID int) (string, error) {
+    var status string
+    err := r.DB.QueryRow(`SELECT status FROM users WHERE id = $1`, userID).Scan(&status)
+    if err == sql.ErrNoRows {
+        return "", errors.New("user not found")
+    }
+    return status, err
+}
+
+func (r *UserRepository) CreateUser(user *models.User, password string) error {
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return err
+    }
+
+    if user.MembershipTier == "" {
+        user.MembershipTier = "Basic"
+    }
+
+    query := `
+        INSERT INTO users (email, phone_number, password_hash, membership_tier, created_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id
+    `
+    err = r.DB.QueryRow(
+        query, 
+        user.Email, 
+        user.PhoneNumber, 
+        string(hashedPassword), 
+        user.MembershipTier, 
+        time.Now(),
+    ).Scan(&user.ID)
+
+    return err
+}
+
+func (r *UserRepository) FindByEmail(email string) (*models.User, string, error) {
+    var user models.User
+    var storedPassword string
+    query := `SELECT id, email, password_hash, membership_tier, role, status FROM users WHERE email = $1`
+    
+    err := r.DB.QueryRow(query, email).Scan(
+        &user.ID, 
+        &user.Email, 
+        &storedPassword, 
+        &user.MembershipTier,
+        &user.Role,
+        &user.Status,
+    )
+    if err != nil {
+        return nil, "", err
+    }
+
+    return &user, storedPassword, nil
+}
+
+// UpdateProfile applies the phone number part of a profile patch. Email
+// changes go through RequestEmailChange instead.
+func (r *UserRepository) UpdateProfile(userID int, updates models.UpdateProfileRequest) error {
+    var setClause []string
+    var updateValues []interface{}
+    paramCount := 1
+
+    // Build dynamic update query based on provided fields; null clears a field
+    if updates.PhoneNumber.Set {
+        setClause = append(setClause, fmt.Sprintf("phone_number = $%d", paramCount))
+        updateValues = append(updateValues, updates.PhoneNumber.Value)
+        paramCount++
+    }
+
+    // Nothing to do here; the handler has already rejected empty patches
+    if len(setClause) == 0 {
+        return nil
+    }
+
+    // Construct the final query
+    query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d",
+        strings.Join(setClause, ", "),
+        paramCount)
+    
+    // Add the userID as the last parameter
+    updateValues = append(updateValues, userID)
+
+    // Execute the update
+    result, err := r.DB.Exec(query, updateValues...)
+    if err != nil {
+        return err
+    }
+
+    // Check if any rows were affected
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+
+    if rowsAffected == 0 {
+        return errors.New("user not found")
+    }
+
+    return nil
+}
+
+// EmailInUse reports whether another account already uses or is claiming the address
+func (r *UserRepository) EmailInUse(email string, exceptUserID int) (bool, error) {
+    var count int
+    err := r.DB.QueryRow(`
+        SELECT COUNT(*) FROM users
+        WHERE (LOWER(email) = LOWER($1) OR LOWER(pending_email) = LOWER($1)) AND id != $2
+    `, email, exceptUserID).Scan(&count)
+    return count > 0, err
+}
+
+// RequestEmailChange stores the new address until the user proves they own it
+func (r *UserRepository) RequestEmailChange(userID int, newEmail, tokenHash string, expiresAt time.Time) error {
+    result, err := r.DB.Exec(`
+        UPDATE users
+        SET pending_email = $1,
+            email_change_token_hash = $2,
+            email_change_expires_at = $3
+        WHERE id = $4
+    `, newEmail, tokenHash, expiresAt, userID)
+    if err != nil {
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("user not found")
+    }
+    return nil
+}
+
+// ConfirmEmailChange swaps in the pending email for the matching, unexpired token
+func (r *UserRepository) ConfirmEmailChange(tokenHash string) (*models.User, error) {
+    var userID int
+    err := r.DB.QueryRow(`
+        UPDATE users
+        SET email = pending_email,
+            pending_email = NULL,
+            email_change_token_hash = NULL,
+            email_change_expires_at = NULL
+        WHERE email_change_token_hash = $1
+          AND email_change_expires_at > CURRENT_TIMESTAMP
+          AND pending_email IS NOT NULL
+        RETURNING id
+    `, tokenHash).Scan(&userID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("verification link is invalid or has expired")
+        }
+        return nil, err
+    }
+
+    return r.GetUserByID(userID)
+}
+
+func (r *UserRepository) GetPasswordHash(userID int) (string, error) {
+    var hash string
+    err := r.DB.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&hash)
+    if err == sql.ErrNoRows {
+        return "", errors.New("user not found")
+    }
+    return hash, err
+}
+
+func (r *UserRepository) UpdatePassword(userID int, password string) error {
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return err
+    }
+
+    _, err = r.DB.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hashedPassword), userID)
+    return err
+}
+
+// SetMembershipTier moves a user to another pricing tier
+func (r *UserRepository) SetMembershipTier(userID int, tier string) error {
+    result, err := r.DB.Exec(`UPDATE users SET membership_tier = $1 WHERE id = $2`, tier, userID)
+    if err != nil {
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("user not found")
+    }
+    return nil
+}
+
+// MarkPaymentMethodsRemoved records that billing-service no longer holds
+// payment methods for a closed account
+func (r *UserRepository) MarkPaymentMethodsRemoved(userID int) error {
+    _, err := r.DB.Exec(`UPDATE users SET payment_methods_removed_at = CURRENT_TIMESTAMP WHERE id = $1`, userID)
+    return err
+}
+
+// GetPendingPaymentMethodRemovals lists closed accounts whose payment methods
+// haven't been removed from billing-service yet
+func (r *UserRepository) GetPendingPaymentMethodRemovals() ([]int, error) {
+    rows, err := r.DB.Query(`
+        SELECT id FROM users WHERE status = 'deleted' AND payment_methods_removed_at IS NULL ORDER BY id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// AnonymiseUser closes an account by scrubbing personal fields. The row itself is
+// kept so invoices that reference it stay valid until retainUntil. Returns the
+// storage keys of licence documents the caller should remove from the blob store.
+func (r *UserRepository) AnonymiseUser(userID int, retainUntil time.Time) ([]string, error) {
+    tx, err := r.DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := tx.Query(`DELETE FROM licence_documents WHERE user_id = $1 RETURNING storage_key`, userID)
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error deleting licence documents: %v", err)
+    }
+    var keys []string
+    for rows.Next() {
+        var key string
+        if err := rows.Scan(&key); err != nil {
+            rows.Close()
+            tx.Rollback()
+            return nil, err
+        }
+        keys = append(keys, key)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    // '!' is never a valid bcrypt hash, so the account can no longer log in
+    result, err := tx.Exec(`
+        UPDATE users
+        SET email = 'deleted-' || id || '@deleted.invalid',
+            pending_email = NULL,
+            email_change_token_hash = NULL,
+            email_change_expires_at = NULL,
+            phone_number = NULL,
+            password_hash = '!',
+            licence_number = NULL,
+            licence_class = NULL,
+            licence_expiry = NULL,
+            licence_country = NULL,
+            verification_status = 'unverified',
+            verification_note = NULL,
+            verified_at = NULL,
+            status = 'deleted',
+            deleted_at = CURRENT_TIMESTAMP,
+            data_retention_until = $1
+        WHERE id = $2 AND status != 'deleted'
+    `, retainUntil, userID)
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error anonymising user: %v", err)
+    }
+
+    affected, err := result.RowsAffected()
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+    if affected == 0 {
+        tx.Rollback()
+        return nil, errors.New("user not found")
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    return keys, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores uploaded documents. The local filesystem store is used by
+// default; other backends (S3, Supabase storage) only need to satisfy this interface.
+type BlobStore interface {
+    Put(key string, r io.Reader) (int64, error)
+    Get(key string) (io.ReadCloser, error)
+    Delete(key string) error
+}
+
+type LocalStore struct {
+    root string
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+    if err := os.MkdirAll(root, 0o750); err != nil {
+        return nil, fmt.Errorf("error creating blob directory: %v", err)
+    }
+    return &LocalStore{root: root}, nil
+}
+
+// path resolves a key inside the store root, rejecting keys that escape it
+func (s *LocalStore) path(key string) (string, error) {
+    cleaned := filepath.Clean("/" + key)
+    if cleaned == "/" || strings.Contains(key, "..") {
+        return "", fmt.Errorf("invalid blob key %q", key)
+    }
+    return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *LocalStore) Put(key string, r io.Reader) (int64, error) {
+    p, err := s.path(key)
+    if err != nil {
+        return 0, err
+    }
+
+    if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+        return 0, err
+    }
+
+    // Write to a temp file first so readers never see a partial blob
+    tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+    if err != nil {
+        return 0, err
+    }
+    defer os.Remove(tmp.Name())
+
+    n, err := io.Copy(tmp, r)
+    if err != nil {
+        tmp.Close()
+        return 0, err
+    }
+    if err := tmp.Close(); err != nil {
+        return 0, err
+    }
+
+    if err := os.Rename(tmp.Name(), p); err != nil {
+        return 0, err
+    }
+    return n, nil
+}
+
+func (s *LocalStore) Get(key string) (io.ReadCloser, error) {
+    p, err := s.path(key)
+    if err != nil {
+        return nil, err
+    }
+
+    f, err := os.Open(p)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    return f, nil
+}
+
+func (s *LocalStore) Delete(key string) error {
+    p, err := s.path(key)
+    if err != nil {
+        return err
+    }
+
+    if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
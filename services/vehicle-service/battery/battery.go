@@ -0,0 +1,86 @@
+// Package battery estimates how far an electric vehicle can go on its current
+// charge and whether that is enough for a planned trip.
+package battery
+
+import (
+    "errors"
+    "math"
+    "time"
+)
+
+const (
+    // Drivers are never planned below this share of the pack
+    ReservePercent = 10
+
+    // Straight-line distances are stretched by this factor to approximate roads
+    RoadFactor = 1.3
+
+    // A trip needing more than this share of the estimated range gets a warning
+    warnShare = 0.8
+
+    // Charging sessions end and the car returns to service at this level
+    ChargedLevel = 80
+
+    // Charger output assumed when none is given (a typical AC wallbox)
+    DefaultChargerKW = 11.0
+)
+
+// Spec is the per-model battery capacity and average consumption
+type Spec struct {
+    Model               string  `json:"model"`
+    CapacityKWh         float64 `json:"battery_capacity_kwh"`
+    ConsumptionPer100Km float64 `json:"consumption_kwh_per_100km"`
+}
+
+func (s Spec) Validate() error {
+    if s.CapacityKWh <= 0 {
+        return errors.New("battery_capacity_kwh must be positive")
+    }
+    if s.ConsumptionPer100Km <= 0 {
+        return errors.New("consumption_kwh_per_100km must be positive")
+    }
+    return nil
+}
+
+// RangeKm is the usable range at the given charge level, keeping the reserve
+// untouched. The result is rounded to one decimal place.
+func (s Spec) RangeKm(level int) float64 {
+    usable := float64(level - ReservePercent)
+    if usable <= 0 || s.ConsumptionPer100Km <= 0 {
+        return 0
+    }
+    km := s.CapacityKWh * usable / 100 / s.ConsumptionPer100Km * 100
+    return math.Round(km*10) / 10
+}
+
+// Feasibility is the verdict on a planned trip
+type Feasibility int
+
+const (
+    Feasible Feasibility = iota
+    Marginal             // possible, but with little range to spare
+    Infeasible
+)
+
+// Check compares a planned distance with the range available at level
+func (s Spec) Check(level int, distanceKm float64) Feasibility {
+    rangeKm := s.RangeKm(level)
+    switch {
+    case distanceKm > rangeKm:
+        return Infeasible
+    case distanceKm > rangeKm*warnShare:
+        return Marginal
+    default:
+        return Feasible
+    }
+}
+
+// ChargeDuration estimates how long a charger of powerKW takes to bring the
+// pack from one level to another
+func (s Spec) ChargeDuration(from, to int, powerKW float64) time.Duration {
+    if to <= from || powerKW <= 0 {
+        return 0
+    }
+    hours := s.CapacityKWh * float64(to-from) / 100 / powerKW
+    return time.Duration(hours * float64(time.Hour)).Round(time.Minute)
+}
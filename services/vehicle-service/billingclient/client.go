@@ -0,0 +1,192 @@
+// Package billingclient asks billing-service to price bookings and charge
+// cancellation and late return fees. Calls are authenticated as
+// vehicle-service with a signed service token.
+package billingclient
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "vehicle-service/serviceauth"
+)
+
+const requestTimeout = 3 * time.Second
+
+// Estimate is billing-service's price for a rental before any drop-off fee
+type Estimate struct {
+    Duration       float64 `json:"duration"` // hours
+    BaseRate       float64 `json:"base_rate"`
+    MemberDiscount float64 `json:"member_discount"`
+    FinalAmount    float64 `json:"final_amount"`
+}
+
+// CancellationPolicy is a membership tier's cancellation terms
+type CancellationPolicy struct {
+    MembershipTier   string  `json:"membership_tier"`
+    FreeHoursBefore  float64 `json:"free_hours_before"`
+    LateFeePercent   float64 `json:"late_fee_percent"`
+    NoShowFeePercent float64 `json:"no_show_fee_percent"`
+}
+
+// Invoice is the billing-service invoice raised for a fee
+type Invoice struct {
+    ID          int     `json:"id"`
+    FinalAmount float64 `json:"final_amount"`
+    Status      string  `json:"status"`
+}
+
+// CancellationFee is what cancelling a booking costs under the driver's
+// policy. Invoice is set once a non-zero fee has been charged.
+type CancellationFee struct {
+    Policy      CancellationPolicy `json:"policy"`
+    FreeUntil   time.Time          `json:"free_until"`
+    NoShow      bool               `json:"no_show"`
+    Waived      bool               `json:"waived"`
+    BookingCost float64            `json:"booking_cost"`
+    FeePercent  float64            `json:"fee_percent"`
+    Fee         float64            `json:"fee"`
+    Invoice     *Invoice           `json:"invoice,omitempty"`
+}
+
+// LateReturnFee is the charge for a car brought back after its booking ended
+type LateReturnFee struct {
+    LateHours float64  `json:"late_hours"`
+    Fee       float64  `json:"fee"`
+    Invoice   *Invoice `json:"invoice,omitempty"`
+}
+
+type Client struct {
+    baseURL  string
+    identity *serviceauth.Identity
+    http     *http.Client
+}
+
+func New(baseURL string, identity *serviceauth.Identity) *Client {
+    return &Client{
+        baseURL:  strings.TrimRight(baseURL, "/"),
+        identity: identity,
+        http:     &http.Client{Timeout: requestTimeout},
+    }
+}
+
+func (c *Client) do(ctx context.Context, path string, body, out interface{}) error {
+    ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+    defer cancel()
+
+    var payload bytes.Buffer
+    if err := json.NewEncoder(&payload).Encode(body); err != nil {
+        return err
+    }
+
+    token, err := c.identity.Token("billing-service")
+    if err != nil {
+        return fmt.Errorf("error signing service token: %v", err)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, &payload)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(serviceauth.Header, token)
+
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return fmt.Errorf("billing-service %s: %v", path, err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("billing-service %s: unexpected status %d", path, resp.StatusCode)
+    }
+
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return fmt.Errorf("billing-service %s: %v", path, err)
+    }
+    return nil
+}
+
+// Estimate prices a rental from start to end at the user's membership rate
+func (c *Client) Estimate(ctx context.Context, userID int, start, end time.Time) (*Estimate, error) {
+    var body struct {
+        Data struct {
+            Calculation Estimate `json:"calculation"`
+        } `json:"data"`
+    }
+    err := c.do(ctx, "/internal/estimates", map[string]interface{}{
+        "user_id":    userID,
+        "start_time": start.UTC().Format(time.RFC3339),
+        "end_time":   end.UTC().Format(time.RFC3339),
+    }, &body)
+    if err != nil {
+        return nil, err
+    }
+    return &body.Data.Calculation, nil
+}
+
+func (c *Client) cancellation(ctx context.Context, path string, userID, bookingID int, at time.Time, noShow, waived bool) (*CancellationFee, error) {
+    var body struct {
+        Data CancellationFee `json:"data"`
+    }
+    err := c.do(ctx, path, map[string]interface{}{
+        "booking_id":   bookingID,
+        "user_id":      userID,
+        "cancelled_at": at.UTC().Format(time.RFC3339),
+        "no_show":      noShow,
+        "waived":       waived,
+    }, &body)
+    if err != nil {
+        return nil, err
+    }
+    return &body.Data, nil
+}
+
+// QuoteCancellation prices cancelling a booking at the given time without
+// charging anything
+func (c *Client) QuoteCancellation(ctx context.Context, userID, bookingID int, at time.Time) (*CancellationFee, error) {
+    return c.cancellation(ctx, "/internal/cancellations/quote", userID, bookingID, at, false, false)
+}
+
+// ChargeCancellation voids the booking's rental invoice and raises one for
+// its cancellation fee. Charging a booking twice returns the first charge.
+func (c *Client) ChargeCancellation(ctx context.Context, userID, bookingID int, at time.Time, noShow bool) (*CancellationFee, error) {
+    return c.cancellation(ctx, "/internal/cancellations", userID, bookingID, at, noShow, false)
+}
+
+// WaiveCancellation voids the rental invoice of a booking the operator
+// cancelled, without charging the driver a fee
+func (c *Client) WaiveCancellation(ctx context.Context, userID, bookingID int, at time.Time) error {
+    _, err := c.cancellation(ctx, "/internal/cancellations", userID, bookingID, at, false, true)
+    return err
+}
+
+// RevertCancellation undoes a cancellation charge for a booking that couldn't
+// be cancelled: the unpaid fee invoice is dropped and the rental one restored
+func (c *Client) RevertCancellation(ctx context.Context, bookingID int) error {
+    var body struct{}
+    return c.do(ctx, "/internal/cancellations/revert", map[string]interface{}{
+        "booking_id": bookingID,
+    }, &body)
+}
+
+// ChargeLateReturn invoices the driver for the time between the booking's end
+// and returnedAt. Charging a booking twice returns the first charge.
+func (c *Client) ChargeLateReturn(ctx context.Context, userID, bookingID int, returnedAt time.Time) (*LateReturnFee, error) {
+    var body struct {
+        Data LateReturnFee `json:"data"`
+    }
+    err := c.do(ctx, "/internal/late-returns", map[string]interface{}{
+        "booking_id":  bookingID,
+        "user_id":     userID,
+        "returned_at": returnedAt.UTC().Format(time.RFC3339),
+    }, &body)
+    if err != nil {
+        return nil, err
+    }
+    return &body.Data, nil
+}
@@ -0,0 +1,107 @@
+// Package events fans vehicle and booking changes out to streaming clients.
+// Publishing never blocks: a client that falls behind is cut off and told to
+// resynchronise rather than slowing everyone else down.
+package events
+
+import (
+    "sync"
+    "time"
+)
+
+// Buffered events per client before it is considered too slow
+const clientBuffer = 64
+
+type Event struct {
+    ID        uint64      `json:"id"`
+    Type      string      `json:"type"`
+    VehicleID int         `json:"vehicle_id"`
+    StationID *int        `json:"station_id,omitempty"`
+    Time      time.Time   `json:"time"`
+    Data      interface{} `json:"data,omitempty"`
+}
+
+// Filter limits a subscription to some stations and/or vehicles. Empty sets match everything.
+type Filter struct {
+    StationIDs map[int]bool
+    VehicleIDs map[int]bool
+}
+
+func (f Filter) matches(e Event) bool {
+    if len(f.VehicleIDs) > 0 && !f.VehicleIDs[e.VehicleID] {
+        return false
+    }
+    if len(f.StationIDs) > 0 && (e.StationID == nil || !f.StationIDs[*e.StationID]) {
+        return false
+    }
+    return true
+}
+
+// Subscription delivers matching events on C. C is closed when the client
+// unsubscribes or, with Overflowed set, when it fell too far behind.
+type Subscription struct {
+    C      <-chan Event
+    ch     chan Event
+    filter Filter
+
+    overflowed bool
+}
+
+// Overflowed reports whether the subscription was dropped for being too slow.
+// Only meaningful once C is closed.
+func (s *Subscription) Overflowed() bool {
+    return s.overflowed
+}
+
+type Hub struct {
+    mu     sync.Mutex
+    nextID uint64
+    subs   map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+    return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+func (h *Hub) Subscribe(filter Filter) *Subscription {
+    ch := make(chan Event, clientBuffer)
+    sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+    h.mu.Lock()
+    h.subs[sub] = struct{}{}
+    h.mu.Unlock()
+    return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if _, ok := h.subs[sub]; ok {
+        delete(h.subs, sub)
+        close(sub.ch)
+    }
+}
+
+// Publish stamps the event and hands it to every matching subscriber
+func (h *Hub) Publish(e Event) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    h.nextID++
+    e.ID = h.nextID
+    if e.Time.IsZero() {
+        e.Time = time.Now().UTC()
+    }
+
+    for sub := range h.subs {
+        if !sub.filter.matches(e) {
+            continue
+        }
+        select {
+        case sub.ch <- e:
+        default:
+            sub.overflowed = true
+            delete(h.subs, sub)
+            close(sub.ch)
+        }
+    }
+}
@@ -0,0 +1,64 @@
+// Package geo has the distance maths used for "cars near me" searches.
+// Distances are great-circle distances in kilometres.
+package geo
+
+import (
+    "errors"
+    "fmt"
+    "math"
+)
+
+const earthRadiusKm = 6371.0
+
+type Point struct {
+    Lat float64 `json:"latitude"`
+    Lng float64 `json:"longitude"`
+}
+
+func (p Point) Validate() error {
+    if math.IsNaN(p.Lat) || p.Lat < -90 || p.Lat > 90 {
+        return errors.New("latitude must be between -90 and 90")
+    }
+    if math.IsNaN(p.Lng) || p.Lng < -180 || p.Lng > 180 {
+        return errors.New("longitude must be between -180 and 180")
+    }
+    return nil
+}
+
+func toRadians(deg float64) float64 {
+    return deg * math.Pi / 180
+}
+
+// DistanceKm returns the haversine distance between two points
+func DistanceKm(a, b Point) float64 {
+    dLat := toRadians(b.Lat - a.Lat)
+    dLng := toRadians(b.Lng - a.Lng)
+    h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+        math.Cos(toRadians(a.Lat))*math.Cos(toRadians(b.Lat))*math.Sin(dLng/2)*math.Sin(dLng/2)
+    return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// DistanceSQL is DistanceKm as a SQL expression, for filtering and ordering in
+// the database. latCol/lngCol are column names and latParam/lngParam placeholders.
+func DistanceSQL(latCol, lngCol, latParam, lngParam string) string {
+    return fmt.Sprintf(
+        "(2 * %g * asin(least(1, sqrt(power(sin(radians(%s - %s) / 2), 2) + cos(radians(%s)) * cos(radians(%s)) * power(sin(radians(%s - %s) / 2), 2)))))",
+        earthRadiusKm, latCol, latParam, latParam, latCol, lngCol, lngParam,
+    )
+}
+
+// BoundingBox returns the min/max latitude and longitude of a box enclosing
+// the circle, so the database can use an index before exact distances are computed
+func BoundingBox(center Point, radiusKm float64) (minLat, maxLat, minLng, maxLng float64) {
+    dLat := radiusKm / earthRadiusKm * 180 / math.Pi
+    minLat = math.Max(-90, center.Lat-dLat)
+    maxLat = math.Min(90, center.Lat+dLat)
+
+    // Near the poles a degree of longitude shrinks to nothing; search all longitudes there
+    cosLat := math.Cos(toRadians(center.Lat))
+    if cosLat < 1e-6 || maxLat >= 90 || minLat <= -90 {
+        return minLat, maxLat, -180, 180
+    }
+    dLng := dLat / cosLat
+    return minLat, maxLat, math.Max(-180, center.Lng-dLng), math.Min(180, center.Lng+dLng)
+}
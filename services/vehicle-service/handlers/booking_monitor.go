@@ -0,0 +1,159 @@
+package handlers
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+)
+
+const (
+    monitorInterval = time.Minute
+
+    // A booking whose car hasn't been unlocked this long after the start is a
+    // no-show; a trip still out once the lock grace has passed is overdue
+    noShowGrace     = 30 * time.Minute
+    lateReturnGrace = lockGrace
+
+    // Drivers whose booking starts within this window are warned when the car
+    // is still out on an overdue trip
+    delayWarningWindow = 3 * time.Hour
+
+    timeLayout = "2 Jan 15:04"
+)
+
+// MonitorBookings checks bookings every minute until ctx is done: it closes
+// no-shows, flags overdue trips, warns the drivers booked after them, charges
+// late returns once the car is locked, bases cars at the station one-way
+// trips left them at and offers freed cars to the waitlist
+func (h *VehicleHandler) MonitorBookings(ctx context.Context) {
+    ticker := time.NewTicker(monitorInterval)
+    defer ticker.Stop()
+
+    for {
+        h.closeNoShows(ctx)
+        h.flagOverdueTrips(ctx)
+        h.warnDelayedBookings(ctx)
+        h.chargeLateReturns(ctx)
+        h.rehomeVehicles()
+        h.matchWaitlist(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// notify emails a driver, logging rather than failing when user-service is down
+func (h *VehicleHandler) notify(ctx context.Context, userID int, subject, body string) {
+    if err := h.users.Notify(ctx, userID, subject, body); err != nil {
+        log.Printf("Error notifying user %d: %v", userID, err)
+    }
+}
+
+// closeNoShows charges the no-show fee and releases the car. A booking whose
+// fee can't be charged is left for the next run.
+func (h *VehicleHandler) closeNoShows(ctx context.Context) {
+    bookings, err := h.repo.GetNoShows(time.Now().Add(-noShowGrace))
+    if err != nil {
+        log.Printf("Error finding no-shows: %v", err)
+        return
+    }
+
+    for _, b := range bookings {
+        charge, err := h.billing.ChargeCancellation(ctx, b.UserID, b.ID, time.Now(), true)
+        if err != nil {
+            log.Printf("Error charging no-show for booking %d: %v", b.ID, err)
+            continue
+        }
+        if err := h.repo.MarkNoShow(b.ID, charge.Fee); err != nil {
+            log.Printf("Error marking booking %d as no-show: %v", b.ID, err)
+            continue
+        }
+
+        h.publishBooking(b.ID, "booking.no_show")
+        h.offerVehicle(ctx, b.VehicleID)
+        h.notify(ctx, b.UserID, "Your booking has been released",
+            fmt.Sprintf("Your %s booking from %s was not picked up within %d minutes and has been released. "+
+                "A no-show fee of $%.2f has been charged.",
+                b.VehicleModel, b.StartTime.Format(timeLayout), int(noShowGrace.Minutes()), charge.Fee))
+    }
+}
+
+// rehomeVehicles bases cars at the return station of the one-way trip they
+// finished, so later bookings pick them up there
+func (h *VehicleHandler) rehomeVehicles() {
+    moved, err := h.repo.RehomeOneWayTrips(time.Now())
+    if err != nil {
+        log.Printf("Error rehoming vehicles: %v", err)
+        return
+    }
+    for _, id := range moved {
+        h.publishVehicle(id)
+    }
+}
+
+// flagOverdueTrips tells drivers who haven't returned the car that their
+// booking has ended
+func (h *VehicleHandler) flagOverdueTrips(ctx context.Context) {
+    bookings, err := h.repo.FlagOverdueTrips(time.Now().Add(-lateReturnGrace))
+    if err != nil {
+        log.Printf("Error flagging overdue trips: %v", err)
+        return
+    }
+
+    for _, b := range bookings {
+        h.publishBookingChange(&b, "booking.overdue")
+        h.notify(ctx, b.UserID, "Your booking has ended",
+            fmt.Sprintf("Your %s booking ended at %s but the car hasn't been returned. Please return and lock it "+
+                "as soon as possible; late returns are charged until the car is locked.",
+                b.VehicleModel, b.EndTime.Format(timeLayout)))
+    }
+}
+
+// warnDelayedBookings tells drivers booked soon after an overdue trip that
+// their car may be late
+func (h *VehicleHandler) warnDelayedBookings(ctx context.Context) {
+    bookings, err := h.repo.FlagDelayedBookings(time.Now().Add(delayWarningWindow))
+    if err != nil {
+        log.Printf("Error flagging delayed bookings: %v", err)
+        return
+    }
+
+    for _, b := range bookings {
+        h.publishBookingChange(&b, "booking.delayed")
+        h.notify(ctx, b.UserID, "Your car may be delayed",
+            fmt.Sprintf("The %s you booked from %s hasn't been returned by its previous driver yet, so it may "+
+                "not be ready on time.",
+                b.VehicleModel, b.StartTime.Format(timeLayout)))
+    }
+}
+
+// chargeLateReturns invoices overdue trips once the car has been locked. A
+// trip whose fee can't be charged is left for the next run.
+func (h *VehicleHandler) chargeLateReturns(ctx context.Context) {
+    trips, err := h.repo.GetReturnedOverdueTrips()
+    if err != nil {
+        log.Printf("Error finding returned trips: %v", err)
+        return
+    }
+
+    for _, t := range trips {
+        charge, err := h.billing.ChargeLateReturn(ctx, t.UserID, t.ID, *t.ReturnedAt)
+        if err != nil {
+            log.Printf("Error charging late return for booking %d: %v", t.ID, err)
+            continue
+        }
+        if err := h.repo.RecordReturn(t.ID, *t.ReturnedAt, charge.Fee); err != nil {
+            log.Printf("Error recording return of booking %d: %v", t.ID, err)
+            continue
+        }
+
+        h.publishBooking(t.ID, "booking.returned")
+        h.notify(ctx, t.UserID, "Thanks for returning the car",
+            fmt.Sprintf("The car was returned %s after your booking ended. A late return fee of $%.2f has "+
+                "been charged.", t.ReturnedAt.Sub(t.EndTime).Round(time.Minute), charge.Fee))
+    }
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/ical"
+    "vehicle-service/models"
+    "vehicle-service/userclient"
+)
+
+const (
+    // Calendars show bookings that ended up to this long ago
+    calendarHistory = 30 * 24 * time.Hour
+
+    // How often subscribed calendars are asked to refetch the feed
+    calendarRefresh = 15 * time.Minute
+
+    pickupReminder = time.Hour
+    returnReminder = 15 * time.Minute
+)
+
+// bookingEvent turns a booking into its calendar event. The UID stays the
+// same for the life of the booking so changes replace the earlier event.
+func bookingEvent(b models.CalendarBooking) ical.Event {
+    status := "CONFIRMED"
+    if !bookingAccessStatuses[b.Status] {
+        status = "CANCELLED"
+    }
+
+    lines := []string{
+        fmt.Sprintf("Booking #%d", b.ID),
+        fmt.Sprintf("Vehicle: %s (%s)", b.VehicleModel, b.LicensePlate),
+    }
+    location := ""
+    if b.PickupLocation != nil {
+        location = *b.PickupLocation
+        lines = append(lines, "Pick up: "+location)
+    }
+    if b.ReturnLocation != nil {
+        lines = append(lines, "Return to: "+*b.ReturnLocation)
+    }
+    if status == "CANCELLED" {
+        lines = append(lines, "This booking has been cancelled.")
+    } else {
+        lines = append(lines, "Unlock the car in the app when the booking starts.")
+    }
+
+    return ical.Event{
+        UID:          fmt.Sprintf("booking-%d@vehicle-service", b.ID),
+        Sequence:     b.Revision,
+        Start:        b.StartTime,
+        End:          b.EndTime,
+        Summary:      fmt.Sprintf("Car: %s (%s)", b.VehicleModel, b.LicensePlate),
+        Location:     location,
+        Description:  strings.Join(lines, "\n"),
+        Status:       status,
+        LastModified: b.UpdatedAt,
+        Reminders: []ical.Reminder{
+            {Before: pickupReminder, Description: fmt.Sprintf("Your %s booking starts in an hour", b.VehicleModel)},
+            {Before: returnReminder, FromEnd: true, Description: fmt.Sprintf("Your %s booking ends in 15 minutes", b.VehicleModel)},
+        },
+    }
+}
+
+// writeCalendar answers with the driver's bookings as an iCalendar file
+func (h *VehicleHandler) writeCalendar(w http.ResponseWriter, userID int, filename string) {
+    now := time.Now()
+    bookings, err := h.repo.GetCalendarBookings(userID, now.Add(-calendarHistory))
+    if err != nil {
+        log.Printf("Error getting calendar bookings: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get bookings",
+        })
+        return
+    }
+
+    cal := ical.Calendar{
+        ProdID:  "-//CNAD Car Sharing//Bookings//EN",
+        Name:    "Car sharing bookings",
+        Refresh: calendarRefresh,
+    }
+    for _, b := range bookings {
+        cal.Events = append(cal.Events, bookingEvent(b))
+    }
+
+    w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-cache")
+    if filename != "" {
+        w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+    }
+    if err := cal.Write(w, now); err != nil {
+        log.Printf("Error writing calendar: %v", err)
+    }
+}
+
+// ExportCalendar downloads the driver's bookings as an .ics file
+func (h *VehicleHandler) ExportCalendar(w http.ResponseWriter, r *http.Request) {
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    h.writeCalendar(w, userID, "bookings.ics")
+}
+
+// CreateCalendarFeed issues the driver a secret subscription URL for their
+// bookings. Creating a new one stops the old URL working.
+func (h *VehicleHandler) CreateCalendarFeed(w http.ResponseWriter, r *http.Request) {
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    token, createdAt, err := h.repo.CreateCalendarToken(userID)
+    if err != nil {
+        log.Printf("Error creating calendar feed: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to create calendar feed",
+        })
+        return
+    }
+
+    scheme := "http"
+    if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+        scheme = "https"
+    }
+    path := r.Host + "/api/calendar/" + token + ".ics"
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: models.CalendarFeed{
+            URL:       scheme + "://" + path,
+            WebcalURL: "webcal://" + path,
+            CreatedAt: createdAt,
+        },
+    })
+}
+
+// DeleteCalendarFeed stops the driver's subscription URL working
+func (h *VehicleHandler) DeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    if err := h.repo.RevokeCalendarToken(userID); err != nil {
+        sendRepoError(w, err)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]string{"message": "calendar feed revoked"},
+    })
+}
+
+// CalendarFeed serves a subscribed calendar. Calendar apps can't send a
+// bearer token, so the secret token in the URL identifies the driver. Feeds
+// of closed or suspended accounts are gone.
+func (h *VehicleHandler) CalendarFeed(w http.ResponseWriter, r *http.Request) {
+    userID, err := h.repo.CalendarTokenUser(mux.Vars(r)["token"])
+    if err != nil {
+        sendRepoError(w, err)
+        return
+    }
+
+    driver, err := h.users.GetUser(r.Context(), userID)
+    if err != nil && err != userclient.ErrNotFound {
+        log.Printf("Error checking calendar feed account: %v", err)
+        sendJSON(w, http.StatusBadGateway, Response{
+            Success: false,
+            Error: "failed to check the account",
+        })
+        return
+    }
+    if err == userclient.ErrNotFound || driver.Status != "active" {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "calendar not found",
+        })
+        return
+    }
+
+    h.writeCalendar(w, userID, "")
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/battery"
+    "vehicle-service/models"
+)
+
+func parseVehicleID(w http.ResponseWriter, r *http.Request) (int, bool) {
+    vehicleID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid vehicle ID",
+        })
+        return 0, false
+    }
+    return vehicleID, true
+}
+
+func validLevel(level int) bool {
+    return level >= 0 && level <= 100
+}
+
+// readyAt projects when a vehicle charging from level will reach
+// battery.ChargedLevel. It is nil when the model has no battery spec.
+func readyAt(vehicle *models.Vehicle, level int, powerKW float64) *time.Time {
+    if vehicle.Spec == nil {
+        return nil
+    }
+    t := time.Now().Add(vehicle.Spec.ChargeDuration(level, battery.ChargedLevel, powerKW))
+    return &t
+}
+
+// sendRepoError maps repository errors onto HTTP statuses: "not found" is a
+// 404, anything else a conflict with the current state
+func sendRepoError(w http.ResponseWriter, err error) {
+    status := http.StatusConflict
+    if strings.HasSuffix(err.Error(), "not found") {
+        status = http.StatusNotFound
+    }
+    sendJSON(w, status, Response{
+        Success: false,
+        Error: err.Error(),
+    })
+}
+
+// StartCharging plugs a vehicle into a charger
+func (h *VehicleHandler) StartCharging(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        ChargerID    string   `json:"charger_id"`
+        StationID    *int     `json:"station_id"`
+        PowerKW      *float64 `json:"power_kw"`
+        BatteryLevel *int     `json:"battery_level"` // defaults to the last reported level
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    req.ChargerID = strings.TrimSpace(req.ChargerID)
+    if req.ChargerID == "" {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "charger_id is required",
+        })
+        return
+    }
+
+    powerKW := battery.DefaultChargerKW
+    if req.PowerKW != nil {
+        if *req.PowerKW <= 0 {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "power_kw must be positive",
+            })
+            return
+        }
+        powerKW = *req.PowerKW
+    }
+
+    vehicle, err := h.repo.GetVehicleByID(vehicleID)
+    if err != nil {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    level := req.BatteryLevel
+    if level == nil {
+        level = vehicle.BatteryLevel
+    }
+    if level == nil || !validLevel(*level) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "battery_level between 0 and 100 is required",
+        })
+        return
+    }
+
+    session := &models.ChargingSession{
+        VehicleID:       vehicleID,
+        ChargerID:       req.ChargerID,
+        StationID:       req.StationID,
+        PowerKW:         powerKW,
+        StartLevel:      *level,
+        ExpectedReadyAt: readyAt(vehicle, *level, powerKW),
+    }
+    if err := h.repo.StartCharging(session); err != nil {
+        log.Printf("Error starting charging session: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishVehicle(vehicleID)
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: session,
+    })
+}
+
+// UpdateCharging reports progress from the charger. The session ends by itself
+// once the battery reaches battery.ChargedLevel.
+func (h *VehicleHandler) UpdateCharging(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        BatteryLevel *int     `json:"battery_level"`
+        EnergyKWh    *float64 `json:"energy_kwh"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+    if req.BatteryLevel == nil || !validLevel(*req.BatteryLevel) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "battery_level between 0 and 100 is required",
+        })
+        return
+    }
+
+    vehicle, err := h.repo.GetVehicleByID(vehicleID)
+    if err != nil {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    open, err := h.repo.GetOpenChargingSession(vehicleID)
+    if err != nil {
+        sendRepoError(w, err)
+        return
+    }
+
+    // Re-project from the new level using the session's charger power
+    session, err := h.repo.UpdateCharging(vehicleID, *req.BatteryLevel, req.EnergyKWh, readyAt(vehicle, *req.BatteryLevel, open.PowerKW))
+    if err != nil {
+        log.Printf("Error updating charging session: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishVehicle(vehicleID)
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: session,
+    })
+}
+
+// StopCharging unplugs a vehicle, whatever its charge, and returns it to service
+func (h *VehicleHandler) StopCharging(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        BatteryLevel *int     `json:"battery_level"`
+        EnergyKWh    *float64 `json:"energy_kwh"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "invalid request body",
+            })
+            return
+        }
+    }
+    if req.BatteryLevel != nil && !validLevel(*req.BatteryLevel) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "battery_level must be between 0 and 100",
+        })
+        return
+    }
+
+    session, err := h.repo.StopCharging(vehicleID, req.BatteryLevel, req.EnergyKWh)
+    if err != nil {
+        log.Printf("Error stopping charging session: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishVehicle(vehicleID)
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: session,
+    })
+}
+
+func (h *VehicleHandler) GetChargingSessions(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    sessions, err := h.repo.GetChargingSessions(vehicleID)
+    if err != nil {
+        log.Printf("Error getting charging sessions: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get charging sessions",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: sessions,
+    })
+}
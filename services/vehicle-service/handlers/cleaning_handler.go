@@ -0,0 +1,309 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/models"
+    "vehicle-service/repository"
+)
+
+const (
+    // A car is put on the cleaning queue after this many trips without a clean
+    cleaningTripInterval = 5
+
+    // Ratings at or below this flag the car as needing cleaning
+    cleanlinessFlagRating = 2
+)
+
+var cleanlinessStatuses = map[string]bool{
+    "clean":          true,
+    "needs_cleaning": true,
+}
+
+func parseTaskID(w http.ResponseWriter, r *http.Request) (int, bool) {
+    taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid task ID",
+        })
+        return 0, false
+    }
+    return taskID, true
+}
+
+// GetCleaningQueue is the crew's work queue: open and in-progress tasks, cars
+// reported dirty first. station_id narrows it to one station.
+func (h *VehicleHandler) GetCleaningQueue(w http.ResponseWriter, r *http.Request) {
+    status := r.URL.Query().Get("status")
+    switch status {
+    case "", "open", "in_progress", "completed":
+    default:
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "status must be one of open, in_progress, completed",
+        })
+        return
+    }
+
+    var stationID *int
+    if raw := r.URL.Query().Get("station_id"); raw != "" {
+        id, err := strconv.Atoi(raw)
+        if err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "invalid station_id",
+            })
+            return
+        }
+        stationID = &id
+    }
+
+    // Cars that reached their trip count since the last look join the queue now
+    if err := h.repo.QueueDueCleanings(cleaningTripInterval); err != nil {
+        log.Printf("Error queueing due cleanings: %v", err)
+    }
+
+    tasks, err := h.repo.GetCleaningQueue(status, stationID)
+    if err != nil {
+        log.Printf("Error getting cleaning queue: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get cleaning tasks",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: tasks,
+    })
+}
+
+// ClaimCleaningTask assigns an open task to the calling crew member
+func (h *VehicleHandler) ClaimCleaningTask(w http.ResponseWriter, r *http.Request) {
+    taskID, ok := parseTaskID(w, r)
+    if !ok {
+        return
+    }
+
+    crewID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    task, err := h.repo.ClaimCleaningTask(taskID, crewID)
+    if err != nil {
+        sendRepoError(w, err)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: task,
+    })
+}
+
+// CompleteCleaningTask closes a task. It is a multipart form with optional
+// notes and one to six photos of the cleaned car; the vehicle is marked clean
+// and can be booked again.
+func (h *VehicleHandler) CompleteCleaningTask(w http.ResponseWriter, r *http.Request) {
+    taskID, ok := parseTaskID(w, r)
+    if !ok {
+        return
+    }
+
+    crewID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    if !parsePhotoForm(w, r) {
+        return
+    }
+    defer r.MultipartForm.RemoveAll()
+
+    files := r.MultipartForm.File["photos"]
+    if len(files) == 0 {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "at least one photo of the cleaned car is required",
+        })
+        return
+    }
+
+    var notes *string
+    if raw := strings.TrimSpace(r.FormValue("notes")); raw != "" {
+        notes = &raw
+    }
+
+    stored, status, err := h.storePhotos(files, fmt.Sprintf("cleaning/%d", taskID))
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    photos := make([]models.CleaningPhoto, 0, len(stored))
+    for _, photo := range stored {
+        photos = append(photos, models.CleaningPhoto{
+            StorageKey:  photo.key,
+            ContentType: photo.contentType,
+            Size:        photo.size,
+        })
+    }
+
+    task, err := h.repo.CompleteCleaningTask(taskID, crewID, notes, photos)
+    if err == repository.ErrTaskClaimed {
+        h.deletePhotos(stored)
+        sendJSON(w, http.StatusForbidden, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+    if err != nil {
+        log.Printf("Error completing cleaning task: %v", err)
+        h.deletePhotos(stored)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishVehicle(task.VehicleID)
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: task,
+    })
+}
+
+func (h *VehicleHandler) GetCleaningPhoto(w http.ResponseWriter, r *http.Request) {
+    taskID, ok := parseTaskID(w, r)
+    if !ok {
+        return
+    }
+    photoID, err := strconv.Atoi(mux.Vars(r)["photoId"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid photo ID",
+        })
+        return
+    }
+
+    photo, err := h.repo.GetCleaningPhoto(taskID, photoID)
+    if err != nil {
+        sendRepoError(w, err)
+        return
+    }
+
+    h.servePhoto(w, photo.StorageKey, photo.ContentType)
+}
+
+// RateCleanliness lets the driver score the car's cleanliness from 1 to 5
+// during the trip or up to a day after it. A low score sends the car for
+// cleaning.
+func (h *VehicleHandler) RateCleanliness(w http.ResponseWriter, r *http.Request) {
+    bookingID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid booking ID",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    var req struct {
+        Rating  int     `json:"rating"`
+        Comment *string `json:"comment"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+    if req.Rating < 1 || req.Rating > 5 {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "rating must be between 1 and 5",
+        })
+        return
+    }
+
+    booking, err := h.repo.GetBookingByID(bookingID)
+    if err != nil || booking.UserID != userID {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "booking not found or unauthorized",
+        })
+        return
+    }
+    if !bookingAccessStatuses[booking.Status] {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking is " + booking.Status,
+        })
+        return
+    }
+
+    opens, closes := damageReportWindow(booking, "end")
+    now := time.Now()
+    if now.Before(opens) || now.After(closes) {
+        sendJSON(w, http.StatusForbidden, Response{
+            Success: false,
+            Error: "a booking can be rated from its start until a day after it ends",
+        })
+        return
+    }
+
+    rating := &models.CleanlinessRating{
+        BookingID: booking.ID,
+        VehicleID: booking.VehicleID,
+        UserID:    userID,
+        Rating:    req.Rating,
+        Comment:   req.Comment,
+    }
+    flagged, err := h.repo.RateCleanliness(rating, cleanlinessFlagRating)
+    if err != nil {
+        log.Printf("Error saving cleanliness rating: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    if flagged {
+        h.publishVehicle(rating.VehicleID)
+    }
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: rating,
+    })
+}
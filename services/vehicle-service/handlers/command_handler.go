@@ -0,0 +1,247 @@
+package handlers
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/models"
+    "vehicle-service/userclient"
+)
+
+const (
+    // Devices must pick up and answer a command within this time
+    commandTTL = 30 * time.Second
+
+    // Cars can be opened a little before the booking starts, and locked for a
+    // while after it ends so a late return can still be secured
+    accessEarly = 5 * time.Minute
+    lockGrace   = 15 * time.Minute
+)
+
+// bookingAccessStatuses are the booking states that give the driver access to the car
+var bookingAccessStatuses = map[string]bool{
+    "pending":   true,
+    "confirmed": true,
+}
+
+func (h *VehicleHandler) UnlockBooking(w http.ResponseWriter, r *http.Request) {
+    h.sendBookingCommand(w, r, "unlock")
+}
+
+func (h *VehicleHandler) LockBooking(w http.ResponseWriter, r *http.Request) {
+    h.sendBookingCommand(w, r, "lock")
+}
+
+// sendBookingCommand queues a lock or unlock for the booked car, but only for
+// the booking's driver and only inside the booking window
+func (h *VehicleHandler) sendBookingCommand(w http.ResponseWriter, r *http.Request, command string) {
+    bookingID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid booking ID",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    booking, err := h.repo.GetBookingByID(bookingID)
+    if err != nil || booking.UserID != userID {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "booking not found or unauthorized",
+        })
+        return
+    }
+
+    if !bookingAccessStatuses[booking.Status] {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking is " + booking.Status,
+        })
+        return
+    }
+
+    now := time.Now()
+    closes := booking.EndTime
+    if command == "lock" {
+        closes = closes.Add(lockGrace)
+        // An overdue car can be locked whenever it is brought back
+        if booking.OverdueAt != nil && booking.ReturnedAt == nil {
+            closes = now
+        }
+    }
+    if now.Before(booking.StartTime.Add(-accessEarly)) || now.After(closes) {
+        sendJSON(w, http.StatusForbidden, Response{
+            Success: false,
+            Error: "the car can only be " + command + "ed during the booking",
+        })
+        return
+    }
+
+    connected, err := h.repo.VehicleHasDevice(booking.VehicleID)
+    if err != nil {
+        log.Printf("Error checking vehicle device: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to send command",
+        })
+        return
+    }
+    if !connected {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "this vehicle has no connected device",
+        })
+        return
+    }
+
+    queued := &models.VehicleCommand{
+        VehicleID: booking.VehicleID,
+        BookingID: &booking.ID,
+        UserID:    &userID,
+        Command:   command,
+    }
+    if err := h.repo.QueueCommand(queued, commandTTL); err != nil {
+        log.Printf("Error queueing %s command: %v", command, err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to send command",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusAccepted, Response{
+        Success: true,
+        Data: queued,
+    })
+}
+
+// GetCommand lets the requesting driver (or an operator) follow a command's progress
+func (h *VehicleHandler) GetCommand(w http.ResponseWriter, r *http.Request) {
+    commandID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid command ID",
+        })
+        return
+    }
+
+    userID, _ := r.Context().Value("user_id").(int)
+    user, _ := r.Context().Value("user").(*userclient.User)
+
+    command, err := h.repo.GetCommand(commandID)
+    if err != nil || ((command.UserID == nil || *command.UserID != userID) && (user == nil || user.Role != "operator")) {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "command not found",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: command,
+    })
+}
+
+// GetDeviceCommands is polled by in-car devices for commands to carry out
+func (h *VehicleHandler) GetDeviceCommands(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := r.Context().Value("device_vehicle_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    commands, err := h.repo.TakePendingCommands(vehicleID)
+    if err != nil {
+        log.Printf("Error getting device commands: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get commands",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: commands,
+    })
+}
+
+// AcknowledgeCommand records whether the device carried out a command
+func (h *VehicleHandler) AcknowledgeCommand(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := r.Context().Value("device_vehicle_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    commandID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid command ID",
+        })
+        return
+    }
+
+    var req struct {
+        Success bool   `json:"success"`
+        Error   string `json:"error"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    var failure *string
+    if !req.Success {
+        reason := strings.TrimSpace(req.Error)
+        if reason == "" {
+            reason = "device reported failure"
+        }
+        failure = &reason
+    }
+
+    command, err := h.repo.AcknowledgeCommand(vehicleID, commandID, req.Success, failure)
+    if err != nil {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    if req.Success {
+        h.publishVehicle(vehicleID)
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: command,
+    })
+}
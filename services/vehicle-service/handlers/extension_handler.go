@@ -0,0 +1,326 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/models"
+    "vehicle-service/repository"
+    "vehicle-service/userclient"
+)
+
+// loadOwnBooking reads the {id} booking and checks it belongs to the caller
+func (h *VehicleHandler) loadOwnBooking(w http.ResponseWriter, r *http.Request) (*models.Booking, bool) {
+    bookingID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid booking ID",
+        })
+        return nil, false
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return nil, false
+    }
+
+    booking, err := h.repo.GetBookingByID(bookingID)
+    if err != nil || booking.UserID != userID {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "booking not found or unauthorized",
+        })
+        return nil, false
+    }
+    return booking, true
+}
+
+// estimateCost prices a booking window with billing-service. It returns nil
+// when billing-service can't be reached, so amendments don't depend on it.
+func (h *VehicleHandler) estimateCost(ctx context.Context, userID int, start, end time.Time) *float64 {
+    estimate, err := h.billing.Estimate(ctx, userID, start, end)
+    if err != nil {
+        log.Printf("Error estimating booking cost: %v", err)
+        return nil
+    }
+    return &estimate.FinalAmount
+}
+
+// amend prices and applies a change of times, then answers with the booking,
+// the recorded amendment and the change in estimated cost
+func (h *VehicleHandler) amend(w http.ResponseWriter, r *http.Request, booking *models.Booking, kind string, start, end time.Time) {
+    amendment := &models.BookingAmendment{
+        BookingID:        booking.ID,
+        Kind:             kind,
+        NewStartTime:     start,
+        NewEndTime:       end,
+        PreviousEstimate: h.estimateCost(r.Context(), booking.UserID, booking.StartTime, booking.EndTime),
+        NewEstimate:      h.estimateCost(r.Context(), booking.UserID, start, end),
+        AmendedBy:        booking.UserID,
+    }
+
+    if err := h.repo.AmendBooking(amendment); err != nil {
+        if err == repository.ErrBookingConflict {
+            limit, _ := h.repo.GetExtensionLimit(booking.ID)
+            sendJSON(w, http.StatusConflict, Response{
+                Success: false,
+                Data: map[string]interface{}{"max_end_time": limit},
+                Error: err.Error(),
+            })
+            return
+        }
+        log.Printf("Error amending booking: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishBooking(booking.ID, "booking.updated")
+
+    data := map[string]interface{}{
+        "booking_id": booking.ID,
+        "start_time": start,
+        "end_time":   end,
+        "amendment":  amendment,
+    }
+    var warning string
+    if amendment.PreviousEstimate != nil && amendment.NewEstimate != nil {
+        data["cost_change"] = math.Round((*amendment.NewEstimate-*amendment.PreviousEstimate)*100) / 100
+    } else {
+        warning = "the booking was changed but its new cost couldn't be estimated"
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: data,
+        Warning: warning,
+    })
+}
+
+// GetExtensionOffer tells the driver how far the booking can be extended:
+// up to the start of the vehicle's next booking, or without limit
+func (h *VehicleHandler) GetExtensionOffer(w http.ResponseWriter, r *http.Request) {
+    booking, ok := h.loadOwnBooking(w, r)
+    if !ok {
+        return
+    }
+
+    limit, err := h.repo.GetExtensionLimit(booking.ID)
+    if err != nil {
+        log.Printf("Error getting extension limit: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to check the vehicle's next booking",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]interface{}{
+            "booking_id":   booking.ID,
+            "end_time":     booking.EndTime,
+            "max_end_time": limit,
+        },
+    })
+}
+
+// ExtendBooking moves a booking's end later. The body gives either the new
+// end_time or the extra minutes; max=true takes the longest extension the
+// vehicle's next booking allows.
+func (h *VehicleHandler) ExtendBooking(w http.ResponseWriter, r *http.Request) {
+    booking, ok := h.loadOwnBooking(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        EndTime *time.Time `json:"end_time"`
+        Minutes *int       `json:"minutes"`
+        Max     bool       `json:"max"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    if !bookingAccessStatuses[booking.Status] {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking is " + booking.Status,
+        })
+        return
+    }
+    if time.Now().After(booking.EndTime) {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking has already ended",
+        })
+        return
+    }
+
+    var end time.Time
+    switch {
+    case req.EndTime != nil:
+        end = *req.EndTime
+    case req.Minutes != nil:
+        end = booking.EndTime.Add(time.Duration(*req.Minutes) * time.Minute)
+    case req.Max:
+        limit, err := h.repo.GetExtensionLimit(booking.ID)
+        if err != nil {
+            log.Printf("Error getting extension limit: %v", err)
+            sendJSON(w, http.StatusInternalServerError, Response{
+                Success: false,
+                Error: "failed to check the vehicle's next booking",
+            })
+            return
+        }
+        if limit == nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "the vehicle has no later booking; give end_time or minutes",
+            })
+            return
+        }
+        end = *limit
+    default:
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "one of end_time, minutes or max is required",
+        })
+        return
+    }
+    if !end.After(booking.EndTime) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "the new end must be after the current end",
+        })
+        return
+    }
+
+    if status, err := h.checkNewTimes(r.Context(), booking, booking.StartTime, end); err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    h.amend(w, r, booking, "extension", booking.StartTime, end)
+}
+
+// checkNewTimes applies the booking rules that depend on a changed booking's
+// times: the driver's licence must still be valid, the return station open,
+// the booking within their membership tier's length and advance limits, and
+// the car neither out for maintenance nor short of range for the planned trip
+func (h *VehicleHandler) checkNewTimes(ctx context.Context, booking *models.Booking, start, end time.Time) (int, error) {
+    driver, err := h.users.GetUser(ctx, booking.UserID)
+    if err == userclient.ErrNotFound {
+        return http.StatusNotFound, err
+    }
+    if err != nil {
+        log.Printf("Error getting driver licence: %v", err)
+        return http.StatusInternalServerError, fmt.Errorf("failed to verify driver licence")
+    }
+    if !licenceCovers(driver, end) {
+        return http.StatusForbidden, fmt.Errorf("driver licence expires before the new end of this booking")
+    }
+
+    policy, err := h.repo.GetBookingPolicy(driver.MembershipTier)
+    if err != nil {
+        log.Printf("Error getting booking policy: %v", err)
+        return http.StatusInternalServerError, fmt.Errorf("failed to check booking policy")
+    }
+    if policy != nil {
+        if err := checkPolicyTimes(policy, start, end, !start.Equal(booking.StartTime)); err != nil {
+            return http.StatusForbidden, err
+        }
+    }
+
+    if booking.ReturnStationID != nil {
+        dropoff, err := h.repo.GetStationByID(*booking.ReturnStationID)
+        if err != nil {
+            return http.StatusInternalServerError, err
+        }
+        if !stationOpenAt(dropoff, end) {
+            return http.StatusBadRequest, fmt.Errorf("%s is closed at the new end of this booking", dropoff.Name)
+        }
+    }
+
+    blocked, err := h.repo.VehicleBlocked(booking.VehicleID, start)
+    if err != nil {
+        log.Printf("Error checking maintenance tickets: %v", err)
+        return http.StatusInternalServerError, fmt.Errorf("failed to check the vehicle")
+    }
+    if blocked {
+        return http.StatusConflict, fmt.Errorf("this vehicle is out of service for maintenance")
+    }
+
+    if booking.PlannedDistanceKm != nil {
+        vehicle, err := h.repo.GetVehicleByID(booking.VehicleID)
+        if err != nil {
+            return http.StatusInternalServerError, err
+        }
+        if _, err := checkRange(vehicle, *booking.PlannedDistanceKm); err != nil {
+            return http.StatusUnprocessableEntity, err
+        }
+    }
+    return 0, nil
+}
+
+// licenceCovers reports whether the licence is valid through t; it is valid
+// through its expiry date
+func licenceCovers(driver *userclient.User, t time.Time) bool {
+    return driver.LicenceExpiry != nil && !driver.LicenceExpiry.AddDate(0, 0, 1).Before(t)
+}
+
+// GetBookingAmendments lists a booking's changes for its driver or an operator
+func (h *VehicleHandler) GetBookingAmendments(w http.ResponseWriter, r *http.Request) {
+    bookingID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid booking ID",
+        })
+        return
+    }
+
+    booking, err := h.repo.GetBookingByID(bookingID)
+    user, _ := r.Context().Value("user").(*userclient.User)
+    if err != nil || user == nil || (user.Role != "operator" && user.ID != booking.UserID) {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "booking not found or unauthorized",
+        })
+        return
+    }
+
+    amendments, err := h.repo.GetBookingAmendments(bookingID)
+    if err != nil {
+        log.Printf("Error getting booking amendments: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get booking history",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: amendments,
+    })
+}
@@ -0,0 +1,489 @@
+package handlers
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+
+    "vehicle-service/geo"
+    "vehicle-service/models"
+)
+
+const (
+    defaultHourlyRate = 9.00
+    maxImportRows     = 1000
+    maxImportSize     = 1 << 20 // 1 MB
+)
+
+// Singapore plates: a one to three letter prefix, up to four digits and a checksum letter
+var licensePlatePattern = regexp.MustCompile(`^[A-Z]{1,3}[0-9]{1,4}[A-Z]$`)
+
+// vehicleFields is a new vehicle as sent to the create and import endpoints
+type vehicleFields struct {
+    Model         string   `json:"model"`
+    Type          string   `json:"type"`
+    LicensePlate  string   `json:"license_plate"`
+    HourlyRate    *float64 `json:"hourly_rate"`
+    BatteryLevel  *int     `json:"battery_level"`
+    HomeStationID *int     `json:"home_station_id"`
+    Location      *string  `json:"location"`
+    Latitude      *float64 `json:"latitude"`
+    Longitude     *float64 `json:"longitude"`
+    VehicleClass  *string  `json:"vehicle_class"`
+}
+
+// normalizePlate upper-cases a plate and drops spaces and dashes
+func normalizePlate(plate string) string {
+    plate = strings.ToUpper(plate)
+    return strings.NewReplacer(" ", "", "-", "").Replace(plate)
+}
+
+func validatePlate(plate string) error {
+    if !licensePlatePattern.MatchString(plate) {
+        return fmt.Errorf("license plate %q is not a valid plate number", plate)
+    }
+    return nil
+}
+
+func validateClass(class string) error {
+    if !vehicleClasses[class] {
+        return fmt.Errorf("vehicle_class must be one of standard, premium, luxury")
+    }
+    return nil
+}
+
+func validateRate(rate float64) error {
+    if rate <= 0 || rate > 1000 {
+        return fmt.Errorf("hourly_rate must be between 0 and 1000")
+    }
+    return nil
+}
+
+// vehicle checks the fields and builds the vehicle to insert
+func (f vehicleFields) vehicle() (*models.Vehicle, error) {
+    v := &models.Vehicle{
+        Model:         strings.TrimSpace(f.Model),
+        Type:          strings.TrimSpace(f.Type),
+        LicensePlate:  normalizePlate(f.LicensePlate),
+        HourlyRate:    defaultHourlyRate,
+        VehicleClass:  "standard",
+        BatteryLevel:  f.BatteryLevel,
+        HomeStationID: f.HomeStationID,
+        Location:      f.Location,
+    }
+    if v.Model == "" || v.Type == "" {
+        return nil, fmt.Errorf("model and type are required")
+    }
+    if err := validatePlate(v.LicensePlate); err != nil {
+        return nil, err
+    }
+    if f.HourlyRate != nil {
+        if err := validateRate(*f.HourlyRate); err != nil {
+            return nil, err
+        }
+        v.HourlyRate = *f.HourlyRate
+    }
+    if f.VehicleClass != nil {
+        if err := validateClass(*f.VehicleClass); err != nil {
+            return nil, err
+        }
+        v.VehicleClass = *f.VehicleClass
+    }
+    if f.BatteryLevel != nil && !validLevel(*f.BatteryLevel) {
+        return nil, fmt.Errorf("battery_level must be between 0 and 100")
+    }
+    if f.Latitude != nil || f.Longitude != nil {
+        if f.Latitude == nil || f.Longitude == nil {
+            return nil, fmt.Errorf("latitude and longitude must be given together")
+        }
+        point := geo.Point{Lat: *f.Latitude, Lng: *f.Longitude}
+        if err := point.Validate(); err != nil {
+            return nil, err
+        }
+        v.Latitude, v.Longitude = f.Latitude, f.Longitude
+    }
+    return v, nil
+}
+
+// GetFleet lists every vehicle in service, or the retired ones with ?status=decommissioned
+func (h *VehicleHandler) GetFleet(w http.ResponseWriter, r *http.Request) {
+    vehicles, err := h.repo.GetFleet(r.URL.Query().Get("status"))
+    if err != nil {
+        log.Printf("Error getting fleet: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get vehicles",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: vehicles,
+    })
+}
+
+func (h *VehicleHandler) CreateVehicle(w http.ResponseWriter, r *http.Request) {
+    var req vehicleFields
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    vehicle, err := req.vehicle()
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    if err := h.repo.CreateVehicles([]*models.Vehicle{vehicle}, false); err != nil {
+        log.Printf("Error creating vehicle: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    created, err := h.repo.GetVehicleByID(vehicle.ID)
+    if err != nil {
+        log.Printf("Error loading created vehicle: %v", err)
+        created = vehicle
+    }
+    h.publishVehicle(vehicle.ID)
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: created,
+    })
+}
+
+// UpdateVehicle edits a vehicle's model, type, plate, hourly rate or class.
+// Location, battery and station have their own endpoints.
+func (h *VehicleHandler) UpdateVehicle(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        Model        *string  `json:"model"`
+        Type         *string  `json:"type"`
+        LicensePlate *string  `json:"license_plate"`
+        HourlyRate   *float64 `json:"hourly_rate"`
+        VehicleClass *string  `json:"vehicle_class"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    for _, field := range []*string{req.Model, req.Type} {
+        if field != nil {
+            *field = strings.TrimSpace(*field)
+            if *field == "" {
+                sendJSON(w, http.StatusBadRequest, Response{
+                    Success: false,
+                    Error: "model and type can't be empty",
+                })
+                return
+            }
+        }
+    }
+    if req.LicensePlate != nil {
+        plate := normalizePlate(*req.LicensePlate)
+        if err := validatePlate(plate); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+        req.LicensePlate = &plate
+    }
+    if req.HourlyRate != nil {
+        if err := validateRate(*req.HourlyRate); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+    }
+
+    if req.VehicleClass != nil {
+        if err := validateClass(*req.VehicleClass); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+    }
+
+    if err := h.repo.UpdateVehicle(vehicleID, req.Model, req.Type, req.LicensePlate, req.HourlyRate, req.VehicleClass); err != nil {
+        log.Printf("Error updating vehicle: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    vehicle, err := h.repo.GetVehicleByID(vehicleID)
+    if err != nil {
+        sendRepoError(w, err)
+        return
+    }
+    h.publishVehicle(vehicleID)
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: vehicle,
+    })
+}
+
+// DecommissionVehicle retires a vehicle from the fleet. It stays in the
+// database for past bookings; its future bookings are reallocated or cancelled.
+func (h *VehicleHandler) DecommissionVehicle(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        Reason *string `json:"reason"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "invalid request body",
+            })
+            return
+        }
+    }
+
+    disruptions, err := h.repo.DecommissionVehicle(vehicleID, req.Reason)
+    if err != nil {
+        log.Printf("Error decommissioning vehicle: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishVehicle(vehicleID)
+    h.settleDisruptions(disruptions)
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]interface{}{
+            "message":     "vehicle decommissioned",
+            "disruptions": disruptions,
+        },
+    })
+}
+
+// importColumns maps CSV header names onto the fields they fill
+var importColumns = map[string]func(f *vehicleFields, value string) error{
+    "model":           func(f *vehicleFields, v string) error { f.Model = v; return nil },
+    "type":            func(f *vehicleFields, v string) error { f.Type = v; return nil },
+    "license_plate":   func(f *vehicleFields, v string) error { f.LicensePlate = v; return nil },
+    "location":        func(f *vehicleFields, v string) error { f.Location = &v; return nil },
+    "hourly_rate":     func(f *vehicleFields, v string) error { return parseCSVFloat(v, &f.HourlyRate) },
+    "latitude":        func(f *vehicleFields, v string) error { return parseCSVFloat(v, &f.Latitude) },
+    "longitude":       func(f *vehicleFields, v string) error { return parseCSVFloat(v, &f.Longitude) },
+    "battery_level":   func(f *vehicleFields, v string) error { return parseCSVInt(v, &f.BatteryLevel) },
+    "home_station_id": func(f *vehicleFields, v string) error { return parseCSVInt(v, &f.HomeStationID) },
+    "vehicle_class":   func(f *vehicleFields, v string) error { f.VehicleClass = &v; return nil },
+}
+
+func parseCSVFloat(value string, dest **float64) error {
+    n, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        return fmt.Errorf("%q is not a number", value)
+    }
+    *dest = &n
+    return nil
+}
+
+func parseCSVInt(value string, dest **int) error {
+    n, err := strconv.Atoi(value)
+    if err != nil {
+        return fmt.Errorf("%q is not a whole number", value)
+    }
+    *dest = &n
+    return nil
+}
+
+// importRowError explains why one line of an import was rejected
+type importRowError struct {
+    Line  int    `json:"line"`
+    Error string `json:"error"`
+}
+
+// ImportVehicles adds a fleet from CSV, sent as the request body (text/csv) or
+// as the "file" field of a multipart form. The first line names the columns;
+// model, type and license_plate are required. Rows are all checked before any
+// is saved, and nothing is saved unless every row is valid. ?dry_run=true
+// only checks.
+func (h *VehicleHandler) ImportVehicles(w http.ResponseWriter, r *http.Request) {
+    var body io.Reader
+    if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+        body = http.MaxBytesReader(w, r.Body, maxImportSize)
+    } else {
+        r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+1<<10)
+        if err := r.ParseMultipartForm(maxImportSize); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "import must be no larger than 1 MB",
+            })
+            return
+        }
+        defer r.MultipartForm.RemoveAll()
+        file, _, err := r.FormFile("file")
+        if err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "file is required",
+            })
+            return
+        }
+        defer file.Close()
+        body = file
+    }
+    dryRun := r.URL.Query().Get("dry_run") == "true"
+
+    reader := csv.NewReader(body)
+    reader.TrimLeadingSpace = true
+    header, err := reader.Read()
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "the first line must name the columns",
+        })
+        return
+    }
+    for i, name := range header {
+        header[i] = strings.ToLower(strings.TrimSpace(name))
+        if _, ok := importColumns[header[i]]; !ok {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("unknown column %q", name),
+            })
+            return
+        }
+    }
+
+    var vehicles []*models.Vehicle
+    rowErrors := []importRowError{}
+    plates := make(map[string]int)
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            msg := fmt.Sprintf("malformed CSV: %v", err)
+            var parseErr *csv.ParseError
+            if errors.As(err, &parseErr) {
+                msg = fmt.Sprintf("malformed CSV on line %d: %v", parseErr.Line, parseErr.Err)
+            }
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: msg,
+            })
+            return
+        }
+        line, _ := reader.FieldPos(0)
+        if len(vehicles)+len(rowErrors) >= maxImportRows {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("an import may hold at most %d vehicles", maxImportRows),
+            })
+            return
+        }
+
+        vehicle, err := importRow(header, record)
+        if err == nil {
+            if first, seen := plates[vehicle.LicensePlate]; seen {
+                err = fmt.Errorf("license plate %s is repeated from line %d", vehicle.LicensePlate, first)
+            } else {
+                plates[vehicle.LicensePlate] = line
+            }
+        }
+        if err != nil {
+            rowErrors = append(rowErrors, importRowError{Line: line, Error: err.Error()})
+            continue
+        }
+        vehicles = append(vehicles, vehicle)
+    }
+
+    if len(rowErrors) > 0 {
+        sendJSON(w, http.StatusUnprocessableEntity, Response{
+            Success: false,
+            Data: rowErrors,
+            Error: fmt.Sprintf("%d rows are invalid; nothing was imported", len(rowErrors)),
+        })
+        return
+    }
+    if len(vehicles) == 0 {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "the file has no vehicles",
+        })
+        return
+    }
+
+    if err := h.repo.CreateVehicles(vehicles, dryRun); err != nil {
+        log.Printf("Error importing vehicles: %v", err)
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: err.Error() + "; nothing was imported",
+        })
+        return
+    }
+
+    if dryRun {
+        sendJSON(w, http.StatusOK, Response{
+            Success: true,
+            Data: map[string]int{"valid": len(vehicles)},
+        })
+        return
+    }
+
+    for _, v := range vehicles {
+        h.publishVehicle(v.ID)
+    }
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: map[string]interface{}{
+            "imported": len(vehicles),
+            "vehicles": vehicles,
+        },
+    })
+}
+
+// importRow turns one CSV record into a checked vehicle. Empty cells are left unset.
+func importRow(header, record []string) (*models.Vehicle, error) {
+    var fields vehicleFields
+    for i, value := range record {
+        value = strings.TrimSpace(value)
+        if value == "" {
+            continue
+        }
+        if err := importColumns[header[i]](&fields, value); err != nil {
+            return nil, fmt.Errorf("%s: %v", header[i], err)
+        }
+    }
+    return fields.vehicle()
+}
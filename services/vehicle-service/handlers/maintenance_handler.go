@@ -0,0 +1,475 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/models"
+    "vehicle-service/userclient"
+)
+
+const (
+    // Start-of-trip reports may be filed as the car is collected; end-of-trip
+    // reports until a day after it is due back
+    damageReportEarly = 5 * time.Minute
+    damageReportLate  = 24 * time.Hour
+)
+
+var ticketSeverities = map[string]bool{
+    "low":      true,
+    "medium":   true,
+    "high":     true,
+    "critical": true,
+}
+
+// settleDisruptions tells stream clients about bookings moved or cancelled
+// when a vehicle was taken out of service, and has billing-service void the
+// rental invoices of the cancelled ones without a fee
+func (h *VehicleHandler) settleDisruptions(disruptions []models.BookingDisruption) {
+    for _, d := range disruptions {
+        if d.Outcome == "cancelled" {
+            h.publishBooking(d.BookingID, "booking.cancelled")
+            go h.waiveCancellation(d.UserID, d.BookingID, time.Now())
+        } else {
+            h.publishBooking(d.BookingID, "booking.reallocated")
+        }
+    }
+}
+
+// waiveCancellation voids an operator-cancelled booking's rental invoice,
+// retrying briefly while billing-service can't be reached
+func (h *VehicleHandler) waiveCancellation(userID, bookingID int, at time.Time) {
+    for attempt := 1; attempt <= billingAttempts; attempt++ {
+        err := h.billing.WaiveCancellation(context.Background(), userID, bookingID, at)
+        if err == nil {
+            return
+        }
+        log.Printf("Error voiding invoice of cancelled booking %d (attempt %d): %v", bookingID, attempt, err)
+        if attempt < billingAttempts {
+            time.Sleep(billingRetryDelay)
+        }
+    }
+}
+
+// OpenMaintenanceTicket takes a vehicle out of service and reallocates or
+// cancels the future bookings it can no longer serve
+func (h *VehicleHandler) OpenMaintenanceTicket(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        Reason             string     `json:"reason"`
+        Severity           string     `json:"severity"`
+        AssignedTechnician *string    `json:"assigned_technician"`
+        ExpectedReturnAt   *time.Time `json:"expected_return_at"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    req.Reason = strings.TrimSpace(req.Reason)
+    if req.Reason == "" {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "reason is required",
+        })
+        return
+    }
+    if !ticketSeverities[req.Severity] {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "severity must be one of low, medium, high, critical",
+        })
+        return
+    }
+    if req.ExpectedReturnAt != nil && !req.ExpectedReturnAt.After(time.Now()) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "expected_return_at must be in the future",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    ticket := &models.MaintenanceTicket{
+        VehicleID:          vehicleID,
+        Reason:             req.Reason,
+        Severity:           req.Severity,
+        ReportedBy:         userID,
+        AssignedTechnician: req.AssignedTechnician,
+        ExpectedReturnAt:   req.ExpectedReturnAt,
+    }
+    disruptions, err := h.repo.OpenTicket(ticket)
+    if err != nil {
+        log.Printf("Error opening maintenance ticket: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishVehicle(vehicleID)
+    h.settleDisruptions(disruptions)
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: map[string]interface{}{
+            "ticket":      ticket,
+            "disruptions": disruptions,
+        },
+    })
+}
+
+// GetMaintenanceTickets lists unresolved tickets, or those with ?status=
+func (h *VehicleHandler) GetMaintenanceTickets(w http.ResponseWriter, r *http.Request) {
+    h.listTickets(w, r.URL.Query().Get("status"), nil)
+}
+
+func (h *VehicleHandler) GetVehicleMaintenance(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+    h.listTickets(w, r.URL.Query().Get("status"), &vehicleID)
+}
+
+func (h *VehicleHandler) listTickets(w http.ResponseWriter, status string, vehicleID *int) {
+    switch status {
+    case "", "open", "in_progress", "resolved":
+    default:
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "status must be one of open, in_progress, resolved",
+        })
+        return
+    }
+
+    tickets, err := h.repo.GetTickets(status, vehicleID)
+    if err != nil {
+        log.Printf("Error getting maintenance tickets: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get maintenance tickets",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: tickets,
+    })
+}
+
+func parseTicketID(w http.ResponseWriter, r *http.Request) (int, bool) {
+    ticketID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid ticket ID",
+        })
+        return 0, false
+    }
+    return ticketID, true
+}
+
+// UpdateMaintenanceTicket assigns a technician, moves the expected return or
+// marks work as in progress
+func (h *VehicleHandler) UpdateMaintenanceTicket(w http.ResponseWriter, r *http.Request) {
+    ticketID, ok := parseTicketID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        AssignedTechnician *string    `json:"assigned_technician"`
+        ExpectedReturnAt   *time.Time `json:"expected_return_at"`
+        Status             *string    `json:"status"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+    if req.Status != nil && *req.Status != "open" && *req.Status != "in_progress" {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "status must be open or in_progress; use resolve to close a ticket",
+        })
+        return
+    }
+
+    ticket, err := h.repo.UpdateTicket(ticketID, req.AssignedTechnician, req.ExpectedReturnAt, req.Status)
+    if err != nil {
+        log.Printf("Error updating maintenance ticket: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: ticket,
+    })
+}
+
+// ResolveMaintenanceTicket closes a ticket; the vehicle becomes bookable again
+// when no other ticket is open for it
+func (h *VehicleHandler) ResolveMaintenanceTicket(w http.ResponseWriter, r *http.Request) {
+    ticketID, ok := parseTicketID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        ResolutionNotes *string `json:"resolution_notes"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "invalid request body",
+            })
+            return
+        }
+    }
+
+    ticket, err := h.repo.ResolveTicket(ticketID, req.ResolutionNotes)
+    if err != nil {
+        log.Printf("Error resolving maintenance ticket: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    h.publishVehicle(ticket.VehicleID)
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: ticket,
+    })
+}
+
+// damageReportWindow returns when a report for the given stage of a booking
+// may be filed
+func damageReportWindow(booking *models.Booking, stage string) (time.Time, time.Time) {
+    if stage == "start" {
+        return booking.StartTime.Add(-damageReportEarly), booking.EndTime
+    }
+    return booking.StartTime, booking.EndTime.Add(damageReportLate)
+}
+
+// CreateDamageReport records the car's condition at the start or end of a
+// trip. It is a multipart form with stage, description, drivable and up to
+// six photos. A car reported as not drivable is taken out of service at once.
+func (h *VehicleHandler) CreateDamageReport(w http.ResponseWriter, r *http.Request) {
+    bookingID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid booking ID",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    booking, err := h.repo.GetBookingByID(bookingID)
+    if err != nil || booking.UserID != userID {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "booking not found or unauthorized",
+        })
+        return
+    }
+
+    if !parsePhotoForm(w, r) {
+        return
+    }
+    defer r.MultipartForm.RemoveAll()
+
+    stage := r.FormValue("stage")
+    if stage != "start" && stage != "end" {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "stage must be start or end",
+        })
+        return
+    }
+    description := strings.TrimSpace(r.FormValue("description"))
+    if description == "" {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "description is required",
+        })
+        return
+    }
+    drivable := true
+    if raw := r.FormValue("drivable"); raw != "" {
+        drivable, err = strconv.ParseBool(raw)
+        if err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "drivable must be true or false",
+            })
+            return
+        }
+    }
+
+    if !bookingAccessStatuses[booking.Status] {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking is " + booking.Status,
+        })
+        return
+    }
+    opens, closes := damageReportWindow(booking, stage)
+    now := time.Now()
+    if now.Before(opens) || now.After(closes) {
+        sendJSON(w, http.StatusForbidden, Response{
+            Success: false,
+            Error: fmt.Sprintf("a %s-of-trip report can't be filed at this time", stage),
+        })
+        return
+    }
+
+    stored, status, err := h.storePhotos(r.MultipartForm.File["photos"], fmt.Sprintf("damage/%d", booking.ID))
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    report := &models.DamageReport{
+        BookingID:   booking.ID,
+        VehicleID:   booking.VehicleID,
+        UserID:      userID,
+        Stage:       stage,
+        Description: description,
+        Drivable:    drivable,
+        Photos:      []models.DamagePhoto{},
+    }
+    for _, photo := range stored {
+        report.Photos = append(report.Photos, models.DamagePhoto{
+            StorageKey:  photo.key,
+            ContentType: photo.contentType,
+            Size:        photo.size,
+        })
+    }
+
+    if err := h.repo.CreateDamageReport(report); err != nil {
+        log.Printf("Error creating damage report: %v", err)
+        h.deletePhotos(stored)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to save damage report",
+        })
+        return
+    }
+
+    resp := map[string]interface{}{"report": report}
+    if !drivable {
+        ticket := &models.MaintenanceTicket{
+            VehicleID:      report.VehicleID,
+            Reason:         "Reported not drivable: " + description,
+            Severity:       "critical",
+            ReportedBy:     userID,
+            DamageReportID: &report.ID,
+        }
+        disruptions, err := h.repo.OpenTicket(ticket)
+        if err != nil {
+            // The report is saved; operators can still open a ticket by hand
+            log.Printf("Error opening ticket for damage report %d: %v", report.ID, err)
+        } else {
+            resp["ticket"] = ticket
+            h.publishVehicle(ticket.VehicleID)
+            h.settleDisruptions(disruptions)
+        }
+    }
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: resp,
+    })
+}
+
+func (h *VehicleHandler) GetDamageReports(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    reports, err := h.repo.GetDamageReports(vehicleID)
+    if err != nil {
+        log.Printf("Error getting damage reports: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get damage reports",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: reports,
+    })
+}
+
+// GetDamagePhoto serves a photo to operators and to the driver who took it
+func (h *VehicleHandler) GetDamagePhoto(w http.ResponseWriter, r *http.Request) {
+    reportID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid report ID",
+        })
+        return
+    }
+    photoID, err := strconv.Atoi(mux.Vars(r)["photoId"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid photo ID",
+        })
+        return
+    }
+
+    photo, reporterID, err := h.repo.GetDamagePhoto(photoID)
+    user, _ := r.Context().Value("user").(*userclient.User)
+    if err != nil || photo.ReportID != reportID || user == nil || (user.Role != "operator" && user.ID != reporterID) {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "photo not found",
+        })
+        return
+    }
+
+    h.servePhoto(w, photo.StorageKey, photo.ContentType)
+}
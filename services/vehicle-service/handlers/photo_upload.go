@@ -0,0 +1,119 @@
+package handlers
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "log"
+    "mime/multipart"
+    "net/http"
+    "time"
+)
+
+const (
+    maxPhotos    = 6
+    maxPhotoSize = 8 << 20 // 8 MB
+)
+
+var photoTypes = map[string]string{
+    "image/jpeg": ".jpg",
+    "image/png":  ".png",
+}
+
+// storedPhoto is an uploaded photo saved in the blob store
+type storedPhoto struct {
+    key         string
+    contentType string
+    size        int64
+}
+
+// parsePhotoForm reads a multipart form carrying up to maxPhotos photos
+func parsePhotoForm(w http.ResponseWriter, r *http.Request) bool {
+    r.Body = http.MaxBytesReader(w, r.Body, maxPhotos*maxPhotoSize+1<<20)
+    if err := r.ParseMultipartForm(maxPhotoSize); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "request must be a multipart form",
+        })
+        return false
+    }
+    return true
+}
+
+// storePhotos checks each upload is a JPEG or PNG within the size limit and
+// saves it under dir. Nothing is left behind when any photo is rejected. It
+// returns an HTTP status alongside any error.
+func (h *VehicleHandler) storePhotos(files []*multipart.FileHeader, dir string) ([]storedPhoto, int, error) {
+    if len(files) > maxPhotos {
+        return nil, http.StatusBadRequest, fmt.Errorf("at most %d photos may be attached", maxPhotos)
+    }
+
+    stored := []storedPhoto{}
+    for i, header := range files {
+        if header.Size > maxPhotoSize {
+            h.deletePhotos(stored)
+            return nil, http.StatusBadRequest, fmt.Errorf("each photo must be no larger than 8 MB")
+        }
+        photo, status, err := h.storePhoto(header, fmt.Sprintf("%s/%d-%d", dir, time.Now().UnixNano(), i))
+        if err != nil {
+            h.deletePhotos(stored)
+            return nil, status, err
+        }
+        stored = append(stored, *photo)
+    }
+    return stored, 0, nil
+}
+
+func (h *VehicleHandler) storePhoto(header *multipart.FileHeader, key string) (*storedPhoto, int, error) {
+    file, err := header.Open()
+    if err != nil {
+        return nil, http.StatusBadRequest, fmt.Errorf("failed to read photo")
+    }
+    defer file.Close()
+
+    // Trust the file contents rather than the client-supplied content type
+    head := make([]byte, 512)
+    n, err := io.ReadFull(file, head)
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return nil, http.StatusBadRequest, fmt.Errorf("failed to read photo")
+    }
+    head = head[:n]
+    contentType := http.DetectContentType(head)
+    ext, ok := photoTypes[contentType]
+    if !ok {
+        return nil, http.StatusUnsupportedMediaType, fmt.Errorf("photos must be JPEG or PNG images")
+    }
+
+    key += ext
+    size, err := h.store.Put(key, io.MultiReader(bytes.NewReader(head), file))
+    if err != nil {
+        log.Printf("Blob store error: %v", err)
+        return nil, http.StatusInternalServerError, fmt.Errorf("failed to store photo")
+    }
+    return &storedPhoto{key: key, contentType: contentType, size: size}, 0, nil
+}
+
+// deletePhotos removes stored photos whose record couldn't be saved
+func (h *VehicleHandler) deletePhotos(photos []storedPhoto) {
+    for _, photo := range photos {
+        h.store.Delete(photo.key)
+    }
+}
+
+// servePhoto streams a stored photo back to an authorised caller
+func (h *VehicleHandler) servePhoto(w http.ResponseWriter, key, contentType string) {
+    blob, err := h.store.Get(key)
+    if err != nil {
+        log.Printf("Blob store error for %s: %v", key, err)
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "photo content unavailable",
+        })
+        return
+    }
+    defer blob.Close()
+
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Cache-Control", "private, no-store")
+    io.Copy(w, blob)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/models"
+    "vehicle-service/userclient"
+)
+
+var vehicleClasses = map[string]bool{
+    "standard": true,
+    "premium":  true,
+    "luxury":   true,
+}
+
+// policyAllowsClass reports whether the policy lets drivers book a vehicle class
+func policyAllowsClass(policy *models.BookingPolicy, class string) bool {
+    for _, c := range policy.VehicleClasses {
+        if c == class {
+            return true
+        }
+    }
+    return false
+}
+
+// allowedClasses returns the vehicle classes the signed-in driver's tier may
+// book, or nil when the tier has no policy and any class is allowed
+func (h *VehicleHandler) allowedClasses(r *http.Request) ([]string, error) {
+    driver, ok := r.Context().Value("user").(*userclient.User)
+    if !ok {
+        return nil, nil
+    }
+    policy, err := h.repo.GetBookingPolicy(driver.MembershipTier)
+    if err != nil || policy == nil {
+        return nil, err
+    }
+    return policy.VehicleClasses, nil
+}
+
+// checkPolicyTimes checks a booking's length and how far ahead it starts
+// against the driver's policy. The advance window is only checked when
+// checkStart is set, so extensions of a booking made earlier aren't refused.
+func checkPolicyTimes(policy *models.BookingPolicy, start, end time.Time, checkStart bool) error {
+    maxDuration := time.Duration(policy.MaxDurationHours * float64(time.Hour))
+    if end.Sub(start) > maxDuration {
+        return fmt.Errorf("bookings on the %s membership can last at most %g hours", policy.MembershipTier, policy.MaxDurationHours)
+    }
+    if checkStart && start.After(time.Now().AddDate(0, 0, policy.MaxAdvanceDays)) {
+        return fmt.Errorf("bookings on the %s membership can start at most %d days ahead", policy.MembershipTier, policy.MaxAdvanceDays)
+    }
+    return nil
+}
+
+// checkBookingPolicy applies the driver's membership tier rules to new
+// bookings of a vehicle: its class and each booking's length and start. It
+// returns the policy, nil when the tier has none, so the repository can
+// enforce the limit on upcoming bookings, and an HTTP status alongside any
+// error.
+func (h *VehicleHandler) checkBookingPolicy(driver *userclient.User, vehicle *models.Vehicle, bookings []models.Booking) (*models.BookingPolicy, int, error) {
+    policy, err := h.repo.GetBookingPolicy(driver.MembershipTier)
+    if err != nil {
+        log.Printf("Error getting booking policy: %v", err)
+        return nil, http.StatusInternalServerError, fmt.Errorf("failed to check booking policy")
+    }
+    if policy == nil {
+        return nil, 0, nil
+    }
+
+    if !policyAllowsClass(policy, vehicle.VehicleClass) {
+        return nil, http.StatusForbidden, fmt.Errorf("%s vehicles can't be booked on the %s membership", vehicle.VehicleClass, policy.MembershipTier)
+    }
+    for _, b := range bookings {
+        if err := checkPolicyTimes(policy, b.StartTime, b.EndTime, true); err != nil {
+            return nil, http.StatusForbidden, err
+        }
+    }
+    return policy, 0, nil
+}
+
+// maxActiveBookings is the policy's limit on upcoming bookings, 0 for none
+func maxActiveBookings(policy *models.BookingPolicy) int {
+    if policy == nil {
+        return 0
+    }
+    return policy.MaxActiveBookings
+}
+
+// bookingLimitError explains repository.ErrBookingLimit to the driver
+func bookingLimitError(policy *models.BookingPolicy) string {
+    return fmt.Sprintf("the %s membership allows at most %d upcoming bookings at once", policy.MembershipTier, policy.MaxActiveBookings)
+}
+
+// GetBookingPolicies lists what each membership tier may book
+func (h *VehicleHandler) GetBookingPolicies(w http.ResponseWriter, r *http.Request) {
+    policies, err := h.repo.GetBookingPolicies()
+    if err != nil {
+        log.Printf("Error getting booking policies: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get booking policies",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: policies,
+    })
+}
+
+// SaveBookingPolicy sets a membership tier's booking rules. Bookings already
+// made are kept when the rules tighten.
+func (h *VehicleHandler) SaveBookingPolicy(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        MaxDurationHours  float64  `json:"max_duration_hours"`
+        MaxAdvanceDays    int      `json:"max_advance_days"`
+        MaxActiveBookings int      `json:"max_active_bookings"`
+        VehicleClasses    []string `json:"vehicle_classes"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    if req.MaxDurationHours <= 0 || req.MaxAdvanceDays < 1 || req.MaxActiveBookings < 1 {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "max_duration_hours, max_advance_days and max_active_bookings must be positive",
+        })
+        return
+    }
+    if len(req.VehicleClasses) == 0 {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "vehicle_classes must name at least one class",
+        })
+        return
+    }
+    for _, class := range req.VehicleClasses {
+        if err := validateClass(class); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+    }
+
+    policy := &models.BookingPolicy{
+        MembershipTier:    mux.Vars(r)["tier"],
+        MaxDurationHours:  req.MaxDurationHours,
+        MaxAdvanceDays:    req.MaxAdvanceDays,
+        MaxActiveBookings: req.MaxActiveBookings,
+        VehicleClasses:    req.VehicleClasses,
+    }
+    if err := h.repo.SaveBookingPolicy(policy); err != nil {
+        log.Printf("Error saving booking policy: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: policy,
+    })
+}
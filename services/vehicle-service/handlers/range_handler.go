@@ -0,0 +1,130 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strings"
+
+    "vehicle-service/battery"
+    "vehicle-service/geo"
+    "vehicle-service/models"
+)
+
+func (h *VehicleHandler) GetVehicleSpecs(w http.ResponseWriter, r *http.Request) {
+    specs, err := h.repo.GetVehicleSpecs()
+    if err != nil {
+        log.Printf("Error getting vehicle specs: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get vehicle specs",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: specs,
+    })
+}
+
+// SaveVehicleSpec sets the battery capacity and consumption for a model
+func (h *VehicleHandler) SaveVehicleSpec(w http.ResponseWriter, r *http.Request) {
+    var spec battery.Spec
+    if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    spec.Model = strings.TrimSpace(spec.Model)
+    if spec.Model == "" {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "model is required",
+        })
+        return
+    }
+    if err := spec.Validate(); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    if err := h.repo.SaveVehicleSpec(&spec); err != nil {
+        log.Printf("Error saving vehicle spec: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to save vehicle spec",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: spec,
+    })
+}
+
+// plannedDistance works out the trip length from either an explicit distance or
+// a destination. A destination trip runs from the car out to the destination and
+// on to the return station (or back to the car's pickup point).
+func (h *VehicleHandler) plannedDistance(booking *models.Booking, vehicle *models.Vehicle, distanceKm *float64, destination *geo.Point) (*float64, error) {
+    if distanceKm != nil && destination != nil {
+        return nil, fmt.Errorf("give either planned_distance_km or destination, not both")
+    }
+    if distanceKm != nil {
+        if *distanceKm <= 0 || math.IsNaN(*distanceKm) {
+            return nil, fmt.Errorf("planned_distance_km must be positive")
+        }
+        return distanceKm, nil
+    }
+    if destination == nil {
+        return nil, nil
+    }
+
+    if err := destination.Validate(); err != nil {
+        return nil, err
+    }
+    if vehicle.Latitude == nil || vehicle.Longitude == nil {
+        return nil, fmt.Errorf("this vehicle has no known position to plan a route from")
+    }
+
+    origin := geo.Point{Lat: *vehicle.Latitude, Lng: *vehicle.Longitude}
+    end := origin
+    if booking.ReturnStationID != nil && booking.PickupStationID != nil && *booking.ReturnStationID != *booking.PickupStationID {
+        station, err := h.repo.GetStationByID(*booking.ReturnStationID)
+        if err != nil {
+            return nil, err
+        }
+        end = geo.Point{Lat: station.Latitude, Lng: station.Longitude}
+    }
+
+    km := (geo.DistanceKm(origin, *destination) + geo.DistanceKm(*destination, end)) * battery.RoadFactor
+    km = math.Round(km*10) / 10
+    return &km, nil
+}
+
+// checkRange refuses a trip the car can't complete on its current charge and
+// returns a warning when the margin is thin. Cars without a battery spec or
+// level are not checked.
+func checkRange(vehicle *models.Vehicle, distanceKm float64) (string, error) {
+    if vehicle.Spec == nil || vehicle.BatteryLevel == nil {
+        return "", nil
+    }
+
+    rangeKm := vehicle.Spec.RangeKm(*vehicle.BatteryLevel)
+    switch vehicle.Spec.Check(*vehicle.BatteryLevel, distanceKm) {
+    case battery.Infeasible:
+        return "", fmt.Errorf("estimated range of %.1f km is not enough for a %.1f km trip", rangeKm, distanceKm)
+    case battery.Marginal:
+        return fmt.Sprintf("a %.1f km trip uses most of the estimated %.1f km range; plan a charging stop", distanceKm, rangeKm), nil
+    }
+    return "", nil
+}
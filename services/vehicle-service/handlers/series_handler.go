@@ -0,0 +1,503 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/models"
+    "vehicle-service/recurrence"
+    "vehicle-service/repository"
+)
+
+// Occurrences a single recurring booking can have
+const maxSeriesOccurrences = 100
+
+// sendSeriesConflicts refuses a series change that the car can't take,
+// listing each occurrence that conflicts
+func sendSeriesConflicts(w http.ResponseWriter, conflicts []models.SeriesConflict, total int) {
+    sendJSON(w, http.StatusConflict, Response{
+        Success: false,
+        Data: conflicts,
+        Error: fmt.Sprintf("%d of %d occurrences can't be booked, so nothing was changed", len(conflicts), total),
+    })
+}
+
+// loadOwnSeries reads the {id} booking series and checks it belongs to the caller
+func (h *VehicleHandler) loadOwnSeries(w http.ResponseWriter, r *http.Request) (*models.BookingSeries, bool) {
+    seriesID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid series ID",
+        })
+        return nil, false
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return nil, false
+    }
+
+    series, err := h.repo.GetBookingSeries(seriesID)
+    if err != nil || series.UserID != userID {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: "booking series not found or unauthorized",
+        })
+        return nil, false
+    }
+    return series, true
+}
+
+// stationConflicts lists the bookings whose pickup station is closed at the
+// start or return station at the end. pickup is nil for cars not based at a
+// station.
+func stationConflicts(bookings []models.Booking, pickup, dropoff *models.Station) []models.SeriesConflict {
+    conflicts := []models.SeriesConflict{}
+    if pickup == nil {
+        return conflicts
+    }
+
+    for _, b := range bookings {
+        reason := ""
+        if !stationOpenAt(pickup, b.StartTime) {
+            reason = fmt.Sprintf("%s is closed at the start of this booking", pickup.Name)
+        } else if !stationOpenAt(dropoff, b.EndTime) {
+            reason = fmt.Sprintf("%s is closed at the end of this booking", dropoff.Name)
+        }
+        if reason != "" {
+            conflicts = append(conflicts, models.SeriesConflict{StartTime: b.StartTime, EndTime: b.EndTime, Reason: reason})
+        }
+    }
+    return conflicts
+}
+
+// CreateBookingSeries books the same car for every occurrence of an RRULE
+// recurrence. Either every occurrence is booked or, with 409, none is and
+// each conflicting occurrence is listed.
+func (h *VehicleHandler) CreateBookingSeries(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        VehicleID       int       `json:"vehicle_id"`
+        StartTime       time.Time `json:"start_time"` // first occurrence
+        EndTime         time.Time `json:"end_time"`
+        Recurrence      string    `json:"recurrence"`
+        ReturnStationID *int      `json:"return_station_id"` // omit to return where picked up
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    if !req.StartTime.After(time.Now()) || !req.EndTime.After(req.StartTime) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "start_time must be in the future and before end_time",
+        })
+        return
+    }
+
+    rule, err := recurrence.Parse(req.Recurrence)
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: fmt.Sprintf("invalid recurrence: %v", err),
+        })
+        return
+    }
+    starts, err := rule.Occurrences(req.StartTime, maxSeriesOccurrences)
+    if err != nil || len(starts) == 0 {
+        if err == nil {
+            err = fmt.Errorf("no occurrences fall on or after start_time")
+        }
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: fmt.Sprintf("invalid recurrence: %v", err),
+        })
+        return
+    }
+
+    length := req.EndTime.Sub(req.StartTime)
+    for i := 1; i < len(starts); i++ {
+        if starts[i].Before(starts[i-1].Add(length)) {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "each occurrence must end before the next one starts",
+            })
+            return
+        }
+    }
+
+    driver, status, err := h.checkDriver(r.Context(), userID, starts[len(starts)-1].Add(length))
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    vehicle, err := h.repo.GetVehicleByID(req.VehicleID)
+    if err != nil {
+        sendRepoError(w, err)
+        return
+    }
+    if err := checkVehicleInService(vehicle); err != nil {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    // Where the car is picked up and returned doesn't change between occurrences
+    pickup, dropoff, status, err := h.findStations(vehicle, req.ReturnStationID)
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    series := &models.BookingSeries{
+        UserID:          userID,
+        VehicleID:       vehicle.ID,
+        Recurrence:      rule.String(),
+        StartTime:       starts[0],
+        EndTime:         starts[0].Add(length),
+        ReturnStationID: req.ReturnStationID,
+    }
+    for _, start := range starts {
+        booking := models.Booking{
+            UserID:    userID,
+            VehicleID: vehicle.ID,
+            StartTime: start,
+            EndTime:   start.Add(length),
+            Status:    "pending",
+        }
+        if pickup != nil {
+            setStations(&booking, pickup, dropoff)
+        }
+        series.Bookings = append(series.Bookings, booking)
+    }
+
+    // The tier's rules apply to every occurrence, each of which also counts
+    // towards the driver's upcoming bookings when the series is saved
+    policy, status, err := h.checkBookingPolicy(driver, vehicle, series.Bookings)
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    conflicts := stationConflicts(series.Bookings, pickup, dropoff)
+    conflicts, err = h.repo.CreateBookingSeries(series, conflicts, maxActiveBookings(policy))
+    if err == repository.ErrBookingLimit {
+        sendJSON(w, http.StatusForbidden, Response{
+            Success: false,
+            Error: bookingLimitError(policy),
+        })
+        return
+    }
+    if err == repository.ErrReturnStationFull {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+    if err != nil {
+        log.Printf("Error creating booking series: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to create booking series",
+        })
+        return
+    }
+    if len(conflicts) > 0 {
+        sendSeriesConflicts(w, conflicts, len(series.Bookings))
+        return
+    }
+
+    for i := range series.Bookings {
+        h.publishBookingChange(&series.Bookings[i], "booking.created")
+    }
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: series,
+    })
+}
+
+// GetBookingSeries returns one of the driver's recurring bookings with its
+// occurrences
+func (h *VehicleHandler) GetBookingSeries(w http.ResponseWriter, r *http.Request) {
+    series, ok := h.loadOwnSeries(w, r)
+    if !ok {
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: series,
+    })
+}
+
+// nextOccurrence is the series' first live booking that hasn't started
+func nextOccurrence(series *models.BookingSeries, now time.Time) *models.Booking {
+    for i := range series.Bookings {
+        b := &series.Bookings[i]
+        if bookingAccessStatuses[b.Status] && b.StartTime.After(now) {
+            return b
+        }
+    }
+    return nil
+}
+
+// UpdateBookingSeries reschedules every upcoming occurrence. The new times
+// are given for the next occurrence; later ones move by the same amount and
+// take the same length. As when creating, either all move or none does.
+func (h *VehicleHandler) UpdateBookingSeries(w http.ResponseWriter, r *http.Request) {
+    series, ok := h.loadOwnSeries(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        StartTime time.Time `json:"start_time"`
+        EndTime   time.Time `json:"end_time"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    now := time.Now()
+    next := nextOccurrence(series, now)
+    if series.Status != "active" || next == nil {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "the series has no upcoming occurrences",
+        })
+        return
+    }
+    if !req.StartTime.After(now) || !req.EndTime.After(req.StartTime) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "start_time must be in the future and before end_time",
+        })
+        return
+    }
+
+    shift, length := req.StartTime.Sub(next.StartTime), req.EndTime.Sub(req.StartTime)
+    moved := []models.Booking{}
+    for _, b := range series.Bookings {
+        if bookingAccessStatuses[b.Status] && b.StartTime.After(now) {
+            b.StartTime = b.StartTime.Add(shift)
+            b.EndTime = b.StartTime.Add(length)
+            moved = append(moved, b)
+        }
+    }
+    for i := 1; i < len(moved); i++ {
+        if moved[i].StartTime.Before(moved[i-1].EndTime) {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "each occurrence must end before the next one starts",
+            })
+            return
+        }
+    }
+
+    driver, status, err := h.checkDriver(r.Context(), series.UserID, moved[len(moved)-1].EndTime)
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    policy, err := h.repo.GetBookingPolicy(driver.MembershipTier)
+    if err != nil {
+        log.Printf("Error getting booking policy: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to check booking policy",
+        })
+        return
+    }
+    if policy != nil {
+        for _, b := range moved {
+            if err := checkPolicyTimes(policy, b.StartTime, b.EndTime, shift != 0); err != nil {
+                sendJSON(w, http.StatusForbidden, Response{
+                    Success: false,
+                    Error: err.Error(),
+                })
+                return
+            }
+        }
+    }
+
+    // As for single bookings, occurrences that would already cost a fee to
+    // cancel can't be moved or shortened. Later occurrences open their fee
+    // window later, so checking stops at the first that is still free.
+    for i := range series.Bookings {
+        b := &series.Bookings[i]
+        if !bookingAccessStatuses[b.Status] || !b.StartTime.After(now) {
+            continue
+        }
+        start := b.StartTime.Add(shift)
+        if start.Equal(b.StartTime) && !start.Add(length).Before(b.EndTime) {
+            continue
+        }
+        free, status, err := h.feeWindowOpen(r.Context(), b)
+        if err != nil {
+            sendJSON(w, status, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+        if free {
+            break
+        }
+    }
+
+    var pickup, dropoff *models.Station
+    if next.PickupStationID != nil {
+        var err error
+        if pickup, err = h.repo.GetStationByID(*next.PickupStationID); err == nil {
+            dropoff, err = h.repo.GetStationByID(*next.ReturnStationID)
+        }
+        if err != nil {
+            sendRepoError(w, err)
+            return
+        }
+    }
+
+    amendments, conflicts, err := h.repo.RescheduleBookingSeries(series.ID, now, shift, length, series.UserID,
+        stationConflicts(moved, pickup, dropoff))
+    if err != nil {
+        log.Printf("Error rescheduling booking series: %v", err)
+        sendRepoError(w, err)
+        return
+    }
+    if len(conflicts) > 0 {
+        sendSeriesConflicts(w, conflicts, len(moved))
+        return
+    }
+
+    for _, a := range amendments {
+        h.publishBooking(a.BookingID, "booking.updated")
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]interface{}{
+            "series_id":  series.ID,
+            "amendments": amendments,
+        },
+    })
+}
+
+// CancelBookingSeries cancels every occurrence that hasn't started, charging
+// each under the cancellation policy as if cancelled on its own. If
+// billing-service fails part way the occurrences already cancelled stay
+// cancelled and retrying finishes the rest.
+func (h *VehicleHandler) CancelBookingSeries(w http.ResponseWriter, r *http.Request) {
+    series, ok := h.loadOwnSeries(w, r)
+    if !ok {
+        return
+    }
+
+    if series.Status != "active" {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking series is " + series.Status,
+        })
+        return
+    }
+
+    now := time.Now()
+    cancelled, fees := 0, 0.0
+    for _, b := range series.Bookings {
+        if !bookingAccessStatuses[b.Status] || !b.StartTime.After(now) {
+            continue
+        }
+
+        charge, err := h.billing.ChargeCancellation(r.Context(), b.UserID, b.ID, now, false)
+        if err != nil {
+            log.Printf("Error charging cancellation of booking %d: %v", b.ID, err)
+            sendJSON(w, http.StatusBadGateway, Response{
+                Success: false,
+                Data: map[string]interface{}{"cancelled": cancelled},
+                Error: "failed to charge a cancellation; retry to cancel the remaining occurrences",
+            })
+            return
+        }
+        if err := h.repo.CancelBooking(b.ID, now, charge.Fee); err != nil {
+            log.Printf("Error cancelling booking %d: %v", b.ID, err)
+            sendJSON(w, http.StatusInternalServerError, Response{
+                Success: false,
+                Data: map[string]interface{}{"cancelled": cancelled},
+                Error: cancelFailedError(b.ID, h.revertCancellation(b.ID)),
+            })
+            return
+        }
+
+        h.publishBooking(b.ID, "booking.cancelled")
+        cancelled++
+        fees += charge.Fee
+    }
+
+    if err := h.repo.EndBookingSeries(series.ID); err != nil {
+        log.Printf("Error ending booking series: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to cancel booking series",
+        })
+        return
+    }
+    if cancelled > 0 {
+        go h.offerVehicle(context.Background(), series.VehicleID)
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]interface{}{
+            "message":           "booking series cancelled",
+            "cancelled":         cancelled,
+            "cancellation_fees": math.Round(fees*100) / 100,
+        },
+    })
+}
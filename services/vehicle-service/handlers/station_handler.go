@@ -0,0 +1,288 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/events"
+    "vehicle-service/geo"
+    "vehicle-service/models"
+)
+
+const (
+    // One-way trips pay a flat fee plus a per-km charge for rebalancing the fleet
+    oneWayBaseFee  = 5.00
+    oneWayFeePerKm = 0.50
+)
+
+// Station opening hours are local Singapore time
+var stationTimeZone = time.FixedZone("SGT", 8*60*60)
+
+func validateStation(s *models.Station) error {
+    s.Name = strings.TrimSpace(s.Name)
+    if s.Name == "" {
+        return errors.New("name is required")
+    }
+    if err := (geo.Point{Lat: s.Latitude, Lng: s.Longitude}).Validate(); err != nil {
+        return err
+    }
+    if s.Capacity <= 0 {
+        return errors.New("capacity must be positive")
+    }
+    if s.ChargerCount < 0 || s.ChargerCount > s.Capacity {
+        return errors.New("charger_count must be between 0 and capacity")
+    }
+    if (s.OpensAt == nil) != (s.ClosesAt == nil) {
+        return errors.New("opens_at and closes_at must be set together")
+    }
+    for _, clock := range []*string{s.OpensAt, s.ClosesAt} {
+        if clock == nil {
+            continue
+        }
+        if _, err := time.Parse("15:04", *clock); err != nil {
+            return errors.New("opening hours must be HH:MM")
+        }
+    }
+    return nil
+}
+
+// stationOpenAt reports whether t falls inside the station's opening hours.
+// Hours may wrap past midnight, e.g. 18:00-02:00.
+func stationOpenAt(s *models.Station, t time.Time) bool {
+    if s.OpensAt == nil || s.ClosesAt == nil {
+        return true
+    }
+    clock := t.In(stationTimeZone).Format("15:04")
+    opens, closes := *s.OpensAt, *s.ClosesAt
+    if opens <= closes {
+        return clock >= opens && clock < closes
+    }
+    return clock >= opens || clock < closes
+}
+
+// dropoffFee prices returning a vehicle to a different station than it was picked up from
+func dropoffFee(from, to *models.Station) float64 {
+    km := geo.DistanceKm(
+        geo.Point{Lat: from.Latitude, Lng: from.Longitude},
+        geo.Point{Lat: to.Latitude, Lng: to.Longitude},
+    )
+    return math.Round((oneWayBaseFee+oneWayFeePerKm*km)*100) / 100
+}
+
+func parseStationID(w http.ResponseWriter, r *http.Request) (int, bool) {
+    stationID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid station ID",
+        })
+        return 0, false
+    }
+    return stationID, true
+}
+
+func (h *VehicleHandler) GetStations(w http.ResponseWriter, r *http.Request) {
+    stations, err := h.repo.GetStations()
+    if err != nil {
+        log.Printf("Error getting stations: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get stations",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: stations,
+    })
+}
+
+func (h *VehicleHandler) GetStation(w http.ResponseWriter, r *http.Request) {
+    stationID, ok := parseStationID(w, r)
+    if !ok {
+        return
+    }
+
+    station, err := h.repo.GetStationByID(stationID)
+    if err != nil {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: station,
+    })
+}
+
+func (h *VehicleHandler) CreateStation(w http.ResponseWriter, r *http.Request) {
+    var station models.Station
+    if err := json.NewDecoder(r.Body).Decode(&station); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    if err := validateStation(&station); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    if err := h.repo.CreateStation(&station); err != nil {
+        log.Printf("Error creating station: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to create station",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: station,
+    })
+}
+
+func (h *VehicleHandler) UpdateStation(w http.ResponseWriter, r *http.Request) {
+    stationID, ok := parseStationID(w, r)
+    if !ok {
+        return
+    }
+
+    var station models.Station
+    if err := json.NewDecoder(r.Body).Decode(&station); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+    station.ID = stationID
+
+    if err := validateStation(&station); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    if err := h.repo.UpdateStation(&station); err != nil {
+        log.Printf("Error updating station: %v", err)
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    updated, err := h.repo.GetStationByID(stationID)
+    if err != nil {
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to load station",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: updated,
+    })
+}
+
+func (h *VehicleHandler) DeleteStation(w http.ResponseWriter, r *http.Request) {
+    stationID, ok := parseStationID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.repo.DeleteStation(stationID); err != nil {
+        status := http.StatusConflict
+        if err.Error() == "station not found" {
+            status = http.StatusNotFound
+        }
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]string{"message": "station deleted"},
+    })
+}
+
+// AssignVehicleStation sets (or with null, clears) a vehicle's home station
+func (h *VehicleHandler) AssignVehicleStation(w http.ResponseWriter, r *http.Request) {
+    vehicleID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid vehicle ID",
+        })
+        return
+    }
+
+    var req struct {
+        StationID *int `json:"station_id"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    if err := h.repo.AssignHomeStation(vehicleID, req.StationID); err != nil {
+        status := http.StatusConflict
+        if strings.HasSuffix(err.Error(), "not found") {
+            status = http.StatusNotFound
+        }
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    vehicle, err := h.repo.GetVehicleByID(vehicleID)
+    if err != nil {
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to load vehicle",
+        })
+        return
+    }
+
+    h.events.Publish(events.Event{
+        Type:      "vehicle.updated",
+        VehicleID: vehicle.ID,
+        StationID: vehicle.HomeStationID,
+        Data:      vehicle,
+    })
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: vehicle,
+    })
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "vehicle-service/events"
+    "vehicle-service/models"
+)
+
+// Comment lines keep idle connections (and proxies) from timing out
+const streamKeepAlive = 20 * time.Second
+
+// publishVehicle broadcasts a vehicle's current state to stream clients
+func (h *VehicleHandler) publishVehicle(vehicleID int) {
+    vehicle, err := h.repo.GetVehicleByID(vehicleID)
+    if err != nil {
+        log.Printf("Error loading vehicle %d for stream: %v", vehicleID, err)
+        return
+    }
+    h.events.Publish(events.Event{
+        Type:      "vehicle.updated",
+        VehicleID: vehicle.ID,
+        StationID: vehicle.HomeStationID,
+        Data:      vehicle,
+    })
+}
+
+// publishBooking tells stream clients that a vehicle's bookings changed. Who
+// made the booking is left out; clients only need to know the car is taken.
+func (h *VehicleHandler) publishBooking(bookingID int, eventType string) {
+    booking, err := h.repo.GetBookingByID(bookingID)
+    if err != nil {
+        log.Printf("Error loading booking %d for stream: %v", bookingID, err)
+        return
+    }
+    h.publishBookingChange(booking, eventType)
+}
+
+func (h *VehicleHandler) publishBookingChange(booking *models.Booking, eventType string) {
+    h.events.Publish(events.Event{
+        Type:      eventType,
+        VehicleID: booking.VehicleID,
+        StationID: booking.PickupStationID,
+        Data: map[string]interface{}{
+            "booking_id": booking.ID,
+            "start_time": booking.StartTime,
+            "end_time":   booking.EndTime,
+            "status":     booking.Status,
+        },
+    })
+}
+
+// parseIDSet reads a comma-separated list of IDs such as "1,4,7"
+func parseIDSet(raw string) (map[int]bool, error) {
+    ids := make(map[int]bool)
+    if raw == "" {
+        return ids, nil
+    }
+    for _, part := range strings.Split(raw, ",") {
+        id, err := strconv.Atoi(strings.TrimSpace(part))
+        if err != nil {
+            return nil, err
+        }
+        ids[id] = true
+    }
+    return ids, nil
+}
+
+// StreamVehicles pushes vehicle and booking changes as server-sent events.
+// station_id and vehicle_id (comma-separated) narrow what is sent. A client
+// that can't keep up receives a "resync" event and is disconnected; it should
+// reload its data and reconnect.
+func (h *VehicleHandler) StreamVehicles(w http.ResponseWriter, r *http.Request) {
+    stations, err := parseIDSet(r.URL.Query().Get("station_id"))
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid station_id",
+        })
+        return
+    }
+    vehicles, err := parseIDSet(r.URL.Query().Get("vehicle_id"))
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid vehicle_id",
+        })
+        return
+    }
+
+    // The server's write timeout would end the stream; lift it for this response
+    rc := http.NewResponseController(w)
+    if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+        log.Printf("Streaming not supported: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "streaming not supported",
+        })
+        return
+    }
+
+    sub := h.events.Subscribe(events.Filter{StationIDs: stations, VehicleIDs: vehicles})
+    defer h.events.Unsubscribe(sub)
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, "retry: 3000\nevent: ready\ndata: {}\n\n")
+    rc.Flush()
+
+    keepAlive := time.NewTicker(streamKeepAlive)
+    defer keepAlive.Stop()
+
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-keepAlive.C:
+            if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+                return
+            }
+            rc.Flush()
+        case event, ok := <-sub.C:
+            if !ok {
+                if sub.Overflowed() {
+                    fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+                    rc.Flush()
+                }
+                return
+            }
+            payload, err := json.Marshal(event)
+            if err != nil {
+                log.Printf("Error encoding stream event: %v", err)
+                continue
+            }
+            if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload); err != nil {
+                return
+            }
+            rc.Flush()
+        }
+    }
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "vehicle-service/geo"
+    "vehicle-service/models"
+)
+
+const (
+    maxTelemetryBatch    = 1000
+    maxTelemetryBody     = 1 << 20
+    maxClockSkew         = 5 * time.Minute
+    defaultHistoryRange  = 24 * time.Hour
+    maxHistoryRange      = 90 * 24 * time.Hour
+    defaultHistoryPoints = 200
+    maxHistoryPoints     = 1000
+)
+
+func validateReading(reading *models.TelemetryReading, now time.Time) error {
+    if reading.RecordedAt.IsZero() {
+        return fmt.Errorf("recorded_at is required")
+    }
+    if reading.RecordedAt.After(now.Add(maxClockSkew)) {
+        return fmt.Errorf("recorded_at is in the future")
+    }
+    reading.RecordedAt = reading.RecordedAt.UTC()
+
+    if (reading.Latitude == nil) != (reading.Longitude == nil) {
+        return fmt.Errorf("latitude and longitude must be sent together")
+    }
+    if reading.Latitude != nil {
+        if err := (geo.Point{Lat: *reading.Latitude, Lng: *reading.Longitude}).Validate(); err != nil {
+            return err
+        }
+    }
+    if reading.BatteryLevel != nil && !validLevel(*reading.BatteryLevel) {
+        return fmt.Errorf("battery_level must be between 0 and 100")
+    }
+    if reading.OdometerKm != nil && *reading.OdometerKm < 0 {
+        return fmt.Errorf("odometer_km can't be negative")
+    }
+    if reading.SpeedKmh != nil && *reading.SpeedKmh < 0 {
+        return fmt.Errorf("speed_kmh can't be negative")
+    }
+    return nil
+}
+
+// IngestTelemetry accepts a batch of readings from an in-car device. The
+// vehicle is the one the authenticated device is fitted to.
+func (h *VehicleHandler) IngestTelemetry(w http.ResponseWriter, r *http.Request) {
+    deviceID, _ := r.Context().Value("device_id").(string)
+    vehicleID, ok := r.Context().Value("device_vehicle_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    var req struct {
+        Readings []models.TelemetryReading `json:"readings"`
+    }
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTelemetryBody)).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    if len(req.Readings) == 0 || len(req.Readings) > maxTelemetryBatch {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: fmt.Sprintf("send between 1 and %d readings", maxTelemetryBatch),
+        })
+        return
+    }
+
+    now := time.Now()
+    for i := range req.Readings {
+        if err := validateReading(&req.Readings[i], now); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("reading %d: %v", i, err),
+            })
+            return
+        }
+    }
+
+    if err := h.repo.IngestTelemetry(deviceID, vehicleID, req.Readings); err != nil {
+        log.Printf("Error ingesting telemetry from %s: %v", deviceID, err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to store telemetry",
+        })
+        return
+    }
+
+    h.publishVehicle(vehicleID)
+
+    sendJSON(w, http.StatusAccepted, Response{
+        Success: true,
+        Data: map[string]int{"accepted": len(req.Readings)},
+    })
+}
+
+// RegisterDevice fits a telemetry device to a vehicle and returns its key once
+func (h *VehicleHandler) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        DeviceID string `json:"device_id"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    req.DeviceID = strings.TrimSpace(req.DeviceID)
+    if req.DeviceID == "" || len(req.DeviceID) > 64 {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "device_id of up to 64 characters is required",
+        })
+        return
+    }
+
+    device, err := h.repo.RegisterDevice(req.DeviceID, vehicleID)
+    if err != nil {
+        log.Printf("Error registering device: %v", err)
+        status := http.StatusInternalServerError
+        if err.Error() == "vehicle not found" {
+            status = http.StatusNotFound
+        }
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: device,
+    })
+}
+
+// GetVehicleHistory returns downsampled telemetry for charts. from and to are
+// RFC 3339 times (default: the last 24 hours); points is the rough number of buckets.
+func (h *VehicleHandler) GetVehicleHistory(w http.ResponseWriter, r *http.Request) {
+    vehicleID, ok := parseVehicleID(w, r)
+    if !ok {
+        return
+    }
+
+    q := r.URL.Query()
+    to := time.Now().UTC()
+    if raw := q.Get("to"); raw != "" {
+        t, err := time.Parse(time.RFC3339, raw)
+        if err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "invalid to; use RFC 3339",
+            })
+            return
+        }
+        to = t.UTC()
+    }
+
+    from := to.Add(-defaultHistoryRange)
+    if raw := q.Get("from"); raw != "" {
+        t, err := time.Parse(time.RFC3339, raw)
+        if err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "invalid from; use RFC 3339",
+            })
+            return
+        }
+        from = t.UTC()
+    }
+
+    if !from.Before(to) || to.Sub(from) > maxHistoryRange {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "from must be before to and at most 90 days earlier",
+        })
+        return
+    }
+
+    points := defaultHistoryPoints
+    if raw := q.Get("points"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n < 1 || n > maxHistoryPoints {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("points must be between 1 and %d", maxHistoryPoints),
+            })
+            return
+        }
+        points = n
+    }
+
+    // Buckets are whole seconds, so short ranges come back as raw readings
+    bucket := time.Duration(math.Ceil(to.Sub(from).Seconds()/float64(points))) * time.Second
+
+    history, err := h.repo.GetVehicleHistory(vehicleID, from, to, bucket)
+    if err != nil {
+        log.Printf("Error getting vehicle history: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get vehicle history",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]interface{}{
+            "from":           from,
+            "to":             to,
+            "bucket_seconds": int(bucket.Seconds()),
+            "points":         history,
+        },
+    })
+}
@@ -1,307 +1,858 @@
-package handlers
-
-import (
-    "encoding/json"
-    "log"
-    "net/http"
-    "strconv"
-    "time"
-    "fmt"
-
-    "github.com/gorilla/mux"
-    "vehicle-service/models"
-    "vehicle-service/repository"
-)
-
-type VehicleHandler struct {
-    repo *repository.VehicleRepository
-}
-
-func NewVehicleHandler(repo *repository.VehicleRepository) *VehicleHandler {
-    return &VehicleHandler{repo: repo}
-}
-
-// Response wrapper
-type Response struct {
-    Success bool        `json:"success"`
-    Data    interface{} `json:"data,omitempty"`
-    Error   string      `json:"error,omitempty"`
-}
-
-// sendJSON helper
-func sendJSON(w http.ResponseWriter, status int, payload interface{}) {
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(status)
-    if err := json.NewEncoder(w).Encode(payload); err != nil {
-        log.Printf("Error sending response: %v", err)
-    }
-}
-
-
-func (h *VehicleHandler) GetAvailableVehicles(w http.ResponseWriter, r *http.Request) {
-    log.Printf("Received request for available vehicles")
-    
-    startTime := r.URL.Query().Get("start_time")
-    endTime := r.URL.Query().Get("end_time")
-    
-    log.Printf("Query parameters - start_time: %s, end_time: %s", startTime, endTime)
-    
-    if startTime == "" || endTime == "" {
-        log.Printf("Missing required query parameters")
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: "start_time and end_time query parameters are required",
-        })
-        return
-    }
-
-    start, err := time.Parse(time.RFC3339, startTime)
-    if err != nil {
-        log.Printf("Error parsing start_time: %v", err)
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: fmt.Sprintf("invalid start_time format: %v", err),
-        })
-        return
-    }
-
-    end, err := time.Parse(time.RFC3339, endTime)
-    if err != nil {
-        log.Printf("Error parsing end_time: %v", err)
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: fmt.Sprintf("invalid end_time format: %v", err),
-        })
-        return
-    }
-
-    vehicles, err := h.repo.GetAvailableVehicles(start, end)
-    if err != nil {
-        log.Printf("Error getting available vehicles: %v", err)
-        sendJSON(w, http.StatusInternalServerError, Response{
-            Success: false,
-            Error: fmt.Sprintf("failed to get available vehicles: %v", err),
-        })
-        return
-    }
-
-    log.Printf("Successfully retrieved %d vehicles", len(vehicles))
-    sendJSON(w, http.StatusOK, Response{
-        Success: true,
-        Data: vehicles,
-    })
-}
-
-func (h *VehicleHandler) CreateBooking(w http.ResponseWriter, r *http.Request) {
-    var req struct {
-        VehicleID int       `json:"vehicle_id"`
-        StartTime time.Time `json:"start_time"`
-        EndTime   time.Time `json:"end_time"`
-    }
-
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: "invalid request body",
-        })
-        return
-    }
-
-    userID, ok := r.Context().Value("user_id").(int)
-    if !ok {
-        sendJSON(w, http.StatusUnauthorized, Response{
-            Success: false,
-            Error: "unauthorized",
-        })
-        return
-    }
-
-    booking := &models.Booking{
-        UserID:    userID,
-        VehicleID: req.VehicleID,
-        StartTime: req.StartTime,
-        EndTime:   req.EndTime,
-        Status:    "pending",
-    }
-
-    if err := h.repo.CreateReservation(booking); err != nil {
-        log.Printf("Error creating booking: %v", err)
-        sendJSON(w, http.StatusInternalServerError, Response{
-            Success: false,
-            Error: "failed to create booking",
-        })
-        return
-    }
-
-    sendJSON(w, http.StatusCreated, Response{
-        Success: true,
-        Data: booking,
-    })
-}
-
-func (h *VehicleHandler) GetUserBookings(w http.ResponseWriter, r *http.Request) {
-    userID, ok := r.Context().Value("user_id").(int)
-    if !ok {
-        sendJSON(w, http.StatusUnauthorized, Response{
-            Success: false,
-            Error: "unauthorized",
-        })
-        return
-    }
-
-    bookings, err := h.repo.GetUserReservations(userID)
-    if err != nil {
-        log.Printf("Error getting user bookings: %v", err)
-        sendJSON(w, http.StatusInternalServerError, Response{
-            Success: false,
-            Error: "failed to get bookings",
-        })
-        return
-    }
-
-    sendJSON(w, http.StatusOK, Response{
-        Success: true,
-        Data: bookings,
-    })
-}
-
-func (h *VehicleHandler) UpdateBooking(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    bookingID, err := strconv.Atoi(vars["id"])
-    if err != nil {
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: "invalid booking ID",
-        })
-        return
-    }
-
-    userID, ok := r.Context().Value("user_id").(int)
-    if !ok {
-        sendJSON(w, http.StatusUnauthorized, Response{
-            Success: false,
-            Error: "unauthorized",
-        })
-        return
-    }
-
-    var req struct {
-        StartTime *time.Time `json:"start_time,omitempty"`
-        EndTime   *time.Time `json:"end_time,omitempty"`
-    }
-
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: "invalid request body",
-        })
-        return
-    }
-
-    // Verify ownership
-    if err := h.repo.VerifyBookingOwnership(bookingID, userID); err != nil {
-        sendJSON(w, http.StatusNotFound, Response{
-            Success: false,
-            Error: "booking not found or unauthorized",
-        })
-        return
-    }
-
-    if err := h.repo.UpdateReservation(bookingID, req.StartTime, req.EndTime); err != nil {
-        log.Printf("Error updating booking: %v", err)
-        sendJSON(w, http.StatusInternalServerError, Response{
-            Success: false,
-            Error: "failed to update booking",
-        })
-        return
-    }
-
-    sendJSON(w, http.StatusOK, Response{
-        Success: true,
-        Data: map[string]string{"message": "booking updated successfully"},
-    })
-}
-
-func (h *VehicleHandler) CancelBooking(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    bookingID, err := strconv.Atoi(vars["id"])
-    if err != nil {
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: "invalid booking ID",
-        })
-        return
-    }
-
-    userID, ok := r.Context().Value("user_id").(int)
-    if !ok {
-        sendJSON(w, http.StatusUnauthorized, Response{
-            Success: false,
-            Error: "unauthorized",
-        })
-        return
-    }
-
-    // Verify ownership
-    if err := h.repo.VerifyBookingOwnership(bookingID, userID); err != nil {
-        sendJSON(w, http.StatusNotFound, Response{
-            Success: false,
-            Error: "booking not found or unauthorized",
-        })
-        return
-    }
-
-    if err := h.repo.UpdateBookingStatus(bookingID, "cancelled"); err != nil {
-        log.Printf("Error cancelling booking: %v", err)
-        sendJSON(w, http.StatusInternalServerError, Response{
-            Success: false,
-            Error: "failed to cancel booking",
-        })
-        return
-    }
-
-    sendJSON(w, http.StatusOK, Response{
-        Success: true,
-        Data: map[string]string{"message": "booking cancelled successfully"},
-    })
-}
-
-func (h *VehicleHandler) UpdateVehicleStatus(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    vehicleID, err := strconv.Atoi(vars["id"])
-    if err != nil {
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: "invalid vehicle ID",
-        })
-        return
-    }
-
-    var update struct {
-        Location         *string `json:"location,omitempty"`
-        BatteryLevel    *int    `json:"battery_level,omitempty"`
-        CleanlinessStatus *string `json:"cleanliness_status,omitempty"`
-    }
-
-    if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
-        sendJSON(w, http.StatusBadRequest, Response{
-            Success: false,
-            Error: "invalid request body",
-        })
-        return
-    }
-
-    if err := h.repo.UpdateVehicleStatus(vehicleID, update.Location, update.BatteryLevel, update.CleanlinessStatus); err != nil {
-        log.Printf("Error updating vehicle status: %v", err)
-        sendJSON(w, http.StatusInternalServerError, Response{
-            Success: false,
-            Error: "failed to update vehicle status",
-        })
-        return
-    }
-
-    sendJSON(w, http.StatusOK, Response{
-        Success: true,
-        Data: map[string]string{"message": "vehicle status updated successfully"},
-    })
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "fmt"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/billingclient"
+    "vehicle-service/events"
+    "vehicle-service/geo"
+    "vehicle-service/models"
+    "vehicle-service/repository"
+    "vehicle-service/storage"
+    "vehicle-service/userclient"
+)
+
+type VehicleHandler struct {
+    repo   *repository.VehicleRepository
+    users   *userclient.Client
+    billing *billingclient.Client
+    events  *events.Hub
+    store   storage.BlobStore
+}
+
+func NewVehicleHandler(repo *repository.VehicleRepository, users *userclient.Client, billing *billingclient.Client, hub *events.Hub, store storage.BlobStore) *VehicleHandler {
+    return &VehicleHandler{repo: repo, users: users, billing: billing, events: hub, store: store}
+}
+
+// Response wrapper. Paged lists also report the total number of matches and
+// the cursor for the next page (absent on the last page); writes may carry a
+// non-fatal warning.
+type Response struct {
+    Success    bool        `json:"success"`
+    Data       interface{} `json:"data,omitempty"`
+    Error      string      `json:"error,omitempty"`
+    Total      *int        `json:"total,omitempty"`
+    NextCursor string      `json:"next_cursor,omitempty"`
+    Warning    string      `json:"warning,omitempty"`
+}
+
+// sendJSON helper
+func sendJSON(w http.ResponseWriter, status int, payload interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(payload); err != nil {
+        log.Printf("Error sending response: %v", err)
+    }
+}
+
+
+const (
+    defaultSearchRadiusKm = 2.0
+    maxSearchRadiusKm     = 50.0
+    defaultPageSize       = 20
+    maxPageSize           = 100
+)
+
+// parseNear reads lat, lng and radius (km) query parameters. It returns a nil
+// point when lat and lng are both absent.
+func parseNear(r *http.Request) (*geo.Point, float64, error) {
+    q := r.URL.Query()
+    if q.Get("lat") == "" && q.Get("lng") == "" {
+        return nil, 0, nil
+    }
+
+    lat, err := strconv.ParseFloat(q.Get("lat"), 64)
+    if err != nil {
+        return nil, 0, fmt.Errorf("lat must be a number")
+    }
+    lng, err := strconv.ParseFloat(q.Get("lng"), 64)
+    if err != nil {
+        return nil, 0, fmt.Errorf("lng must be a number")
+    }
+    point := &geo.Point{Lat: lat, Lng: lng}
+    if err := point.Validate(); err != nil {
+        return nil, 0, err
+    }
+
+    radius := defaultSearchRadiusKm
+    if raw := q.Get("radius"); raw != "" {
+        radius, err = strconv.ParseFloat(raw, 64)
+        if err != nil || radius <= 0 || radius > maxSearchRadiusKm {
+            return nil, 0, fmt.Errorf("radius must be a number of kilometres between 0 and %g", maxSearchRadiusKm)
+        }
+    }
+    return point, radius, nil
+}
+
+// searchVehicles runs an availability search and writes the response.
+// The time window is required unless windowOptional, in which case it defaults to now.
+func (h *VehicleHandler) searchVehicles(w http.ResponseWriter, r *http.Request, windowOptional bool) {
+    startTime := r.URL.Query().Get("start_time")
+    endTime := r.URL.Query().Get("end_time")
+    
+    log.Printf("Query parameters - start_time: %s, end_time: %s", startTime, endTime)
+
+    search := models.VehicleSearch{Start: time.Now(), End: time.Now()}
+    search.UserID, _ = r.Context().Value("user_id").(int)
+
+    if startTime != "" || endTime != "" || !windowOptional {
+        if startTime == "" || endTime == "" {
+            log.Printf("Missing required query parameters")
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "start_time and end_time query parameters are required",
+            })
+            return
+        }
+
+        start, err := time.Parse(time.RFC3339, startTime)
+        if err != nil {
+            log.Printf("Error parsing start_time: %v", err)
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("invalid start_time format: %v", err),
+            })
+            return
+        }
+
+        end, err := time.Parse(time.RFC3339, endTime)
+        if err != nil {
+            log.Printf("Error parsing end_time: %v", err)
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("invalid end_time format: %v", err),
+            })
+            return
+        }
+        search.Start, search.End = start, end
+    }
+
+    near, radius, err := parseNear(r)
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+    if near == nil && windowOptional {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "lat and lng query parameters are required",
+        })
+        return
+    }
+    search.Near, search.RadiusKm = near, radius
+
+    if err := parseFilters(r, &search); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    // Only list cars the driver's membership lets them book
+    if search.Classes, err = h.allowedClasses(r); err != nil {
+        log.Printf("Error getting booking policy: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get available vehicles",
+        })
+        return
+    }
+
+    page, err := h.repo.GetAvailableVehicles(search)
+    if err == repository.ErrInvalidCursor {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid cursor; start again without one",
+        })
+        return
+    }
+    if err != nil {
+        log.Printf("Error getting available vehicles: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: fmt.Sprintf("failed to get available vehicles: %v", err),
+        })
+        return
+    }
+
+    log.Printf("Successfully retrieved %d of %d vehicles", len(page.Vehicles), page.Total)
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: page.Vehicles,
+        Total: &page.Total,
+        NextCursor: page.NextCursor,
+    })
+}
+
+// parseFilters reads the optional attribute filters, sort and paging parameters
+func parseFilters(r *http.Request, search *models.VehicleSearch) error {
+    q := r.URL.Query()
+
+    if raw := q.Get("station_id"); raw != "" {
+        stationID, err := strconv.Atoi(raw)
+        if err != nil {
+            return fmt.Errorf("invalid station_id")
+        }
+        search.StationID = &stationID
+    }
+
+    search.Type = strings.TrimSpace(q.Get("type"))
+    search.Model = strings.TrimSpace(q.Get("model"))
+
+    if raw := q.Get("min_battery"); raw != "" {
+        minBattery, err := strconv.Atoi(raw)
+        if err != nil || minBattery < 0 || minBattery > 100 {
+            return fmt.Errorf("min_battery must be between 0 and 100")
+        }
+        search.MinBattery = &minBattery
+    }
+
+    if raw := q.Get("max_rate"); raw != "" {
+        maxRate, err := strconv.ParseFloat(raw, 64)
+        if err != nil || maxRate < 0 {
+            return fmt.Errorf("max_rate must be a positive number")
+        }
+        search.MaxRate = &maxRate
+    }
+
+    search.Sort = q.Get("sort")
+    if !repository.ValidSort(search.Sort) {
+        return fmt.Errorf("sort must be one of price, -price, battery, -battery, distance")
+    }
+    if search.Sort == "distance" && search.Near == nil {
+        return fmt.Errorf("sort=distance needs lat and lng")
+    }
+
+    search.Limit = defaultPageSize
+    if raw := q.Get("limit"); raw != "" {
+        limit, err := strconv.Atoi(raw)
+        if err != nil || limit < 1 || limit > maxPageSize {
+            return fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+        }
+        search.Limit = limit
+    }
+
+    search.Cursor = q.Get("cursor")
+    return nil
+}
+
+func (h *VehicleHandler) GetAvailableVehicles(w http.ResponseWriter, r *http.Request) {
+    log.Printf("Received request for available vehicles")
+    h.searchVehicles(w, r, false)
+}
+
+// GetNearbyVehicles lists cars within a radius of lat/lng, nearest first. The
+// start_time/end_time window is optional and defaults to right now.
+func (h *VehicleHandler) GetNearbyVehicles(w http.ResponseWriter, r *http.Request) {
+    log.Printf("Received request for nearby vehicles")
+    h.searchVehicles(w, r, true)
+}
+
+func (h *VehicleHandler) CreateBooking(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        VehicleID       int       `json:"vehicle_id"`
+        StartTime       time.Time `json:"start_time"`
+        EndTime         time.Time `json:"end_time"`
+        ReturnStationID *int      `json:"return_station_id"` // omit to return where picked up
+        PlannedDistanceKm *float64 `json:"planned_distance_km"`
+        Destination     *geo.Point `json:"destination"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    if !req.StartTime.After(time.Now()) || !req.EndTime.After(req.StartTime) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "start_time must be in the future and before end_time",
+        })
+        return
+    }
+
+    driver, status, err := h.checkDriver(r.Context(), userID, req.EndTime)
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    booking := &models.Booking{
+        UserID:    userID,
+        VehicleID: req.VehicleID,
+        StartTime: req.StartTime,
+        EndTime:   req.EndTime,
+        Status:    "pending",
+    }
+
+    vehicle, err := h.repo.GetVehicleByID(booking.VehicleID)
+    if err != nil {
+        sendJSON(w, http.StatusNotFound, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    if err := checkVehicleInService(vehicle); err != nil {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    blocked, err := h.repo.VehicleBlocked(vehicle.ID, booking.StartTime)
+    if err != nil {
+        log.Printf("Error checking maintenance tickets: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to create booking",
+        })
+        return
+    }
+    if blocked {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "this vehicle is out of service for maintenance",
+        })
+        return
+    }
+
+    policy, status, err := h.checkBookingPolicy(driver, vehicle, []models.Booking{*booking})
+    if err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    if status, err := h.planStations(booking, vehicle, req.ReturnStationID); err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    booking.PlannedDistanceKm, err = h.plannedDistance(booking, vehicle, req.PlannedDistanceKm, req.Destination)
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    var warning string
+    if booking.PlannedDistanceKm != nil {
+        warning, err = checkRange(vehicle, *booking.PlannedDistanceKm)
+        if err != nil {
+            sendJSON(w, http.StatusUnprocessableEntity, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+    }
+
+    if err := h.repo.CreateReservation(booking, maxActiveBookings(policy)); err != nil {
+        if err == repository.ErrBookingLimit {
+            sendJSON(w, http.StatusForbidden, Response{
+                Success: false,
+                Error: bookingLimitError(policy),
+            })
+            return
+        }
+        if err == repository.ErrVehicleBooked || err == repository.ErrVehicleHeld || err == repository.ErrReturnStationFull {
+            sendJSON(w, http.StatusConflict, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+        log.Printf("Error creating booking: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to create booking",
+        })
+        return
+    }
+
+    h.publishBookingChange(booking, "booking.created")
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: booking,
+        Warning: warning,
+    })
+}
+
+// checkVehicleInService refuses bookings of retired cars and of cars waiting
+// to be cleaned
+func checkVehicleInService(vehicle *models.Vehicle) error {
+    if vehicle.Status == "decommissioned" {
+        return fmt.Errorf("this vehicle has been retired from the fleet")
+    }
+    if vehicle.CleanlinessStatus != nil && *vehicle.CleanlinessStatus == "needs_cleaning" {
+        return fmt.Errorf("this vehicle is waiting to be cleaned")
+    }
+    return nil
+}
+
+// checkDriver checks the driver's licence is verified and valid through end
+// and returns the driver. It returns an HTTP status alongside any error.
+func (h *VehicleHandler) checkDriver(ctx context.Context, userID int, end time.Time) (*userclient.User, int, error) {
+    driver, err := h.users.GetUser(ctx, userID)
+    if err == userclient.ErrNotFound {
+        return nil, http.StatusNotFound, err
+    }
+    if err != nil {
+        log.Printf("Error getting driver licence: %v", err)
+        return nil, http.StatusInternalServerError, fmt.Errorf("failed to verify driver licence")
+    }
+
+    if driver.VerificationStatus != "verified" {
+        return nil, http.StatusForbidden, fmt.Errorf("driver licence has not been verified")
+    }
+
+    // The licence is valid through its expiry date and must cover the whole booking
+    if !licenceCovers(driver, end) {
+        return nil, http.StatusForbidden, fmt.Errorf("driver licence expires before the end of this booking")
+    }
+    return driver, 0, nil
+}
+
+// planStations sets the pickup and return stations on a new booking and prices
+// one-way trips. It returns an HTTP status alongside any validation error.
+func (h *VehicleHandler) planStations(booking *models.Booking, vehicle *models.Vehicle, returnStationID *int) (int, error) {
+    pickup, dropoff, status, err := h.findStations(vehicle, returnStationID)
+    if err != nil || pickup == nil {
+        return status, err
+    }
+
+    if !stationOpenAt(pickup, booking.StartTime) {
+        return http.StatusBadRequest, fmt.Errorf("%s is closed at the start of this booking", pickup.Name)
+    }
+    if !stationOpenAt(dropoff, booking.EndTime) {
+        return http.StatusBadRequest, fmt.Errorf("%s is closed at the end of this booking", dropoff.Name)
+    }
+    setStations(booking, pickup, dropoff)
+    return 0, nil
+}
+
+// findStations looks up where a car is picked up and returned. Both are nil
+// for cars not based at a station; the return station defaults to the pickup.
+func (h *VehicleHandler) findStations(vehicle *models.Vehicle, returnStationID *int) (*models.Station, *models.Station, int, error) {
+    if vehicle.HomeStationID == nil {
+        if returnStationID != nil {
+            return nil, nil, http.StatusBadRequest, fmt.Errorf("this vehicle is not based at a station and cannot be returned to one")
+        }
+        return nil, nil, 0, nil
+    }
+
+    pickup, err := h.repo.GetStationByID(*vehicle.HomeStationID)
+    if err != nil {
+        return nil, nil, http.StatusInternalServerError, err
+    }
+    if returnStationID == nil || *returnStationID == pickup.ID {
+        return pickup, pickup, 0, nil
+    }
+
+    dropoff, err := h.repo.GetStationByID(*returnStationID)
+    if err != nil {
+        return nil, nil, http.StatusBadRequest, fmt.Errorf("return station not found")
+    }
+    return pickup, dropoff, 0, nil
+}
+
+// setStations records the stations on a booking, with the drop-off fee for
+// one-way trips
+func setStations(booking *models.Booking, pickup, dropoff *models.Station) {
+    booking.PickupStationID = &pickup.ID
+    booking.ReturnStationID = &dropoff.ID
+    if dropoff.ID != pickup.ID {
+        booking.DropoffFee = dropoffFee(pickup, dropoff)
+    }
+}
+
+func (h *VehicleHandler) GetUserBookings(w http.ResponseWriter, r *http.Request) {
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    bookings, err := h.repo.GetUserReservations(userID)
+    if err != nil {
+        log.Printf("Error getting user bookings: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get bookings",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: bookings,
+    })
+}
+
+// GetBookingsForUser lists any user's bookings for user-service (data export, account deletion)
+func (h *VehicleHandler) GetBookingsForUser(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid user ID",
+        })
+        return
+    }
+
+    bookings, err := h.repo.GetUserReservations(userID)
+    if err != nil {
+        log.Printf("Error getting bookings for user %d: %v", userID, err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get bookings",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: bookings,
+    })
+}
+
+// UpdateBooking reschedules a booking. The new times must not overlap another
+// booking of the same vehicle; the change is recorded like an extension.
+func (h *VehicleHandler) UpdateBooking(w http.ResponseWriter, r *http.Request) {
+    booking, ok := h.loadOwnBooking(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        StartTime *time.Time `json:"start_time,omitempty"`
+        EndTime   *time.Time `json:"end_time,omitempty"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    start, end := booking.StartTime, booking.EndTime
+    if req.StartTime != nil {
+        start = *req.StartTime
+    }
+    if req.EndTime != nil {
+        end = *req.EndTime
+    }
+    if !end.After(start) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "end_time must be after start_time",
+        })
+        return
+    }
+    if !start.Equal(booking.StartTime) && !start.After(time.Now()) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "start_time must be in the future",
+        })
+        return
+    }
+
+    if booking.PickupStationID != nil && !start.Equal(booking.StartTime) {
+        pickup, err := h.repo.GetStationByID(*booking.PickupStationID)
+        if err != nil {
+            sendRepoError(w, err)
+            return
+        }
+        if !stationOpenAt(pickup, start) {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("%s is closed at the start of this booking", pickup.Name),
+            })
+            return
+        }
+    }
+    if status, err := h.checkNewTimes(r.Context(), booking, start, end); err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+    if status, err := h.checkFeeWindow(r.Context(), booking, start, end); err != nil {
+        sendJSON(w, status, Response{
+            Success: false,
+            Error: err.Error(),
+        })
+        return
+    }
+
+    h.amend(w, r, booking, "reschedule", start, end)
+}
+
+// checkFeeWindow stops a booking that would already cost a fee to cancel from
+// being moved or shortened, which would make cancelling it cheaper.
+// Extending it is still allowed.
+func (h *VehicleHandler) checkFeeWindow(ctx context.Context, booking *models.Booking, start, end time.Time) (int, error) {
+    if start.Equal(booking.StartTime) && !end.Before(booking.EndTime) {
+        return 0, nil
+    }
+    _, status, err := h.feeWindowOpen(ctx, booking)
+    return status, err
+}
+
+// feeWindowOpen reports whether cancelling the booking now would be free. When
+// it wouldn't, it returns 409 and an error saying so.
+func (h *VehicleHandler) feeWindowOpen(ctx context.Context, booking *models.Booking) (bool, int, error) {
+    quote, err := h.billing.QuoteCancellation(ctx, booking.UserID, booking.ID, time.Now())
+    if err != nil {
+        log.Printf("Error quoting cancellation: %v", err)
+        return false, http.StatusBadGateway, fmt.Errorf("failed to check the cancellation policy")
+    }
+    if quote.FeePercent > 0 {
+        return false, http.StatusConflict, fmt.Errorf("bookings can't be moved or shortened once cancelling them costs a fee (free until %s)",
+            quote.FreeUntil.Format(timeLayout))
+    }
+    return true, 0, nil
+}
+
+// GetCancellationQuote tells the driver what cancelling the booking now would
+// cost under their membership tier's policy
+func (h *VehicleHandler) GetCancellationQuote(w http.ResponseWriter, r *http.Request) {
+    booking, ok := h.loadOwnBooking(w, r)
+    if !ok {
+        return
+    }
+
+    if !bookingAccessStatuses[booking.Status] {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking is " + booking.Status,
+        })
+        return
+    }
+
+    quote, err := h.billing.QuoteCancellation(r.Context(), booking.UserID, booking.ID, time.Now())
+    if err != nil {
+        log.Printf("Error quoting cancellation: %v", err)
+        sendJSON(w, http.StatusBadGateway, Response{
+            Success: false,
+            Error: "failed to price the cancellation",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: quote,
+    })
+}
+
+// CancelBooking cancels the driver's booking. billing-service charges the fee
+// first, so a booking is never cancelled without its fee; if the booking then
+// can't be cancelled the charge is reverted.
+func (h *VehicleHandler) CancelBooking(w http.ResponseWriter, r *http.Request) {
+    booking, ok := h.loadOwnBooking(w, r)
+    if !ok {
+        return
+    }
+
+    if !bookingAccessStatuses[booking.Status] {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking is " + booking.Status,
+        })
+        return
+    }
+    now := time.Now()
+    if now.After(booking.EndTime) {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "booking has already ended",
+        })
+        return
+    }
+
+    charge, err := h.billing.ChargeCancellation(r.Context(), booking.UserID, booking.ID, now, false)
+    if err != nil {
+        log.Printf("Error charging cancellation: %v", err)
+        sendJSON(w, http.StatusBadGateway, Response{
+            Success: false,
+            Error: "failed to charge the cancellation, the booking is unchanged",
+        })
+        return
+    }
+
+    if err := h.repo.CancelBooking(booking.ID, now, charge.Fee); err != nil {
+        log.Printf("Error cancelling booking: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: cancelFailedError(booking.ID, h.revertCancellation(booking.ID)),
+        })
+        return
+    }
+
+    h.publishBooking(booking.ID, "booking.cancelled")
+    go h.offerVehicle(context.Background(), booking.VehicleID)
+
+    data := map[string]interface{}{
+        "message":          "booking cancelled successfully",
+        "cancellation_fee": charge.Fee,
+        "no_show":          charge.NoShow,
+    }
+    if charge.Invoice != nil {
+        data["invoice_id"] = charge.Invoice.ID
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: data,
+    })
+}
+
+// Attempts at a billing-service correction before giving up on it
+const (
+    billingAttempts   = 3
+    billingRetryDelay = 500 * time.Millisecond
+)
+
+// revertCancellation undoes the cancellation charge of a booking that is
+// still live, retrying briefly. It returns the last error when the charge
+// still stands, so the caller can tell the driver.
+func (h *VehicleHandler) revertCancellation(bookingID int) error {
+    var err error
+    for attempt := 1; attempt <= billingAttempts; attempt++ {
+        if err = h.billing.RevertCancellation(context.Background(), bookingID); err == nil {
+            return nil
+        }
+        log.Printf("Error reverting cancellation charge of booking %d (attempt %d): %v", bookingID, attempt, err)
+        if attempt < billingAttempts {
+            time.Sleep(billingRetryDelay)
+        }
+    }
+    return err
+}
+
+// cancelFailedError explains a cancellation that failed after billing-service
+// charged it, depending on whether the charge could be undone
+func cancelFailedError(bookingID int, revertErr error) string {
+    if revertErr != nil {
+        return fmt.Sprintf("failed to cancel booking %d, and its cancellation charge couldn't be reversed; contact support", bookingID)
+    }
+    return fmt.Sprintf("failed to cancel booking %d; you have not been charged", bookingID)
+}
+
+func (h *VehicleHandler) UpdateVehicleStatus(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    vehicleID, err := strconv.Atoi(vars["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid vehicle ID",
+        })
+        return
+    }
+
+    var update struct {
+        Location         *string `json:"location,omitempty"`
+        Latitude        *float64 `json:"latitude,omitempty"`
+        Longitude       *float64 `json:"longitude,omitempty"`
+        BatteryLevel    *int    `json:"battery_level,omitempty"`
+        CleanlinessStatus *string `json:"cleanliness_status,omitempty"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    // needs_cleaning hides the car from search until the crew completes its cleaning task
+    if update.CleanlinessStatus != nil && !cleanlinessStatuses[*update.CleanlinessStatus] {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "cleanliness_status must be clean or needs_cleaning",
+        })
+        return
+    }
+
+    // Coordinates only make sense as a pair
+    var position *geo.Point
+    if update.Latitude != nil || update.Longitude != nil {
+        if update.Latitude == nil || update.Longitude == nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: "latitude and longitude must be updated together",
+            })
+            return
+        }
+        position = &geo.Point{Lat: *update.Latitude, Lng: *update.Longitude}
+        if err := position.Validate(); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+    }
+
+    if err := h.repo.UpdateVehicleStatus(vehicleID, update.Location, position, update.BatteryLevel, update.CleanlinessStatus); err != nil {
+        log.Printf("Error updating vehicle status: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to update vehicle status",
+        })
+        return
+    }
+
+    h.publishVehicle(vehicleID)
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]string{"message": "vehicle status updated successfully"},
+    })
 }
\ No newline at end of file
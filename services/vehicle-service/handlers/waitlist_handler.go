@@ -0,0 +1,290 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "vehicle-service/geo"
+    "vehicle-service/models"
+    "vehicle-service/repository"
+)
+
+const (
+    // A driver offered a car from the waitlist has this long to book it
+    // before it is offered to the next driver
+    waitlistHold = 15 * time.Minute
+
+    // Waiting or held entries a driver can have at once
+    maxWaitlistEntries = 5
+)
+
+// JoinWaitlist registers interest in a car for a fully booked time window, at
+// a station or near a point, optionally of one vehicle type
+func (h *VehicleHandler) JoinWaitlist(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        StartTime   time.Time  `json:"start_time"`
+        EndTime     time.Time  `json:"end_time"`
+        StationID   *int       `json:"station_id"`
+        Near        *geo.Point `json:"near"`
+        RadiusKm    *float64   `json:"radius_km"`
+        VehicleType string     `json:"vehicle_type"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid request body",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    if !req.StartTime.After(time.Now()) || !req.EndTime.After(req.StartTime) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "start_time must be in the future and before end_time",
+        })
+        return
+    }
+
+    if (req.StationID == nil) == (req.Near == nil) {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "give either station_id or near",
+        })
+        return
+    }
+
+    entry := models.WaitlistEntry{
+        UserID:    userID,
+        StartTime: req.StartTime,
+        EndTime:   req.EndTime,
+        StationID: req.StationID,
+    }
+    search := models.VehicleSearch{
+        Start:     req.StartTime,
+        End:       req.EndTime,
+        StationID: req.StationID,
+        Type:      strings.TrimSpace(req.VehicleType),
+        Limit:     1,
+        UserID:    userID,
+    }
+
+    if req.Near != nil {
+        if err := req.Near.Validate(); err != nil {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: err.Error(),
+            })
+            return
+        }
+        radius := defaultSearchRadiusKm
+        if req.RadiusKm != nil {
+            radius = *req.RadiusKm
+        }
+        if radius <= 0 || radius > maxSearchRadiusKm {
+            sendJSON(w, http.StatusBadRequest, Response{
+                Success: false,
+                Error: fmt.Sprintf("radius_km must be between 0 and %g", maxSearchRadiusKm),
+            })
+            return
+        }
+        entry.Latitude, entry.Longitude, entry.RadiusKm = &req.Near.Lat, &req.Near.Lng, &radius
+        search.Near, search.RadiusKm = req.Near, radius
+    } else if _, err := h.repo.GetStationByID(*req.StationID); err != nil {
+        sendRepoError(w, err)
+        return
+    }
+
+    if search.Type != "" {
+        entry.VehicleType = &search.Type
+    }
+
+    // Only cars the driver's membership lets them book are offered or count as free
+    classes, err := h.allowedClasses(r)
+    if err != nil {
+        log.Printf("Error getting booking policy: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to join waitlist",
+        })
+        return
+    }
+    entry.Classes, search.Classes = classes, classes
+
+    // There is nothing to wait for when a matching car is already free
+    page, err := h.repo.GetAvailableVehicles(search)
+    if err != nil {
+        log.Printf("Error checking availability for waitlist: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to check availability",
+        })
+        return
+    }
+    if page.Total > 0 {
+        sendJSON(w, http.StatusConflict, Response{
+            Success: false,
+            Error: "a matching vehicle is available for these times; book it instead",
+        })
+        return
+    }
+
+    if err := h.repo.CreateWaitlistEntry(&entry, maxWaitlistEntries); err != nil {
+        if err == repository.ErrWaitlistLimit {
+            sendJSON(w, http.StatusConflict, Response{
+                Success: false,
+                Error: fmt.Sprintf("you can have at most %d waitlist entries at once", maxWaitlistEntries),
+            })
+            return
+        }
+        log.Printf("Error joining waitlist: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to join waitlist",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusCreated, Response{
+        Success: true,
+        Data: entry,
+    })
+}
+
+// GetWaitlist lists the driver's waitlist entries with their place in the queue
+func (h *VehicleHandler) GetWaitlist(w http.ResponseWriter, r *http.Request) {
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    entries, err := h.repo.GetWaitlistEntries(userID)
+    if err != nil {
+        log.Printf("Error getting waitlist: %v", err)
+        sendJSON(w, http.StatusInternalServerError, Response{
+            Success: false,
+            Error: "failed to get waitlist",
+        })
+        return
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: entries,
+    })
+}
+
+// LeaveWaitlist cancels one of the driver's entries. A car held for it is
+// offered to the next driver straight away.
+func (h *VehicleHandler) LeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+    entryID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        sendJSON(w, http.StatusBadRequest, Response{
+            Success: false,
+            Error: "invalid waitlist entry ID",
+        })
+        return
+    }
+
+    userID, ok := r.Context().Value("user_id").(int)
+    if !ok {
+        sendJSON(w, http.StatusUnauthorized, Response{
+            Success: false,
+            Error: "unauthorized",
+        })
+        return
+    }
+
+    entry, err := h.repo.LeaveWaitlist(entryID, userID)
+    if err != nil {
+        sendRepoError(w, err)
+        return
+    }
+
+    if entry.Status == "held" {
+        go h.offerVehicle(context.Background(), *entry.HeldVehicleID)
+    }
+
+    sendJSON(w, http.StatusOK, Response{
+        Success: true,
+        Data: map[string]string{"message": "left the waitlist"},
+    })
+}
+
+// offerVehicle holds a freed car for the first matching drivers on the
+// waitlist and tells them, along with any driver whose hold on it lapsed
+func (h *VehicleHandler) offerVehicle(ctx context.Context, vehicleID int) {
+    offered, expired, err := h.repo.OfferVehicle(vehicleID, waitlistHold)
+    if err != nil {
+        log.Printf("Error offering vehicle %d to the waitlist: %v", vehicleID, err)
+        return
+    }
+
+    for _, e := range expired {
+        h.notify(ctx, e.UserID, "Your waitlist hold has lapsed",
+            fmt.Sprintf("The car held for you from %s wasn't booked in time and has been offered to the next "+
+                "driver.", e.StartTime.Format(timeLayout)))
+    }
+
+    if len(offered) == 0 {
+        return
+    }
+
+    vehicle, err := h.repo.GetVehicleByID(vehicleID)
+    if err != nil {
+        log.Printf("Error getting vehicle %d for waitlist offer: %v", vehicleID, err)
+        return
+    }
+    h.publishVehicle(vehicleID)
+
+    for _, e := range offered {
+        h.notify(ctx, e.UserID, "A car is free for your booking",
+            fmt.Sprintf("A %s (%s) has come free for %s to %s. It is held for you until %s; book it with "+
+                "vehicle_id %d before then or it goes to the next driver.",
+                vehicle.Model, vehicle.LicensePlate, e.StartTime.Format(timeLayout), e.EndTime.Format(timeLayout),
+                e.HoldExpiresAt.Format(timeLayout), vehicleID))
+    }
+}
+
+// matchWaitlist closes entries whose window has started, then offers every
+// car that has come free or whose hold lapsed
+func (h *VehicleHandler) matchWaitlist(ctx context.Context) {
+    closed, err := h.repo.ExpireWaitlist()
+    if err != nil {
+        log.Printf("Error expiring waitlist: %v", err)
+    }
+    for _, e := range closed {
+        h.notify(ctx, e.UserID, "No car came free",
+            fmt.Sprintf("Sorry, no matching car came free for %s to %s, so your waitlist entry has closed.",
+                e.StartTime.Format(timeLayout), e.EndTime.Format(timeLayout)))
+    }
+
+    vehicleIDs, err := h.repo.GetWaitlistVehicles()
+    if err != nil {
+        log.Printf("Error finding vehicles for the waitlist: %v", err)
+        return
+    }
+    for _, id := range vehicleIDs {
+        h.offerVehicle(ctx, id)
+    }
+}
@@ -0,0 +1,164 @@
+// Package ical writes RFC 5545 iCalendar files: a calendar of events, each
+// with optional display reminders.
+package ical
+
+import (
+    "bufio"
+    "io"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    timeFormat = "20060102T150405Z"
+
+    // Content lines longer than this many octets are folded
+    maxLineOctets = 75
+)
+
+// Event is a VEVENT. Status is TENTATIVE, CONFIRMED or CANCELLED; calendar
+// clients replace an event with a higher Sequence for the same UID.
+type Event struct {
+    UID          string
+    Sequence     int
+    Start        time.Time
+    End          time.Time
+    Summary      string
+    Location     string
+    Description  string
+    Status       string
+    LastModified time.Time
+    Reminders    []Reminder
+}
+
+// Reminder is a display VALARM Before the event's start, or before its end
+// when FromEnd is set
+type Reminder struct {
+    Before      time.Duration
+    FromEnd     bool
+    Description string
+}
+
+// Calendar is a VCALENDAR. Subscribed clients are asked to refetch it every
+// Refresh when it is set.
+type Calendar struct {
+    ProdID  string
+    Name    string
+    Refresh time.Duration
+    Events  []Event
+}
+
+// Write writes the calendar with CRLF line endings, stamping events with now
+func (c *Calendar) Write(out io.Writer, now time.Time) error {
+    w := &writer{buf: bufio.NewWriter(out)}
+
+    w.line("BEGIN", "VCALENDAR")
+    w.line("VERSION", "2.0")
+    w.line("PRODID", c.ProdID)
+    w.line("CALSCALE", "GREGORIAN")
+    w.line("METHOD", "PUBLISH")
+    if c.Name != "" {
+        w.line("X-WR-CALNAME", escape(c.Name))
+    }
+    if c.Refresh > 0 {
+        w.line("REFRESH-INTERVAL;VALUE=DURATION", duration(c.Refresh))
+        w.line("X-PUBLISHED-TTL", duration(c.Refresh))
+    }
+
+    for _, e := range c.Events {
+        w.line("BEGIN", "VEVENT")
+        w.line("UID", e.UID)
+        w.line("SEQUENCE", strconv.Itoa(e.Sequence))
+        w.line("DTSTAMP", now.UTC().Format(timeFormat))
+        w.line("DTSTART", e.Start.UTC().Format(timeFormat))
+        w.line("DTEND", e.End.UTC().Format(timeFormat))
+        if !e.LastModified.IsZero() {
+            w.line("LAST-MODIFIED", e.LastModified.UTC().Format(timeFormat))
+        }
+        w.line("SUMMARY", escape(e.Summary))
+        if e.Location != "" {
+            w.line("LOCATION", escape(e.Location))
+        }
+        if e.Description != "" {
+            w.line("DESCRIPTION", escape(e.Description))
+        }
+        if e.Status != "" {
+            w.line("STATUS", e.Status)
+        }
+
+        // Cancelled events don't remind anyone
+        if e.Status != "CANCELLED" {
+            for _, r := range e.Reminders {
+                w.line("BEGIN", "VALARM")
+                w.line("ACTION", "DISPLAY")
+                w.line("DESCRIPTION", escape(r.Description))
+                if r.FromEnd {
+                    w.line("TRIGGER;RELATED=END", "-"+duration(r.Before))
+                } else {
+                    w.line("TRIGGER", "-"+duration(r.Before))
+                }
+                w.line("END", "VALARM")
+            }
+        }
+        w.line("END", "VEVENT")
+    }
+
+    w.line("END", "VCALENDAR")
+    if w.err != nil {
+        return w.err
+    }
+    return w.buf.Flush()
+}
+
+type writer struct {
+    buf *bufio.Writer
+    err error
+}
+
+// line writes one content line, folding it so no physical line is longer
+// than maxLineOctets. Folds never split a UTF-8 character.
+func (w *writer) line(name, value string) {
+    if w.err != nil {
+        return
+    }
+
+    s := name + ":" + value
+    limit := maxLineOctets
+    for len(s) > limit {
+        cut := limit
+        for cut > 0 && !startsRune(s[cut]) {
+            cut--
+        }
+        if _, w.err = w.buf.WriteString(s[:cut] + "\r\n "); w.err != nil {
+            return
+        }
+        s = s[cut:]
+        // Continuation lines start with a space, which counts towards the limit
+        limit = maxLineOctets - 1
+    }
+    _, w.err = w.buf.WriteString(s + "\r\n")
+}
+
+func startsRune(b byte) bool {
+    return b&0xC0 != 0x80
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escape makes text safe for a TEXT property value
+func escape(s string) string {
+    return escaper.Replace(s)
+}
+
+// duration formats a positive duration as an RFC 5545 DURATION, e.g. PT1H30M
+func duration(d time.Duration) string {
+    s := "PT"
+    if h := int(d.Hours()); h > 0 {
+        s += strconv.Itoa(h) + "H"
+    }
+    if m := int(d.Minutes()) % 60; m > 0 || s == "PT" {
+        s += strconv.Itoa(m) + "M"
+    }
+    return s
+}
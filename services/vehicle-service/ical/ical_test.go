@@ -0,0 +1,147 @@
+package ical
+
+import (
+    "bufio"
+    "bytes"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestEscape(t *testing.T) {
+    tests := []struct {
+        in, want string
+    }{
+        {"Tesla Model 3", "Tesla Model 3"},
+        {"Bay 4, Level 2; north entrance", `Bay 4\, Level 2\; north entrance`},
+        {`C:\cars`, `C:\\cars`},
+        {"line one\nline two", `line one\nline two`},
+        {"line one\r\nline two", `line one\nline two`},
+        {`a\,b`, `a\\\,b`},
+    }
+
+    for _, tt := range tests {
+        if got := escape(tt.in); got != tt.want {
+            t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestLineFolding(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+        want  []string // physical lines, without CRLF
+    }{
+        {
+            name:  "short",
+            value: "Booking",
+            want:  []string{"SUMMARY:Booking"},
+        },
+        {
+            name:  "exactly 75 octets",
+            value: strings.Repeat("a", 67),
+            want:  []string{"SUMMARY:" + strings.Repeat("a", 67)},
+        },
+        {
+            name:  "76 octets",
+            value: strings.Repeat("a", 68),
+            want:  []string{"SUMMARY:" + strings.Repeat("a", 67), " a"},
+        },
+        {
+            // Continuation lines hold 74 octets after the leading space
+            name:  "three lines",
+            value: strings.Repeat("a", 67+74+1),
+            want: []string{
+                "SUMMARY:" + strings.Repeat("a", 67),
+                " " + strings.Repeat("a", 74),
+                " a",
+            },
+        },
+        {
+            // "é" is two octets; the one at octets 75-76 moves to the next line
+            name:  "multi-byte character at the limit",
+            value: strings.Repeat("a", 66) + "éb",
+            want:  []string{"SUMMARY:" + strings.Repeat("a", 66), " éb"},
+        },
+    }
+
+    for _, tt := range tests {
+        var out bytes.Buffer
+        w := &writer{buf: bufio.NewWriter(&out)}
+        w.line("SUMMARY", tt.value)
+        if err := w.buf.Flush(); err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+
+        got := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+        if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+            t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+        }
+        for _, line := range got {
+            if len(line) > maxLineOctets {
+                t.Errorf("%s: line of %d octets: %q", tt.name, len(line), line)
+            }
+        }
+        // Unfolding gives back the original content line
+        unfolded := strings.ReplaceAll(strings.TrimSuffix(out.String(), "\r\n"), "\r\n ", "")
+        if unfolded != "SUMMARY:"+tt.value {
+            t.Errorf("%s: unfolds to %q", tt.name, unfolded)
+        }
+    }
+}
+
+func TestDuration(t *testing.T) {
+    tests := []struct {
+        in   time.Duration
+        want string
+    }{
+        {15 * time.Minute, "PT15M"},
+        {time.Hour, "PT1H"},
+        {90 * time.Minute, "PT1H30M"},
+        {24 * time.Hour, "PT24H"},
+        {30 * time.Second, "PT0M"},
+    }
+
+    for _, tt := range tests {
+        if got := duration(tt.in); got != tt.want {
+            t.Errorf("duration(%v) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestCancelledEventHasNoReminders(t *testing.T) {
+    at := time.Date(2026, 10, 21, 9, 0, 0, 0, time.UTC)
+    cal := &Calendar{
+        ProdID: "-//test//EN",
+        Events: []Event{
+            {
+                UID:       "booking-1",
+                Start:     at,
+                End:       at.Add(2 * time.Hour),
+                Summary:   "Booking",
+                Status:    "CONFIRMED",
+                Reminders: []Reminder{{Before: time.Hour, Description: "Pick-up"}},
+            },
+            {
+                UID:       "booking-2",
+                Start:     at,
+                End:       at.Add(2 * time.Hour),
+                Summary:   "Booking",
+                Status:    "CANCELLED",
+                Reminders: []Reminder{{Before: time.Hour, Description: "Pick-up"}},
+            },
+        },
+    }
+
+    var out bytes.Buffer
+    if err := cal.Write(&out, at); err != nil {
+        t.Fatal(err)
+    }
+    if n := strings.Count(out.String(), "BEGIN:VALARM\r\n"); n != 1 {
+        t.Errorf("got %d alarms, want 1", n)
+    }
+    if !strings.Contains(out.String(), "TRIGGER:-PT1H\r\n") {
+        t.Errorf("missing reminder trigger in\n%s", out.String())
+    }
+}
@@ -1,139 +1,271 @@
-// Path: services/vehicle-service/main.go
-package main
-
-import (
-    "database/sql"
-    "log"
-    "net/http"
-    "path/filepath"
-    "time"
-    "fmt"
-
-    _ "github.com/lib/pq"
-    gorillaCORS "github.com/gorilla/handlers"
-    "github.com/gorilla/mux"
-    
-    "vehicle-service/handlers"
-    "vehicle-service/middleware"
-    "vehicle-service/repository"
-)
-
-func setupDB() (*sql.DB, error) {
-    connStr := "postgres://postgres.wjdhhzmaclmsvaiszagk:22KC6282t04@@aws-0-ap-southeast-1.pooler.supabase.com:6543/postgres?sslmode=require"
-    
-    log.Printf("Attempting to connect to database with connection string: %v", connStr)
-    
-    db, err := sql.Open("postgres", connStr)
-    if err != nil {
-        return nil, fmt.Errorf("error opening database: %v", err)
-    }
-    
-    if err = db.Ping(); err != nil {
-        return nil, fmt.Errorf("error pinging database: %v", err)
-    }
-    
-    // Configure connection pool
-    db.SetMaxOpenConns(25)
-    db.SetMaxIdleConns(25)
-    db.SetConnMaxLifetime(5 * time.Minute)
-    
-    log.Printf("Successfully connected to database")
-    return db, nil
-}
-
-func checkDatabaseConnection(db *sql.DB) {
-    log.Printf("Testing database connection...")
-
-    // Test vehicles table
-    var vehicleCount int
-    err := db.QueryRow("SELECT COUNT(*) FROM vehicles").Scan(&vehicleCount)
-    if err != nil {
-        log.Printf("ERROR: Failed to query vehicles table: %v", err)
-    } else {
-        log.Printf("SUCCESS: Found %d vehicles in the database", vehicleCount)
-    }
-
-    // Test bookings table
-    var bookingCount int
-    err = db.QueryRow("SELECT COUNT(*) FROM bookings").Scan(&bookingCount)
-    if err != nil {
-        log.Printf("ERROR: Failed to query bookings table: %v", err)
-    } else {
-        log.Printf("SUCCESS: Found %d bookings in the database", bookingCount)
-    }
-
-    // Test vehicle_status_history table
-    var historyCount int
-    err = db.QueryRow("SELECT COUNT(*) FROM vehicle_status_history").Scan(&historyCount)
-    if err != nil {
-        log.Printf("ERROR: Failed to query vehicle_status_history table: %v", err)
-    } else {
-        log.Printf("SUCCESS: Found %d status history records in the database", historyCount)
-    }
-}
-
-func setupCORS(handler http.Handler) http.Handler {
-    return gorillaCORS.CORS(
-        gorillaCORS.AllowedOrigins([]string{"*"}),
-        gorillaCORS.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-        gorillaCORS.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-        gorillaCORS.MaxAge(86400),
-    )(handler)
-}
-
-func main() {
-    // Setup logging
-    log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-
-    // Setup database connection
-    db, err := setupDB()
-    if err != nil {
-        log.Fatal("Failed to connect to database:", err)
-    }
-    defer db.Close()
-
-    // Test database connection
-    checkDatabaseConnection(db)
-
-    // Initialize repository and handler
-    vehicleRepo := repository.NewVehicleRepository(db)
-    vehicleHandler := handlers.NewVehicleHandler(vehicleRepo)
-
-    // Setup routes
-    router := mux.NewRouter()
-    
-    // API routes
-    api := router.PathPrefix("/api").Subrouter()
-    
-    // Vehicle routes
-    api.HandleFunc("/vehicles/available", middleware.AuthMiddleware(vehicleHandler.GetAvailableVehicles)).Methods("GET", "OPTIONS")
-    
-    // Booking routes
-    api.HandleFunc("/bookings", middleware.AuthMiddleware(vehicleHandler.CreateBooking)).Methods("POST", "OPTIONS")
-    api.HandleFunc("/bookings/{id}", middleware.AuthMiddleware(vehicleHandler.UpdateBooking)).Methods("PUT", "OPTIONS")
-    api.HandleFunc("/bookings/{id}", middleware.AuthMiddleware(vehicleHandler.CancelBooking)).Methods("DELETE", "OPTIONS")
-    api.HandleFunc("/bookings/my", middleware.AuthMiddleware(vehicleHandler.GetUserBookings)).Methods("GET", "OPTIONS")
-    
-    // Vehicle status update route
-    api.HandleFunc("/vehicles/{id}/status", middleware.AuthMiddleware(vehicleHandler.UpdateVehicleStatus)).Methods("PUT", "OPTIONS")
-    
-
-    router.PathPrefix("/").Handler(http.FileServer(http.Dir("frontend")))
-    corsHandler := setupCORS(router)
-
-    // Create server
-    server := &http.Server{
-        Addr:         ":8085",
-        Handler:      corsHandler,
-        ReadTimeout:  15 * time.Second,
-        WriteTimeout: 15 * time.Second,
-        IdleTimeout:  60 * time.Second,
-    }
-
-    // Start server
-    log.Printf("Vehicle Service starting on port 8085\n")
-    log.Printf("Serving frontend from: %s\n", filepath.Join(".", "frontend"))
-    if err := server.ListenAndServe(); err != nil {
-        log.Fatal("Server failed to start:", err)
-    }
+// Path: services/vehicle-service/main.go
+package main
+
+import (
+    "context"
+    "database/sql"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "time"
+    "fmt"
+
+    _ "github.com/lib/pq"
+    gorillaCORS "github.com/gorilla/handlers"
+    "github.com/gorilla/mux"
+    
+    "vehicle-service/billingclient"
+    "vehicle-service/events"
+    "vehicle-service/handlers"
+    "vehicle-service/middleware"
+    "vehicle-service/repository"
+    "vehicle-service/serviceauth"
+    "vehicle-service/storage"
+    "vehicle-service/userclient"
+)
+
+// internalPolicy lists which services may call each internal route
+var internalPolicy = serviceauth.Policy{
+    "GET /internal/users/{id}/bookings": {"user-service"},
+}
+
+func setupDB() (*sql.DB, error) {
+    connStr := "postgres://postgres.wjdhhzmaclmsvaiszagk:22KC6282t04@@aws-0-ap-southeast-1.pooler.supabase.com:6543/postgres?sslmode=require"
+    
+    log.Printf("Attempting to connect to database with connection string: %v", connStr)
+    
+    db, err := sql.Open("postgres", connStr)
+    if err != nil {
+        return nil, fmt.Errorf("error opening database: %v", err)
+    }
+    
+    if err = db.Ping(); err != nil {
+        return nil, fmt.Errorf("error pinging database: %v", err)
+    }
+    
+    // Configure connection pool
+    db.SetMaxOpenConns(25)
+    db.SetMaxIdleConns(25)
+    db.SetConnMaxLifetime(5 * time.Minute)
+    
+    log.Printf("Successfully connected to database")
+    return db, nil
+}
+
+func checkDatabaseConnection(db *sql.DB) {
+    log.Printf("Testing database connection...")
+
+    // Test vehicles table
+    var vehicleCount int
+    err := db.QueryRow("SELECT COUNT(*) FROM vehicles").Scan(&vehicleCount)
+    if err != nil {
+        log.Printf("ERROR: Failed to query vehicles table: %v", err)
+    } else {
+        log.Printf("SUCCESS: Found %d vehicles in the database", vehicleCount)
+    }
+
+    // Test bookings table
+    var bookingCount int
+    err = db.QueryRow("SELECT COUNT(*) FROM bookings").Scan(&bookingCount)
+    if err != nil {
+        log.Printf("ERROR: Failed to query bookings table: %v", err)
+    } else {
+        log.Printf("SUCCESS: Found %d bookings in the database", bookingCount)
+    }
+
+    // Test vehicle_status_history table
+    var historyCount int
+    err = db.QueryRow("SELECT COUNT(*) FROM vehicle_status_history").Scan(&historyCount)
+    if err != nil {
+        log.Printf("ERROR: Failed to query vehicle_status_history table: %v", err)
+    } else {
+        log.Printf("SUCCESS: Found %d status history records in the database", historyCount)
+    }
+}
+
+// getEnv returns the environment variable or a fallback when it is unset
+func getEnv(key, fallback string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return fallback
+}
+
+func setupCORS(handler http.Handler) http.Handler {
+    return gorillaCORS.CORS(
+        gorillaCORS.AllowedOrigins([]string{"*"}),
+        gorillaCORS.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+        gorillaCORS.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+        gorillaCORS.MaxAge(86400),
+    )(handler)
+}
+
+func main() {
+    // Setup logging
+    log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+
+    // Setup database connection
+    db, err := setupDB()
+    if err != nil {
+        log.Fatal("Failed to connect to database:", err)
+    }
+    defer db.Close()
+
+    // Test database connection
+    checkDatabaseConnection(db)
+
+    // Signing key for calls to other services and public keys to check theirs
+    identity, err := serviceauth.Load(getEnv("SERVICE_KEYS_DIR", "../../keys"), "vehicle-service")
+    if err != nil {
+        log.Fatal("Failed to load service keys (run `go run ./cmd/servicekeys` from the repo root):", err)
+    }
+
+    // Identity and account state come from user-service's internal API
+    users := userclient.New(getEnv("USER_SERVICE_URL", "http://localhost:8080"), identity)
+    requireAuth := middleware.AuthMiddleware(users)
+
+    // Booking changes are re-priced by billing-service
+    billing := billingclient.New(getEnv("BILLING_SERVICE_URL", "http://localhost:8083"), identity)
+
+    // Damage report and cleaning photos
+    store, err := storage.NewLocalStore(getEnv("BLOB_STORE_DIR", "uploads"))
+    if err != nil {
+        log.Fatal("Failed to initialise blob store:", err)
+    }
+
+    // Initialize repository and handler
+    vehicleRepo := repository.NewVehicleRepository(db)
+    vehicleHandler := handlers.NewVehicleHandler(vehicleRepo, users, billing, events.NewHub(), store)
+
+    // No-shows, overdue trips and late returns are checked in the background
+    go vehicleHandler.MonitorBookings(context.Background())
+
+    // Setup routes
+    router := mux.NewRouter()
+    
+    // API routes
+    api := router.PathPrefix("/api").Subrouter()
+    
+    // Vehicle routes
+    api.HandleFunc("/vehicles/available", requireAuth(vehicleHandler.GetAvailableVehicles)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/vehicles/nearby", requireAuth(vehicleHandler.GetNearbyVehicles)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/vehicles/stream", middleware.TokenFromQuery(requireAuth(vehicleHandler.StreamVehicles))).Methods("GET", "OPTIONS")
+    
+    // Booking routes
+    api.HandleFunc("/bookings", requireAuth(vehicleHandler.CreateBooking)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/bookings/{id}", requireAuth(vehicleHandler.UpdateBooking)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/bookings/{id}", requireAuth(vehicleHandler.CancelBooking)).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/bookings/{id}/cancellation", requireAuth(vehicleHandler.GetCancellationQuote)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/bookings/my", requireAuth(vehicleHandler.GetUserBookings)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/bookings/my.ics", requireAuth(vehicleHandler.ExportCalendar)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/bookings/{id}/extend", requireAuth(vehicleHandler.GetExtensionOffer)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/bookings/{id}/extend", requireAuth(vehicleHandler.ExtendBooking)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/bookings/{id}/amendments", requireAuth(vehicleHandler.GetBookingAmendments)).Methods("GET", "OPTIONS")
+
+    // Calendar subscriptions; the feed itself is authorised by its secret token
+    api.HandleFunc("/calendar/feed", requireAuth(vehicleHandler.CreateCalendarFeed)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/calendar/feed", requireAuth(vehicleHandler.DeleteCalendarFeed)).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/calendar/{token:[0-9a-f]+}.ics", vehicleHandler.CalendarFeed).Methods("GET")
+
+    // Recurring bookings; single occurrences are changed through the booking routes
+    api.HandleFunc("/booking-series", requireAuth(vehicleHandler.CreateBookingSeries)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/booking-series/{id}", requireAuth(vehicleHandler.GetBookingSeries)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/booking-series/{id}", requireAuth(vehicleHandler.UpdateBookingSeries)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/booking-series/{id}", requireAuth(vehicleHandler.CancelBookingSeries)).Methods("DELETE", "OPTIONS")
+
+    // Waitlist for fully booked time slots
+    api.HandleFunc("/waitlist", requireAuth(vehicleHandler.JoinWaitlist)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/waitlist", requireAuth(vehicleHandler.GetWaitlist)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/waitlist/{id}", requireAuth(vehicleHandler.LeaveWaitlist)).Methods("DELETE", "OPTIONS")
+
+    requireOperator := func(next http.HandlerFunc) http.HandlerFunc {
+        return requireAuth(middleware.RequireOperator(next))
+    }
+
+    // Vehicle status updates can end charging and open or close cleaning tasks, so are operator-only
+    api.HandleFunc("/vehicles/{id}/status", requireOperator(vehicleHandler.UpdateVehicleStatus)).Methods("PUT", "OPTIONS")
+
+    // Stations; changes are operator-only
+    api.HandleFunc("/stations", requireAuth(vehicleHandler.GetStations)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/stations", requireOperator(vehicleHandler.CreateStation)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/stations/{id}", requireAuth(vehicleHandler.GetStation)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/stations/{id}", requireOperator(vehicleHandler.UpdateStation)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/stations/{id}", requireOperator(vehicleHandler.DeleteStation)).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/station", requireOperator(vehicleHandler.AssignVehicleStation)).Methods("PUT", "OPTIONS")
+
+    // Fleet administration; decommissioning keeps the vehicle for booking history
+    api.HandleFunc("/vehicles", requireOperator(vehicleHandler.GetFleet)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/vehicles", requireOperator(vehicleHandler.CreateVehicle)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/import", requireOperator(vehicleHandler.ImportVehicles)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}", requireOperator(vehicleHandler.UpdateVehicle)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}", requireOperator(vehicleHandler.DecommissionVehicle)).Methods("DELETE", "OPTIONS")
+
+    // Booking rules per membership tier
+    api.HandleFunc("/booking-policies", requireAuth(vehicleHandler.GetBookingPolicies)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/booking-policies/{tier}", requireOperator(vehicleHandler.SaveBookingPolicy)).Methods("PUT", "OPTIONS")
+
+    // Charging sessions, reported by operators or chargers
+    api.HandleFunc("/vehicles/{id}/charging", requireOperator(vehicleHandler.StartCharging)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/charging", requireOperator(vehicleHandler.UpdateCharging)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/charging/stop", requireOperator(vehicleHandler.StopCharging)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/charging-sessions", requireOperator(vehicleHandler.GetChargingSessions)).Methods("GET", "OPTIONS")
+
+    // Telemetry: devices are registered by operators and post readings with their own key
+    requireDevice := middleware.DeviceAuth(vehicleRepo)
+    api.HandleFunc("/telemetry", requireDevice(vehicleHandler.IngestTelemetry)).Methods("POST")
+    api.HandleFunc("/vehicles/{id}/devices", requireOperator(vehicleHandler.RegisterDevice)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/history", requireOperator(vehicleHandler.GetVehicleHistory)).Methods("GET", "OPTIONS")
+
+    // Remote lock/unlock: drivers queue commands, the car's device polls and acknowledges them
+    api.HandleFunc("/bookings/{id}/unlock", requireAuth(vehicleHandler.UnlockBooking)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/bookings/{id}/lock", requireAuth(vehicleHandler.LockBooking)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/commands/{id}", requireAuth(vehicleHandler.GetCommand)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/device/commands", requireDevice(vehicleHandler.GetDeviceCommands)).Methods("GET")
+    api.HandleFunc("/device/commands/{id}/ack", requireDevice(vehicleHandler.AcknowledgeCommand)).Methods("POST")
+
+    // Maintenance tickets take vehicles out of service; drivers report damage at trip start and end
+    api.HandleFunc("/maintenance", requireOperator(vehicleHandler.GetMaintenanceTickets)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/maintenance/{id}", requireOperator(vehicleHandler.UpdateMaintenanceTicket)).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/maintenance/{id}/resolve", requireOperator(vehicleHandler.ResolveMaintenanceTicket)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/maintenance", requireOperator(vehicleHandler.OpenMaintenanceTicket)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/maintenance", requireOperator(vehicleHandler.GetVehicleMaintenance)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/bookings/{id}/damage-reports", requireAuth(vehicleHandler.CreateDamageReport)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/vehicles/{id}/damage-reports", requireOperator(vehicleHandler.GetDamageReports)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/damage-reports/{id}/photos/{photoId}", requireAuth(vehicleHandler.GetDamagePhoto)).Methods("GET", "OPTIONS")
+
+    // Cleaning crew work queue; drivers rate cleanliness at the end of a trip
+    api.HandleFunc("/cleaning/tasks", requireOperator(vehicleHandler.GetCleaningQueue)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/cleaning/tasks/{id}/claim", requireOperator(vehicleHandler.ClaimCleaningTask)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/cleaning/tasks/{id}/complete", requireOperator(vehicleHandler.CompleteCleaningTask)).Methods("POST", "OPTIONS")
+    api.HandleFunc("/cleaning/tasks/{id}/photos/{photoId}", requireOperator(vehicleHandler.GetCleaningPhoto)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/bookings/{id}/rating", requireAuth(vehicleHandler.RateCleanliness)).Methods("POST", "OPTIONS")
+
+    // Per-model battery figures used for range estimates
+    api.HandleFunc("/vehicle-specs", requireAuth(vehicleHandler.GetVehicleSpecs)).Methods("GET", "OPTIONS")
+    api.HandleFunc("/vehicle-specs", requireOperator(vehicleHandler.SaveVehicleSpec)).Methods("PUT", "OPTIONS")
+
+    // Internal routes for other services, never called by browsers
+    serviceAuth := serviceauth.Middleware(identity, internalPolicy)
+    internal := router.PathPrefix("/internal").Subrouter()
+    internal.HandleFunc("/users/{id}/bookings", serviceAuth(vehicleHandler.GetBookingsForUser)).Methods("GET")
+
+    router.PathPrefix("/").Handler(http.FileServer(http.Dir("frontend")))
+    corsHandler := setupCORS(router)
+
+    // Create server
+    server := &http.Server{
+        Addr:         ":8085",
+        Handler:      corsHandler,
+        ReadTimeout:  15 * time.Second,
+        WriteTimeout: 15 * time.Second,
+        IdleTimeout:  60 * time.Second,
+    }
+
+    // Start server
+    log.Printf("Vehicle Service starting on port 8085\n")
+    log.Printf("Serving frontend from: %s\n", filepath.Join(".", "frontend"))
+    if err := server.ListenAndServe(); err != nil {
+        log.Fatal("Server failed to start:", err)
+    }
 }
\ No newline at end of file
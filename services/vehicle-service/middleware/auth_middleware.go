@@ -1,44 +1,81 @@
-package middleware
-
-import (
-    "context"
-    "net/http"
-    "strings"
-)
-
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        // Handle CORS preflight
-        w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-        
-        if r.Method == "OPTIONS" {
-            w.WriteHeader(http.StatusOK)
-            return
-        }
-
-        authHeader := r.Header.Get("Authorization")
-        if authHeader == "" {
-            http.Error(w, "Authorization header required", http.StatusUnauthorized)
-            return
-        }
-
-        bearerToken := strings.Split(authHeader, " ")
-        if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
-            http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
-            return
-        }
-
-        userID := 1
-
-        // Add user ID to request context
-        ctx := context.WithValue(r.Context(), "user_id", userID)
-        next.ServeHTTP(w, r.WithContext(ctx))
-    }
-}
-
-// Placeholder function 
-func extractUserIDFromToken(token string) int {
-    return 1 
-}
\ No newline at end of file
+package middleware
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "strings"
+
+    "vehicle-service/userclient"
+)
+
+// AuthMiddleware checks the bearer token with user-service and stores the
+// caller's user ID and account details in the request context
+func AuthMiddleware(users *userclient.Client) func(http.HandlerFunc) http.HandlerFunc {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+            // Handle CORS preflight
+            w.Header().Set("Access-Control-Allow-Origin", "*")
+            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+            
+            if r.Method == "OPTIONS" {
+                w.WriteHeader(http.StatusOK)
+                return
+            }
+
+            authHeader := r.Header.Get("Authorization")
+            if authHeader == "" {
+                http.Error(w, "Authorization header required", http.StatusUnauthorized)
+                return
+            }
+
+            bearerToken := strings.Split(authHeader, " ")
+            if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
+                http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+                return
+            }
+
+            introspection, err := users.Introspect(r.Context(), bearerToken[1])
+            if err != nil {
+                log.Printf("Token introspection failed: %v", err)
+                http.Error(w, "Unable to verify token", http.StatusServiceUnavailable)
+                return
+            }
+
+            if !introspection.Active {
+                http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+                return
+            }
+
+            // Add user ID and account details to request context
+            ctx := context.WithValue(r.Context(), "user_id", introspection.ID)
+            ctx = context.WithValue(ctx, "user", &introspection.User)
+            next.ServeHTTP(w, r.WithContext(ctx))
+        }
+    }
+}
+
+// RequireOperator allows only operator accounts; use inside AuthMiddleware
+func RequireOperator(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := r.Context().Value("user").(*userclient.User)
+        if !ok || user.Role != "operator" {
+            http.Error(w, "Operator access required", http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    }
+}
+
+// TokenFromQuery lets clients that can't set headers, such as the browser's
+// EventSource, pass their bearer token as ?access_token=. Use only on GET
+// routes wrapped by AuthMiddleware.
+func TokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+            r.Header.Set("Authorization", "Bearer "+token)
+        }
+        next.ServeHTTP(w, r)
+    }
+}
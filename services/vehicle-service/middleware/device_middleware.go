@@ -0,0 +1,43 @@
+package middleware
+
+import (
+    "context"
+    "log"
+    "net/http"
+)
+
+const (
+    DeviceIDHeader  = "X-Device-ID"
+    DeviceKeyHeader = "X-Device-Key"
+)
+
+// DeviceVerifier looks up a telemetry device and returns the vehicle it is fitted to
+type DeviceVerifier interface {
+    VerifyDevice(deviceID, key string) (int, error)
+}
+
+// DeviceAuth admits in-car devices by their ID and key headers and stores the
+// device ID and its vehicle ID in the request context
+func DeviceAuth(devices DeviceVerifier) func(http.HandlerFunc) http.HandlerFunc {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+            deviceID := r.Header.Get(DeviceIDHeader)
+            key := r.Header.Get(DeviceKeyHeader)
+            if deviceID == "" || key == "" {
+                http.Error(w, "Device credentials required", http.StatusUnauthorized)
+                return
+            }
+
+            vehicleID, err := devices.VerifyDevice(deviceID, key)
+            if err != nil {
+                log.Printf("Device %s rejected: %v", deviceID, err)
+                http.Error(w, "Invalid device credentials", http.StatusUnauthorized)
+                return
+            }
+
+            ctx := context.WithValue(r.Context(), "device_id", deviceID)
+            ctx = context.WithValue(ctx, "device_vehicle_id", vehicleID)
+            next.ServeHTTP(w, r.WithContext(ctx))
+        }
+    }
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// CalendarBooking is a booking with what its calendar event shows.
+// Revision goes up each time the booking is changed or cancelled.
+type CalendarBooking struct {
+    Booking
+    LicensePlate   string
+    PickupLocation *string // station name and address, or where the car was last reported
+    ReturnLocation *string // set for one-way trips
+    Revision       int
+}
+
+// CalendarFeed is a driver's secret calendar subscription. The token is only
+// returned when the feed is created.
+type CalendarFeed struct {
+    URL       string    `json:"url"`
+    WebcalURL string    `json:"webcal_url"`
+    CreatedAt time.Time `json:"created_at"`
+}
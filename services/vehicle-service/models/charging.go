@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ChargingSession is one plug-in of a vehicle at a charger. EndedAt and
+// EndLevel stay nil while the car is still charging.
+type ChargingSession struct {
+    ID              int        `json:"id"`
+    VehicleID       int        `json:"vehicle_id"`
+    ChargerID       string     `json:"charger_id"`
+    StationID       *int       `json:"station_id"`
+    PowerKW         float64    `json:"power_kw"`
+    StartLevel      int        `json:"start_level"`
+    EndLevel        *int       `json:"end_level"`
+    EnergyKWh       float64    `json:"energy_kwh"`
+    ExpectedReadyAt *time.Time `json:"expected_ready_at"` // nil when the model has no battery spec
+    StartedAt       time.Time  `json:"started_at"`
+    EndedAt         *time.Time `json:"ended_at"`
+}
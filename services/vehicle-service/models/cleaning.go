@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// CleaningTask is a job on the cleaning crew's queue. A vehicle has at most
+// one unfinished task; completing it marks the vehicle clean.
+type CleaningTask struct {
+    ID           int             `json:"id"`
+    VehicleID    int             `json:"vehicle_id"`
+    LicensePlate string          `json:"license_plate"`
+    Location     *string         `json:"location"`
+    StationID    *int            `json:"station_id"`
+    Reason       string          `json:"reason"` // flagged, rating, trip_count
+    Status       string          `json:"status"` // open, in_progress, completed
+    AssignedTo   *int            `json:"assigned_to"`
+    BookingID    *int            `json:"booking_id"` // the rated booking, for rating tasks
+    Notes        *string         `json:"notes"`
+    CreatedAt    time.Time       `json:"created_at"`
+    StartedAt    *time.Time      `json:"started_at"`
+    CompletedAt  *time.Time      `json:"completed_at"`
+    Photos       []CleaningPhoto `json:"photos,omitempty"`
+}
+
+type CleaningPhoto struct {
+    ID          int    `json:"id"`
+    TaskID      int    `json:"task_id"`
+    StorageKey  string `json:"-"`
+    ContentType string `json:"content_type"`
+    Size        int64  `json:"size"`
+}
+
+// CleanlinessRating is a driver's 1-5 score for how clean the car was
+type CleanlinessRating struct {
+    ID        int       `json:"id"`
+    BookingID int       `json:"booking_id"`
+    VehicleID int       `json:"vehicle_id"`
+    UserID    int       `json:"user_id"`
+    Rating    int       `json:"rating"`
+    Comment   *string   `json:"comment"`
+    CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// VehicleCommand is a remote lock or unlock queued for a vehicle's device.
+// Status moves pending → sent → acknowledged or failed, or to expired when the
+// device doesn't answer before ExpiresAt.
+type VehicleCommand struct {
+    ID             int        `json:"id"`
+    VehicleID      int        `json:"vehicle_id"`
+    BookingID      *int       `json:"booking_id"`
+    UserID         *int       `json:"user_id"`
+    Command        string     `json:"command"`
+    Status         string     `json:"status"`
+    Error          *string    `json:"error,omitempty"`
+    CreatedAt      time.Time  `json:"created_at"`
+    SentAt         *time.Time `json:"sent_at"`
+    AcknowledgedAt *time.Time `json:"acknowledged_at"`
+    ExpiresAt      time.Time  `json:"expires_at"`
+}
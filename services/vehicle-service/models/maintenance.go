@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// MaintenanceTicket records why a vehicle is out of service and until when.
+// While any ticket for a vehicle is open the vehicle can't be booked.
+type MaintenanceTicket struct {
+    ID                 int        `json:"id"`
+    VehicleID          int        `json:"vehicle_id"`
+    Reason             string     `json:"reason"`
+    Severity           string     `json:"severity"` // low, medium, high, critical
+    Status             string     `json:"status"`   // open, in_progress, resolved
+    ReportedBy         int        `json:"reported_by"`
+    DamageReportID     *int       `json:"damage_report_id"`
+    AssignedTechnician *string    `json:"assigned_technician"`
+    ExpectedReturnAt   *time.Time `json:"expected_return_at"`
+    ResolutionNotes    *string    `json:"resolution_notes"`
+    CreatedAt          time.Time  `json:"created_at"`
+    ResolvedAt         *time.Time `json:"resolved_at"`
+}
+
+// BookingDisruption is what happened to a future booking when its vehicle was
+// taken out of service: moved to another vehicle or cancelled
+type BookingDisruption struct {
+    BookingID    int    `json:"booking_id"`
+    UserID       int    `json:"user_id"`
+    Outcome      string `json:"outcome"` // reallocated, cancelled
+    NewVehicleID *int   `json:"new_vehicle_id,omitempty"`
+}
+
+// DamageReport is a driver's record of the car's condition at the start or
+// end of a trip
+type DamageReport struct {
+    ID          int           `json:"id"`
+    BookingID   int           `json:"booking_id"`
+    VehicleID   int           `json:"vehicle_id"`
+    UserID      int           `json:"user_id"`
+    Stage       string        `json:"stage"` // start, end
+    Description string        `json:"description"`
+    Drivable    bool          `json:"drivable"`
+    Photos      []DamagePhoto `json:"photos"`
+    CreatedAt   time.Time     `json:"created_at"`
+}
+
+type DamagePhoto struct {
+    ID          int    `json:"id"`
+    ReportID    int    `json:"report_id"`
+    StorageKey  string `json:"-"`
+    ContentType string `json:"content_type"`
+    Size        int64  `json:"size"`
+}
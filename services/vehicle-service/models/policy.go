@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// BookingPolicy is what drivers on a membership tier may book: how long a
+// booking can last, how far ahead it can start, how many upcoming bookings
+// they can hold at once and which vehicle classes they can drive.
+type BookingPolicy struct {
+    MembershipTier    string    `json:"membership_tier"`
+    MaxDurationHours  float64   `json:"max_duration_hours"`
+    MaxAdvanceDays    int       `json:"max_advance_days"`
+    MaxActiveBookings int       `json:"max_active_bookings"`
+    VehicleClasses    []string  `json:"vehicle_classes"`
+    UpdatedAt         time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// BookingSeries is a recurring booking: one booking of the same car per
+// occurrence of Recurrence, an RRULE such as "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=20".
+// Occurrences can be changed or cancelled on their own like any booking.
+type BookingSeries struct {
+    ID              int       `json:"id"`
+    UserID          int       `json:"user_id"`
+    VehicleID       int       `json:"vehicle_id"`
+    Recurrence      string    `json:"recurrence"`
+    StartTime       time.Time `json:"start_time"` // first occurrence
+    EndTime         time.Time `json:"end_time"`
+    ReturnStationID *int      `json:"return_station_id"`
+    Status          string    `json:"status"` // active, cancelled
+    CreatedAt       time.Time `json:"created_at"`
+    Bookings        []Booking `json:"bookings"`
+}
+
+// SeriesConflict is an occurrence of a recurring booking that can't be made
+type SeriesConflict struct {
+    StartTime time.Time `json:"start_time"`
+    EndTime   time.Time `json:"end_time"`
+    Reason    string    `json:"reason"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Station is a parking hub vehicles are based at. OpensAt/ClosesAt are "HH:MM"
+// local time; both nil means the station is open around the clock.
+type Station struct {
+    ID           int       `json:"id"`
+    Name         string    `json:"name"`
+    Address      string    `json:"address"`
+    Latitude     float64   `json:"latitude"`
+    Longitude    float64   `json:"longitude"`
+    Capacity     int       `json:"capacity"`
+    ChargerCount int       `json:"charger_count"`
+    OpensAt      *string   `json:"opens_at"`
+    ClosesAt     *string   `json:"closes_at"`
+    VehicleCount int       `json:"vehicle_count"` // vehicles based here
+    CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// TelemetryReading is one sample sent by an in-car device. Fields the device
+// doesn't report are left out.
+type TelemetryReading struct {
+    RecordedAt   time.Time `json:"recorded_at"`
+    Latitude     *float64  `json:"latitude"`
+    Longitude    *float64  `json:"longitude"`
+    BatteryLevel *int      `json:"battery_level"`
+    OdometerKm   *float64  `json:"odometer_km"`
+    SpeedKmh     *float64  `json:"speed_kmh"`
+    DoorsLocked  *bool     `json:"doors_locked"`
+}
+
+// HistoryPoint summarises the readings in one time bucket. Levels and speeds
+// are averages; position, odometer and lock state are the last reported values.
+type HistoryPoint struct {
+    Time         time.Time `json:"time"`
+    Samples      int       `json:"samples"`
+    Latitude     *float64  `json:"latitude"`
+    Longitude    *float64  `json:"longitude"`
+    BatteryLevel *float64  `json:"battery_level"`
+    SpeedKmh     *float64  `json:"speed_kmh"`
+    MaxSpeedKmh  *float64  `json:"max_speed_kmh"`
+    OdometerKm   *float64  `json:"odometer_km"`
+    DoorsLocked  *bool     `json:"doors_locked"`
+}
+
+// VehicleDevice is a telemetry unit installed in a vehicle. The key is only
+// returned when the device is registered.
+type VehicleDevice struct {
+    DeviceID   string     `json:"device_id"`
+    VehicleID  int        `json:"vehicle_id"`
+    DeviceKey  string     `json:"device_key,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+    LastSeenAt *time.Time `json:"last_seen_at"`
+}
@@ -1,28 +1,97 @@
-package models
-
-import "time"
-
-type Vehicle struct {
-    ID               int       `json:"id"`
-    Model           string    `json:"model"`
-    Type            string    `json:"type"`
-    LicensePlate    string    `json:"license_plate"`
-    Status          string    `json:"status"`
-    Location        *string   `json:"location"`
-    BatteryLevel    *int      `json:"battery_level"`
-    CleanlinessStatus *string `json:"cleanliness_status"`
-    CreatedAt       time.Time `json:"created_at"`
-    LastStatusUpdate time.Time `json:"last_status_update"`
-}
-
-type Booking struct {
-    ID          int       `json:"id"`
-    UserID      int       `json:"user_id"`
-    VehicleID   int       `json:"vehicle_id"`
-    VehicleModel string   `json:"vehicle_model,omitempty"` // Added for frontend display
-    StartTime   time.Time `json:"start_time"`
-    EndTime     time.Time `json:"end_time"`
-    Status      string    `json:"status"`
-    CreatedAt   time.Time `json:"created_at"`
-    UpdatedAt   time.Time `json:"updated_at"`
-}
+package models
+
+import (
+    "time"
+
+    "vehicle-service/battery"
+    "vehicle-service/geo"
+)
+
+type Vehicle struct {
+    ID               int       `json:"id"`
+    Model           string    `json:"model"`
+    Type            string    `json:"type"`
+    VehicleClass    string    `json:"vehicle_class"` // standard, premium or luxury; see BookingPolicy
+    LicensePlate    string    `json:"license_plate"`
+    Status          string    `json:"status"`
+    Location        *string   `json:"location"`
+    Latitude        *float64  `json:"latitude"`
+    Longitude       *float64  `json:"longitude"`
+    DistanceKm      *float64  `json:"distance_km,omitempty"` // set by location searches
+    HomeStationID   *int      `json:"home_station_id"`
+    BatteryLevel    *int      `json:"battery_level"`
+    EstimatedRangeKm *float64 `json:"estimated_range_km"` // nil when the model has no battery spec
+    Spec            *battery.Spec `json:"-"`
+    AvailableFrom   *time.Time `json:"available_from,omitempty"` // projected end of the current charging session
+    CleanlinessStatus *string `json:"cleanliness_status"`
+    OdometerKm      *float64  `json:"odometer_km"`
+    DoorsLocked     *bool     `json:"doors_locked"`
+    HourlyRate      float64   `json:"hourly_rate"`
+    DecommissionedAt *time.Time `json:"decommissioned_at,omitempty"` // set once retired from the fleet
+    CreatedAt       time.Time `json:"created_at"`
+    LastStatusUpdate time.Time `json:"last_status_update"`
+}
+
+type Booking struct {
+    ID          int       `json:"id"`
+    UserID      int       `json:"user_id"`
+    VehicleID   int       `json:"vehicle_id"`
+    VehicleModel string   `json:"vehicle_model,omitempty"` // Added for frontend display
+    StartTime   time.Time `json:"start_time"`
+    EndTime     time.Time `json:"end_time"`
+    PickupStationID *int  `json:"pickup_station_id"`
+    ReturnStationID *int  `json:"return_station_id"`
+    DropoffFee  float64   `json:"dropoff_fee"` // one-way trips only
+    PlannedDistanceKm *float64 `json:"planned_distance_km"`
+    Status      string    `json:"status"`
+    CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+    CancellationFee *float64 `json:"cancellation_fee,omitempty"` // charged by billing-service, also for no-shows
+    OverdueAt   *time.Time `json:"overdue_at,omitempty"`
+    ReturnedAt  *time.Time `json:"returned_at,omitempty"` // only recorded for overdue trips
+    LateFee     *float64  `json:"late_fee,omitempty"`
+    SeriesID    *int      `json:"series_id,omitempty"` // set on occurrences of a recurring booking
+    CreatedAt   time.Time `json:"created_at"`
+    UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// VehicleSearch narrows availability to a time window and, optionally, a radius
+// around a point, a home station and vehicle attributes. Results are returned a
+// page at a time; Cursor continues from the previous page's NextCursor.
+type VehicleSearch struct {
+    Start      time.Time
+    End        time.Time
+    Near       *geo.Point
+    RadiusKm   float64
+    StationID  *int
+    Type       string
+    Model      string
+    MinBattery *int
+    MaxRate    *float64
+    Sort       string // price, -price, battery, -battery, distance; "" for default order
+    Limit      int
+    Cursor     string
+    UserID     int // cars held for this driver from the waitlist are included
+    Classes    []string // vehicle classes the driver may book; nil for any
+}
+
+type VehiclePage struct {
+    Vehicles   []Vehicle
+    Total      int
+    NextCursor string
+}
+
+// BookingAmendment records a change to a booking's times after it was made,
+// with billing-service's estimate before and after (nil when it couldn't be priced)
+type BookingAmendment struct {
+    ID                int       `json:"id"`
+    BookingID         int       `json:"booking_id"`
+    Kind              string    `json:"kind"` // extension, reschedule
+    PreviousStartTime time.Time `json:"previous_start_time"`
+    PreviousEndTime   time.Time `json:"previous_end_time"`
+    NewStartTime      time.Time `json:"new_start_time"`
+    NewEndTime        time.Time `json:"new_end_time"`
+    PreviousEstimate  *float64  `json:"previous_estimate"`
+    NewEstimate       *float64  `json:"new_estimate"`
+    AmendedBy         int       `json:"amended_by"`
+    CreatedAt         time.Time `json:"created_at"`
+}
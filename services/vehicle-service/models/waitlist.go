@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// WaitlistEntry is a driver's interest in a car for a time window, at a
+// station or within RadiusKm of a point, optionally of one vehicle type.
+// When a matching car frees up the oldest waiting entry is given a hold on it
+// until HoldExpiresAt, during which only that driver can book it.
+type WaitlistEntry struct {
+    ID            int        `json:"id"`
+    UserID        int        `json:"user_id"`
+    StartTime     time.Time  `json:"start_time"`
+    EndTime       time.Time  `json:"end_time"`
+    StationID     *int       `json:"station_id"`
+    Latitude      *float64   `json:"latitude"`
+    Longitude     *float64   `json:"longitude"`
+    RadiusKm      *float64   `json:"radius_km"`
+    VehicleType   *string    `json:"vehicle_type"`
+    Classes       []string   `json:"vehicle_classes"` // allowed by the driver's tier when they joined; nil for any
+    Status        string     `json:"status"` // waiting, held, booked, expired, cancelled
+    HeldVehicleID *int       `json:"held_vehicle_id"`
+    HoldExpiresAt *time.Time `json:"hold_expires_at"`
+    BookingID     *int       `json:"booking_id"`
+    CreatedAt     time.Time  `json:"created_at"`
+    Position      *int       `json:"position,omitempty"` // place in the queue while waiting
+}
@@ -0,0 +1,197 @@
+// Package recurrence reads the subset of iCalendar RRULEs used for recurring
+// bookings (daily or weekly, on set weekdays, until a date or for a count)
+// and lists the days they fall on.
+package recurrence
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var weekdays = map[string]time.Weekday{
+    "MO": time.Monday,
+    "TU": time.Tuesday,
+    "WE": time.Wednesday,
+    "TH": time.Thursday,
+    "FR": time.Friday,
+    "SA": time.Saturday,
+    "SU": time.Sunday,
+}
+
+var untilLayouts = []string{"20060102", "20060102T150405Z", "2006-01-02"}
+
+// The longest INTERVAL accepted for each FREQ: a year
+var maxInterval = map[string]int{
+    "DAILY":  365,
+    "WEEKLY": 52,
+}
+
+// Rule is a parsed RRULE. Exactly one of Count and Until is set.
+type Rule struct {
+    Freq     string // DAILY or WEEKLY
+    Interval int
+    ByDay    []time.Weekday // empty means every day for DAILY, the first day's weekday for WEEKLY
+    Count    int
+    Until    *time.Time // last date an occurrence can start on
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;UNTIL=20261231".
+// An "RRULE:" prefix is allowed.
+func Parse(s string) (*Rule, error) {
+    s = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(s)), "RRULE:")
+    if s == "" {
+        return nil, errors.New("recurrence is empty")
+    }
+
+    rule := &Rule{Interval: 1}
+    for _, part := range strings.Split(s, ";") {
+        kv := strings.SplitN(part, "=", 2)
+        if len(kv) != 2 || kv[1] == "" {
+            return nil, fmt.Errorf("invalid recurrence part %q", part)
+        }
+        key, value := kv[0], kv[1]
+
+        switch key {
+        case "FREQ":
+            if value != "DAILY" && value != "WEEKLY" {
+                return nil, errors.New("FREQ must be DAILY or WEEKLY")
+            }
+            rule.Freq = value
+        case "INTERVAL":
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 {
+                return nil, errors.New("INTERVAL must be a positive number")
+            }
+            rule.Interval = n
+        case "BYDAY":
+            for _, code := range strings.Split(value, ",") {
+                day, ok := weekdays[code]
+                if !ok {
+                    return nil, fmt.Errorf("invalid BYDAY day %q", code)
+                }
+                rule.ByDay = append(rule.ByDay, day)
+            }
+        case "COUNT":
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 {
+                return nil, errors.New("COUNT must be a positive number")
+            }
+            rule.Count = n
+        case "UNTIL":
+            until, err := parseUntil(value)
+            if err != nil {
+                return nil, err
+            }
+            rule.Until = &until
+        default:
+            return nil, fmt.Errorf("unsupported recurrence part %s", key)
+        }
+    }
+
+    if rule.Freq == "" {
+        return nil, errors.New("FREQ is required")
+    }
+    if rule.Interval > maxInterval[rule.Freq] {
+        return nil, fmt.Errorf("INTERVAL can be at most %d for FREQ=%s", maxInterval[rule.Freq], rule.Freq)
+    }
+    if (rule.Count == 0) == (rule.Until == nil) {
+        return nil, errors.New("give either COUNT or UNTIL")
+    }
+    return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+    for _, layout := range untilLayouts {
+        if t, err := time.Parse(layout, value); err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, errors.New("UNTIL must be a date such as 20261231")
+}
+
+// String is the rule in RRULE form
+func (r Rule) String() string {
+    parts := []string{"FREQ=" + r.Freq}
+    if r.Interval > 1 {
+        parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+    }
+    if len(r.ByDay) > 0 {
+        codes := make([]string, len(r.ByDay))
+        for i, day := range r.ByDay {
+            codes[i] = strings.ToUpper(day.String()[:2])
+        }
+        parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+    }
+    if r.Count > 0 {
+        parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+    } else {
+        parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+    }
+    return strings.Join(parts, ";")
+}
+
+func (r Rule) onDay(day time.Weekday, first time.Time) bool {
+    if len(r.ByDay) == 0 {
+        return r.Freq == "DAILY" || day == first.Weekday()
+    }
+    for _, d := range r.ByDay {
+        if d == day {
+            return true
+        }
+    }
+    return false
+}
+
+// Occurrences lists the start of each occurrence, keeping first's time of
+// day. The series starts on the first matching day on or after first; weeks
+// run Monday to Sunday. It fails when the rule gives more than max.
+func (r Rule) Occurrences(first time.Time, max int) ([]time.Time, error) {
+    var untilKey int
+    if r.Until != nil {
+        untilKey = dateKey(*r.Until)
+    }
+    // Days since the Monday of first's week, for counting weekly intervals
+    offset := (int(first.Weekday()) + 6) % 7
+
+    starts := []time.Time{}
+    for i := 0; ; i++ {
+        day := first.AddDate(0, 0, i)
+        if r.Until != nil && dateKey(day) > untilKey {
+            break
+        }
+
+        period := i
+        if r.Freq == "WEEKLY" {
+            period = (offset + i) / 7
+        }
+        if skip := period % r.Interval; skip != 0 {
+            // Jump to the first day of the next period in the interval
+            if r.Freq == "WEEKLY" {
+                i = (period+r.Interval-skip)*7 - offset - 1
+            } else {
+                i += r.Interval - skip - 1
+            }
+            continue
+        }
+        if !r.onDay(day.Weekday(), first) {
+            continue
+        }
+
+        if len(starts) == max {
+            return nil, fmt.Errorf("recurrence gives more than %d occurrences", max)
+        }
+        starts = append(starts, day)
+        if r.Count > 0 && len(starts) == r.Count {
+            break
+        }
+    }
+    return starts, nil
+}
+
+func dateKey(t time.Time) int {
+    y, m, d := t.Date()
+    return y*10000 + int(m)*100 + d
+}
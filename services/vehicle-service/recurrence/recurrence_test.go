@@ -0,0 +1,167 @@
+package recurrence
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        in      string
+        want    string // the rule's String form
+        wantErr string
+    }{
+        {in: "RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4", want: "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4"},
+        {in: "freq=daily;interval=2;until=2026-12-31", want: "FREQ=DAILY;INTERVAL=2;UNTIL=20261231"},
+        {in: "FREQ=DAILY;UNTIL=20261231T235959Z", want: "FREQ=DAILY;UNTIL=20261231"},
+        {in: "FREQ=WEEKLY;INTERVAL=52;COUNT=2", want: "FREQ=WEEKLY;INTERVAL=52;COUNT=2"},
+        {in: "", wantErr: "recurrence is empty"},
+        {in: "COUNT=3", wantErr: "FREQ is required"},
+        {in: "FREQ=MONTHLY;COUNT=3", wantErr: "FREQ must be DAILY or WEEKLY"},
+        {in: "FREQ=DAILY", wantErr: "give either COUNT or UNTIL"},
+        {in: "FREQ=DAILY;COUNT=3;UNTIL=20261231", wantErr: "give either COUNT or UNTIL"},
+        {in: "FREQ=DAILY;INTERVAL=0;COUNT=3", wantErr: "INTERVAL must be a positive number"},
+        {in: "FREQ=DAILY;INTERVAL=366;COUNT=3", wantErr: "INTERVAL can be at most 365 for FREQ=DAILY"},
+        {in: "FREQ=WEEKLY;INTERVAL=53;COUNT=3", wantErr: "INTERVAL can be at most 52 for FREQ=WEEKLY"},
+        {in: "FREQ=WEEKLY;BYDAY=MO,XX;COUNT=3", wantErr: `invalid BYDAY day "XX"`},
+        {in: "FREQ=DAILY;UNTIL=31/12/2026", wantErr: "UNTIL must be a date"},
+        {in: "FREQ=DAILY;COUNT", wantErr: `invalid recurrence part "COUNT"`},
+        {in: "FREQ=DAILY;BYMONTH=1;COUNT=3", wantErr: "unsupported recurrence part BYMONTH"},
+    }
+
+    for _, tt := range tests {
+        rule, err := Parse(tt.in)
+        if tt.wantErr != "" {
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Errorf("Parse(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("Parse(%q) error = %v", tt.in, err)
+            continue
+        }
+        if got := rule.String(); got != tt.want {
+            t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestOccurrences(t *testing.T) {
+    // A Wednesday, so weekly rules start mid-week
+    first := time.Date(2026, 10, 21, 9, 30, 0, 0, time.UTC)
+
+    tests := []struct {
+        name    string
+        rule    string
+        max     int
+        want    []string
+        wantErr string
+    }{
+        {
+            name: "daily",
+            rule: "FREQ=DAILY;COUNT=3",
+            want: []string{"2026-10-21", "2026-10-22", "2026-10-23"},
+        },
+        {
+            name: "daily interval skips days",
+            rule: "FREQ=DAILY;INTERVAL=3;COUNT=3",
+            want: []string{"2026-10-21", "2026-10-24", "2026-10-27"},
+        },
+        {
+            name: "daily on weekend days until a date",
+            rule: "FREQ=DAILY;BYDAY=SA,SU;UNTIL=20261101",
+            want: []string{"2026-10-24", "2026-10-25", "2026-10-31", "2026-11-01"},
+        },
+        {
+            name: "weekly defaults to the first day's weekday",
+            rule: "FREQ=WEEKLY;UNTIL=20261104",
+            want: []string{"2026-10-21", "2026-10-28", "2026-11-04"},
+        },
+        {
+            name: "count with byday starts on the next matching day",
+            rule: "FREQ=WEEKLY;BYDAY=TU,TH;COUNT=3",
+            want: []string{"2026-10-22", "2026-10-27", "2026-10-29"},
+        },
+        {
+            // The first week runs from Monday 19 October, so a Monday
+            // before first is not in the series and the next week is skipped
+            name: "weekly interval with first mid-week",
+            rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=4",
+            want: []string{"2026-10-23", "2026-11-02", "2026-11-06", "2026-11-16"},
+        },
+        {
+            name: "weekly interval on first's weekday",
+            rule: "FREQ=WEEKLY;INTERVAL=3;COUNT=3",
+            want: []string{"2026-10-21", "2026-11-11", "2026-12-02"},
+        },
+        {
+            name: "until is inclusive",
+            rule: "FREQ=DAILY;UNTIL=20261022",
+            want: []string{"2026-10-21", "2026-10-22"},
+        },
+        {
+            name: "until before first",
+            rule: "FREQ=DAILY;UNTIL=20261001",
+            want: []string{},
+        },
+        {
+            name: "no matching day before until",
+            rule: "FREQ=WEEKLY;BYDAY=MO;UNTIL=20261025",
+            want: []string{},
+        },
+        {
+            name: "exactly max",
+            rule: "FREQ=DAILY;COUNT=5",
+            max:  5,
+            want: []string{"2026-10-21", "2026-10-22", "2026-10-23", "2026-10-24", "2026-10-25"},
+        },
+        {
+            name:    "more than max",
+            rule:    "FREQ=DAILY;COUNT=6",
+            max:     5,
+            wantErr: "recurrence gives more than 5 occurrences",
+        },
+        {
+            name:    "until past max",
+            rule:    "FREQ=DAILY;UNTIL=20271231",
+            max:     5,
+            wantErr: "recurrence gives more than 5 occurrences",
+        },
+    }
+
+    for _, tt := range tests {
+        rule, err := Parse(tt.rule)
+        if err != nil {
+            t.Fatalf("%s: Parse(%q) error = %v", tt.name, tt.rule, err)
+        }
+        max := tt.max
+        if max == 0 {
+            max = 100
+        }
+
+        starts, err := rule.Occurrences(first, max)
+        if tt.wantErr != "" {
+            if err == nil || err.Error() != tt.wantErr {
+                t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: error = %v", tt.name, err)
+            continue
+        }
+
+        got := make([]string, len(starts))
+        for i, start := range starts {
+            got[i] = start.Format("2006-01-02")
+            if h, m, _ := start.Clock(); h != 9 || m != 30 {
+                t.Errorf("%s: occurrence %s starts at %02d:%02d, want 09:30", tt.name, got[i], h, m)
+            }
+        }
+        if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
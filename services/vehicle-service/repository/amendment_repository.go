@@ -0,0 +1,157 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "vehicle-service/models"
+)
+
+var (
+    ErrBookingConflict = errors.New("the vehicle is booked by someone else during the new times")
+    ErrVehicleBooked   = errors.New("the vehicle is already booked during these times")
+)
+
+const amendmentColumns = `
+    id, booking_id, kind, previous_start_time, previous_end_time, new_start_time, new_end_time,
+    previous_estimate, new_estimate, amended_by, created_at
+`
+
+func scanAmendment(row rowScanner) (*models.BookingAmendment, error) {
+    var a models.BookingAmendment
+    err := row.Scan(
+        &a.ID, &a.BookingID, &a.Kind, &a.PreviousStartTime, &a.PreviousEndTime, &a.NewStartTime, &a.NewEndTime,
+        &a.PreviousEstimate, &a.NewEstimate, &a.AmendedBy, &a.CreatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &a, nil
+}
+
+// GetExtensionLimit returns when the vehicle's next booking after this one
+// starts, which is as far as the booking can be extended. It is nil when
+// nothing follows.
+func (r *VehicleRepository) GetExtensionLimit(bookingID int) (*time.Time, error) {
+    var next *time.Time
+    err := r.db.QueryRow(`
+        SELECT MIN(o.start_time)
+        FROM bookings b
+        JOIN bookings o ON o.vehicle_id = b.vehicle_id AND o.id != b.id
+        WHERE b.id = $1
+        AND o.status IN ('pending', 'confirmed')
+        AND o.start_time >= b.end_time
+    `, bookingID).Scan(&next)
+    if err != nil {
+        return nil, fmt.Errorf("error finding next booking: %v", err)
+    }
+    return next, nil
+}
+
+// AmendBooking moves a live booking to amendment's new times and records the
+// change. The booking's previous times are filled in from the locked row. It
+// returns ErrBookingConflict when the new times overlap another booking of
+// the same vehicle.
+func (r *VehicleRepository) AmendBooking(amendment *models.BookingAmendment) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    var vehicleID int
+    err = tx.QueryRow(`
+        SELECT vehicle_id, start_time, end_time
+        FROM bookings
+        WHERE id = $1 AND status IN ('pending', 'confirmed')
+        FOR UPDATE
+    `, amendment.BookingID).Scan(&vehicleID, &amendment.PreviousStartTime, &amendment.PreviousEndTime)
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return errors.New("booking not found or cannot be updated")
+        }
+        return err
+    }
+
+    // Amendments to bookings of the same car are checked one at a time
+    if _, err = tx.Exec(`SELECT id FROM vehicles WHERE id = $1 FOR UPDATE`, vehicleID); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    moved := &models.Booking{
+        ID:        amendment.BookingID,
+        UserID:    amendment.AmendedBy,
+        VehicleID: vehicleID,
+        StartTime: amendment.NewStartTime,
+        EndTime:   amendment.NewEndTime,
+    }
+    if err = checkVehicleFree(tx, moved, nil); err != nil {
+        tx.Rollback()
+        if err == ErrVehicleBooked {
+            return ErrBookingConflict
+        }
+        return err
+    }
+
+    if err = applyAmendment(tx, amendment); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// applyAmendment moves a booking to the amendment's new times and records it
+func applyAmendment(tx *sql.Tx, amendment *models.BookingAmendment) error {
+    _, err := tx.Exec(`
+        UPDATE bookings SET start_time = $1, end_time = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3
+    `, amendment.NewStartTime, amendment.NewEndTime, amendment.BookingID)
+    if err != nil {
+        return fmt.Errorf("error updating booking: %v", err)
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO booking_amendments (booking_id, kind, previous_start_time, previous_end_time,
+                                        new_start_time, new_end_time, previous_estimate, new_estimate, amended_by)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id, created_at
+    `, amendment.BookingID, amendment.Kind, amendment.PreviousStartTime, amendment.PreviousEndTime,
+        amendment.NewStartTime, amendment.NewEndTime, amendment.PreviousEstimate, amendment.NewEstimate,
+        amendment.AmendedBy).Scan(&amendment.ID, &amendment.CreatedAt)
+    if err != nil {
+        return fmt.Errorf("error recording amendment: %v", err)
+    }
+    return nil
+}
+
+// GetBookingAmendments returns a booking's changes, oldest first
+func (r *VehicleRepository) GetBookingAmendments(bookingID int) ([]models.BookingAmendment, error) {
+    rows, err := r.db.Query(`
+        SELECT `+amendmentColumns+`
+        FROM booking_amendments
+        WHERE booking_id = $1
+        ORDER BY created_at, id
+    `, bookingID)
+    if err != nil {
+        return nil, fmt.Errorf("error querying booking amendments: %v", err)
+    }
+    defer rows.Close()
+
+    amendments := []models.BookingAmendment{}
+    for rows.Next() {
+        a, err := scanAmendment(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning amendment row: %v", err)
+        }
+        amendments = append(amendments, *a)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating amendment rows: %v", err)
+    }
+
+    return amendments, nil
+}
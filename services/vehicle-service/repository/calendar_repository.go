@@ -0,0 +1,112 @@
+package repository
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "time"
+
+    "vehicle-service/models"
+)
+
+func hashCalendarToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// GetCalendarBookings returns a driver's bookings ending after since, for
+// their calendar. Cancelled bookings are included so calendars drop them.
+func (r *VehicleRepository) GetCalendarBookings(userID int, since time.Time) ([]models.CalendarBooking, error) {
+    rows, err := r.db.Query(`
+        SELECT `+bookingColumns+`, v.license_plate,
+               CASE WHEN ps.id IS NULL THEN v.location ELSE concat_ws(', ', ps.name, NULLIF(ps.address, '')) END,
+               CASE WHEN rs.id != ps.id THEN concat_ws(', ', rs.name, NULLIF(rs.address, '')) END,
+               (SELECT COUNT(*) FROM booking_amendments a WHERE a.booking_id = b.id)
+                   + CASE WHEN b.status IN ('cancelled', 'no_show') THEN 1 ELSE 0 END
+        FROM bookings b
+        JOIN vehicles v ON b.vehicle_id = v.id
+        LEFT JOIN stations ps ON ps.id = b.pickup_station_id
+        LEFT JOIN stations rs ON rs.id = b.return_station_id
+        WHERE b.user_id = $1 AND b.end_time > $2
+        ORDER BY b.start_time
+    `, userID, since)
+    if err != nil {
+        return nil, fmt.Errorf("error querying calendar bookings: %v", err)
+    }
+    defer rows.Close()
+
+    bookings := []models.CalendarBooking{}
+    for rows.Next() {
+        var c models.CalendarBooking
+        b, err := scanBooking(rows, &c.LicensePlate, &c.PickupLocation, &c.ReturnLocation, &c.Revision)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning calendar booking row: %v", err)
+        }
+        c.Booking = *b
+        bookings = append(bookings, c)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating calendar booking rows: %v", err)
+    }
+
+    return bookings, nil
+}
+
+// CreateCalendarToken issues a driver a new calendar subscription token,
+// replacing any earlier one. Only its hash is kept.
+func (r *VehicleRepository) CreateCalendarToken(userID int) (string, time.Time, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return "", time.Time{}, err
+    }
+    token := hex.EncodeToString(raw)
+
+    var createdAt time.Time
+    err := r.db.QueryRow(`
+        INSERT INTO calendar_tokens (user_id, token_hash)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET token_hash = EXCLUDED.token_hash, created_at = CURRENT_TIMESTAMP
+        RETURNING created_at
+    `, userID, hashCalendarToken(token)).Scan(&createdAt)
+    if err != nil {
+        return "", time.Time{}, fmt.Errorf("error creating calendar token: %v", err)
+    }
+    return token, createdAt, nil
+}
+
+// RevokeCalendarToken stops a driver's calendar subscription working
+func (r *VehicleRepository) RevokeCalendarToken(userID int) error {
+    result, err := r.db.Exec(`DELETE FROM calendar_tokens WHERE user_id = $1`, userID)
+    if err != nil {
+        return fmt.Errorf("error revoking calendar token: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("calendar subscription not found")
+    }
+    return nil
+}
+
+// CalendarTokenUser returns the driver a calendar subscription token belongs to
+func (r *VehicleRepository) CalendarTokenUser(token string) (int, error) {
+    var userID int
+    err := r.db.QueryRow(`
+        SELECT user_id FROM calendar_tokens WHERE token_hash = $1
+    `, hashCalendarToken(token)).Scan(&userID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return 0, errors.New("calendar not found")
+        }
+        return 0, err
+    }
+    return userID, nil
+}
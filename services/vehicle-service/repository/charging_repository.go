@@ -0,0 +1,248 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "vehicle-service/battery"
+    "vehicle-service/models"
+)
+
+const chargingColumns = `
+    id, vehicle_id, charger_id, station_id, power_kw, start_level, end_level,
+    energy_kwh, expected_ready_at, started_at, ended_at
+`
+
+func scanChargingSession(row rowScanner) (*models.ChargingSession, error) {
+    var s models.ChargingSession
+    err := row.Scan(
+        &s.ID, &s.VehicleID, &s.ChargerID, &s.StationID, &s.PowerKW, &s.StartLevel, &s.EndLevel,
+        &s.EnergyKWh, &s.ExpectedReadyAt, &s.StartedAt, &s.EndedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &s, nil
+}
+
+// StartCharging opens a session and puts the vehicle into charging status.
+// A vehicle and a charger can each be in only one open session, and a station
+// can't have more cars charging than it has chargers.
+func (r *VehicleRepository) StartCharging(session *models.ChargingSession) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    var status string
+    err = tx.QueryRow(`SELECT status FROM vehicles WHERE id = $1 FOR UPDATE`, session.VehicleID).Scan(&status)
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return errors.New("vehicle not found")
+        }
+        return err
+    }
+    if status != "available" && status != "charging" {
+        tx.Rollback()
+        return fmt.Errorf("a vehicle in %s status can't start charging", status)
+    }
+
+    var open int
+    err = tx.QueryRow(`
+        SELECT COUNT(*) FROM charging_sessions
+        WHERE ended_at IS NULL AND (vehicle_id = $1 OR charger_id = $2)
+    `, session.VehicleID, session.ChargerID).Scan(&open)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if open > 0 {
+        tx.Rollback()
+        return errors.New("vehicle or charger is already in a charging session")
+    }
+
+    if session.StationID != nil {
+        var chargers, inUse int
+        err = tx.QueryRow(`SELECT charger_count FROM stations WHERE id = $1 FOR UPDATE`, *session.StationID).Scan(&chargers)
+        if err != nil {
+            tx.Rollback()
+            if err == sql.ErrNoRows {
+                return errors.New("station not found")
+            }
+            return err
+        }
+
+        err = tx.QueryRow(`
+            SELECT COUNT(*) FROM charging_sessions WHERE station_id = $1 AND ended_at IS NULL
+        `, *session.StationID).Scan(&inUse)
+        if err != nil {
+            tx.Rollback()
+            return err
+        }
+        if inUse >= chargers {
+            tx.Rollback()
+            return errors.New("all chargers at this station are in use")
+        }
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO charging_sessions (vehicle_id, charger_id, station_id, power_kw, start_level, expected_ready_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, started_at
+    `, session.VehicleID, session.ChargerID, session.StationID, session.PowerKW,
+        session.StartLevel, session.ExpectedReadyAt).Scan(&session.ID, &session.StartedAt)
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error creating charging session: %v", err)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles
+        SET status = 'charging', battery_level = $1, last_status_update = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, session.StartLevel, session.VehicleID)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// UpdateCharging records charging progress. Once the battery reaches
+// battery.ChargedLevel the session is closed and the vehicle made available.
+func (r *VehicleRepository) UpdateCharging(vehicleID, level int, energyKWh *float64, readyAt *time.Time) (*models.ChargingSession, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    session, err := scanChargingSession(tx.QueryRow(`
+        UPDATE charging_sessions
+        SET energy_kwh = COALESCE($1, energy_kwh), expected_ready_at = $2
+        WHERE vehicle_id = $3 AND ended_at IS NULL
+        RETURNING `+chargingColumns, energyKWh, readyAt, vehicleID))
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, errors.New("vehicle is not charging")
+        }
+        return nil, err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles SET battery_level = $1, last_status_update = CURRENT_TIMESTAMP WHERE id = $2
+    `, level, vehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    if level >= battery.ChargedLevel {
+        session, err = endChargingTx(tx, vehicleID, &level, nil)
+        if err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return session, nil
+}
+
+// StopCharging closes the open session early, e.g. when a car is unplugged
+// before it is full, and returns the vehicle to service
+func (r *VehicleRepository) StopCharging(vehicleID int, level *int, energyKWh *float64) (*models.ChargingSession, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    session, err := endChargingTx(tx, vehicleID, level, energyKWh)
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, errors.New("vehicle is not charging")
+        }
+        return nil, err
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return session, nil
+}
+
+// endChargingTx closes the vehicle's open session at the given (or current)
+// battery level and sets the vehicle back to available. It returns
+// sql.ErrNoRows when the vehicle has no open session.
+func endChargingTx(tx *sql.Tx, vehicleID int, level *int, energyKWh *float64) (*models.ChargingSession, error) {
+    session, err := scanChargingSession(tx.QueryRow(`
+        UPDATE charging_sessions
+        SET ended_at = CURRENT_TIMESTAMP,
+            end_level = COALESCE($2, (SELECT battery_level FROM vehicles WHERE id = $1)),
+            energy_kwh = COALESCE($3, energy_kwh)
+        WHERE vehicle_id = $1 AND ended_at IS NULL
+        RETURNING `+chargingColumns, vehicleID, level, energyKWh))
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles
+        SET status = CASE WHEN status = 'charging' THEN 'available' ELSE status END,
+            battery_level = COALESCE($1, battery_level),
+            last_status_update = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, level, vehicleID)
+    if err != nil {
+        return nil, err
+    }
+    return session, nil
+}
+
+func (r *VehicleRepository) GetOpenChargingSession(vehicleID int) (*models.ChargingSession, error) {
+    s, err := scanChargingSession(r.db.QueryRow(`
+        SELECT `+chargingColumns+` FROM charging_sessions WHERE vehicle_id = $1 AND ended_at IS NULL
+    `, vehicleID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("vehicle is not charging")
+        }
+        return nil, err
+    }
+    return s, nil
+}
+
+func (r *VehicleRepository) GetChargingSessions(vehicleID int) ([]models.ChargingSession, error) {
+    rows, err := r.db.Query(`
+        SELECT `+chargingColumns+`
+        FROM charging_sessions
+        WHERE vehicle_id = $1
+        ORDER BY started_at DESC
+    `, vehicleID)
+    if err != nil {
+        return nil, fmt.Errorf("error querying charging sessions: %v", err)
+    }
+    defer rows.Close()
+
+    sessions := []models.ChargingSession{}
+    for rows.Next() {
+        s, err := scanChargingSession(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning charging session row: %v", err)
+        }
+        sessions = append(sessions, *s)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating charging session rows: %v", err)
+    }
+
+    return sessions, nil
+}
@@ -0,0 +1,271 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+
+    "github.com/lib/pq"
+    "vehicle-service/models"
+)
+
+const cleaningTaskColumns = `
+    t.id, t.vehicle_id, v.license_plate, v.location, v.home_station_id, t.reason, t.status,
+    t.assigned_to, t.booking_id, t.notes, t.created_at, t.started_at, t.completed_at
+`
+
+const cleaningTaskFrom = `cleaning_tasks t JOIN vehicles v ON v.id = t.vehicle_id`
+
+func scanCleaningTask(row rowScanner) (*models.CleaningTask, error) {
+    var t models.CleaningTask
+    err := row.Scan(
+        &t.ID, &t.VehicleID, &t.LicensePlate, &t.Location, &t.StationID, &t.Reason, &t.Status,
+        &t.AssignedTo, &t.BookingID, &t.Notes, &t.CreatedAt, &t.StartedAt, &t.CompletedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
+
+// openCleaningTaskTx puts a vehicle on the cleaning queue unless it already has
+// an unfinished task. A routine trip-count task is upgraded when the car is
+// reported dirty, so it moves up the queue.
+func openCleaningTaskTx(tx *sql.Tx, vehicleID int, reason string, bookingID *int) error {
+    _, err := tx.Exec(`
+        INSERT INTO cleaning_tasks (vehicle_id, reason, booking_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (vehicle_id) WHERE status != 'completed'
+        DO UPDATE SET reason = EXCLUDED.reason, booking_id = EXCLUDED.booking_id
+        WHERE cleaning_tasks.reason = 'trip_count' AND EXCLUDED.reason != 'trip_count'
+    `, vehicleID, reason, bookingID)
+    if err != nil {
+        return fmt.Errorf("error opening cleaning task: %v", err)
+    }
+    return nil
+}
+
+// QueueDueCleanings opens tasks for flagged vehicles that have none yet and
+// for vehicles that have finished tripInterval trips since they were last
+// cleaned
+func (r *VehicleRepository) QueueDueCleanings(tripInterval int) error {
+    _, err := r.db.Exec(`
+        INSERT INTO cleaning_tasks (vehicle_id, reason)
+        SELECT v.id, CASE WHEN v.cleanliness_status = 'needs_cleaning' THEN 'flagged' ELSE 'trip_count' END
+        FROM vehicles v
+        WHERE v.status != 'decommissioned'
+        AND NOT EXISTS (
+            SELECT 1 FROM cleaning_tasks t WHERE t.vehicle_id = v.id AND t.status != 'completed'
+        )
+        AND (
+            v.cleanliness_status = 'needs_cleaning'
+            OR (
+                SELECT COUNT(*) FROM bookings b
+                WHERE b.vehicle_id = v.id
+                AND b.status IN ('pending', 'confirmed')
+                AND b.end_time <= CURRENT_TIMESTAMP
+                AND b.end_time > COALESCE(v.last_cleaned_at, v.created_at)
+            ) >= $1
+        )
+        ON CONFLICT (vehicle_id) WHERE status != 'completed' DO NOTHING
+    `, tripInterval)
+    if err != nil {
+        return fmt.Errorf("error queueing cleaning tasks: %v", err)
+    }
+    return nil
+}
+
+// GetCleaningQueue lists tasks for the crew: cars reported dirty first, then
+// oldest first. An empty status returns open and in-progress tasks.
+func (r *VehicleRepository) GetCleaningQueue(status string, stationID *int) ([]models.CleaningTask, error) {
+    query := `SELECT ` + cleaningTaskColumns + ` FROM ` + cleaningTaskFrom + `
+        WHERE ($1 = '' AND t.status != 'completed' OR t.status = $1)`
+    args := []interface{}{status}
+    if stationID != nil {
+        query += ` AND v.home_station_id = $2`
+        args = append(args, *stationID)
+    }
+    query += ` ORDER BY CASE WHEN t.reason = 'trip_count' THEN 1 ELSE 0 END, t.created_at`
+
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error querying cleaning tasks: %v", err)
+    }
+    defer rows.Close()
+
+    tasks := []models.CleaningTask{}
+    for rows.Next() {
+        t, err := scanCleaningTask(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning cleaning task row: %v", err)
+        }
+        tasks = append(tasks, *t)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating cleaning task rows: %v", err)
+    }
+
+    return tasks, nil
+}
+
+// ClaimCleaningTask assigns an open task to a crew member and starts it
+func (r *VehicleRepository) ClaimCleaningTask(taskID, crewID int) (*models.CleaningTask, error) {
+    t, err := scanCleaningTask(r.db.QueryRow(`
+        WITH claimed AS (
+            UPDATE cleaning_tasks
+            SET status = 'in_progress', assigned_to = $1, started_at = CURRENT_TIMESTAMP
+            WHERE id = $2 AND status = 'open'
+            RETURNING *
+        )
+        SELECT `+cleaningTaskColumns+` FROM claimed t JOIN vehicles v ON v.id = t.vehicle_id
+    `, crewID, taskID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("task not found or already claimed")
+        }
+        return nil, err
+    }
+    return t, nil
+}
+
+// ErrTaskClaimed is returned when a crew member completes a task someone else claimed
+var ErrTaskClaimed = errors.New("this task was claimed by another crew member")
+
+// CompleteCleaningTask closes a task with its evidence photos and marks the
+// vehicle clean, restarting its trip count. Only the crew member who claimed
+// the task can complete it; an unclaimed task is assigned to crewID.
+func (r *VehicleRepository) CompleteCleaningTask(taskID, crewID int, notes *string, photos []models.CleaningPhoto) (*models.CleaningTask, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    t, err := scanCleaningTask(tx.QueryRow(`
+        WITH done AS (
+            UPDATE cleaning_tasks
+            SET status = 'completed', assigned_to = COALESCE(assigned_to, $1), notes = $2,
+                started_at = COALESCE(started_at, CURRENT_TIMESTAMP), completed_at = CURRENT_TIMESTAMP
+            WHERE id = $3 AND status != 'completed'
+            AND (assigned_to IS NULL OR assigned_to = $1)
+            RETURNING *
+        )
+        SELECT `+cleaningTaskColumns+` FROM done t JOIN vehicles v ON v.id = t.vehicle_id
+    `, crewID, notes, taskID))
+    if err == sql.ErrNoRows {
+        var claimed bool
+        err = tx.QueryRow(`
+            SELECT EXISTS (SELECT 1 FROM cleaning_tasks WHERE id = $1 AND status != 'completed')
+        `, taskID).Scan(&claimed)
+        tx.Rollback()
+        if err != nil {
+            return nil, err
+        }
+        if claimed {
+            return nil, ErrTaskClaimed
+        }
+        return nil, errors.New("task not found or already completed")
+    }
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    t.Photos = []models.CleaningPhoto{}
+    for _, photo := range photos {
+        photo.TaskID = t.ID
+        err = tx.QueryRow(`
+            INSERT INTO cleaning_task_photos (task_id, storage_key, content_type, size_bytes)
+            VALUES ($1, $2, $3, $4)
+            RETURNING id
+        `, photo.TaskID, photo.StorageKey, photo.ContentType, photo.Size).Scan(&photo.ID)
+        if err != nil {
+            tx.Rollback()
+            return nil, fmt.Errorf("error saving cleaning photo: %v", err)
+        }
+        t.Photos = append(t.Photos, photo)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles
+        SET cleanliness_status = 'clean', last_cleaned_at = CURRENT_TIMESTAMP, last_status_update = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `, t.VehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO vehicle_status_history (vehicle_id, cleanliness_status, source)
+        VALUES ($1, 'clean', 'cleaning')
+    `, t.VehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return t, nil
+}
+
+// GetCleaningPhoto returns a completion photo belonging to the given task
+func (r *VehicleRepository) GetCleaningPhoto(taskID, photoID int) (*models.CleaningPhoto, error) {
+    var p models.CleaningPhoto
+    err := r.db.QueryRow(`
+        SELECT id, task_id, storage_key, content_type, size_bytes
+        FROM cleaning_task_photos
+        WHERE id = $1 AND task_id = $2
+    `, photoID, taskID).Scan(&p.ID, &p.TaskID, &p.StorageKey, &p.ContentType, &p.Size)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("photo not found")
+        }
+        return nil, err
+    }
+    return &p, nil
+}
+
+// RateCleanliness records a driver's rating. A rating at or below flagAt marks
+// the vehicle as needing cleaning and puts it on the crew's queue; the result
+// reports whether that happened.
+func (r *VehicleRepository) RateCleanliness(rating *models.CleanlinessRating, flagAt int) (bool, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return false, err
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO cleanliness_ratings (booking_id, vehicle_id, user_id, rating, comment)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at
+    `, rating.BookingID, rating.VehicleID, rating.UserID, rating.Rating, rating.Comment).Scan(&rating.ID, &rating.CreatedAt)
+    if err != nil {
+        tx.Rollback()
+        if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+            return false, errors.New("this booking has already been rated")
+        }
+        return false, fmt.Errorf("error saving rating: %v", err)
+    }
+
+    if rating.Rating > flagAt {
+        return false, tx.Commit()
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles SET cleanliness_status = 'needs_cleaning', last_status_update = CURRENT_TIMESTAMP WHERE id = $1
+    `, rating.VehicleID)
+    if err != nil {
+        tx.Rollback()
+        return false, err
+    }
+
+    if err = openCleaningTaskTx(tx, rating.VehicleID, "rating", &rating.BookingID); err != nil {
+        tx.Rollback()
+        return false, err
+    }
+
+    return true, tx.Commit()
+}
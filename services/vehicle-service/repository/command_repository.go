@@ -0,0 +1,174 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "vehicle-service/models"
+)
+
+const commandColumns = `
+    id, vehicle_id, booking_id, user_id, command, status, error,
+    created_at, sent_at, acknowledged_at, expires_at
+`
+
+func scanCommand(row rowScanner) (*models.VehicleCommand, error) {
+    var c models.VehicleCommand
+    err := row.Scan(
+        &c.ID, &c.VehicleID, &c.BookingID, &c.UserID, &c.Command, &c.Status, &c.Error,
+        &c.CreatedAt, &c.SentAt, &c.AcknowledgedAt, &c.ExpiresAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &c, nil
+}
+
+// expireCommands marks commands the device never answered in time. It runs
+// before every read so callers always see timeouts without a background job.
+func (r *VehicleRepository) expireCommands() error {
+    _, err := r.db.Exec(`
+        UPDATE vehicle_commands
+        SET status = 'expired'
+        WHERE status IN ('pending', 'sent') AND expires_at < CURRENT_TIMESTAMP
+    `)
+    return err
+}
+
+// QueueCommand adds a command for the vehicle's device. An earlier command
+// still waiting for the same vehicle is superseded so the device only acts on
+// the latest request.
+func (r *VehicleRepository) QueueCommand(command *models.VehicleCommand, ttl time.Duration) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicle_commands
+        SET status = 'failed', error = 'superseded by a newer command'
+        WHERE vehicle_id = $1 AND status IN ('pending', 'sent')
+    `, command.VehicleID)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    queued, err := scanCommand(tx.QueryRow(`
+        INSERT INTO vehicle_commands (vehicle_id, booking_id, user_id, command, status, expires_at)
+        VALUES ($1, $2, $3, $4, 'pending', CURRENT_TIMESTAMP + $5 * interval '1 second')
+        RETURNING `+commandColumns,
+        command.VehicleID, command.BookingID, command.UserID, command.Command, int(ttl.Seconds())))
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error queueing command: %v", err)
+    }
+
+    if err = tx.Commit(); err != nil {
+        return err
+    }
+    *command = *queued
+    return nil
+}
+
+func (r *VehicleRepository) GetCommand(commandID int) (*models.VehicleCommand, error) {
+    if err := r.expireCommands(); err != nil {
+        return nil, err
+    }
+
+    c, err := scanCommand(r.db.QueryRow(`SELECT `+commandColumns+` FROM vehicle_commands WHERE id = $1`, commandID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("command not found")
+        }
+        return nil, err
+    }
+    return c, nil
+}
+
+// TakePendingCommands hands the vehicle's unexpired pending commands to its
+// device and marks them sent
+func (r *VehicleRepository) TakePendingCommands(vehicleID int) ([]models.VehicleCommand, error) {
+    if err := r.expireCommands(); err != nil {
+        return nil, err
+    }
+
+    rows, err := r.db.Query(`
+        UPDATE vehicle_commands
+        SET status = 'sent', sent_at = CURRENT_TIMESTAMP
+        WHERE vehicle_id = $1 AND status = 'pending'
+        RETURNING `+commandColumns, vehicleID)
+    if err != nil {
+        return nil, fmt.Errorf("error taking pending commands: %v", err)
+    }
+    defer rows.Close()
+
+    commands := []models.VehicleCommand{}
+    for rows.Next() {
+        c, err := scanCommand(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning command row: %v", err)
+        }
+        commands = append(commands, *c)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating command rows: %v", err)
+    }
+
+    return commands, nil
+}
+
+// AcknowledgeCommand records the device's answer. A successful lock or unlock
+// also updates the vehicle's door state.
+func (r *VehicleRepository) AcknowledgeCommand(vehicleID, commandID int, success bool, failure *string) (*models.VehicleCommand, error) {
+    if err := r.expireCommands(); err != nil {
+        return nil, err
+    }
+
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    status := "acknowledged"
+    if !success {
+        status = "failed"
+    }
+
+    command, err := scanCommand(tx.QueryRow(`
+        UPDATE vehicle_commands
+        SET status = $1, error = $2, acknowledged_at = CURRENT_TIMESTAMP
+        WHERE id = $3 AND vehicle_id = $4 AND status IN ('pending', 'sent')
+        RETURNING `+commandColumns, status, failure, commandID, vehicleID))
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, errors.New("command not found or no longer awaiting an answer")
+        }
+        return nil, err
+    }
+
+    if success {
+        _, err = tx.Exec(`
+            UPDATE vehicles SET doors_locked = $1, last_status_update = CURRENT_TIMESTAMP WHERE id = $2
+        `, command.Command == "lock", vehicleID)
+        if err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return command, nil
+}
+
+func (r *VehicleRepository) VehicleHasDevice(vehicleID int) (bool, error) {
+    var exists bool
+    err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM vehicle_devices WHERE vehicle_id = $1)`, vehicleID).Scan(&exists)
+    return exists, err
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+
+    "github.com/lib/pq"
+    "vehicle-service/models"
+)
+
+// GetFleet lists vehicles by ID. An empty status returns every vehicle still
+// in service; "decommissioned" lists retired ones.
+func (r *VehicleRepository) GetFleet(status string) ([]models.Vehicle, error) {
+    rows, err := r.db.Query(`
+        SELECT `+vehicleColumns+` FROM `+vehicleFrom+`
+        WHERE ($1 = '' AND v.status != 'decommissioned' OR v.status = $1)
+        ORDER BY v.id
+    `, status)
+    if err != nil {
+        return nil, fmt.Errorf("error querying fleet: %v", err)
+    }
+    defer rows.Close()
+
+    vehicles := []models.Vehicle{}
+    for rows.Next() {
+        v, err := scanVehicle(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning vehicle row: %v", err)
+        }
+        vehicles = append(vehicles, *v)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating vehicle rows: %v", err)
+    }
+
+    return vehicles, nil
+}
+
+// CreateVehicles adds vehicles to the fleet in one transaction, so a bulk
+// import either succeeds completely or adds nothing. With dryRun set every
+// check runs but the transaction is rolled back.
+func (r *VehicleRepository) CreateVehicles(vehicles []*models.Vehicle, dryRun bool) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    for _, v := range vehicles {
+        if v.HomeStationID != nil {
+            if err = checkStationCapacityTx(tx, *v.HomeStationID, 0); err != nil {
+                tx.Rollback()
+                return fmt.Errorf("%s: %v", v.LicensePlate, err)
+            }
+        }
+
+        err = tx.QueryRow(`
+            INSERT INTO vehicles (model, type, license_plate, status, location, latitude, longitude,
+                                  battery_level, cleanliness_status, hourly_rate, home_station_id, vehicle_class)
+            VALUES ($1, $2, $3, 'available', $4, $5, $6, $7, 'clean', $8, $9, $10)
+            RETURNING id, status, created_at, last_status_update
+        `, v.Model, v.Type, v.LicensePlate, v.Location, v.Latitude, v.Longitude,
+            v.BatteryLevel, v.HourlyRate, v.HomeStationID, v.VehicleClass).Scan(&v.ID, &v.Status, &v.CreatedAt, &v.LastStatusUpdate)
+        if err != nil {
+            tx.Rollback()
+            if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+                return fmt.Errorf("license plate %s is already registered", v.LicensePlate)
+            }
+            return fmt.Errorf("error creating vehicle %s: %v", v.LicensePlate, err)
+        }
+    }
+
+    if dryRun {
+        return tx.Rollback()
+    }
+    return tx.Commit()
+}
+
+// UpdateVehicle changes a vehicle's model, type, plate, rate or class. Retired
+// vehicles can't be edited.
+func (r *VehicleRepository) UpdateVehicle(vehicleID int, model, vehicleType, plate *string, hourlyRate *float64, vehicleClass *string) error {
+    result, err := r.db.Exec(`
+        UPDATE vehicles
+        SET model = COALESCE($1, model),
+            type = COALESCE($2, type),
+            license_plate = COALESCE($3, license_plate),
+            hourly_rate = COALESCE($4, hourly_rate),
+            vehicle_class = COALESCE($6, vehicle_class)
+        WHERE id = $5 AND status != 'decommissioned'
+    `, model, vehicleType, plate, hourlyRate, vehicleID, vehicleClass)
+    if err != nil {
+        if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+            return fmt.Errorf("license plate %s is already registered", *plate)
+        }
+        return fmt.Errorf("error updating vehicle: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("vehicle not found")
+    }
+    return nil
+}
+
+// DecommissionVehicle retires a vehicle. The row is kept so past bookings and
+// invoices still refer to it; future bookings are moved to another car or
+// cancelled. A vehicle can't be retired mid-trip.
+func (r *VehicleRepository) DecommissionVehicle(vehicleID int, reason *string) ([]models.BookingDisruption, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    var status, vehicleType string
+    err = tx.QueryRow(`SELECT status, type FROM vehicles WHERE id = $1 FOR UPDATE`, vehicleID).Scan(&status, &vehicleType)
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, errors.New("vehicle not found")
+        }
+        return nil, err
+    }
+    if status == "decommissioned" {
+        tx.Rollback()
+        return nil, errors.New("vehicle is already decommissioned")
+    }
+
+    var onTrip bool
+    err = tx.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM bookings
+            WHERE vehicle_id = $1 AND status IN ('pending', 'confirmed')
+            AND start_time <= CURRENT_TIMESTAMP AND end_time > CURRENT_TIMESTAMP
+        )
+    `, vehicleID).Scan(&onTrip)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+    if onTrip {
+        tx.Rollback()
+        return nil, errors.New("vehicle is on a trip")
+    }
+
+    if _, err = endChargingTx(tx, vehicleID, nil, nil); err != nil && err != sql.ErrNoRows {
+        tx.Rollback()
+        return nil, err
+    }
+
+    disruptions, err := reallocateBookingsTx(tx, vehicleID, vehicleType, nil)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    // A retired car no longer needs cleaning or takes up a station space
+    _, err = tx.Exec(`DELETE FROM cleaning_tasks WHERE vehicle_id = $1 AND status != 'completed'`, vehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles
+        SET status = 'decommissioned', decommissioned_at = CURRENT_TIMESTAMP, decommission_reason = $1,
+            home_station_id = NULL, last_status_update = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, reason, vehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return disruptions, nil
+}
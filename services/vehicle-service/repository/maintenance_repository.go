@@ -0,0 +1,400 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "vehicle-service/models"
+)
+
+const ticketColumns = `
+    id, vehicle_id, reason, severity, status, reported_by, damage_report_id,
+    assigned_technician, expected_return_at, resolution_notes, created_at, resolved_at
+`
+
+func scanTicket(row rowScanner) (*models.MaintenanceTicket, error) {
+    var t models.MaintenanceTicket
+    err := row.Scan(
+        &t.ID, &t.VehicleID, &t.Reason, &t.Severity, &t.Status, &t.ReportedBy, &t.DamageReportID,
+        &t.AssignedTechnician, &t.ExpectedReturnAt, &t.ResolutionNotes, &t.CreatedAt, &t.ResolvedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
+
+// openTicketFilter is true for vehicles with no unresolved maintenance ticket
+const openTicketFilter = `NOT EXISTS (
+    SELECT 1 FROM maintenance_tickets t WHERE t.vehicle_id = v.id AND t.status != 'resolved'
+)`
+
+// blockedFilter is true when an unresolved ticket keeps the vehicle out of
+// service at a time, unless it is expected back by then. vehicle and at are
+// SQL expressions.
+func blockedFilter(vehicle, at string) string {
+    return `EXISTS (
+        SELECT 1 FROM maintenance_tickets t
+        WHERE t.vehicle_id = ` + vehicle + ` AND t.status != 'resolved'
+        AND (t.expected_return_at IS NULL OR t.expected_return_at > ` + at + `)
+    )`
+}
+
+// vehicleBlocked runs blockedFilter for one vehicle, on the database or in a
+// transaction
+func vehicleBlocked(q interface {
+    QueryRow(query string, args ...interface{}) *sql.Row
+}, vehicleID int, at time.Time) (bool, error) {
+    var blocked bool
+    err := q.QueryRow(`SELECT `+blockedFilter("$1", "$2"), vehicleID, at).Scan(&blocked)
+    return blocked, err
+}
+
+// OpenTicket takes a vehicle out of service. Its future bookings that start
+// before the expected return (all of them when no return is set) are moved to
+// a free vehicle of the same type at the same station, or cancelled when none
+// is free.
+func (r *VehicleRepository) OpenTicket(ticket *models.MaintenanceTicket) ([]models.BookingDisruption, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    var status, vehicleType string
+    err = tx.QueryRow(`SELECT status, type FROM vehicles WHERE id = $1 FOR UPDATE`, ticket.VehicleID).Scan(&status, &vehicleType)
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, errors.New("vehicle not found")
+        }
+        return nil, err
+    }
+    if status == "decommissioned" {
+        tx.Rollback()
+        return nil, errors.New("vehicle is decommissioned")
+    }
+
+    opened, err := scanTicket(tx.QueryRow(`
+        INSERT INTO maintenance_tickets (vehicle_id, reason, severity, status, reported_by, damage_report_id,
+                                         assigned_technician, expected_return_at)
+        VALUES ($1, $2, $3, 'open', $4, $5, $6, $7)
+        RETURNING `+ticketColumns,
+        ticket.VehicleID, ticket.Reason, ticket.Severity, ticket.ReportedBy, ticket.DamageReportID,
+        ticket.AssignedTechnician, ticket.ExpectedReturnAt))
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error creating maintenance ticket: %v", err)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles SET status = 'maintenance', last_status_update = CURRENT_TIMESTAMP WHERE id = $1
+    `, ticket.VehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    disruptions, err := reallocateBookingsTx(tx, ticket.VehicleID, vehicleType, ticket.ExpectedReturnAt)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    *ticket = *opened
+    return disruptions, nil
+}
+
+func reallocateBookingsTx(tx *sql.Tx, vehicleID int, vehicleType string, until *time.Time) ([]models.BookingDisruption, error) {
+    rows, err := tx.Query(`
+        SELECT id, user_id, start_time, end_time, pickup_station_id
+        FROM bookings
+        WHERE vehicle_id = $1
+        AND status IN ('pending', 'confirmed')
+        AND start_time > CURRENT_TIMESTAMP
+        AND ($2::timestamp IS NULL OR start_time < $2)
+        ORDER BY start_time
+        FOR UPDATE
+    `, vehicleID, until)
+    if err != nil {
+        return nil, fmt.Errorf("error finding affected bookings: %v", err)
+    }
+
+    type affected struct {
+        id, userID int
+        start, end time.Time
+        stationID  *int
+    }
+    var bookings []affected
+    for rows.Next() {
+        var b affected
+        if err := rows.Scan(&b.id, &b.userID, &b.start, &b.end, &b.stationID); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        bookings = append(bookings, b)
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    disruptions := []models.BookingDisruption{}
+    for _, b := range bookings {
+        // Same type of car, based where the driver expects to pick up, charged and
+        // clean enough to be bookable, free for the whole booking
+        var replacement int
+        err := tx.QueryRow(`
+            SELECT v.id
+            FROM `+vehicleFrom+`
+            WHERE v.id != $1
+            AND v.type = $2
+            AND v.status = 'available'
+            AND v.home_station_id IS NOT DISTINCT FROM $3
+            AND `+readyFilter("$4")+`
+            AND `+openTicketFilter+`
+            AND NOT EXISTS (
+                SELECT 1 FROM bookings o
+                WHERE o.vehicle_id = v.id
+                AND o.status IN ('pending', 'confirmed')
+                AND o.start_time < $5 AND o.end_time > $4
+            )
+            ORDER BY v.battery_level DESC NULLS LAST, v.id
+            LIMIT 1
+            FOR UPDATE OF v SKIP LOCKED
+        `, vehicleID, vehicleType, b.stationID, b.start, b.end).Scan(&replacement)
+
+        d := models.BookingDisruption{BookingID: b.id, UserID: b.userID}
+        switch {
+        case err == sql.ErrNoRows:
+            _, err = tx.Exec(`
+                UPDATE bookings SET status = 'cancelled', cancelled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+            `, b.id)
+            d.Outcome = "cancelled"
+        case err == nil:
+            _, err = tx.Exec(`
+                UPDATE bookings SET vehicle_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+            `, replacement, b.id)
+            d.Outcome = "reallocated"
+            d.NewVehicleID = &replacement
+        }
+        if err != nil {
+            return nil, fmt.Errorf("error reallocating booking %d: %v", b.id, err)
+        }
+        disruptions = append(disruptions, d)
+    }
+
+    return disruptions, nil
+}
+
+// GetTickets lists tickets, newest first. An empty status returns unresolved
+// tickets; vehicleID narrows to one vehicle.
+func (r *VehicleRepository) GetTickets(status string, vehicleID *int) ([]models.MaintenanceTicket, error) {
+    query := `SELECT ` + ticketColumns + ` FROM maintenance_tickets WHERE ($1 = '' AND status != 'resolved' OR status = $1)`
+    args := []interface{}{status}
+    if vehicleID != nil {
+        query += ` AND vehicle_id = $2`
+        args = append(args, *vehicleID)
+    }
+    query += ` ORDER BY created_at DESC`
+
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error querying maintenance tickets: %v", err)
+    }
+    defer rows.Close()
+
+    tickets := []models.MaintenanceTicket{}
+    for rows.Next() {
+        t, err := scanTicket(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning maintenance ticket row: %v", err)
+        }
+        tickets = append(tickets, *t)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating maintenance ticket rows: %v", err)
+    }
+
+    return tickets, nil
+}
+
+func (r *VehicleRepository) GetTicket(ticketID int) (*models.MaintenanceTicket, error) {
+    t, err := scanTicket(r.db.QueryRow(`SELECT `+ticketColumns+` FROM maintenance_tickets WHERE id = $1`, ticketID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("ticket not found")
+        }
+        return nil, err
+    }
+    return t, nil
+}
+
+// UpdateTicket changes the technician, expected return or progress of an unresolved ticket
+func (r *VehicleRepository) UpdateTicket(ticketID int, technician *string, expectedReturn *time.Time, status *string) (*models.MaintenanceTicket, error) {
+    t, err := scanTicket(r.db.QueryRow(`
+        UPDATE maintenance_tickets
+        SET assigned_technician = COALESCE($1, assigned_technician),
+            expected_return_at = COALESCE($2, expected_return_at),
+            status = COALESCE($3, status)
+        WHERE id = $4 AND status != 'resolved'
+        RETURNING `+ticketColumns, technician, expectedReturn, status, ticketID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("ticket not found or already resolved")
+        }
+        return nil, err
+    }
+    return t, nil
+}
+
+// ResolveTicket closes a ticket. The vehicle returns to service once it has no
+// other unresolved tickets.
+func (r *VehicleRepository) ResolveTicket(ticketID int, notes *string) (*models.MaintenanceTicket, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    t, err := scanTicket(tx.QueryRow(`
+        UPDATE maintenance_tickets
+        SET status = 'resolved', resolution_notes = $1, resolved_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND status != 'resolved'
+        RETURNING `+ticketColumns, notes, ticketID))
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, errors.New("ticket not found or already resolved")
+        }
+        return nil, err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE vehicles v
+        SET status = 'available', last_status_update = CURRENT_TIMESTAMP
+        WHERE v.id = $1 AND v.status = 'maintenance' AND `+openTicketFilter,
+        t.VehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return t, nil
+}
+
+// VehicleBlocked reports whether an unresolved ticket keeps the vehicle out
+// of service at the given time
+func (r *VehicleRepository) VehicleBlocked(vehicleID int, at time.Time) (bool, error) {
+    return vehicleBlocked(r.db, vehicleID, at)
+}
+
+// CreateDamageReport saves a report and its already-stored photos
+func (r *VehicleRepository) CreateDamageReport(report *models.DamageReport) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO damage_reports (booking_id, vehicle_id, user_id, stage, description, drivable)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `, report.BookingID, report.VehicleID, report.UserID, report.Stage, report.Description,
+        report.Drivable).Scan(&report.ID, &report.CreatedAt)
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error creating damage report: %v", err)
+    }
+
+    for i := range report.Photos {
+        photo := &report.Photos[i]
+        photo.ReportID = report.ID
+        err = tx.QueryRow(`
+            INSERT INTO damage_report_photos (report_id, storage_key, content_type, size_bytes)
+            VALUES ($1, $2, $3, $4)
+            RETURNING id
+        `, photo.ReportID, photo.StorageKey, photo.ContentType, photo.Size).Scan(&photo.ID)
+        if err != nil {
+            tx.Rollback()
+            return fmt.Errorf("error saving damage photo: %v", err)
+        }
+    }
+
+    return tx.Commit()
+}
+
+func (r *VehicleRepository) GetDamageReports(vehicleID int) ([]models.DamageReport, error) {
+    rows, err := r.db.Query(`
+        SELECT d.id, d.booking_id, d.vehicle_id, d.user_id, d.stage, d.description, d.drivable, d.created_at,
+               p.id, p.content_type, p.size_bytes
+        FROM damage_reports d
+        LEFT JOIN damage_report_photos p ON p.report_id = d.id
+        WHERE d.vehicle_id = $1
+        ORDER BY d.created_at DESC, p.id
+    `, vehicleID)
+    if err != nil {
+        return nil, fmt.Errorf("error querying damage reports: %v", err)
+    }
+    defer rows.Close()
+
+    reports := []models.DamageReport{}
+    for rows.Next() {
+        var d models.DamageReport
+        var photoID sql.NullInt64
+        var contentType sql.NullString
+        var size sql.NullInt64
+        err := rows.Scan(&d.ID, &d.BookingID, &d.VehicleID, &d.UserID, &d.Stage, &d.Description, &d.Drivable,
+            &d.CreatedAt, &photoID, &contentType, &size)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning damage report row: %v", err)
+        }
+
+        // Rows repeat the report for each photo
+        if len(reports) == 0 || reports[len(reports)-1].ID != d.ID {
+            d.Photos = []models.DamagePhoto{}
+            reports = append(reports, d)
+        }
+        if photoID.Valid {
+            last := &reports[len(reports)-1]
+            last.Photos = append(last.Photos, models.DamagePhoto{
+                ID:          int(photoID.Int64),
+                ReportID:    d.ID,
+                ContentType: contentType.String,
+                Size:        size.Int64,
+            })
+        }
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating damage report rows: %v", err)
+    }
+
+    return reports, nil
+}
+
+// GetDamagePhoto returns a photo with the ID of the user who reported it
+func (r *VehicleRepository) GetDamagePhoto(photoID int) (*models.DamagePhoto, int, error) {
+    var p models.DamagePhoto
+    var userID int
+    err := r.db.QueryRow(`
+        SELECT p.id, p.report_id, p.storage_key, p.content_type, p.size_bytes, d.user_id
+        FROM damage_report_photos p
+        JOIN damage_reports d ON d.id = p.report_id
+        WHERE p.id = $1
+    `, photoID).Scan(&p.ID, &p.ReportID, &p.StorageKey, &p.ContentType, &p.Size, &userID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, 0, errors.New("photo not found")
+        }
+        return nil, 0, err
+    }
+    return &p, userID, nil
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "vehicle-service/models"
+)
+
+// lastAcknowledgedCommand is the last lock or unlock the car carried out for
+// booking b. An unlock means the driver still has the car.
+const lastAcknowledgedCommand = `(
+    SELECT c.command FROM vehicle_commands c
+    WHERE c.booking_id = b.id AND c.status = 'acknowledged'
+    ORDER BY c.acknowledged_at DESC
+    LIMIT 1
+)`
+
+func (r *VehicleRepository) queryBookings(query string, args ...interface{}) ([]models.Booking, error) {
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error querying bookings: %v", err)
+    }
+    defer rows.Close()
+
+    bookings := []models.Booking{}
+    for rows.Next() {
+        b, err := scanBooking(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning booking row: %v", err)
+        }
+        bookings = append(bookings, *b)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating booking rows: %v", err)
+    }
+
+    return bookings, nil
+}
+
+// GetNoShows returns live bookings that started before cutoff without the
+// driver ever trying to unlock the car. Only cars with a device are checked,
+// as others can't report a pickup. When an earlier trip brought the car back
+// late, the booking is only a no-show once the car has been back since cutoff.
+func (r *VehicleRepository) GetNoShows(cutoff time.Time) ([]models.Booking, error) {
+    return r.queryBookings(`
+        SELECT `+bookingColumns+`
+        FROM bookings b
+        JOIN vehicles v ON b.vehicle_id = v.id
+        WHERE b.status IN ('pending', 'confirmed')
+        AND b.start_time < $1
+        AND EXISTS (SELECT 1 FROM vehicle_devices d WHERE d.vehicle_id = b.vehicle_id)
+        AND NOT EXISTS (
+            SELECT 1 FROM vehicle_commands c
+            WHERE c.booking_id = b.id AND c.command = 'unlock'
+        )
+        AND NOT EXISTS (
+            SELECT 1 FROM bookings o
+            WHERE o.vehicle_id = b.vehicle_id AND o.id != b.id
+            AND o.start_time < b.start_time
+            AND o.overdue_at IS NOT NULL
+            AND (o.returned_at IS NULL OR o.returned_at > $1)
+        )
+        ORDER BY b.start_time
+    `, cutoff)
+}
+
+// MarkNoShow closes a booking the driver never picked up, recording the fee
+// billing-service charged for it
+func (r *VehicleRepository) MarkNoShow(bookingID int, fee float64) error {
+    result, err := r.db.Exec(`
+        UPDATE bookings
+        SET status = 'no_show', cancellation_fee = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND status IN ('pending', 'confirmed')
+    `, fee, bookingID)
+    if err != nil {
+        return fmt.Errorf("error marking no-show: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("booking not found or cannot be updated")
+    }
+    return nil
+}
+
+// FlagOverdueTrips marks trips that ended before cutoff while the driver still
+// has the car, and returns them. Each trip is only flagged once.
+func (r *VehicleRepository) FlagOverdueTrips(cutoff time.Time) ([]models.Booking, error) {
+    return r.queryBookings(`
+        WITH flagged AS (
+            UPDATE bookings b
+            SET overdue_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+            WHERE b.status IN ('pending', 'confirmed')
+            AND b.overdue_at IS NULL
+            AND b.end_time < $1
+            AND `+lastAcknowledgedCommand+` = 'unlock'
+            RETURNING b.*
+        )
+        SELECT `+bookingColumns+`
+        FROM flagged b
+        JOIN vehicles v ON b.vehicle_id = v.id
+        ORDER BY b.end_time
+    `, cutoff)
+}
+
+// FlagDelayedBookings marks live bookings starting before horizon whose car is
+// still out on an overdue trip, and returns them. Each booking is only
+// flagged once.
+func (r *VehicleRepository) FlagDelayedBookings(horizon time.Time) ([]models.Booking, error) {
+    return r.queryBookings(`
+        WITH flagged AS (
+            UPDATE bookings b
+            SET delay_warned_at = CURRENT_TIMESTAMP
+            WHERE b.status IN ('pending', 'confirmed')
+            AND b.delay_warned_at IS NULL
+            AND b.start_time < $1
+            AND EXISTS (
+                SELECT 1 FROM bookings o
+                WHERE o.vehicle_id = b.vehicle_id AND o.id != b.id
+                AND o.status IN ('pending', 'confirmed')
+                AND o.overdue_at IS NOT NULL AND o.returned_at IS NULL
+                AND o.start_time < b.start_time
+            )
+            RETURNING b.*
+        )
+        SELECT `+bookingColumns+`
+        FROM flagged b
+        JOIN vehicles v ON b.vehicle_id = v.id
+        ORDER BY b.start_time
+    `, horizon)
+}
+
+// GetReturnedOverdueTrips returns overdue trips whose car has since been
+// locked, with ReturnedAt set to when the lock was carried out
+func (r *VehicleRepository) GetReturnedOverdueTrips() ([]models.Booking, error) {
+    rows, err := r.db.Query(`
+        SELECT b.id, b.user_id, b.vehicle_id, b.end_time, c.acknowledged_at
+        FROM bookings b
+        JOIN LATERAL (
+            SELECT command, acknowledged_at FROM vehicle_commands
+            WHERE booking_id = b.id AND status = 'acknowledged'
+            ORDER BY acknowledged_at DESC
+            LIMIT 1
+        ) c ON c.command = 'lock'
+        WHERE b.status IN ('pending', 'confirmed')
+        AND b.overdue_at IS NOT NULL AND b.returned_at IS NULL
+        ORDER BY b.end_time
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("error querying returned trips: %v", err)
+    }
+    defer rows.Close()
+
+    trips := []models.Booking{}
+    for rows.Next() {
+        var b models.Booking
+        var returnedAt time.Time
+        if err := rows.Scan(&b.ID, &b.UserID, &b.VehicleID, &b.EndTime, &returnedAt); err != nil {
+            return nil, fmt.Errorf("error scanning returned trip row: %v", err)
+        }
+        b.ReturnedAt = &returnedAt
+        trips = append(trips, b)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating returned trip rows: %v", err)
+    }
+
+    return trips, nil
+}
+
+// RecordReturn closes an overdue trip with the late fee billing-service
+// charged for it
+func (r *VehicleRepository) RecordReturn(bookingID int, returnedAt time.Time, lateFee float64) error {
+    _, err := r.db.Exec(`
+        UPDATE bookings
+        SET returned_at = $1, late_fee = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3 AND returned_at IS NULL
+    `, returnedAt, lateFee, bookingID)
+    if err != nil {
+        return fmt.Errorf("error recording return: %v", err)
+    }
+    return nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+
+    "github.com/lib/pq"
+    "vehicle-service/models"
+)
+
+const policyColumns = `
+    membership_tier, max_duration_hours, max_advance_days, max_active_bookings, vehicle_classes, updated_at
+`
+
+func scanPolicy(row rowScanner) (*models.BookingPolicy, error) {
+    var p models.BookingPolicy
+    err := row.Scan(
+        &p.MembershipTier, &p.MaxDurationHours, &p.MaxAdvanceDays, &p.MaxActiveBookings,
+        pq.Array(&p.VehicleClasses), &p.UpdatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &p, nil
+}
+
+// GetBookingPolicies lists every tier's booking policy
+func (r *VehicleRepository) GetBookingPolicies() ([]models.BookingPolicy, error) {
+    rows, err := r.db.Query(`SELECT ` + policyColumns + ` FROM booking_policies ORDER BY max_duration_hours, membership_tier`)
+    if err != nil {
+        return nil, fmt.Errorf("error querying booking policies: %v", err)
+    }
+    defer rows.Close()
+
+    policies := []models.BookingPolicy{}
+    for rows.Next() {
+        p, err := scanPolicy(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning booking policy row: %v", err)
+        }
+        policies = append(policies, *p)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating booking policy rows: %v", err)
+    }
+
+    return policies, nil
+}
+
+// GetBookingPolicy returns a tier's booking policy, or nil when the tier has
+// none and its drivers are not restricted
+func (r *VehicleRepository) GetBookingPolicy(tier string) (*models.BookingPolicy, error) {
+    p, err := scanPolicy(r.db.QueryRow(`SELECT `+policyColumns+` FROM booking_policies WHERE membership_tier = $1`, tier))
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("error getting booking policy: %v", err)
+    }
+    return p, nil
+}
+
+// SaveBookingPolicy creates or replaces a tier's booking policy
+func (r *VehicleRepository) SaveBookingPolicy(p *models.BookingPolicy) error {
+    err := r.db.QueryRow(`
+        INSERT INTO booking_policies (membership_tier, max_duration_hours, max_advance_days,
+                                      max_active_bookings, vehicle_classes)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (membership_tier) DO UPDATE
+        SET max_duration_hours = EXCLUDED.max_duration_hours,
+            max_advance_days = EXCLUDED.max_advance_days,
+            max_active_bookings = EXCLUDED.max_active_bookings,
+            vehicle_classes = EXCLUDED.vehicle_classes,
+            updated_at = CURRENT_TIMESTAMP
+        RETURNING updated_at
+    `, p.MembershipTier, p.MaxDurationHours, p.MaxAdvanceDays, p.MaxActiveBookings,
+        pq.Array(p.VehicleClasses)).Scan(&p.UpdatedAt)
+    if err != nil {
+        if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+            return errors.New("membership tier not found")
+        }
+        return fmt.Errorf("error saving booking policy: %v", err)
+    }
+    return nil
+}
+
+// ErrBookingLimit is returned when a driver would hold more upcoming bookings
+// than their membership tier allows
+var ErrBookingLimit = errors.New("too many upcoming bookings")
+
+// checkBookingLimitTx fails with ErrBookingLimit when the driver's live
+// bookings that haven't ended, plus adding new ones, would exceed max. A max
+// of 0 means no limit. Bookings for one driver are made one at a time, so
+// concurrent requests can't both pass.
+func checkBookingLimitTx(tx *sql.Tx, userID, adding, max int) error {
+    if max == 0 {
+        return nil
+    }
+
+    if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('bookings'), $1)`, userID); err != nil {
+        return err
+    }
+
+    var active int
+    err := tx.QueryRow(`
+        SELECT COUNT(*) FROM bookings
+        WHERE user_id = $1 AND status IN ('pending', 'confirmed') AND end_time > CURRENT_TIMESTAMP
+    `, userID).Scan(&active)
+    if err != nil {
+        return fmt.Errorf("error counting active bookings: %v", err)
+    }
+    if active+adding > max {
+        return ErrBookingLimit
+    }
+    return nil
+}
@@ -0,0 +1,249 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "vehicle-service/models"
+)
+
+var ErrVehicleInMaintenance = errors.New("the vehicle is out of service for maintenance")
+
+const seriesColumns = `
+    id, user_id, vehicle_id, recurrence, start_time, end_time, return_station_id, status, created_at
+`
+
+func scanSeries(row rowScanner) (*models.BookingSeries, error) {
+    var s models.BookingSeries
+    err := row.Scan(
+        &s.ID, &s.UserID, &s.VehicleID, &s.Recurrence, &s.StartTime, &s.EndTime, &s.ReturnStationID,
+        &s.Status, &s.CreatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &s, nil
+}
+
+// checkOccurrences adds a conflict for each booking the car can't take
+// because it is booked, held for another driver or due to be in maintenance.
+// Bookings of series skipSeries don't count. The caller holds the vehicle's
+// row lock.
+func checkOccurrences(tx *sql.Tx, bookings []models.Booking, skipSeries *int, conflicts []models.SeriesConflict) ([]models.SeriesConflict, error) {
+    for i := range bookings {
+        b := &bookings[i]
+
+        err := checkVehicleFree(tx, b, skipSeries)
+        if err == nil {
+            var blocked bool
+            blocked, err = vehicleBlocked(tx, b.VehicleID, b.StartTime)
+            if err != nil {
+                return nil, err
+            }
+            if blocked {
+                err = ErrVehicleInMaintenance
+            }
+        }
+
+        switch err {
+        case nil:
+        case ErrVehicleBooked, ErrVehicleHeld, ErrVehicleInMaintenance:
+            conflicts = append(conflicts, models.SeriesConflict{StartTime: b.StartTime, EndTime: b.EndTime, Reason: err.Error()})
+        default:
+            return nil, err
+        }
+    }
+
+    sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].StartTime.Before(conflicts[j].StartTime) })
+    return conflicts, nil
+}
+
+// CreateBookingSeries books every occurrence in series.Bookings or none of
+// them. Conflicts the caller already found are passed in; when there are
+// any, or the car can't take an occurrence, nothing is saved and all the
+// conflicts are returned, earliest first. Each occurrence counts towards the
+// driver's maxActive upcoming bookings (0 for no limit).
+func (r *VehicleRepository) CreateBookingSeries(series *models.BookingSeries, conflicts []models.SeriesConflict, maxActive int) ([]models.SeriesConflict, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    // Bookings and waitlist offers of the same car are made one at a time
+    if _, err = tx.Exec(`SELECT id FROM vehicles WHERE id = $1 FOR UPDATE`, series.VehicleID); err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    conflicts, err = checkOccurrences(tx, series.Bookings, nil, conflicts)
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error checking occurrences: %v", err)
+    }
+    if len(conflicts) > 0 {
+        tx.Rollback()
+        return conflicts, nil
+    }
+
+    // Every occurrence returns the car to the same station
+    if err = checkReturnStation(tx, &series.Bookings[0]); err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+    if err = checkBookingLimitTx(tx, series.UserID, len(series.Bookings), maxActive); err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO booking_series (user_id, vehicle_id, recurrence, start_time, end_time, return_station_id)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, status, created_at
+    `, series.UserID, series.VehicleID, series.Recurrence, series.StartTime, series.EndTime,
+        series.ReturnStationID).Scan(&series.ID, &series.Status, &series.CreatedAt)
+    if err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error creating booking series: %v", err)
+    }
+
+    for i := range series.Bookings {
+        series.Bookings[i].SeriesID = &series.ID
+        if err = insertBooking(tx, &series.Bookings[i]); err != nil {
+            tx.Rollback()
+            return nil, fmt.Errorf("error creating occurrence: %v", err)
+        }
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return nil, nil
+}
+
+// GetBookingSeries returns a series with its bookings in time order
+func (r *VehicleRepository) GetBookingSeries(seriesID int) (*models.BookingSeries, error) {
+    series, err := scanSeries(r.db.QueryRow(`SELECT `+seriesColumns+` FROM booking_series WHERE id = $1`, seriesID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("booking series not found")
+        }
+        return nil, err
+    }
+
+    series.Bookings, err = r.queryBookings(`
+        SELECT `+bookingColumns+`
+        FROM bookings b
+        JOIN vehicles v ON b.vehicle_id = v.id
+        WHERE b.series_id = $1
+        ORDER BY b.start_time
+    `, seriesID)
+    if err != nil {
+        return nil, err
+    }
+    return series, nil
+}
+
+// RescheduleBookingSeries moves every live occurrence starting after from by
+// shift and gives it the new length, recording each change as a reschedule.
+// Conflicts the caller already found are passed in; when there are any, or
+// the car can't take a moved occurrence, nothing changes and all the
+// conflicts are returned. The series' own occurrences don't conflict.
+func (r *VehicleRepository) RescheduleBookingSeries(seriesID int, from time.Time, shift, length time.Duration, amendedBy int, conflicts []models.SeriesConflict) ([]models.BookingAmendment, []models.SeriesConflict, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var vehicleID int
+    err = tx.QueryRow(`
+        SELECT vehicle_id FROM booking_series WHERE id = $1 AND status = 'active' FOR UPDATE
+    `, seriesID).Scan(&vehicleID)
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, nil, errors.New("booking series not found or cannot be updated")
+        }
+        return nil, nil, err
+    }
+
+    // Amendments to bookings of the same car are checked one at a time
+    if _, err = tx.Exec(`SELECT id FROM vehicles WHERE id = $1 FOR UPDATE`, vehicleID); err != nil {
+        tx.Rollback()
+        return nil, nil, err
+    }
+
+    rows, err := tx.Query(`
+        SELECT id, user_id, start_time, end_time
+        FROM bookings
+        WHERE series_id = $1 AND status IN ('pending', 'confirmed') AND start_time > $2
+        ORDER BY start_time
+        FOR UPDATE
+    `, seriesID, from)
+    if err != nil {
+        tx.Rollback()
+        return nil, nil, fmt.Errorf("error querying occurrences: %v", err)
+    }
+
+    moved := []models.Booking{}
+    amendments := []models.BookingAmendment{}
+    for rows.Next() {
+        var a models.BookingAmendment
+        var userID int
+        if err = rows.Scan(&a.BookingID, &userID, &a.PreviousStartTime, &a.PreviousEndTime); err != nil {
+            rows.Close()
+            tx.Rollback()
+            return nil, nil, fmt.Errorf("error scanning occurrence: %v", err)
+        }
+        a.Kind = "reschedule"
+        a.NewStartTime = a.PreviousStartTime.Add(shift)
+        a.NewEndTime = a.NewStartTime.Add(length)
+        a.AmendedBy = amendedBy
+        amendments = append(amendments, a)
+        moved = append(moved, models.Booking{
+            ID: a.BookingID, UserID: userID, VehicleID: vehicleID, StartTime: a.NewStartTime, EndTime: a.NewEndTime,
+        })
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        tx.Rollback()
+        return nil, nil, fmt.Errorf("error iterating occurrences: %v", err)
+    }
+    if len(amendments) == 0 {
+        tx.Rollback()
+        return nil, nil, errors.New("the series has no upcoming occurrences")
+    }
+
+    conflicts, err = checkOccurrences(tx, moved, &seriesID, conflicts)
+    if err != nil {
+        tx.Rollback()
+        return nil, nil, fmt.Errorf("error checking occurrences: %v", err)
+    }
+    if len(conflicts) > 0 {
+        tx.Rollback()
+        return nil, conflicts, nil
+    }
+
+    for i := range amendments {
+        if err = applyAmendment(tx, &amendments[i]); err != nil {
+            tx.Rollback()
+            return nil, nil, err
+        }
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, nil, err
+    }
+    return amendments, nil, nil
+}
+
+// EndBookingSeries marks a series cancelled once its occurrences are
+func (r *VehicleRepository) EndBookingSeries(seriesID int) error {
+    _, err := r.db.Exec(`UPDATE booking_series SET status = 'cancelled' WHERE id = $1`, seriesID)
+    if err != nil {
+        return fmt.Errorf("error cancelling booking series: %v", err)
+    }
+    return nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+    "fmt"
+
+    "vehicle-service/battery"
+)
+
+func (r *VehicleRepository) GetVehicleSpecs() ([]battery.Spec, error) {
+    rows, err := r.db.Query(`
+        SELECT model, battery_capacity_kwh, consumption_kwh_per_100km
+        FROM vehicle_specs
+        ORDER BY model
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("error querying vehicle specs: %v", err)
+    }
+    defer rows.Close()
+
+    specs := []battery.Spec{}
+    for rows.Next() {
+        var s battery.Spec
+        if err := rows.Scan(&s.Model, &s.CapacityKWh, &s.ConsumptionPer100Km); err != nil {
+            return nil, fmt.Errorf("error scanning vehicle spec row: %v", err)
+        }
+        specs = append(specs, s)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating vehicle spec rows: %v", err)
+    }
+
+    return specs, nil
+}
+
+// SaveVehicleSpec creates or replaces the battery figures for a model
+func (r *VehicleRepository) SaveVehicleSpec(s *battery.Spec) error {
+    _, err := r.db.Exec(`
+        INSERT INTO vehicle_specs (model, battery_capacity_kwh, consumption_kwh_per_100km)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (model) DO UPDATE
+        SET battery_capacity_kwh = EXCLUDED.battery_capacity_kwh,
+            consumption_kwh_per_100km = EXCLUDED.consumption_kwh_per_100km
+    `, s.Model, s.CapacityKWh, s.ConsumptionPer100Km)
+    if err != nil {
+        return fmt.Errorf("error saving vehicle spec: %v", err)
+    }
+    return nil
+}
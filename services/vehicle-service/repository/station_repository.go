@@ -0,0 +1,270 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "vehicle-service/models"
+)
+
+const stationColumns = `
+    s.id, s.name, s.address, s.latitude, s.longitude, s.capacity, s.charger_count,
+    to_char(s.opens_at, 'HH24:MI'), to_char(s.closes_at, 'HH24:MI'),
+    (SELECT COUNT(*) FROM vehicles WHERE home_station_id = s.id), s.created_at
+`
+
+func scanStation(row rowScanner) (*models.Station, error) {
+    var s models.Station
+    err := row.Scan(
+        &s.ID, &s.Name, &s.Address, &s.Latitude, &s.Longitude, &s.Capacity, &s.ChargerCount,
+        &s.OpensAt, &s.ClosesAt, &s.VehicleCount, &s.CreatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &s, nil
+}
+
+func (r *VehicleRepository) GetStations() ([]models.Station, error) {
+    rows, err := r.db.Query(`SELECT ` + stationColumns + ` FROM stations s ORDER BY s.name`)
+    if err != nil {
+        return nil, fmt.Errorf("error querying stations: %v", err)
+    }
+    defer rows.Close()
+
+    var stations []models.Station
+    for rows.Next() {
+        s, err := scanStation(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning station row: %v", err)
+        }
+        stations = append(stations, *s)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating station rows: %v", err)
+    }
+
+    return stations, nil
+}
+
+func (r *VehicleRepository) GetStationByID(stationID int) (*models.Station, error) {
+    s, err := scanStation(r.db.QueryRow(`SELECT `+stationColumns+` FROM stations s WHERE s.id = $1`, stationID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("station not found")
+        }
+        return nil, err
+    }
+    return s, nil
+}
+
+func (r *VehicleRepository) CreateStation(s *models.Station) error {
+    err := r.db.QueryRow(`
+        INSERT INTO stations (name, address, latitude, longitude, capacity, charger_count, opens_at, closes_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id, created_at
+    `, s.Name, s.Address, s.Latitude, s.Longitude, s.Capacity, s.ChargerCount, s.OpensAt, s.ClosesAt).Scan(&s.ID, &s.CreatedAt)
+    if err != nil {
+        return fmt.Errorf("error creating station: %v", err)
+    }
+    return nil
+}
+
+func (r *VehicleRepository) UpdateStation(s *models.Station) error {
+    result, err := r.db.Exec(`
+        UPDATE stations
+        SET name = $1, address = $2, latitude = $3, longitude = $4,
+            capacity = $5, charger_count = $6, opens_at = $7, closes_at = $8
+        WHERE id = $9
+    `, s.Name, s.Address, s.Latitude, s.Longitude, s.Capacity, s.ChargerCount, s.OpensAt, s.ClosesAt, s.ID)
+    if err != nil {
+        return fmt.Errorf("error updating station: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("station not found")
+    }
+    return nil
+}
+
+// DeleteStation removes a station that no vehicle is based at
+func (r *VehicleRepository) DeleteStation(stationID int) error {
+    var based int
+    if err := r.db.QueryRow(`SELECT COUNT(*) FROM vehicles WHERE home_station_id = $1`, stationID).Scan(&based); err != nil {
+        return err
+    }
+    if based > 0 {
+        return fmt.Errorf("station still has %d vehicles assigned", based)
+    }
+
+    result, err := r.db.Exec(`DELETE FROM stations WHERE id = $1`, stationID)
+    if err != nil {
+        return fmt.Errorf("error deleting station: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return errors.New("station not found")
+    }
+    return nil
+}
+
+// ErrReturnStationFull is returned when a one-way trip ends at a station
+// with no room left for the car
+var ErrReturnStationFull = errors.New("the return station has no room for another car")
+
+// checkStationCapacityTx locks a station and checks it has room for one more
+// vehicle besides vehicleID (0 for a vehicle not yet created)
+func checkStationCapacityTx(tx *sql.Tx, stationID, vehicleID int) error {
+    room, err := stationHasRoomTx(tx, stationID, vehicleID)
+    if err != nil {
+        return err
+    }
+    if !room {
+        return fmt.Errorf("station %d is at capacity", stationID)
+    }
+    return nil
+}
+
+// stationHasRoomTx locks a station and reports whether it has room for one
+// more vehicle besides vehicleID. Cars booked to arrive there on a one-way
+// trip count as based there already.
+func stationHasRoomTx(tx *sql.Tx, stationID, vehicleID int) (bool, error) {
+    var capacity, based int
+    err := tx.QueryRow(`SELECT capacity FROM stations WHERE id = $1 FOR UPDATE`, stationID).Scan(&capacity)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return false, fmt.Errorf("station %d not found", stationID)
+        }
+        return false, err
+    }
+
+    err = tx.QueryRow(`
+        SELECT COUNT(*) FROM vehicles v
+        WHERE v.id != $2 AND v.status != 'decommissioned'
+        AND (
+            v.home_station_id = $1
+            OR EXISTS (
+                SELECT 1 FROM bookings b
+                WHERE b.vehicle_id = v.id AND b.return_station_id = $1
+                AND b.pickup_station_id != b.return_station_id
+                AND b.status IN ('pending', 'confirmed') AND b.rehomed_at IS NULL
+            )
+        )
+    `, stationID, vehicleID).Scan(&based)
+    if err != nil {
+        return false, err
+    }
+    return based < capacity, nil
+}
+
+// checkReturnStation returns ErrReturnStationFull when a one-way booking's
+// return station can't take the car
+func checkReturnStation(tx *sql.Tx, booking *models.Booking) error {
+    if booking.ReturnStationID == nil || booking.PickupStationID == nil || *booking.ReturnStationID == *booking.PickupStationID {
+        return nil
+    }
+    room, err := stationHasRoomTx(tx, *booking.ReturnStationID, booking.VehicleID)
+    if err != nil {
+        return err
+    }
+    if !room {
+        return ErrReturnStationFull
+    }
+    return nil
+}
+
+// RehomeOneWayTrips bases each car at the return station of the one-way trip
+// it finished before now, and returns the cars moved. A trip is finished once
+// it has ended and the car isn't still unlocked; cars with a device must also
+// have been unlocked for it. Each trip is only applied once.
+func (r *VehicleRepository) RehomeOneWayTrips(now time.Time) ([]int, error) {
+    rows, err := r.db.Query(`
+        WITH finished AS (
+            UPDATE bookings b
+            SET rehomed_at = CURRENT_TIMESTAMP
+            WHERE b.status IN ('pending', 'confirmed')
+            AND b.rehomed_at IS NULL
+            AND b.pickup_station_id != b.return_station_id
+            AND b.end_time < $1
+            AND `+lastAcknowledgedCommand+` IS DISTINCT FROM 'unlock'
+            AND (
+                NOT EXISTS (SELECT 1 FROM vehicle_devices d WHERE d.vehicle_id = b.vehicle_id)
+                OR EXISTS (
+                    SELECT 1 FROM vehicle_commands c
+                    WHERE c.booking_id = b.id AND c.command = 'unlock' AND c.status = 'acknowledged'
+                )
+            )
+            RETURNING b.vehicle_id, b.return_station_id, b.end_time
+        )
+        UPDATE vehicles v
+        SET home_station_id = f.return_station_id, last_status_update = CURRENT_TIMESTAMP
+        FROM (
+            SELECT DISTINCT ON (vehicle_id) vehicle_id, return_station_id
+            FROM finished
+            ORDER BY vehicle_id, end_time DESC
+        ) f
+        WHERE v.id = f.vehicle_id AND v.status != 'decommissioned'
+        RETURNING v.id
+    `, now)
+    if err != nil {
+        return nil, fmt.Errorf("error rehoming vehicles: %v", err)
+    }
+    defer rows.Close()
+
+    moved := []int{}
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            return nil, fmt.Errorf("error scanning rehomed vehicle: %v", err)
+        }
+        moved = append(moved, id)
+    }
+    return moved, rows.Err()
+}
+
+// AssignHomeStation bases a vehicle at a station, or unassigns it when stationID is nil.
+// The station row is locked so concurrent assignments can't exceed capacity.
+func (r *VehicleRepository) AssignHomeStation(vehicleID int, stationID *int) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    if stationID != nil {
+        if err = checkStationCapacityTx(tx, *stationID, vehicleID); err != nil {
+            tx.Rollback()
+            return err
+        }
+    }
+
+    result, err := tx.Exec(`
+        UPDATE vehicles SET home_station_id = $1 WHERE id = $2 AND status != 'decommissioned'
+    `, stationID, vehicleID)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if rows == 0 {
+        tx.Rollback()
+        return errors.New("vehicle not found")
+    }
+
+    return tx.Commit()
+}
@@ -0,0 +1,191 @@
+package repository
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "database/sql"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/lib/pq"
+    "vehicle-service/battery"
+    "vehicle-service/models"
+)
+
+func hashDeviceKey(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
+
+// RegisterDevice installs a telemetry device in a vehicle and issues it a new
+// key. Registering an existing device ID moves it and replaces its key.
+func (r *VehicleRepository) RegisterDevice(deviceID string, vehicleID int) (*models.VehicleDevice, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return nil, err
+    }
+    device := &models.VehicleDevice{
+        DeviceID:  deviceID,
+        VehicleID: vehicleID,
+        DeviceKey: hex.EncodeToString(raw),
+    }
+
+    err := r.db.QueryRow(`
+        INSERT INTO vehicle_devices (device_id, vehicle_id, key_hash)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (device_id) DO UPDATE
+        SET vehicle_id = EXCLUDED.vehicle_id, key_hash = EXCLUDED.key_hash
+        RETURNING created_at, last_seen_at
+    `, deviceID, vehicleID, hashDeviceKey(device.DeviceKey)).Scan(&device.CreatedAt, &device.LastSeenAt)
+    if err != nil {
+        if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+            return nil, errors.New("vehicle not found")
+        }
+        return nil, fmt.Errorf("error registering device: %v", err)
+    }
+    return device, nil
+}
+
+// VerifyDevice checks a device's key and returns the vehicle it is installed in
+func (r *VehicleRepository) VerifyDevice(deviceID, key string) (int, error) {
+    var vehicleID int
+    var keyHash string
+    err := r.db.QueryRow(`
+        SELECT vehicle_id, key_hash FROM vehicle_devices WHERE device_id = $1
+    `, deviceID).Scan(&vehicleID, &keyHash)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return 0, errors.New("unknown device")
+        }
+        return 0, err
+    }
+
+    if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashDeviceKey(key))) != 1 {
+        return 0, errors.New("invalid device key")
+    }
+    return vehicleID, nil
+}
+
+// IngestTelemetry bulk-loads a batch of readings with COPY and brings the
+// vehicle's current state up to date from the newest one
+func (r *VehicleRepository) IngestTelemetry(deviceID string, vehicleID int, readings []models.TelemetryReading) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    stmt, err := tx.Prepare(pq.CopyIn("vehicle_status_history",
+        "vehicle_id", "recorded_at", "latitude", "longitude", "battery_level",
+        "odometer_km", "speed_kmh", "doors_locked", "source"))
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    latest := readings[0]
+    for _, reading := range readings {
+        _, err = stmt.Exec(vehicleID, reading.RecordedAt, reading.Latitude, reading.Longitude, reading.BatteryLevel,
+            reading.OdometerKm, reading.SpeedKmh, reading.DoorsLocked, "device")
+        if err != nil {
+            stmt.Close()
+            tx.Rollback()
+            return fmt.Errorf("error copying telemetry: %v", err)
+        }
+        if reading.RecordedAt.After(latest.RecordedAt) {
+            latest = reading
+        }
+    }
+
+    // An Exec with no arguments flushes the COPY
+    if _, err = stmt.Exec(); err != nil {
+        stmt.Close()
+        tx.Rollback()
+        return fmt.Errorf("error copying telemetry: %v", err)
+    }
+    if err = stmt.Close(); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    // Late batches from a device that was offline must not overwrite newer state
+    result, err := tx.Exec(`
+        UPDATE vehicles
+        SET latitude = COALESCE($1, latitude),
+            longitude = COALESCE($2, longitude),
+            battery_level = COALESCE($3, battery_level),
+            odometer_km = COALESCE($4, odometer_km),
+            doors_locked = COALESCE($5, doors_locked),
+            last_status_update = $6
+        WHERE id = $7 AND (last_status_update IS NULL OR last_status_update < $6)
+    `, latest.Latitude, latest.Longitude, latest.BatteryLevel, latest.OdometerKm, latest.DoorsLocked,
+        latest.RecordedAt, vehicleID)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    updated, err := result.RowsAffected()
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if updated > 0 && latest.BatteryLevel != nil && *latest.BatteryLevel >= battery.ChargedLevel {
+        if _, err = endChargingTx(tx, vehicleID, latest.BatteryLevel, nil); err != nil && err != sql.ErrNoRows {
+            tx.Rollback()
+            return err
+        }
+    }
+
+    _, err = tx.Exec(`UPDATE vehicle_devices SET last_seen_at = CURRENT_TIMESTAMP WHERE device_id = $1`, deviceID)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// GetVehicleHistory returns the vehicle's readings between from and to,
+// averaged into buckets of the given width so charts get a bounded number of points
+func (r *VehicleRepository) GetVehicleHistory(vehicleID int, from, to time.Time, bucket time.Duration) ([]models.HistoryPoint, error) {
+    seconds := bucket.Seconds()
+    rows, err := r.db.Query(`
+        SELECT to_timestamp(floor(extract(epoch FROM recorded_at) / $4::double precision) * $4::double precision) AT TIME ZONE 'UTC' AS bucket,
+               COUNT(*),
+               (array_agg(latitude ORDER BY recorded_at DESC) FILTER (WHERE latitude IS NOT NULL))[1],
+               (array_agg(longitude ORDER BY recorded_at DESC) FILTER (WHERE longitude IS NOT NULL))[1],
+               round(avg(battery_level), 1)::double precision,
+               round(avg(speed_kmh), 1)::double precision,
+               max(speed_kmh)::double precision,
+               max(odometer_km)::double precision,
+               (array_agg(doors_locked ORDER BY recorded_at DESC) FILTER (WHERE doors_locked IS NOT NULL))[1]
+        FROM vehicle_status_history
+        WHERE vehicle_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+        GROUP BY bucket
+        ORDER BY bucket
+    `, vehicleID, from, to, seconds)
+    if err != nil {
+        return nil, fmt.Errorf("error querying vehicle history: %v", err)
+    }
+    defer rows.Close()
+
+    points := []models.HistoryPoint{}
+    for rows.Next() {
+        var p models.HistoryPoint
+        err := rows.Scan(&p.Time, &p.Samples, &p.Latitude, &p.Longitude, &p.BatteryLevel,
+            &p.SpeedKmh, &p.MaxSpeedKmh, &p.OdometerKm, &p.DoorsLocked)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning history row: %v", err)
+        }
+        points = append(points, p)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating history rows: %v", err)
+    }
+
+    return points, nil
+}
@@ -1,273 +1,362 @@
-package repository
-
-import (
-    "database/sql"
-    "errors"
-    "time"
-    "vehicle-service/models"
-    "fmt"
-)
-
-type VehicleRepository struct {
-    db *sql.DB
-}
-
-func NewVehicleRepository(db *sql.DB) *VehicleRepository {
-    return &VehicleRepository{db: db}
-}
-
-func (r *VehicleRepository) GetAvailableVehicles(startTime, endTime time.Time) ([]models.Vehicle, error) {
-    query := `
-        SELECT id, model, type, license_plate, status, location, 
-               battery_level, cleanliness_status, created_at, last_status_update
-        FROM vehicles v
-        WHERE v.id NOT IN (
-            SELECT vehicle_id 
-            FROM bookings 
-            WHERE status IN ('pending', 'confirmed')
-            AND (
-                (start_time <= $1 AND end_time >= $1)
-                OR (start_time <= $2 AND end_time >= $2)
-                OR (start_time >= $1 AND end_time <= $2)
-            )
-        )
-        AND v.status = 'available'
-        AND (v.battery_level IS NULL OR v.battery_level >= 20)
-        AND (v.cleanliness_status IS NULL OR v.cleanliness_status != 'needs_cleaning')
-    `
-    
-    rows, err := r.db.Query(query, startTime, endTime)
-    if err != nil {
-        return nil, fmt.Errorf("error querying available vehicles: %v", err)
-    }
-    defer rows.Close()
-
-    var vehicles []models.Vehicle
-    for rows.Next() {
-        var v models.Vehicle
-        err := rows.Scan(
-            &v.ID, &v.Model, &v.Type, &v.LicensePlate, &v.Status,
-            &v.Location, &v.BatteryLevel, &v.CleanlinessStatus,
-            &v.CreatedAt, &v.LastStatusUpdate,
-        )
-        if err != nil {
-            return nil, fmt.Errorf("error scanning vehicle row: %v", err)
-        }
-        vehicles = append(vehicles, v)
-    }
-
-    if err = rows.Err(); err != nil {
-        return nil, fmt.Errorf("error iterating vehicle rows: %v", err)
-    }
-
-    return vehicles, nil
-}
-
-func (r *VehicleRepository) GetVehicleByID(vehicleID int) (*models.Vehicle, error) {
-    query := `
-        SELECT id, model, type, license_plate, status, location, 
-               battery_level, cleanliness_status, created_at, last_status_update
-        FROM vehicles
-        WHERE id = $1
-    `
-    
-    var v models.Vehicle
-    err := r.db.QueryRow(query, vehicleID).Scan(
-        &v.ID, &v.Model, &v.Type, &v.LicensePlate, &v.Status,
-        &v.Location, &v.BatteryLevel, &v.CleanlinessStatus,
-        &v.CreatedAt, &v.LastStatusUpdate,
-    )
-    
-    if err != nil {
-        if err == sql.ErrNoRows {
-            return nil, errors.New("vehicle not found")
-        }
-        return nil, err
-    }
-    
-    return &v, nil
-}
-
-func (r *VehicleRepository) CreateReservation(booking *models.Booking) error {
-    tx, err := r.db.Begin()
-    if err != nil {
-        return err
-    }
-
-    query := `
-        INSERT INTO bookings (user_id, vehicle_id, start_time, end_time, status)
-        VALUES ($1, $2, $3, $4, $5)
-        RETURNING id, created_at, updated_at
-    `
-    
-    err = tx.QueryRow(
-        query,
-        booking.UserID,
-        booking.VehicleID,
-        booking.StartTime,
-        booking.EndTime,
-        booking.Status,
-    ).Scan(&booking.ID, &booking.CreatedAt, &booking.UpdatedAt)
-
-    if err != nil {
-        tx.Rollback()
-        return err
-    }
-
-    return tx.Commit()
-}
-
-func (r *VehicleRepository) GetUserReservations(userID int) ([]models.Booking, error) {
-    query := `
-        SELECT b.id, b.user_id, b.vehicle_id, v.model as vehicle_model,
-               b.start_time, b.end_time, b.status, b.created_at, b.updated_at
-        FROM bookings b
-        JOIN vehicles v ON b.vehicle_id = v.id
-        WHERE b.user_id = $1
-        ORDER BY b.created_at DESC
-    `
-    
-    rows, err := r.db.Query(query, userID)
-    if err != nil {
-        return nil, fmt.Errorf("error querying user bookings: %v", err)
-    }
-    defer rows.Close()
-
-    var bookings []models.Booking
-    for rows.Next() {
-        var b models.Booking
-        err := rows.Scan(
-            &b.ID, &b.UserID, &b.VehicleID, &b.VehicleModel,
-            &b.StartTime, &b.EndTime, &b.Status,
-            &b.CreatedAt, &b.UpdatedAt,
-        )
-        if err != nil {
-            return nil, fmt.Errorf("error scanning booking row: %v", err)
-        }
-        bookings = append(bookings, b)
-    }
-
-    if err = rows.Err(); err != nil {
-        return nil, fmt.Errorf("error iterating booking rows: %v", err)
-    }
-
-    return bookings, nil
-}
-
-func (r *VehicleRepository) UpdateReservation(bookingID int, startTime, endTime *time.Time) error {
-    query := `
-        UPDATE bookings
-        SET start_time = COALESCE($1, start_time),
-            end_time = COALESCE($2, end_time),
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = $3 AND status IN ('pending', 'confirmed')
-    `
-    
-    result, err := r.db.Exec(query, startTime, endTime, bookingID)
-    if err != nil {
-        return err
-    }
-
-    rows, err := result.RowsAffected()
-    if err != nil {
-        return err
-    }
-
-    if rows == 0 {
-        return errors.New("booking not found or cannot be updated")
-    }
-
-    return nil
-}
-
-func (r *VehicleRepository) UpdateBookingStatus(bookingID int, status string) error {
-    query := `
-        UPDATE bookings
-        SET status = $1,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = $2 AND status IN ('pending', 'confirmed')
-    `
-    
-    result, err := r.db.Exec(query, status, bookingID)
-    if err != nil {
-        return err
-    }
-
-    rows, err := result.RowsAffected()
-    if err != nil {
-        return err
-    }
-
-    if rows == 0 {
-        return errors.New("booking not found or cannot be updated")
-    }
-
-    return nil
-}
-
-func (r *VehicleRepository) UpdateVehicleStatus(vehicleID int, location *string, batteryLevel *int, cleanlinessStatus *string) error {
-    tx, err := r.db.Begin()
-    if err != nil {
-        return err
-    }
-
-    // Update current status
-    updateQuery := `
-        UPDATE vehicles 
-        SET location = COALESCE($1, location),
-            battery_level = COALESCE($2, battery_level),
-            cleanliness_status = COALESCE($3, cleanliness_status),
-            last_status_update = CURRENT_TIMESTAMP
-        WHERE id = $4
-    `
-    
-    result, err := tx.Exec(updateQuery, location, batteryLevel, cleanlinessStatus, vehicleID)
-    if err != nil {
-        tx.Rollback()
-        return err
-    }
-
-    rows, err := result.RowsAffected()
-    if err != nil {
-        tx.Rollback()
-        return err
-    }
-
-    if rows == 0 {
-        tx.Rollback()
-        return errors.New("vehicle not found")
-    }
-
-    // Record history
-    historyQuery := `
-        INSERT INTO vehicle_status_history (vehicle_id, location, battery_level, cleanliness_status)
-        VALUES ($1, $2, $3, $4)
-    `
-    
-    _, err = tx.Exec(historyQuery, vehicleID, location, batteryLevel, cleanlinessStatus)
-    if err != nil {
-        tx.Rollback()
-        return err
-    }
-
-    return tx.Commit()
-}
-
-func (r *VehicleRepository) VerifyBookingOwnership(bookingID, userID int) error {
-    var count int
-    err := r.db.QueryRow(`
-        SELECT COUNT(*) 
-        FROM bookings 
-        WHERE id = $1 AND user_id = $2
-    `, bookingID, userID).Scan(&count)
-    
-    if err != nil {
-        return err
-    }
-    
-    if count == 0 {
-        return errors.New("booking not found or unauthorized")
-    }
-    
-    return nil
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "vehicle-service/battery"
+    "vehicle-service/geo"
+    "vehicle-service/models"
+    "fmt"
+    "time"
+)
+
+type VehicleRepository struct {
+    db *sql.DB
+}
+
+func NewVehicleRepository(db *sql.DB) *VehicleRepository {
+    return &VehicleRepository{db: db}
+}
+
+const vehicleColumns = `
+    v.id, v.model, v.type, v.vehicle_class, v.license_plate, v.status, v.location, v.latitude, v.longitude,
+    v.battery_level, v.cleanliness_status, v.odometer_km, v.doors_locked, v.hourly_rate, v.home_station_id, v.decommissioned_at, v.created_at, v.last_status_update,
+    vs.battery_capacity_kwh, vs.consumption_kwh_per_100km, cs.expected_ready_at
+`
+
+// vehicleFrom joins the model's battery spec and any open charging session,
+// which vehicleColumns reads
+const vehicleFrom = `vehicles v
+    LEFT JOIN vehicle_specs vs ON vs.model = v.model
+    LEFT JOIN charging_sessions cs ON cs.vehicle_id = v.id AND cs.ended_at IS NULL`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanVehicle(row rowScanner, extra ...interface{}) (*models.Vehicle, error) {
+    var v models.Vehicle
+    var capacity, consumption sql.NullFloat64
+    dest := []interface{}{
+        &v.ID, &v.Model, &v.Type, &v.VehicleClass, &v.LicensePlate, &v.Status,
+        &v.Location, &v.Latitude, &v.Longitude, &v.BatteryLevel, &v.CleanlinessStatus,
+        &v.OdometerKm, &v.DoorsLocked, &v.HourlyRate, &v.HomeStationID, &v.DecommissionedAt, &v.CreatedAt, &v.LastStatusUpdate,
+        &capacity, &consumption, &v.AvailableFrom,
+    }
+    if err := row.Scan(append(dest, extra...)...); err != nil {
+        return nil, err
+    }
+
+    if capacity.Valid && consumption.Valid {
+        v.Spec = &battery.Spec{Model: v.Model, CapacityKWh: capacity.Float64, ConsumptionPer100Km: consumption.Float64}
+        if v.BatteryLevel != nil {
+            rangeKm := v.Spec.RangeKm(*v.BatteryLevel)
+            v.EstimatedRangeKm = &rangeKm
+        }
+    }
+    return &v, nil
+}
+
+func (r *VehicleRepository) GetVehicleByID(vehicleID int) (*models.Vehicle, error) {
+    v, err := scanVehicle(r.db.QueryRow(`SELECT `+vehicleColumns+` FROM `+vehicleFrom+` WHERE v.id = $1`, vehicleID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("vehicle not found")
+        }
+        return nil, err
+    }
+    
+    return v, nil
+}
+
+// CreateReservation books a vehicle. It fails with ErrVehicleBooked when the
+// times overlap another booking, ErrVehicleHeld when the car is held for
+// another driver on the waitlist and ErrBookingLimit when the driver already
+// has maxActive upcoming bookings (0 for no limit); the driver's own hold is
+// marked booked.
+func (r *VehicleRepository) CreateReservation(booking *models.Booking, maxActive int) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    // Bookings and waitlist offers of the same car are made one at a time
+    if _, err = tx.Exec(`SELECT id FROM vehicles WHERE id = $1 FOR UPDATE`, booking.VehicleID); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if err = checkVehicleFree(tx, booking, nil); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err = checkReturnStation(tx, booking); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err = checkBookingLimitTx(tx, booking.UserID, 1, maxActive); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if err = insertBooking(tx, booking); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// checkVehicleFree returns ErrVehicleBooked when the booking's times overlap
+// another live booking of the car, other than those of series skipSeries, and
+// ErrVehicleHeld when the car is held for another driver. The caller holds
+// the vehicle's row lock.
+func checkVehicleFree(tx *sql.Tx, booking *models.Booking, skipSeries *int) error {
+    var booked, held bool
+    err := tx.QueryRow(`
+        SELECT
+            EXISTS (
+                SELECT 1 FROM bookings
+                WHERE vehicle_id = $1 AND id != $5 AND status IN ('pending', 'confirmed')
+                AND start_time < $3 AND end_time > $2
+                AND ($6::integer IS NULL OR series_id IS DISTINCT FROM $6)
+            ),
+            NOT `+heldFilter("$2", "$3", "$4::integer")+`
+        FROM vehicles v
+        WHERE v.id = $1
+    `, booking.VehicleID, booking.StartTime, booking.EndTime, booking.UserID, booking.ID, skipSeries).Scan(&booked, &held)
+    if err != nil {
+        return err
+    }
+    if booked {
+        return ErrVehicleBooked
+    }
+    if held {
+        return ErrVehicleHeld
+    }
+    return nil
+}
+
+// insertBooking saves a new booking and marks the driver's waitlist hold on
+// the car for those times as booked
+func insertBooking(tx *sql.Tx, booking *models.Booking) error {
+    query := `
+        INSERT INTO bookings (user_id, vehicle_id, start_time, end_time, status,
+                              pickup_station_id, return_station_id, dropoff_fee, planned_distance_km, series_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id, created_at, updated_at
+    `
+    
+    err := tx.QueryRow(
+        query,
+        booking.UserID,
+        booking.VehicleID,
+        booking.StartTime,
+        booking.EndTime,
+        booking.Status,
+        booking.PickupStationID,
+        booking.ReturnStationID,
+        booking.DropoffFee,
+        booking.PlannedDistanceKm,
+        booking.SeriesID,
+    ).Scan(&booking.ID, &booking.CreatedAt, &booking.UpdatedAt)
+
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE waitlist_entries
+        SET status = 'booked', booking_id = $1
+        WHERE user_id = $2 AND held_vehicle_id = $3 AND status = 'held'
+        AND start_time < $5 AND end_time > $4
+    `, booking.ID, booking.UserID, booking.VehicleID, booking.StartTime, booking.EndTime)
+    return err
+}
+
+const bookingColumns = `
+    b.id, b.user_id, b.vehicle_id, v.model,
+    b.start_time, b.end_time, b.pickup_station_id, b.return_station_id, b.dropoff_fee,
+    b.planned_distance_km, b.status, b.cancelled_at, b.cancellation_fee,
+    b.overdue_at, b.returned_at, b.late_fee, b.series_id, b.created_at, b.updated_at
+`
+
+func scanBooking(row rowScanner, extra ...interface{}) (*models.Booking, error) {
+    var b models.Booking
+    dest := []interface{}{
+        &b.ID, &b.UserID, &b.VehicleID, &b.VehicleModel,
+        &b.StartTime, &b.EndTime, &b.PickupStationID, &b.ReturnStationID, &b.DropoffFee,
+        &b.PlannedDistanceKm, &b.Status, &b.CancelledAt, &b.CancellationFee,
+        &b.OverdueAt, &b.ReturnedAt, &b.LateFee, &b.SeriesID, &b.CreatedAt, &b.UpdatedAt,
+    }
+    if err := row.Scan(append(dest, extra...)...); err != nil {
+        return nil, err
+    }
+    return &b, nil
+}
+
+func (r *VehicleRepository) GetBookingByID(bookingID int) (*models.Booking, error) {
+    b, err := scanBooking(r.db.QueryRow(`
+        SELECT `+bookingColumns+`
+        FROM bookings b
+        JOIN vehicles v ON b.vehicle_id = v.id
+        WHERE b.id = $1
+    `, bookingID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, errors.New("booking not found")
+        }
+        return nil, err
+    }
+    return b, nil
+}
+
+func (r *VehicleRepository) GetUserReservations(userID int) ([]models.Booking, error) {
+    query := `
+        SELECT ` + bookingColumns + `
+        FROM bookings b
+        JOIN vehicles v ON b.vehicle_id = v.id
+        WHERE b.user_id = $1
+        ORDER BY b.created_at DESC
+    `
+    
+    rows, err := r.db.Query(query, userID)
+    if err != nil {
+        return nil, fmt.Errorf("error querying user bookings: %v", err)
+    }
+    defer rows.Close()
+
+    var bookings []models.Booking
+    for rows.Next() {
+        b, err := scanBooking(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning booking row: %v", err)
+        }
+        bookings = append(bookings, *b)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating booking rows: %v", err)
+    }
+
+    return bookings, nil
+}
+
+// CancelBooking cancels a live booking at cancelledAt, recording the fee
+// billing-service charged for it
+func (r *VehicleRepository) CancelBooking(bookingID int, cancelledAt time.Time, fee float64) error {
+    query := `
+        UPDATE bookings
+        SET status = 'cancelled',
+            cancelled_at = $1,
+            cancellation_fee = $2,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3 AND status IN ('pending', 'confirmed')
+    `
+    
+    result, err := r.db.Exec(query, cancelledAt, fee, bookingID)
+    if err != nil {
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+
+    if rows == 0 {
+        return errors.New("booking not found or cannot be updated")
+    }
+
+    return nil
+}
+
+func (r *VehicleRepository) UpdateVehicleStatus(vehicleID int, location *string, position *geo.Point, batteryLevel *int, cleanlinessStatus *string) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    var lat, lng *float64
+    if position != nil {
+        lat, lng = &position.Lat, &position.Lng
+    }
+
+    // Update current status
+    updateQuery := `
+        UPDATE vehicles 
+        SET location = COALESCE($1, location),
+            battery_level = COALESCE($2, battery_level),
+            cleanliness_status = COALESCE($3, cleanliness_status),
+            latitude = COALESCE($5, latitude),
+            longitude = COALESCE($6, longitude),
+            last_status_update = CURRENT_TIMESTAMP
+        WHERE id = $4
+    `
+    
+    result, err := tx.Exec(updateQuery, location, batteryLevel, cleanlinessStatus, vehicleID, lat, lng)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if rows == 0 {
+        tx.Rollback()
+        return errors.New("vehicle not found")
+    }
+
+    // Record history
+    historyQuery := `
+        INSERT INTO vehicle_status_history (vehicle_id, location, latitude, longitude, battery_level, cleanliness_status, source)
+        VALUES ($1, $2, $3, $4, $5, $6, 'status')
+    `
+    
+    _, err = tx.Exec(historyQuery, vehicleID, location, lat, lng, batteryLevel, cleanlinessStatus)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    // A car flagged as dirty goes straight onto the cleaning queue
+    if cleanlinessStatus != nil && *cleanlinessStatus == "needs_cleaning" {
+        if err = openCleaningTaskTx(tx, vehicleID, "flagged", nil); err != nil {
+            tx.Rollback()
+            return err
+        }
+    }
+
+    // A battery reading at the charged level finishes any open charging session
+    if batteryLevel != nil && *batteryLevel >= battery.ChargedLevel {
+        if _, err = endChargingTx(tx, vehicleID, batteryLevel, nil); err != nil && err != sql.ErrNoRows {
+            tx.Rollback()
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
+
+func (r *VehicleRepository) VerifyBookingOwnership(bookingID, userID int) error {
+    var count int
+    err := r.db.QueryRow(`
+        SELECT COUNT(*) 
+        FROM bookings 
+        WHERE id = $1 AND user_id = $2
+    `, bookingID, userID).Scan(&count)
+    
+    if err != nil {
+        return err
+    }
+    
+    if count == 0 {
+        return errors.New("booking not found or unauthorized")
+    }
+    
+    return nil
 }
\ No newline at end of file
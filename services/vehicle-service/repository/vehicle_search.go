@@ -0,0 +1,226 @@
+package repository
+
+import (
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/lib/pq"
+    "vehicle-service/battery"
+    "vehicle-service/geo"
+    "vehicle-service/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cars charged below this are only offered when charging finishes in time
+const minBookableBattery = 20
+
+// readyFilter is true for vehicles (from vehicleFrom) that will be charged
+// enough by start, an SQL expression, and aren't waiting to be cleaned
+func readyFilter(start string) string {
+    return fmt.Sprintf(`(v.battery_level IS NULL OR v.battery_level >= %d OR cs.expected_ready_at <= %s)
+        AND (v.cleanliness_status IS NULL OR v.cleanliness_status != 'needs_cleaning')`, minBookableBattery, start)
+}
+
+type sortOrder struct {
+    expr string // over the matches subquery m; never NULL so rows compare for keyset paging
+    desc bool
+}
+
+// sortOrders are the accepted VehicleSearch.Sort values. Ties are broken by id.
+var sortOrders = map[string]sortOrder{
+    "":         {expr: "m.id::double precision"},
+    "price":    {expr: "m.hourly_rate::double precision"},
+    "-price":   {expr: "m.hourly_rate::double precision", desc: true},
+    "battery":  {expr: "COALESCE(m.battery_level, 0)::double precision"},
+    "-battery": {expr: "COALESCE(m.battery_level, 0)::double precision", desc: true},
+    "distance": {expr: "m.distance_km"},
+}
+
+// likeEscaper makes user input match literally inside a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func ValidSort(sort string) bool {
+    _, ok := sortOrders[sort]
+    return ok
+}
+
+// searchCursor is the last row of a page: its sort value and id. The sort is
+// included so a cursor can't be replayed against a differently ordered search.
+type searchCursor struct {
+    Sort  string  `json:"s"`
+    Value float64 `json:"v"`
+    ID    int     `json:"id"`
+}
+
+func encodeCursor(c searchCursor) string {
+    b, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(raw, sort string) (*searchCursor, error) {
+    b, err := base64.RawURLEncoding.DecodeString(raw)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+    var c searchCursor
+    if err := json.Unmarshal(b, &c); err != nil || c.Sort != sort {
+        return nil, ErrInvalidCursor
+    }
+    return &c, nil
+}
+
+// GetAvailableVehicles returns one page of vehicles free for the whole search
+// window, plus the total number of matches across all pages
+func (r *VehicleRepository) GetAvailableVehicles(search models.VehicleSearch) (*models.VehiclePage, error) {
+    sort := search.Sort
+    if sort == "" && search.Near != nil {
+        sort = "distance"
+    }
+    order, ok := sortOrders[sort]
+    if !ok {
+        return nil, fmt.Errorf("unknown sort %q", sort)
+    }
+    if sort == "distance" && search.Near == nil {
+        return nil, errors.New("sorting by distance needs lat and lng")
+    }
+
+    args := []interface{}{search.Start, search.End}
+    param := func(v interface{}) string {
+        args = append(args, v)
+        return fmt.Sprintf("$%d", len(args))
+    }
+
+    distance := "NULL::double precision"
+    filters := []string{`
+        v.id NOT IN (
+            SELECT vehicle_id
+            FROM bookings
+            WHERE status IN ('pending', 'confirmed')
+            AND (
+                (start_time <= $1 AND end_time >= $1)
+                OR (start_time <= $2 AND end_time >= $2)
+                OR (start_time >= $1 AND end_time <= $2)
+            )
+        )`,
+        // Charging cars count when they are projected to be charged by the start time
+        "(v.status = 'available' OR (v.status = 'charging' AND cs.expected_ready_at <= $1))",
+        readyFilter("$1"),
+        // Vehicles with an unresolved maintenance ticket stay out of search
+        openTicketFilter,
+    }
+    filters = append(filters, heldFilter("$1", "$2", param(search.UserID)+"::integer"))
+
+    if search.Near != nil {
+        distance = geo.DistanceSQL("v.latitude", "v.longitude", param(search.Near.Lat), param(search.Near.Lng))
+
+        // The bounding box lets the coordinate index discard far-away cars cheaply
+        minLat, maxLat, minLng, maxLng := geo.BoundingBox(*search.Near, search.RadiusKm)
+        filters = append(filters,
+            fmt.Sprintf("v.latitude BETWEEN %s AND %s", param(minLat), param(maxLat)),
+            fmt.Sprintf("v.longitude BETWEEN %s AND %s", param(minLng), param(maxLng)),
+            distance+" <= "+param(search.RadiusKm),
+        )
+    }
+    if search.StationID != nil {
+        filters = append(filters, "v.home_station_id = "+param(*search.StationID))
+    }
+    if search.Type != "" {
+        filters = append(filters, "LOWER(v.type) = LOWER("+param(search.Type)+")")
+    }
+    if search.Classes != nil {
+        filters = append(filters, "v.vehicle_class = ANY("+param(pq.Array(search.Classes))+")")
+    }
+    if search.Model != "" {
+        filters = append(filters, "v.model ILIKE '%' || "+param(likeEscaper.Replace(search.Model))+` || '%' ESCAPE '\'`)
+    }
+    if search.MinBattery != nil {
+        level := fmt.Sprintf("CASE WHEN cs.id IS NULL THEN v.battery_level ELSE GREATEST(v.battery_level, %d) END", battery.ChargedLevel)
+        filters = append(filters, level+" >= "+param(*search.MinBattery))
+    }
+    if search.MaxRate != nil {
+        filters = append(filters, "v.hourly_rate <= "+param(*search.MaxRate))
+    }
+
+    // Distances are rounded before sorting so the cursor holds the exact value compared
+    matches := `
+        SELECT ` + vehicleColumns + `, round((` + distance + `)::numeric, 3)::double precision AS distance_km
+        FROM ` + vehicleFrom + `
+        WHERE ` + strings.Join(filters, "\n        AND ")
+
+    var total int
+    if err := r.db.QueryRow(`SELECT COUNT(*) FROM (`+matches+`) m`, args...).Scan(&total); err != nil {
+        return nil, fmt.Errorf("error counting available vehicles: %v", err)
+    }
+
+    // Keyset paging: continue strictly after the previous page's last row
+    direction, compare := "ASC", ">"
+    if order.desc {
+        direction, compare = "DESC", "<"
+    }
+    query := `SELECT * FROM (` + matches + `) m`
+    if search.Cursor != "" {
+        cursor, err := decodeCursor(search.Cursor, sort)
+        if err != nil {
+            return nil, err
+        }
+        query += fmt.Sprintf(" WHERE (%s, m.id) %s (%s::double precision, %s::integer)",
+            order.expr, compare, param(cursor.Value), param(cursor.ID))
+    }
+    query += fmt.Sprintf(" ORDER BY %s %s, m.id %s LIMIT %s", order.expr, direction, direction, param(search.Limit+1))
+
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error querying available vehicles: %v", err)
+    }
+    defer rows.Close()
+
+    page := &models.VehiclePage{Vehicles: []models.Vehicle{}, Total: total}
+    for rows.Next() {
+        var distanceKm sql.NullFloat64
+        v, err := scanVehicle(rows, &distanceKm)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning vehicle row: %v", err)
+        }
+        if distanceKm.Valid {
+            v.DistanceKm = &distanceKm.Float64
+        }
+        page.Vehicles = append(page.Vehicles, *v)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating vehicle rows: %v", err)
+    }
+
+    // One extra row was fetched to learn whether another page exists
+    if len(page.Vehicles) > search.Limit {
+        page.Vehicles = page.Vehicles[:search.Limit]
+        page.NextCursor = encodeCursor(sortCursor(sort, page.Vehicles[len(page.Vehicles)-1]))
+    }
+
+    return page, nil
+}
+
+// sortCursor mirrors the sortOrders expressions for a row already in memory
+func sortCursor(sort string, v models.Vehicle) searchCursor {
+    c := searchCursor{Sort: sort, ID: v.ID}
+    switch strings.TrimPrefix(sort, "-") {
+    case "price":
+        c.Value = v.HourlyRate
+    case "battery":
+        if v.BatteryLevel != nil {
+            c.Value = float64(*v.BatteryLevel)
+        }
+    case "distance":
+        if v.DistanceKm != nil {
+            c.Value = *v.DistanceKm
+        }
+    default:
+        c.Value = float64(v.ID)
+    }
+    return c
+}
@@ -0,0 +1,315 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/lib/pq"
+    "vehicle-service/geo"
+    "vehicle-service/models"
+)
+
+var ErrVehicleHeld = errors.New("the vehicle is being held for a driver on the waitlist")
+
+// ErrWaitlistLimit is returned when a driver already has the most waitlist entries allowed
+var ErrWaitlistLimit = errors.New("you already have the most waitlist entries allowed")
+
+const waitlistColumns = `
+    w.id, w.user_id, w.start_time, w.end_time, w.station_id, w.latitude, w.longitude, w.radius_km,
+    w.vehicle_type, w.vehicle_classes, w.status, w.held_vehicle_id, w.hold_expires_at, w.booking_id, w.created_at
+`
+
+// waitlistPosition is a waiting entry's place among the waiting entries
+// whose windows overlap it, oldest first
+const waitlistPosition = `CASE WHEN w.status = 'waiting' THEN (
+    SELECT COUNT(*) + 1 FROM waitlist_entries o
+    WHERE o.status = 'waiting' AND (o.created_at, o.id) < (w.created_at, w.id)
+    AND o.start_time < w.end_time AND o.end_time > w.start_time
+) END`
+
+// heldFilter excludes vehicles held for another driver's overlapping window.
+// start, end and user are SQL expressions; a NULL user excludes every hold.
+func heldFilter(start, end, user string) string {
+    return `NOT EXISTS (
+        SELECT 1 FROM waitlist_entries h
+        WHERE h.held_vehicle_id = v.id AND h.status = 'held' AND h.hold_expires_at > CURRENT_TIMESTAMP
+        AND h.start_time < ` + end + ` AND h.end_time > ` + start + `
+        AND h.user_id IS DISTINCT FROM ` + user + `
+    )`
+}
+
+func scanWaitlistEntry(row rowScanner, extra ...interface{}) (*models.WaitlistEntry, error) {
+    var e models.WaitlistEntry
+    dest := []interface{}{
+        &e.ID, &e.UserID, &e.StartTime, &e.EndTime, &e.StationID, &e.Latitude, &e.Longitude, &e.RadiusKm,
+        &e.VehicleType, pq.Array(&e.Classes), &e.Status, &e.HeldVehicleID, &e.HoldExpiresAt, &e.BookingID, &e.CreatedAt,
+    }
+    if err := row.Scan(append(dest, extra...)...); err != nil {
+        return nil, err
+    }
+    return &e, nil
+}
+
+func scanWaitlistEntries(rows *sql.Rows) ([]models.WaitlistEntry, error) {
+    defer rows.Close()
+
+    entries := []models.WaitlistEntry{}
+    for rows.Next() {
+        e, err := scanWaitlistEntry(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning waitlist row: %v", err)
+        }
+        entries = append(entries, *e)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating waitlist rows: %v", err)
+    }
+
+    return entries, nil
+}
+
+// CreateWaitlistEntry puts a driver on the waitlist. It fails with
+// ErrWaitlistLimit when the driver already has maxActive waiting or held entries.
+func (r *VehicleRepository) CreateWaitlistEntry(entry *models.WaitlistEntry, maxActive int) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    // Entries for one driver are added one at a time so the limit holds
+    if _, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('waitlist'), $1)`, entry.UserID); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    var active int
+    err = tx.QueryRow(`
+        SELECT COUNT(*) FROM waitlist_entries WHERE user_id = $1 AND status IN ('waiting', 'held')
+    `, entry.UserID).Scan(&active)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if active >= maxActive {
+        tx.Rollback()
+        return ErrWaitlistLimit
+    }
+
+    created, err := scanWaitlistEntry(tx.QueryRow(`
+        INSERT INTO waitlist_entries AS w (user_id, start_time, end_time, station_id, latitude, longitude,
+                                           radius_km, vehicle_type, vehicle_classes, status)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'waiting')
+        RETURNING `+waitlistColumns,
+        entry.UserID, entry.StartTime, entry.EndTime, entry.StationID, entry.Latitude, entry.Longitude,
+        entry.RadiusKm, entry.VehicleType, pq.Array(entry.Classes)))
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("error creating waitlist entry: %v", err)
+    }
+
+    if err = tx.Commit(); err != nil {
+        return err
+    }
+    *entry = *created
+    return nil
+}
+
+// GetWaitlistEntries lists a driver's entries, newest first, with each
+// waiting entry's place in the queue
+func (r *VehicleRepository) GetWaitlistEntries(userID int) ([]models.WaitlistEntry, error) {
+    rows, err := r.db.Query(`
+        SELECT `+waitlistColumns+`, `+waitlistPosition+`
+        FROM waitlist_entries w
+        WHERE w.user_id = $1
+        ORDER BY w.created_at DESC, w.id DESC
+    `, userID)
+    if err != nil {
+        return nil, fmt.Errorf("error querying waitlist: %v", err)
+    }
+    defer rows.Close()
+
+    entries := []models.WaitlistEntry{}
+    for rows.Next() {
+        var position sql.NullInt64
+        e, err := scanWaitlistEntry(rows, &position)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning waitlist row: %v", err)
+        }
+        if position.Valid {
+            p := int(position.Int64)
+            e.Position = &p
+        }
+        entries = append(entries, *e)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating waitlist rows: %v", err)
+    }
+
+    return entries, nil
+}
+
+// LeaveWaitlist cancels a driver's waiting or held entry. It returns the entry
+// as it was, so a released hold can be offered to the next driver.
+func (r *VehicleRepository) LeaveWaitlist(entryID, userID int) (*models.WaitlistEntry, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+
+    entry, err := scanWaitlistEntry(tx.QueryRow(`
+        SELECT `+waitlistColumns+` FROM waitlist_entries w
+        WHERE w.id = $1 AND w.user_id = $2 AND w.status IN ('waiting', 'held')
+        FOR UPDATE
+    `, entryID, userID))
+    if err != nil {
+        tx.Rollback()
+        if err == sql.ErrNoRows {
+            return nil, errors.New("waitlist entry not found")
+        }
+        return nil, err
+    }
+
+    if _, err = tx.Exec(`UPDATE waitlist_entries SET status = 'cancelled' WHERE id = $1`, entryID); err != nil {
+        tx.Rollback()
+        return nil, fmt.Errorf("error leaving waitlist: %v", err)
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, err
+    }
+    return entry, nil
+}
+
+// GetWaitlistVehicles returns the vehicles with work for OfferVehicle: those
+// with a lapsed hold and those a waiting entry could be offered
+func (r *VehicleRepository) GetWaitlistVehicles() ([]int, error) {
+    rows, err := r.db.Query(`
+        SELECT held_vehicle_id FROM waitlist_entries
+        WHERE status = 'held' AND hold_expires_at <= CURRENT_TIMESTAMP
+        UNION
+        SELECT v.id FROM ` + vehicleFrom + `
+        JOIN waitlist_entries w ON ` + waitlistMatch + `
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("error querying waitlist vehicles: %v", err)
+    }
+    defer rows.Close()
+
+    ids := []int{}
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            return nil, fmt.Errorf("error scanning waitlist vehicle: %v", err)
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// waitlistMatch is when vehicle v (from vehicleFrom) can be offered to entry w:
+// the entry is still waiting for a future window, the car matches its type
+// and place, the driver's tier allows its class, it is charged and clean as
+// search requires, and nothing else claims the car during the window
+var waitlistMatch = `w.status = 'waiting' AND w.start_time > CURRENT_TIMESTAMP
+    AND v.status IN ('available', 'charging')
+    AND (v.status = 'available' OR cs.expected_ready_at <= w.start_time)
+    AND ` + readyFilter("w.start_time") + `
+    AND (w.vehicle_type IS NULL OR LOWER(v.type) = LOWER(w.vehicle_type))
+    AND (w.vehicle_classes IS NULL OR v.vehicle_class = ANY(w.vehicle_classes))
+    AND (w.station_id IS NULL OR v.home_station_id = w.station_id)
+    AND (w.latitude IS NULL OR ` + geo.DistanceSQL("v.latitude", "v.longitude", "w.latitude", "w.longitude") + ` <= w.radius_km)
+    AND NOT EXISTS (
+        SELECT 1 FROM bookings b
+        WHERE b.vehicle_id = v.id AND b.status IN ('pending', 'confirmed')
+        AND b.start_time < w.end_time AND b.end_time > w.start_time
+    )
+    AND NOT ` + blockedFilter("v.id", "w.start_time") + `
+    AND ` + heldFilter("w.start_time", "w.end_time", "NULL")
+
+// OfferVehicle hands a free vehicle to the waitlist. Under the vehicle's lock
+// it expires lapsed holds on it, then holds it for the oldest waiting entry
+// it matches, repeating while other entries can use the rest of its time.
+// It returns the entries given a hold and those whose hold lapsed.
+func (r *VehicleRepository) OfferVehicle(vehicleID int, holdFor time.Duration) ([]models.WaitlistEntry, []models.WaitlistEntry, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, nil, err
+    }
+
+    // Bookings and offers of the same car are made one at a time
+    if _, err = tx.Exec(`SELECT id FROM vehicles WHERE id = $1 FOR UPDATE`, vehicleID); err != nil {
+        tx.Rollback()
+        return nil, nil, err
+    }
+
+    rows, err := tx.Query(`
+        UPDATE waitlist_entries w
+        SET status = 'expired'
+        WHERE w.held_vehicle_id = $1 AND w.status = 'held' AND w.hold_expires_at <= CURRENT_TIMESTAMP
+        RETURNING `+waitlistColumns, vehicleID)
+    if err != nil {
+        tx.Rollback()
+        return nil, nil, fmt.Errorf("error expiring holds: %v", err)
+    }
+    expired, err := scanWaitlistEntries(rows)
+    if err != nil {
+        tx.Rollback()
+        return nil, nil, err
+    }
+
+    offered := []models.WaitlistEntry{}
+    for {
+        var entryID int
+        err = tx.QueryRow(`
+            SELECT w.id
+            FROM `+vehicleFrom+`
+            JOIN waitlist_entries w ON `+waitlistMatch+`
+            WHERE v.id = $1
+            ORDER BY w.created_at, w.id
+            LIMIT 1
+            FOR UPDATE OF w SKIP LOCKED
+        `, vehicleID).Scan(&entryID)
+        if err == sql.ErrNoRows {
+            break
+        }
+        if err != nil {
+            tx.Rollback()
+            return nil, nil, fmt.Errorf("error matching waitlist: %v", err)
+        }
+
+        entry, err := scanWaitlistEntry(tx.QueryRow(`
+            UPDATE waitlist_entries w
+            SET status = 'held', held_vehicle_id = $1,
+                hold_expires_at = CURRENT_TIMESTAMP + $2 * interval '1 second'
+            WHERE w.id = $3
+            RETURNING `+waitlistColumns, vehicleID, int(holdFor.Seconds()), entryID))
+        if err != nil {
+            tx.Rollback()
+            return nil, nil, fmt.Errorf("error holding vehicle: %v", err)
+        }
+        offered = append(offered, *entry)
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, nil, err
+    }
+    return offered, expired, nil
+}
+
+// ExpireWaitlist closes waiting entries whose window has started without a
+// car coming free
+func (r *VehicleRepository) ExpireWaitlist() ([]models.WaitlistEntry, error) {
+    rows, err := r.db.Query(`
+        UPDATE waitlist_entries w
+        SET status = 'expired'
+        WHERE w.status = 'waiting' AND w.start_time <= CURRENT_TIMESTAMP
+        RETURNING ` + waitlistColumns)
+    if err != nil {
+        return nil, fmt.Errorf("error expiring waitlist: %v", err)
+    }
+    return scanWaitlistEntries(rows)
+}
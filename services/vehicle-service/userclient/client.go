@@ -0,0 +1,197 @@
+package userclient
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "vehicle-service/serviceauth"
+)
+
+const (
+    requestTimeout = 3 * time.Second
+    cacheTTL       = 30 * time.Second
+    maxCacheSize   = 10000
+)
+
+var ErrNotFound = errors.New("user not found")
+
+// User is what user-service exposes about an account to other services
+type User struct {
+    ID                 int        `json:"id"`
+    Email              string     `json:"email"`
+    MembershipTier     string     `json:"membership_tier"`
+    Role               string     `json:"role"`
+    Status             string     `json:"status"`
+    VerificationStatus string     `json:"verification_status"`
+    LicenceExpiry      *time.Time `json:"licence_expiry"`
+}
+
+type Introspection struct {
+    Active    bool  `json:"active"`
+    ExpiresAt int64 `json:"exp"`
+    User
+}
+
+type cacheEntry struct {
+    value   interface{}
+    expires time.Time
+}
+
+// Client talks to user-service's /internal API. Responses are cached briefly so
+// that authenticating every request doesn't cost a round trip.
+type Client struct {
+    baseURL  string
+    identity *serviceauth.Identity
+    http     *http.Client
+
+    mu    sync.Mutex
+    cache map[string]cacheEntry
+}
+
+func New(baseURL string, identity *serviceauth.Identity) *Client {
+    return &Client{
+        baseURL:  strings.TrimRight(baseURL, "/"),
+        identity: identity,
+        http:     &http.Client{Timeout: requestTimeout},
+        cache:    make(map[string]cacheEntry),
+    }
+}
+
+func (c *Client) cached(key string) (interface{}, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    entry, ok := c.cache[key]
+    if !ok || time.Now().After(entry.expires) {
+        return nil, false
+    }
+    return entry.value, true
+}
+
+func (c *Client) store(key string, value interface{}, expires time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if len(c.cache) >= maxCacheSize {
+        now := time.Now()
+        for k, e := range c.cache {
+            if now.After(e.expires) {
+                delete(c.cache, k)
+            }
+        }
+        if len(c.cache) >= maxCacheSize {
+            c.cache = make(map[string]cacheEntry)
+        }
+    }
+    c.cache[key] = cacheEntry{value: value, expires: expires}
+}
+
+// Invalidate drops a cached user, e.g. after learning their state changed
+func (c *Client) Invalidate(userID int) {
+    c.mu.Lock()
+    delete(c.cache, fmt.Sprintf("user:%d", userID))
+    c.mu.Unlock()
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+    ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+    defer cancel()
+
+    var payload bytes.Buffer
+    if body != nil {
+        if err := json.NewEncoder(&payload).Encode(body); err != nil {
+            return err
+        }
+    }
+
+    token, err := c.identity.Token("user-service")
+    if err != nil {
+        return fmt.Errorf("error signing service token: %v", err)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, &payload)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(serviceauth.Header, token)
+
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return fmt.Errorf("user-service %s: %v", path, err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusNotFound {
+        return ErrNotFound
+    }
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("user-service %s: unexpected status %d", path, resp.StatusCode)
+    }
+
+    return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) GetUser(ctx context.Context, userID int) (*User, error) {
+    key := fmt.Sprintf("user:%d", userID)
+    if v, ok := c.cached(key); ok {
+        u := v.(User)
+        return &u, nil
+    }
+
+    var u User
+    if err := c.do(ctx, "GET", fmt.Sprintf("/internal/users/%d", userID), nil, &u); err != nil {
+        return nil, err
+    }
+
+    c.store(key, u, time.Now().Add(cacheTTL))
+    return &u, nil
+}
+
+// Introspect validates an end-user bearer token. Only a digest of the token is
+// kept in the cache, and never beyond the token's own expiry.
+func (c *Client) Introspect(ctx context.Context, token string) (*Introspection, error) {
+    sum := sha256.Sum256([]byte(token))
+    key := "token:" + hex.EncodeToString(sum[:])
+    if v, ok := c.cached(key); ok {
+        in := v.(Introspection)
+        return &in, nil
+    }
+
+    var in Introspection
+    req := map[string]string{"token": token}
+    if err := c.do(ctx, "POST", "/internal/tokens/introspect", req, &in); err != nil {
+        return nil, err
+    }
+
+    expires := time.Now().Add(cacheTTL)
+    if in.Active && in.ExpiresAt > 0 {
+        if tokenExpiry := time.Unix(in.ExpiresAt, 0); tokenExpiry.Before(expires) {
+            expires = tokenExpiry
+        }
+    }
+    c.store(key, in, expires)
+
+    if in.Active {
+        c.store(fmt.Sprintf("user:%d", in.ID), in.User, time.Now().Add(cacheTTL))
+    }
+    return &in, nil
+}
+
+// Notify emails the user through user-service, which holds their address
+func (c *Client) Notify(ctx context.Context, userID int, subject, body string) error {
+    var resp struct {
+        Sent bool `json:"sent"`
+    }
+    req := map[string]string{"subject": subject, "body": body}
+    return c.do(ctx, "POST", fmt.Sprintf("/internal/users/%d/notifications", userID), req, &resp)
+}